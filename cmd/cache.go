@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/khase/leaseplan-bot/lpbot/config"
+	"github.com/spf13/cobra"
+)
+
+var (
+	cacheCmd = &cobra.Command{
+		Use:   "cache",
+		Short: "administrate the caches of the users",
+	}
+
+	cacheClearCmd = &cobra.Command{
+		Use:   "clear [user]",
+		Short: "drop the last known cars and the stats of a user or of all users",
+		Long: `clear removes the cached last frame and the stats of a user (telegram id or name) or of all users,
+so the next update of the bot reports all current cars as new. It refuses to run while a bot is running on the userdata.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			query := ""
+			if len(args) > 0 {
+				query = args[0]
+			}
+
+			return updateUserData(func(userMap *config.UserMap) error {
+				users, err := findUsers(userMap, query)
+				if err != nil {
+					return err
+				}
+
+				for _, user := range users {
+					user.ClearCache()
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "cleared the cache of %d users\n", len(users))
+
+				return nil
+			})
+		},
+	}
+)
+
+func init() {
+	addUserDataFileFlag(cacheCmd)
+
+	cacheCmd.AddCommand(cacheClearCmd)
+}
@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/khase/leaseplan-bot/lpbot/config"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	configCmd = &cobra.Command{
+		Use:   "config",
+		Short: "inspect the configuration of the bot",
+	}
+
+	configCheckCmd = &cobra.Command{
+		Use:   "check",
+		Short: "print the effective config and validate it",
+		Long: `check prints the config the bot would start with, i.e. the config file and environment merged with the
+defaults of the start command, with the token, passwords and keys redacted. It fails if the config is invalid,
+e.g. if the telegram token is missing, the watcherDelay is not positive or the cacheDir is not writable.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			botConfig := config.LoadBotConfig()
+
+			configFile := viper.ConfigFileUsed()
+			if configFile == "" {
+				configFile = "none"
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "# config file: %s\n", configFile)
+
+			data, err := yaml.Marshal(botConfig.Redacted())
+			if err != nil {
+				return err
+			}
+			fmt.Fprint(cmd.OutOrStdout(), string(data))
+
+			if err := botConfig.Validate(false); err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), "# config is valid")
+
+			return nil
+		},
+	}
+)
+
+func init() {
+	configCmd.AddCommand(configCheckCmd)
+}
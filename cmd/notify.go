@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/khase/leaseplan-bot/lpbot/config"
+	"github.com/spf13/cobra"
+)
+
+var (
+	notifyOptions []string
+
+	notifyCmd = &cobra.Command{
+		Use:   "notify",
+		Short: "administrate the system notifications",
+	}
+
+	notifySendCmd = &cobra.Command{
+		Use:   "send <message>...",
+		Short: "add a system notification sent by the bot",
+		Long: `send stores a system notification in the userdata like /admin notify add. The bot sends it to all
+matching users once it is due and the bot is running. The options are the ones of /admin notify add, e.g.
+  notify send -o at=2023-05-01T10:00 -o level=abc -o action=clearCache "Neue Autos ab Montag"
+It refuses to run while a bot is running on the userdata, use /admin notify add instead.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			message := strings.TrimSpace(strings.Join(args, " "))
+			if message == "" {
+				return fmt.Errorf("the message must not be empty")
+			}
+
+			notification := config.NewSystemNotification(time.Now(), message)
+			notification.CreatedBy = "cli"
+			for _, option := range notifyOptions {
+				key, value, _ := strings.Cut(option, "=")
+				if err := notification.SetOption(key, value); err != nil {
+					return fmt.Errorf("invalid option %s: %w", option, err)
+				}
+			}
+
+			return updateUserData(func(userMap *config.UserMap) error {
+				userMap.AddNotification(notification)
+				if err := userMap.Save(); err != nil {
+					return err
+				}
+
+				fmt.Fprintf(cmd.OutOrStdout(), "notification #%d will be published at %s\n", notification.Id, notification.Publish.Format("2006-01-02 15:04"))
+				return nil
+			})
+		},
+	}
+)
+
+func init() {
+	addUserDataFileFlag(notifyCmd)
+	notifySendCmd.Flags().StringArrayVarP(&notifyOptions, "option", "o", []string{}, "option of the notification as key=value (at, watcher, level, filters, lang, action), can be repeated")
+
+	notifyCmd.AddCommand(notifySendCmd)
+}
@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/khase/leaseplan-bot/lpbot/config"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	replayUserDataFile   string
+	replayUser           string
+	replayFilters        []string
+	replaySummaryMessage string
+	replayDetailMessage  string
+	replayJson           bool
+
+	replayCmd = &cobra.Command{
+		Use:   "replay <dataframe file>...",
+		Short: "print the messages a user would get for recorded dataframes",
+		Long: `replay loads recorded dataframes (e.g. cache/<id>.lastframe or testdata/*.dataframe.yaml),
+applies the filters and templates of a user from the userdata file (or the defaults when no user is given),
+and prints the messages that would be sent. Filters and templates can be overwritten with flags.
+Nothing is sent and the userdata file is not modified.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			user, err := getReplayUser(cmd)
+			if err != nil {
+				return err
+			}
+
+			results := make([]*config.ReplayResult, 0, len(args))
+			for _, file := range args {
+				frame, err := config.LoadDataFrameFile(file)
+				if err != nil {
+					return fmt.Errorf("could not load %s: %w", file, err)
+				}
+
+				result, err := config.ReplayFrame(frame, user)
+				if err != nil {
+					return fmt.Errorf("could not replay %s: %w", file, err)
+				}
+				result.File = file
+				results = append(results, result)
+			}
+
+			if replayJson {
+				encoder := json.NewEncoder(cmd.OutOrStdout())
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(results)
+			}
+			for _, result := range results {
+				printReplayResult(cmd.OutOrStdout(), result)
+			}
+
+			return nil
+		},
+	}
+)
+
+func init() {
+	replayCmd.Flags().StringVarP(&replayUserDataFile, "userDataFile", "u", "", "path to file containing all user data (defaults to the userDataFile of the config)")
+	replayCmd.Flags().StringVar(&replayUser, "user", "", "telegram id or name of the user whose settings are replayed (empty uses the default settings)")
+	replayCmd.Flags().StringArrayVarP(&replayFilters, "filter", "f", []string{}, "filter replacing the filters of the user (can be repeated)")
+	replayCmd.Flags().StringVar(&replaySummaryMessage, "summaryTemplate", "", "summary message template replacing the one of the user")
+	replayCmd.Flags().StringVar(&replayDetailMessage, "detailTemplate", "", "detail message template replacing the one of the user")
+	replayCmd.Flags().BoolVar(&replayJson, "json", false, "print the result as json")
+}
+
+func getReplayUser(cmd *cobra.Command) (*config.User, error) {
+	user := config.NewUser(nil, 0, "replay")
+	user.WatcherActive = true
+
+	if replayUser != "" {
+		userDataFile := replayUserDataFile
+		if userDataFile == "" {
+			userDataFile = viper.GetString("userDataFile")
+		}
+
+		userMap, err := config.ReadUserMap(userDataFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read userdata: %w", err)
+		}
+		user, err = userMap.FindUser(replayUser)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if cmd.Flags().Changed("filter") {
+		user.Filters = replayFilters
+	}
+	if replaySummaryMessage != "" {
+		user.SummaryMessageTemplate = replaySummaryMessage
+	}
+	if replayDetailMessage != "" {
+		user.DetailMessageTemplate = replayDetailMessage
+	}
+
+	return user, nil
+}
+
+func printReplayResult(out io.Writer, result *config.ReplayResult) {
+	fmt.Fprintf(out, "== %s ==\n", result.File)
+	fmt.Fprintf(out, "after filters: %d -> %d (+%d, -%d)\n", result.Previous, result.Current, result.Added, result.Removed)
+
+	if len(result.Rejected) > 0 {
+		fmt.Fprintln(out, "new cars rejected by filters:")
+		for _, car := range result.Rejected {
+			fmt.Fprintf(out, "  %s (%s): %s\n", car.Name, car.Ident, car.Filter)
+		}
+	}
+	for _, note := range result.Notes {
+		fmt.Fprintf(out, "note: %s\n", note)
+	}
+	for i, message := range result.Messages {
+		fmt.Fprintf(out, "--- message %d ---\n%s\n", i+1, strings.TrimRight(message.Text, "\n"))
+	}
+	fmt.Fprintln(out)
+}
@@ -1,9 +1,12 @@
 package cmd
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"os"
 
+	"github.com/khase/leaseplan-bot/lpbot/config"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -34,6 +37,11 @@ func init() {
 	viper.BindPFlag("useViper", rootCmd.PersistentFlags().Lookup("viper"))
 
 	rootCmd.AddCommand(startCmd)
+	rootCmd.AddCommand(replayCmd)
+	rootCmd.AddCommand(usersCmd)
+	rootCmd.AddCommand(cacheCmd)
+	rootCmd.AddCommand(notifyCmd)
+	rootCmd.AddCommand(configCmd)
 }
 
 func initConfig() {
@@ -49,13 +57,19 @@ func initConfig() {
 		viper.AddConfigPath(home)
 		viper.SetConfigType("yaml")
 		viper.SetConfigName(".leaseplanbot")
-
-		viper.SafeWriteConfigAs(home + "/.leaseplanbot")
 	}
 
 	viper.AutomaticEnv()
 
-	if err := viper.ReadInConfig(); err == nil {
+	// the config file is optional unless given by flag
+	err := viper.ReadInConfig()
+	var notFound viper.ConfigFileNotFoundError
+	if err == nil {
 		log.Println("Using config file:", viper.ConfigFileUsed())
+	} else if !errors.As(err, &notFound) {
+		cobra.CheckErr(fmt.Errorf("could not read config file: %w", err))
 	}
+
+	// the command line tools work on the same caches as the bot
+	config.SetCacheDir(viper.GetString("cacheDir"))
 }
@@ -1,28 +1,28 @@
 package cmd
 
 import (
+	"context"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/khase/leaseplan-bot/lpbot"
+	"github.com/khase/leaseplan-bot/lpbot/config"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
 var (
-	token           string
-	watcherDelay    int
-	watcherPageSize int
-	debug           bool
-
-	userDataFile string
-	createNew    bool
-
 	startCmd = &cobra.Command{
 		Use:   "start",
 		Short: "start the leaseplan bot",
 		Long:  `start the leaseplan bot`,
 		Run: func(cmd *cobra.Command, args []string) {
-			err := startBot(token, userDataFile, createNew, debug)
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			err := lpbot.StartBot(ctx, config.LoadBotConfig())
 			if err != nil {
 				log.Fatal("Bot loop reportet an fatal error: ", err)
 			}
@@ -31,20 +31,46 @@ var (
 )
 
 func init() {
-	startCmd.PersistentFlags().StringVarP(&token, "token", "t", "", "token to be used for telegram auth")
-	startCmd.PersistentFlags().IntVarP(&watcherDelay, "watcherDelay", "w", 15, "polling delay for watchers in minutes")
-	startCmd.PersistentFlags().IntVarP(&watcherPageSize, "watcherPageSize", "n", 20, "pagesize the watchers should use for querying the leaseplan api")
-	startCmd.PersistentFlags().StringVarP(&userDataFile, "userDataFile", "u", "./leaseplan-bot.userdata", "path to file containing all user data")
-	startCmd.PersistentFlags().BoolVar(&createNew, "new", false, "if the userDataFile does not exist the bot will create a new database")
-	startCmd.PersistentFlags().BoolVarP(&debug, "debug", "d", false, "weather or not the bot should be started in debug mode")
+	startCmd.PersistentFlags().StringP("token", "t", "", "token to be used for telegram auth")
+	startCmd.PersistentFlags().IntP("watcherDelay", "w", 15, "polling delay for watchers in minutes")
+	startCmd.PersistentFlags().IntP("watcherPageSize", "n", 20, "pagesize the watchers should use for querying the leaseplan api")
+	startCmd.PersistentFlags().Int("tokenWarningHours", 24, "warn users this many hours before their leaseplan token expires (0 disables the warning)")
+	startCmd.PersistentFlags().Int("sendDelay", config.DefaultSendDelay, "delay in minutes before changes are sent to non admin users (0 sends them right away)")
+	startCmd.PersistentFlags().StringP("userDataFile", "u", "./leaseplan-bot.userdata", "path to file containing all user data")
+	startCmd.PersistentFlags().String("cacheDir", config.DefaultCacheDir, "directory the caches and stats of the users are stored in")
+	startCmd.PersistentFlags().String("auditLogFile", "./leaseplan-bot.audit.log", "path to the file admin actions are logged to (empty disables the audit log)")
+	startCmd.PersistentFlags().StringSlice("apiAdminKey", []string{}, "api key granting admin access to the http api (can be repeated)")
+	startCmd.PersistentFlags().Bool("api", true, "weather or not the http api should be served")
+	startCmd.PersistentFlags().String("apiAddress", ":2112", "address the http api listens on")
+	startCmd.PersistentFlags().String("apiTlsCert", "", "path to a tls certificate for serving the http api via https (requires --apiTlsKey)")
+	startCmd.PersistentFlags().String("apiTlsKey", "", "path to the private key of the tls certificate (requires --apiTlsCert)")
+	startCmd.PersistentFlags().String("smtpHost", "", "smtp server used to send email notifications (empty disables email)")
+	startCmd.PersistentFlags().Int("smtpPort", 587, "port of the smtp server")
+	startCmd.PersistentFlags().String("smtpUsername", "", "username for the smtp server (empty disables authentication)")
+	startCmd.PersistentFlags().String("smtpPassword", "", "password for the smtp server")
+	startCmd.PersistentFlags().String("smtpFrom", "", "sender address of email notifications")
+	startCmd.PersistentFlags().Bool("smtpStartTls", true, "weather or not the connection to the smtp server should be upgraded via STARTTLS")
+	startCmd.PersistentFlags().Bool("new", false, "if the userDataFile does not exist the bot will create a new database")
+	startCmd.PersistentFlags().BoolP("debug", "d", false, "weather or not the bot should be started in debug mode")
 	viper.BindPFlag("telegramApiToken", startCmd.PersistentFlags().Lookup("token"))
 	viper.BindPFlag("watcherDelay", startCmd.PersistentFlags().Lookup("watcherDelay"))
 	viper.BindPFlag("watcherPageSize", startCmd.PersistentFlags().Lookup("watcherPageSize"))
+	viper.BindPFlag("tokenWarningHours", startCmd.PersistentFlags().Lookup("tokenWarningHours"))
+	viper.BindPFlag("sendDelay", startCmd.PersistentFlags().Lookup("sendDelay"))
 	viper.BindPFlag("userDataFile", startCmd.PersistentFlags().Lookup("userDataFile"))
+	viper.BindPFlag("cacheDir", startCmd.PersistentFlags().Lookup("cacheDir"))
+	viper.BindPFlag("auditLogFile", startCmd.PersistentFlags().Lookup("auditLogFile"))
+	viper.BindPFlag("apiAdminKeys", startCmd.PersistentFlags().Lookup("apiAdminKey"))
+	viper.BindPFlag("apiEnabled", startCmd.PersistentFlags().Lookup("api"))
+	viper.BindPFlag("apiAddress", startCmd.PersistentFlags().Lookup("apiAddress"))
+	viper.BindPFlag("apiTlsCert", startCmd.PersistentFlags().Lookup("apiTlsCert"))
+	viper.BindPFlag("apiTlsKey", startCmd.PersistentFlags().Lookup("apiTlsKey"))
+	viper.BindPFlag("smtpHost", startCmd.PersistentFlags().Lookup("smtpHost"))
+	viper.BindPFlag("smtpPort", startCmd.PersistentFlags().Lookup("smtpPort"))
+	viper.BindPFlag("smtpUsername", startCmd.PersistentFlags().Lookup("smtpUsername"))
+	viper.BindPFlag("smtpPassword", startCmd.PersistentFlags().Lookup("smtpPassword"))
+	viper.BindPFlag("smtpFrom", startCmd.PersistentFlags().Lookup("smtpFrom"))
+	viper.BindPFlag("smtpStartTls", startCmd.PersistentFlags().Lookup("smtpStartTls"))
 	viper.BindPFlag("new", startCmd.PersistentFlags().Lookup("new"))
 	viper.BindPFlag("debug", startCmd.PersistentFlags().Lookup("debug"))
 }
-
-func startBot(apiToken string, userDataFile string, createNew bool, debug bool) error {
-	return lpbot.StartBot(apiToken, debug, userDataFile, createNew, watcherDelay, watcherPageSize)
-}
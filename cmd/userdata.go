@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/khase/leaseplan-bot/lpbot/config"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	// userDataFile of the commands administrating the userdata (users,
+	// cache, notify)
+	adminUserDataFile string
+)
+
+func addUserDataFileFlag(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringVarP(&adminUserDataFile, "userDataFile", "u", "", "path to file containing all user data (defaults to the userDataFile of the config)")
+}
+
+func getUserDataFile() string {
+	if adminUserDataFile != "" {
+		return adminUserDataFile
+	}
+
+	return viper.GetString("userDataFile")
+}
+
+// readUserData reads the userdata for commands which do not change it, they
+// can run next to the bot.
+func readUserData() (*config.UserMap, error) {
+	userMap, err := config.ReadUserMap(getUserDataFile())
+	if err != nil {
+		return nil, fmt.Errorf("could not read userdata: %w", err)
+	}
+
+	return userMap, nil
+}
+
+// updateUserData locks the userdata while it is changed. It fails if a bot is
+// running on the same file, as the bot would overwrite the changes with its
+// own state. The userdata has to be saved by change.
+func updateUserData(change func(userMap *config.UserMap) error) error {
+	userDataFile := getUserDataFile()
+	lock, err := config.LockUserData(userDataFile)
+	if errors.Is(err, config.ErrUserDataLocked) {
+		return fmt.Errorf("%s is used by a running bot, stop it first or use the /admin commands", userDataFile)
+	} else if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	userMap, err := readUserData()
+	if err != nil {
+		return err
+	}
+
+	return change(userMap)
+}
+
+// findUsers returns the user matching the query or all users if the query
+// is empty.
+func findUsers(userMap *config.UserMap, query string) ([]*config.User, error) {
+	if query == "" {
+		return userMap.SortedUsers(), nil
+	}
+
+	user, err := userMap.FindUser(query)
+	if err != nil {
+		return nil, err
+	}
+
+	return []*config.User{user}, nil
+}
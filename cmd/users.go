@@ -0,0 +1,265 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/khase/leaseplan-bot/lpbot/config"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	usersJson        bool
+	usersShowSecrets bool
+	usersOverwrite   bool
+
+	usersCmd = &cobra.Command{
+		Use:   "users",
+		Short: "administrate the users of the userdata file",
+		Long: `users lists, shows, changes, deletes, exports and imports the users of the userdata file.
+Commands changing the userdata lock it and refuse to run while a bot is running on the same file.`,
+	}
+
+	usersListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "list all users and chats",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			userMap, err := readUserData()
+			if err != nil {
+				return err
+			}
+
+			users := userMap.SortedUsers()
+			if usersJson {
+				summaries := make([]userSummary, 0, len(users))
+				for _, user := range users {
+					summaries = append(summaries, getUserSummary(user))
+				}
+				encoder := json.NewEncoder(cmd.OutOrStdout())
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(summaries)
+			}
+
+			writer := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			fmt.Fprintln(writer, "ID\tNAME\tTYPE\tLEVEL\tWATCHER\tFILTERS\tNOTIFIER")
+			for _, user := range users {
+				summary := getUserSummary(user)
+				fmt.Fprintf(writer, "%d\t%s\t%s\t%s\t%s\t%d\t%s\n", summary.UserId, summary.Name, summary.Type, summary.LevelKey, summary.Watcher, summary.Filters, summary.Notifier)
+			}
+			return writer.Flush()
+		},
+	}
+
+	usersShowCmd = &cobra.Command{
+		Use:   "show <user>",
+		Short: "print the settings of a user as yaml",
+		Long:  "show prints the settings of a user (telegram id or name) as yaml. Tokens and secrets are redacted unless --secrets is given.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			userMap, err := readUserData()
+			if err != nil {
+				return err
+			}
+			user, err := userMap.FindUser(args[0])
+			if err != nil {
+				return err
+			}
+
+			if !usersShowSecrets {
+				user = user.Redacted()
+			}
+			info, err := user.GetHumanReadableUserInfo()
+			if err != nil {
+				return err
+			}
+			fmt.Fprint(cmd.OutOrStdout(), info)
+
+			return nil
+		},
+	}
+
+	usersSetCmd = &cobra.Command{
+		Use:   "set <user> <field> <value>",
+		Short: "change a single setting of a user",
+		Long: fmt.Sprintf(`set changes a setting of a user (telegram id or name) by its yaml key, e.g.
+  users set 123456 SummaryMessageTemplate '{{ len .Added }} neue Autos'
+  users set bob Filters '["lt .SalaryWaiver 500"]'
+Lists and maps are given as yaml, times as RFC 3339. The change is validated
+like the bot commands do, e.g. templates have to render. Fields:
+  %s`, strings.Join(config.UserFields(), ", ")),
+		Args: cobra.ExactArgs(3),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return updateUserData(func(userMap *config.UserMap) error {
+				user, err := userMap.FindUser(args[0])
+				if err != nil {
+					return err
+				}
+				if err := user.SetField(args[1], args[2]); err != nil {
+					return err
+				}
+				if err := userMap.Save(); err != nil {
+					return err
+				}
+
+				fmt.Fprintf(cmd.OutOrStdout(), "set %s of %s(%d)\n", args[1], user.FriendlyName, user.UserId)
+				return nil
+			})
+		},
+	}
+
+	usersDeleteCmd = &cobra.Command{
+		Use:   "delete <user>",
+		Short: "delete a user, its cache and its stats",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return updateUserData(func(userMap *config.UserMap) error {
+				user, err := userMap.FindUser(args[0])
+				if err != nil {
+					return err
+				}
+
+				userMap.DeleteUser(user.UserId)
+				if err := userMap.Save(); err != nil {
+					return err
+				}
+				user.ClearCache()
+
+				fmt.Fprintf(cmd.OutOrStdout(), "deleted %s(%d)\n", user.FriendlyName, user.UserId)
+				return nil
+			})
+		},
+	}
+
+	usersExportCmd = &cobra.Command{
+		Use:   "export [user]...",
+		Short: "export users as yaml",
+		Long: `export writes the given users (telegram ids or names), or all users, in the format of the userdata file
+to stdout. The export contains all tokens and secrets and can be read by import.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			userMap, err := readUserData()
+			if err != nil {
+				return err
+			}
+
+			export := config.NewUserMap("")
+			users := userMap.SortedUsers()
+			if len(args) > 0 {
+				users = []*config.User{}
+			}
+			for _, query := range args {
+				user, err := userMap.FindUser(query)
+				if err != nil {
+					return err
+				}
+				users = append(users, user)
+			}
+			for _, user := range users {
+				export.SetUser(user)
+			}
+
+			data, err := yaml.Marshal(export)
+			if err != nil {
+				return err
+			}
+			_, err = cmd.OutOrStdout().Write(data)
+			return err
+		},
+	}
+
+	usersImportCmd = &cobra.Command{
+		Use:   "import <file>",
+		Short: "import users exported with export",
+		Long: `import adds the users of a file written by export (or of another userdata file) to the userdata.
+All users are validated before anything is changed. Existing users are skipped unless --overwrite is given.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			imported, err := config.ReadUserMap(args[0])
+			if err != nil {
+				return fmt.Errorf("could not read %s: %w", args[0], err)
+			}
+			for _, user := range imported.SortedUsers() {
+				if err := user.Validate(); err != nil {
+					return fmt.Errorf("%s(%d): %w", user.FriendlyName, user.UserId, err)
+				}
+			}
+
+			return updateUserData(func(userMap *config.UserMap) error {
+				added, skipped := 0, 0
+				for _, user := range imported.SortedUsers() {
+					if userMap.GetUser(user.UserId) != nil && !usersOverwrite {
+						fmt.Fprintf(cmd.OutOrStdout(), "skipped existing %s(%d)\n", user.FriendlyName, user.UserId)
+						skipped++
+						continue
+					}
+					userMap.SetUser(user)
+					added++
+				}
+				if err := userMap.Save(); err != nil {
+					return err
+				}
+
+				fmt.Fprintf(cmd.OutOrStdout(), "imported %d users, skipped %d\n", added, skipped)
+				return nil
+			})
+		},
+	}
+)
+
+func init() {
+	addUserDataFileFlag(usersCmd)
+	usersListCmd.Flags().BoolVar(&usersJson, "json", false, "print the users as json")
+	usersShowCmd.Flags().BoolVar(&usersShowSecrets, "secrets", false, "print tokens and secrets")
+	usersImportCmd.Flags().BoolVar(&usersOverwrite, "overwrite", false, "replace existing users")
+
+	usersCmd.AddCommand(usersListCmd)
+	usersCmd.AddCommand(usersShowCmd)
+	usersCmd.AddCommand(usersSetCmd)
+	usersCmd.AddCommand(usersDeleteCmd)
+	usersCmd.AddCommand(usersExportCmd)
+	usersCmd.AddCommand(usersImportCmd)
+}
+
+type userSummary struct {
+	UserId   int64  `json:"UserId"`
+	Name     string `json:"Name"`
+	Type     string `json:"Type"`
+	LevelKey string `json:"LevelKey"`
+	Watcher  string `json:"Watcher"`
+	Filters  int    `json:"Filters"`
+	Notifier string `json:"Notifier"`
+}
+
+func getUserSummary(user *config.User) userSummary {
+	summary := userSummary{
+		UserId:   user.UserId,
+		Name:     user.FriendlyName,
+		Type:     "user",
+		LevelKey: user.LeaseplanLevelKey,
+		Watcher:  "paused",
+		Filters:  len(user.Filters),
+		Notifier: user.Notifier,
+	}
+	if user.IsChat() {
+		summary.Type = user.ChatType
+	}
+	if user.IsAdmin {
+		summary.Type = "admin"
+	}
+	switch {
+	case user.Banned:
+		summary.Watcher = "banned"
+	case user.WatcherActive:
+		summary.Watcher = "active"
+	case user.WatcherAuthSuspended:
+		summary.Watcher = "suspended"
+	}
+	if summary.Notifier == "" {
+		summary.Notifier = config.NotifierTelegram
+	}
+
+	return summary
+}
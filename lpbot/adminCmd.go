@@ -0,0 +1,239 @@
+package lpbot
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/khase/leaseplan-bot/lpbot/config"
+	"github.com/khase/leaseplan-bot/lpbot/lpcon"
+	"github.com/khase/leaseplan-bot/lpbot/tgcon"
+)
+
+const (
+	adminUsage = "/admin users\n/admin pause <user>\n/admin resume <user>\n/admin ban <user>\n/admin unban <user>\n/admin broadcast <text>\n/admin watchers\n/admin reload\n/admin notify"
+)
+
+var (
+	AdminCmd = &tgcon.MessageCommand{
+		CommandTrigger:   "admin",
+		ShortDescription: "Benutzer- und Botverwaltung",
+		Description:      adminUsage,
+		AdminOnly:        true,
+		Execute: func(message *tgbotapi.Message) ([]tgbotapi.Chattable, error) {
+			return handleAdminCommand(message, UserMap.GetUser(message.From.ID))
+		},
+	}
+)
+
+// checkCommandPermission is used by the telegram connector to gate commands.
+// Banned users can not execute any command, admin commands require IsAdmin
+// and commands in groups are reserved to the admins of the group.
+func checkCommandPermission(message *tgbotapi.Message, cmd *tgcon.MessageCommand) error {
+	user := UserMap.GetUser(message.From.ID)
+	if user != nil && user.Banned {
+		return tgcon.ErrUserBanned
+	}
+	if cmd.AdminOnly && (user == nil || !user.IsAdmin) {
+		return tgcon.ErrCommandPermitted
+	}
+	if !message.Chat.IsPrivate() {
+		return checkChatPermission(message, cmd, user)
+	}
+
+	return nil
+}
+
+func handleAdminCommand(message *tgbotapi.Message, admin *config.User) ([]tgbotapi.Chattable, error) {
+	if admin == nil {
+		return nil, tgcon.ErrCommandPermittedForUnknownUser
+	}
+
+	args := strings.Fields(message.CommandArguments())
+	if len(args) == 0 {
+		return replyText(message, fmt.Sprintf("Verfügbare Admin Kommandos:\n%s", adminUsage)), nil
+	}
+
+	switch args[0] {
+	case "users":
+		return handleAdminUsers(message), nil
+	case "watchers":
+		return handleAdminWatchers(message), nil
+	case "reload":
+		return handleAdminReload(message, admin), nil
+	case "notify":
+		return handleAdminNotify(message, admin, strings.TrimSpace(strings.TrimPrefix(message.CommandArguments(), args[0])))
+	case "broadcast":
+		return handleAdminBroadcast(message, admin, strings.TrimSpace(strings.TrimPrefix(message.CommandArguments(), args[0])))
+	case "pause", "resume", "ban", "unban":
+		if len(args) < 2 {
+			return replyText(message, fmt.Sprintf("Bitte gib einen Benutzer an: /admin %s <id oder name>", args[0])), nil
+		}
+		return handleAdminUserAction(message, admin, args[0], strings.Join(args[1:], " "))
+	}
+
+	return replyText(message, fmt.Sprintf("Ich kann mit '%s' leider nichts anfangen 😨", args[0])), nil
+}
+
+func handleAdminUsers(message *tgbotapi.Message) []tgbotapi.Chattable {
+	lines := []string{fmt.Sprintf("%d Benutzer:", UserMap.CountUsers())}
+	for _, user := range UserMap.SortedUsers() {
+		line := fmt.Sprintf("%s %s (%d), level: %s", getUserStatusIcon(user), user.FriendlyName, user.UserId, user.LeaseplanLevelKey)
+		if user.IsChat() {
+			line += fmt.Sprintf(", %s von %d", user.ChatType, user.OwnerId)
+		}
+		if user.WatcherError != "" {
+			line += fmt.Sprintf(", Fehler: %s", user.WatcherError)
+		}
+		lines = append(lines, line)
+	}
+
+	return replyLines(message, lines)
+}
+
+func getUserStatusIcon(user *config.User) string {
+	switch {
+	case user.Banned:
+		return "⛔"
+	case user.WatcherActive:
+		return "▶️"
+	case user.WatcherAuthSuspended:
+		return "🔑"
+	default:
+		return "⏸"
+	}
+}
+
+func handleAdminWatchers(message *tgbotapi.Message) []tgbotapi.Chattable {
+	states := lpcon.GetStates()
+	if len(states) == 0 {
+		return replyText(message, "Es laufen aktuell keine Watcher.")
+	}
+
+	keys := make([]string, 0, len(states))
+	for key := range states {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	lines := []string{}
+	for _, key := range keys {
+		state := states[key]
+		line := fmt.Sprintf("%s: aktiv: %t, Benutzer: %d, Autos: %d, letzter Abruf: %s (%s)", key, state.IsActive, state.UserCount, state.CurrentCarCount, state.Poll.StartTime, state.Poll.Duration)
+		if state.Poll.IsActive {
+			line += ", Abruf läuft"
+		}
+		lines = append(lines, line)
+	}
+
+	return replyLines(message, lines)
+}
+
+func handleAdminReload(message *tgbotapi.Message, admin *config.User) []tgbotapi.Chattable {
+	audit(admin, "reload")
+
+	report, err := Reload()
+	if err != nil {
+		return replyText(message, fmt.Sprintf("Das Neuladen ist leider fehlgeschlagen 😵: %s", err))
+	}
+
+	return replyText(message, fmt.Sprintf("Neu geladen 🔄\n%s", report))
+}
+
+func handleAdminBroadcast(message *tgbotapi.Message, admin *config.User, text string) ([]tgbotapi.Chattable, error) {
+	if text == "" {
+		return replyText(message, "Bitte gib einen Text an: /admin broadcast <text>"), nil
+	}
+
+	messages := []tgbotapi.Chattable{}
+	for _, user := range UserMap.SortedUsers() {
+		if user.Banned || user.IsChat() {
+			continue
+		}
+		messages = append(messages, tgbotapi.NewMessage(user.UserId, text))
+	}
+	audit(admin, "broadcast to %d users: %s", len(messages), text)
+
+	return append(messages, replyText(message, fmt.Sprintf("Nachricht an %d Benutzer gesendet 📣", len(messages)))...), nil
+}
+
+func handleAdminUserAction(message *tgbotapi.Message, admin *config.User, action string, query string) ([]tgbotapi.Chattable, error) {
+	user, err := UserMap.FindUser(query)
+	if errors.Is(err, config.ErrUserNotFound) || errors.Is(err, config.ErrUserAmbiguous) {
+		return replyText(message, fmt.Sprintf("Benutzer konnte nicht eindeutig bestimmt werden: %s", err)), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var text string
+	switch action {
+	case "pause":
+		user.StopWatcher()
+		lpcon.UnregisterUserWatcher(user)
+		text = fmt.Sprintf("Watcher von %s(%d) pausiert ⏸", user.FriendlyName, user.UserId)
+	case "resume":
+		if user.Banned {
+			return replyText(message, fmt.Sprintf("%s(%d) ist gesperrt, entsperre den Benutzer zuerst mit /admin unban", user.FriendlyName, user.UserId)), nil
+		}
+		user.StartWatcher()
+		lpcon.RegisterUserWatcher(user)
+		text = fmt.Sprintf("Watcher von %s(%d) aktiviert ▶️", user.FriendlyName, user.UserId)
+	case "ban":
+		user.Ban()
+		lpcon.UnregisterUserWatcher(user)
+		text = fmt.Sprintf("%s(%d) wurde gesperrt ⛔", user.FriendlyName, user.UserId)
+	case "unban":
+		user.Unban()
+		text = fmt.Sprintf("%s(%d) wurde entsperrt ✅", user.FriendlyName, user.UserId)
+	}
+
+	user.Save()
+	audit(admin, "%s %s(%d)", action, user.FriendlyName, user.UserId)
+
+	return replyText(message, text), nil
+}
+
+func replyText(message *tgbotapi.Message, text string) []tgbotapi.Chattable {
+	msg := tgbotapi.NewMessage(
+		message.Chat.ID,
+		text)
+	msg.ReplyToMessageID = message.MessageID
+
+	return []tgbotapi.Chattable{msg}
+}
+
+// replyLater runs slow work like requests to other servers in the background,
+// so the bot keeps answering messages meanwhile. The text returned by work is
+// sent as reply to the message.
+func replyLater(message *tgbotapi.Message, work func() string) {
+	go func() {
+		for _, reply := range replyText(message, work()) {
+			if err := tgcon.Send(tgConnector.GetTgBotApi(), reply); err != nil {
+				log.Printf("Could not send reply to %d: %s", message.Chat.ID, err)
+			}
+		}
+	}()
+}
+
+// replyLines splits long listings into multiple messages so they stay below
+// the telegram message size limit.
+func replyLines(message *tgbotapi.Message, lines []string) []tgbotapi.Chattable {
+	messages := []tgbotapi.Chattable{}
+	buf := new(bytes.Buffer)
+	for _, line := range lines {
+		if buf.Len()+len(line) > 3500 {
+			messages = append(messages, tgbotapi.NewMessage(message.Chat.ID, buf.String()))
+			buf.Reset()
+		}
+		buf.WriteString(fmt.Sprintf("%s\n", line))
+	}
+	if buf.Len() > 0 {
+		messages = append(messages, tgbotapi.NewMessage(message.Chat.ID, buf.String()))
+	}
+
+	return messages
+}
@@ -14,8 +14,9 @@ var (
 		CommandTrigger:   "filter",
 		ShortDescription: "setzt einen Filter für Benachrichtigungen",
 		Description:      "",
+		GroupCommand:     true,
 		Execute: func(message *tgbotapi.Message) ([]tgbotapi.Chattable, error) {
-			return handleFilterCommand(message, UserMap.Users[message.From.ID])
+			return handleFilterCommand(message, getSubscriber(message))
 		},
 	}
 	ExcelCmd = &tgcon.MessageCommand{
@@ -23,7 +24,7 @@ var (
 		ShortDescription: "erstellt eine excel liste aller verfügbaren Autos",
 		Description:      "",
 		Execute: func(message *tgbotapi.Message) ([]tgbotapi.Chattable, error) {
-			return handleExcelCommand(message, UserMap.Users[message.From.ID])
+			return handleExcelCommand(message, UserMap.GetUser(message.From.ID))
 		},
 	}
 )
@@ -1,7 +1,12 @@
 package api
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"time"
 
@@ -10,17 +15,117 @@ import (
 
 var (
 	startTime time.Time
+
+	ErrTlsConfigIncomplete = errors.New("tls requires both a certificate and a key file")
 )
 
-func InitAndListen() error {
+// ServerConfig holds the settings of the http server serving the api.
+type ServerConfig struct {
+	Enabled     bool
+	Address     string
+	TlsCertFile string
+	TlsKeyFile  string
+}
+
+func (config ServerConfig) usesTls() bool {
+	return config.TlsCertFile != "" || config.TlsKeyFile != ""
+}
+
+// Listen validates the tls settings and binds the configured address, so
+// errors like a port already in use show up before the bot starts.
+func Listen(config ServerConfig) (net.Listener, error) {
+	if config.usesTls() {
+		if config.TlsCertFile == "" || config.TlsKeyFile == "" {
+			return nil, ErrTlsConfigIncomplete
+		}
+		if _, err := tls.LoadX509KeyPair(config.TlsCertFile, config.TlsKeyFile); err != nil {
+			return nil, fmt.Errorf("could not load tls certificate: %w", err)
+		}
+	}
+
+	return net.Listen("tcp", config.Address)
+}
+
+// NewServer creates the http server for the api with timeouts set. There is
+// no write timeout as the event stream keeps its response open, requests are
+// ended through their context on shutdown instead.
+func NewServer(ctx context.Context) *http.Server {
+	return &http.Server{
+		Handler:           NewRouter(),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+		BaseContext: func(net.Listener) context.Context {
+			return ctx
+		},
+	}
+}
+
+// Serve answers requests on the listener until the context is cancelled.
+func Serve(ctx context.Context, listener net.Listener, config ServerConfig) error {
 	startTime = time.Now()
 
-	log.Printf("Setting up http api...")
-	http.Handle("/metrics", promhttp.Handler())
-	http.HandleFunc("/health", getHealth)
-	http.HandleFunc("/state", getState)
-	http.HandleFunc("/cars", getCars)
+	server := NewServer(ctx)
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	var err error
+	if config.usesTls() {
+		log.Printf("Listening for requests on https://%s.", listener.Addr())
+		err = server.ServeTLS(listener, config.TlsCertFile, config.TlsKeyFile)
+	} else {
+		log.Printf("Listening for requests on http://%s.", listener.Addr())
+		err = server.Serve(listener)
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+
+	return err
+}
+
+// NewRouter creates the handler serving all api endpoints. Everything except
+// /health requires an api key.
+func NewRouter() http.Handler {
+	router := http.NewServeMux()
+
+	router.Handle("/health", methods{http.MethodGet: getHealth})
+	router.Handle("/metrics", methods{http.MethodGet: requireAdmin(promhttp.Handler().ServeHTTP)})
+
+	router.HandleFunc("/api/v1/", notFound)
+	router.Handle("/api/v1/openapi.json", methods{http.MethodGet: getOpenApi})
+	router.Handle("/api/v1/health", methods{http.MethodGet: getHealth})
+	router.Handle("/api/v1/state", methods{http.MethodGet: requireAdmin(getState)})
+	router.Handle("/api/v1/cars", methods{http.MethodGet: requireAdmin(getCars)})
+	router.Handle("/api/v1/stats", methods{http.MethodGet: requireAdmin(getStats)})
+	router.Handle("/api/v1/compare", methods{http.MethodGet: requireAdmin(getCompare)})
+	router.Handle("/api/v1/events", methods{http.MethodGet: getEvents})
+	router.Handle("/api/v1/events/token", methods{http.MethodPost: postStreamToken})
+
+	router.Handle("/api/v1/user", methods{http.MethodGet: requireUser(getUser)})
+	router.Handle("/api/v1/user/filters", methods{
+		http.MethodGet: requireUser(getUserFilters),
+		http.MethodPut: requireUser(putUserFilters),
+	})
+	router.Handle("/api/v1/user/templates", methods{
+		http.MethodGet: requireUser(getUserTemplates),
+		http.MethodPut: requireUser(putUserTemplates),
+	})
+	router.Handle("/api/v1/user/throttle", methods{
+		http.MethodGet: requireUser(getUserThrottle),
+		http.MethodPut: requireUser(putUserThrottle),
+	})
+	router.Handle("/api/v1/user/watcher", methods{
+		http.MethodGet: requireUser(getUserWatcher),
+		http.MethodPut: requireUser(putUserWatcher),
+	})
+	router.Handle("/api/v1/user/cars", methods{http.MethodGet: requireUser(getUserCars)})
+	router.Handle("/api/v1/user/stats", methods{http.MethodGet: requireUser(getUserStats)})
 
-	log.Printf("Listening for requests on %s.", "0.0.0.0:2112")
-	return http.ListenAndServe(":2112", nil)
+	return router
 }
@@ -0,0 +1,424 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/khase/leaseplan-bot/lpbot/config"
+	"github.com/khase/leaseplan-bot/lpbot/lpcon"
+	"github.com/khase/leaseplanabocarexporter/dto"
+)
+
+const testAdminKey = "admin-key"
+
+func newTestCars() map[string][]dto.Item {
+	return map[string][]dto.Item{
+		"Level 1": {
+			{RentalObject: dto.RentalObject{Ident: "a", CarLabel: "BMW", KindOfFuel: "Elektro", PowerHP: 340, PriceProducer1: 70000}, SalaryWaiver: 600},
+			{RentalObject: dto.RentalObject{Ident: "b", CarLabel: "VW", KindOfFuel: "Benzin", PowerHP: 150, PriceProducer1: 35000}, SalaryWaiver: 300},
+			{RentalObject: dto.RentalObject{Ident: "c", CarLabel: "BMW", KindOfFuel: "Diesel", PowerHP: 190, PriceProducer1: 50000}, SalaryWaiver: 450},
+		},
+		"Level 2": {
+			{RentalObject: dto.RentalObject{Ident: "d", CarLabel: "Audi", KindOfFuel: "Elektro", PowerHP: 408, PriceProducer1: 90000}, SalaryWaiver: 800},
+		},
+	}
+}
+
+// setupTestApi prepares a user map with one user (telegram id 1) and returns
+// the api key of that user.
+func setupTestApi(t *testing.T) (*config.User, string) {
+	users := config.NewUserMap(filepath.Join(t.TempDir(), "test.userdata"))
+	user, _ := users.CreateNewUser(1, "test")
+	user.LeaseplanLevelKey = "Level 1"
+	frame, err := config.LoadDataFrameFile("../../testdata/dataframe.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.LastFrame = frame
+
+	key, err := user.GenerateApiKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	SetUserMap(users)
+	SetAdminKeys([]string{testAdminKey})
+	getAllCars = newTestCars
+	getWatcherStates = func() map[string]*lpcon.LpWatcherState {
+		return map[string]*lpcon.LpWatcherState{"Level 1": {UserCount: 1, CurrentCarCount: 3, IsActive: true}}
+	}
+	getWatcherKeys = func() []string { return []string{"Level 1"} }
+	getAssortmentHistory = func(levelKey string) []config.AssortmentSample {
+		cars := newTestCars()[levelKey]
+		return []config.AssortmentSample{
+			config.NewAssortmentSample(cars[:1], time.Now().AddDate(0, 0, -40)),
+			config.NewAssortmentSample(cars[:2], time.Now().AddDate(0, 0, -1)),
+			config.NewAssortmentSample(cars, time.Now()),
+		}
+	}
+
+	return user, key
+}
+
+func doRequest(t *testing.T, method string, path string, key string, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	if key != "" {
+		request.Header.Set("Authorization", "Bearer "+key)
+	}
+
+	recorder := httptest.NewRecorder()
+	NewRouter().ServeHTTP(recorder, request)
+
+	return recorder
+}
+
+func decodeResponse(t *testing.T, recorder *httptest.ResponseRecorder, expectedStatus int, target interface{}) {
+	if recorder.Code != expectedStatus {
+		t.Fatalf("expected status %d but got %d: %s", expectedStatus, recorder.Code, recorder.Body.String())
+	}
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Fatalf("expected json content type but got %q", contentType)
+	}
+	if target == nil {
+		return
+	}
+
+	err := json.Unmarshal(recorder.Body.Bytes(), target)
+	if err != nil {
+		t.Fatalf("could not decode response %q: %s", recorder.Body.String(), err)
+	}
+}
+
+func expectError(t *testing.T, recorder *httptest.ResponseRecorder, expectedStatus int) {
+	var response ErrorResponse
+	decodeResponse(t, recorder, expectedStatus, &response)
+
+	if response.Error.Status != expectedStatus || response.Error.Message == "" {
+		t.Fatalf("expected error envelope with status %d but got %+v", expectedStatus, response)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	setupTestApi(t)
+
+	for _, path := range []string{"/health", "/api/v1/health"} {
+		var health HealthData
+		decodeResponse(t, doRequest(t, http.MethodGet, path, "", ""), http.StatusOK, &health)
+		if len(health.Watchers) != 1 {
+			t.Fatalf("expected 1 watcher but got %d", len(health.Watchers))
+		}
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	setupTestApi(t)
+
+	recorder := doRequest(t, http.MethodPost, "/api/v1/user/filters", "", "")
+	expectError(t, recorder, http.StatusMethodNotAllowed)
+	if allow := recorder.Header().Get("Allow"); allow != "GET, PUT" {
+		t.Fatalf("expected Allow header \"GET, PUT\" but got %q", allow)
+	}
+}
+
+func TestUnknownEndpoint(t *testing.T) {
+	setupTestApi(t)
+
+	expectError(t, doRequest(t, http.MethodGet, "/api/v1/unknown", testAdminKey, ""), http.StatusNotFound)
+}
+
+func TestOpenApi(t *testing.T) {
+	setupTestApi(t)
+
+	var document struct {
+		OpenApi string                 `json:"openapi"`
+		Paths   map[string]interface{} `json:"paths"`
+	}
+	decodeResponse(t, doRequest(t, http.MethodGet, "/api/v1/openapi.json", "", ""), http.StatusOK, &document)
+
+	for _, path := range []string{"/health", "/state", "/cars", "/user", "/user/filters", "/user/templates", "/user/throttle", "/user/watcher", "/user/cars", "/stats", "/user/stats", "/compare", "/events", "/events/token"} {
+		if _, exists := document.Paths[path]; !exists {
+			t.Fatalf("expected openapi document to describe %s", path)
+		}
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	_, key := setupTestApi(t)
+
+	expectError(t, doRequest(t, http.MethodGet, "/metrics", "", ""), http.StatusUnauthorized)
+	expectError(t, doRequest(t, http.MethodGet, "/metrics", key, ""), http.StatusForbidden)
+
+	if recorder := doRequest(t, http.MethodGet, "/metrics", testAdminKey, ""); recorder.Code != http.StatusOK {
+		t.Fatalf("expected status 200 but got %d", recorder.Code)
+	}
+}
+
+func TestState(t *testing.T) {
+	_, key := setupTestApi(t)
+
+	expectError(t, doRequest(t, http.MethodGet, "/api/v1/state", "", ""), http.StatusUnauthorized)
+	expectError(t, doRequest(t, http.MethodGet, "/api/v1/state", key, ""), http.StatusForbidden)
+
+	var states map[string]*lpcon.LpWatcherState
+	decodeResponse(t, doRequest(t, http.MethodGet, "/api/v1/state", testAdminKey, ""), http.StatusOK, &states)
+	if states["Level 1"].CurrentCarCount != 3 {
+		t.Fatalf("expected state of Level 1 with 3 cars but got %+v", states)
+	}
+}
+
+func TestCars(t *testing.T) {
+	setupTestApi(t)
+
+	var page CarPage
+	decodeResponse(t, doRequest(t, http.MethodGet, "/api/v1/cars", testAdminKey, ""), http.StatusOK, &page)
+	if page.Total != 4 || len(page.Items) != 4 {
+		t.Fatalf("expected all 4 cars but got %d", page.Total)
+	}
+
+	decodeResponse(t, doRequest(t, http.MethodGet, "/api/v1/cars?level=Level+1&brand=bmw&sort=-hp", testAdminKey, ""), http.StatusOK, &page)
+	if page.Total != 2 || page.Items[0].Car.RentalObject.Ident != "a" || page.Items[1].Car.RentalObject.Ident != "c" {
+		t.Fatalf("expected BMWs of Level 1 sorted by hp descending but got %+v", page.Items)
+	}
+
+	decodeResponse(t, doRequest(t, http.MethodGet, "/api/v1/cars?fuel=elektro&sort=bgv&page=2&pageSize=1", testAdminKey, ""), http.StatusOK, &page)
+	if page.Total != 2 || len(page.Items) != 1 || page.Items[0].Car.RentalObject.Ident != "d" {
+		t.Fatalf("expected second electric car by bgv but got %+v", page.Items)
+	}
+
+	expectError(t, doRequest(t, http.MethodGet, "/api/v1/cars?sort=color", testAdminKey, ""), http.StatusBadRequest)
+	expectError(t, doRequest(t, http.MethodGet, "/api/v1/cars?pageSize=0", testAdminKey, ""), http.StatusBadRequest)
+}
+
+func TestUser(t *testing.T) {
+	_, key := setupTestApi(t)
+
+	var info UserInfo
+	decodeResponse(t, doRequest(t, http.MethodGet, "/api/v1/user", key, ""), http.StatusOK, &info)
+	if info.UserId != 1 || info.LeaseplanLevelKey != "Level 1" {
+		t.Fatalf("unexpected user info %+v", info)
+	}
+
+	expectError(t, doRequest(t, http.MethodGet, "/api/v1/user", "wrong-key", ""), http.StatusUnauthorized)
+	expectError(t, doRequest(t, http.MethodGet, "/api/v1/user?user=1", key, ""), http.StatusForbidden)
+	expectError(t, doRequest(t, http.MethodGet, "/api/v1/user", testAdminKey, ""), http.StatusBadRequest)
+	expectError(t, doRequest(t, http.MethodGet, "/api/v1/user?user=2", testAdminKey, ""), http.StatusNotFound)
+
+	decodeResponse(t, doRequest(t, http.MethodGet, "/api/v1/user?user=1", testAdminKey, ""), http.StatusOK, &info)
+	if info.UserId != 1 {
+		t.Fatalf("expected admin to access user 1 but got %+v", info)
+	}
+}
+
+func TestUserFilters(t *testing.T) {
+	user, key := setupTestApi(t)
+
+	var filters []string
+	decodeResponse(t, doRequest(t, http.MethodPut, "/api/v1/user/filters", key, `["gt .RentalObject.PowerHP 160", "gt .RentalObject.PowerHP 160"]`), http.StatusOK, &filters)
+	if len(filters) != 1 || len(user.Filters) != 1 {
+		t.Fatalf("expected 1 distinct filter but got %v", filters)
+	}
+
+	decodeResponse(t, doRequest(t, http.MethodGet, "/api/v1/user/filters", key, ""), http.StatusOK, &filters)
+	if len(filters) != 1 {
+		t.Fatalf("expected 1 filter but got %v", filters)
+	}
+
+	expectError(t, doRequest(t, http.MethodPut, "/api/v1/user/filters", key, `{"no": "list"}`), http.StatusBadRequest)
+}
+
+func TestUserTemplates(t *testing.T) {
+	user, key := setupTestApi(t)
+
+	var templates UserTemplates
+	decodeResponse(t, doRequest(t, http.MethodPut, "/api/v1/user/templates", key, `{"SummaryMessageTemplate": "{{ len .Added }} neu", "DetailMessageTemplate": "{{ .RentalObject.PowerHP }}PS"}`), http.StatusOK, &templates)
+	if user.SummaryMessageTemplate != "{{ len .Added }} neu" || templates.DetailMessageTemplate != "{{ .RentalObject.PowerHP }}PS" {
+		t.Fatalf("expected templates to be updated but got %+v", templates)
+	}
+
+	expectError(t, doRequest(t, http.MethodPut, "/api/v1/user/templates", key, `{"SummaryMessageTemplate": "{{ .Unknown }}", "DetailMessageTemplate": "{{ .RentalObject.PowerHP }}PS"}`), http.StatusUnprocessableEntity)
+	if user.SummaryMessageTemplate != "{{ len .Added }} neu" {
+		t.Fatalf("expected invalid template to be rejected but got %q", user.SummaryMessageTemplate)
+	}
+
+	decodeResponse(t, doRequest(t, http.MethodGet, "/api/v1/user/templates", key, ""), http.StatusOK, &templates)
+	if templates.SummaryMessageTemplate != "{{ len .Added }} neu" {
+		t.Fatalf("unexpected templates %+v", templates)
+	}
+}
+
+func TestUserThrottle(t *testing.T) {
+	_, key := setupTestApi(t)
+
+	var throttle UserThrottle
+	decodeResponse(t, doRequest(t, http.MethodPut, "/api/v1/user/throttle", key, `{"WatcherDelay": 30}`), http.StatusOK, &throttle)
+	if throttle.WatcherDelay != 30 {
+		t.Fatalf("expected WatcherDelay 30 but got %d", throttle.WatcherDelay)
+	}
+
+	expectError(t, doRequest(t, http.MethodPut, "/api/v1/user/throttle", key, `{"WatcherDelay": 5}`), http.StatusUnprocessableEntity)
+
+	decodeResponse(t, doRequest(t, http.MethodPut, "/api/v1/user/throttle?user=1", testAdminKey, `{"WatcherDelay": 5}`), http.StatusOK, &throttle)
+	decodeResponse(t, doRequest(t, http.MethodGet, "/api/v1/user/throttle", key, ""), http.StatusOK, &throttle)
+	if throttle.WatcherDelay != 5 {
+		t.Fatalf("expected admins to set WatcherDelay 5 but got %d", throttle.WatcherDelay)
+	}
+
+	expectError(t, doRequest(t, http.MethodPut, "/api/v1/user/throttle?user=1", testAdminKey, `{"WatcherDelay": -1}`), http.StatusUnprocessableEntity)
+}
+
+func TestUserWatcher(t *testing.T) {
+	user, key := setupTestApi(t)
+
+	registered := 0
+	registerUserWatcher = func(*config.User) { registered++ }
+	unregisterUserWatcher = func(*config.User) { registered-- }
+
+	var watcher UserWatcher
+	decodeResponse(t, doRequest(t, http.MethodPut, "/api/v1/user/watcher", key, `{"Active": true}`), http.StatusOK, &watcher)
+	if !watcher.Active || !user.WatcherActive || registered != 1 {
+		t.Fatalf("expected watcher to be started")
+	}
+
+	decodeResponse(t, doRequest(t, http.MethodPut, "/api/v1/user/watcher", key, `{"Active": false}`), http.StatusOK, &watcher)
+	if watcher.Active || user.WatcherActive || registered != 0 {
+		t.Fatalf("expected watcher to be stopped")
+	}
+
+	user.Ban()
+	expectError(t, doRequest(t, http.MethodPut, "/api/v1/user/watcher?user=1", testAdminKey, `{"Active": true}`), http.StatusConflict)
+
+	decodeResponse(t, doRequest(t, http.MethodGet, "/api/v1/user/watcher?user=1", testAdminKey, ""), http.StatusOK, &watcher)
+	if watcher.Active {
+		t.Fatalf("expected watcher of banned user to stay inactive")
+	}
+	expectError(t, doRequest(t, http.MethodGet, "/api/v1/user/watcher", key, ""), http.StatusUnauthorized)
+}
+
+func TestUserCars(t *testing.T) {
+	user, key := setupTestApi(t)
+	user.AddFilter("gt .RentalObject.PowerHP 160")
+
+	var page CarPage
+	decodeResponse(t, doRequest(t, http.MethodGet, "/api/v1/user/cars?sort=netcost", key, ""), http.StatusOK, &page)
+	if page.Total != 2 || page.Items[0].Car.RentalObject.Ident != "c" || page.Items[1].Car.RentalObject.Ident != "a" {
+		t.Fatalf("expected the 2 filtered cars of Level 1 sorted by net cost but got %+v", page.Items)
+	}
+
+	config.SetCacheDir(t.TempDir())
+	defer config.SetCacheDir(config.DefaultCacheDir)
+	config.RecordModelHistory("Level 1", newTestCars()["Level 1"])
+	user.SetFilters([]string{"gt (discount .) 0.0"})
+	decodeResponse(t, doRequest(t, http.MethodGet, "/api/v1/user/cars", key, ""), http.StatusOK, &page)
+	if page.Total != 1 || page.Items[0].Car.RentalObject.Ident != "c" {
+		t.Fatalf("expected the discount filter to be rendered with the funcs of the user but got %+v", page.Items)
+	}
+}
+
+func TestStats(t *testing.T) {
+	_, key := setupTestApi(t)
+
+	expectError(t, doRequest(t, http.MethodGet, "/api/v1/stats", key, ""), http.StatusForbidden)
+	expectError(t, doRequest(t, http.MethodGet, "/api/v1/stats?days=0", testAdminKey, ""), http.StatusBadRequest)
+
+	var stats map[string][]config.AssortmentSample
+	decodeResponse(t, doRequest(t, http.MethodGet, "/api/v1/stats", testAdminKey, ""), http.StatusOK, &stats)
+	if len(stats["Level 1"]) != 2 || stats["Level 1"][1].Count != 3 || stats["Level 1"][1].Brands["BMW"] != 2 {
+		t.Fatalf("expected the samples of the last 30 days but got %+v", stats)
+	}
+
+	decodeResponse(t, doRequest(t, http.MethodGet, "/api/v1/stats?days=60", testAdminKey, ""), http.StatusOK, &stats)
+	if len(stats["Level 1"]) != 3 {
+		t.Fatalf("expected the samples of the last 60 days but got %+v", stats)
+	}
+}
+
+func TestUserStats(t *testing.T) {
+	user, key := setupTestApi(t)
+	user.AssortmentHistory = []config.AssortmentSample{config.NewAssortmentSample(newTestCars()["Level 1"][:1], time.Now())}
+
+	var report config.AssortmentReport
+	decodeResponse(t, doRequest(t, http.MethodGet, "/api/v1/user/stats", key, ""), http.StatusOK, &report)
+	if report.LevelKey != "Level 1" || len(report.Visible) != 2 || len(report.Filtered) != 1 || report.Filtered[0].AverageSalaryWaiver != 600 {
+		t.Fatalf("expected the visible and filtered stats of the user but got %+v", report)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	_, key := setupTestApi(t)
+
+	expectError(t, doRequest(t, http.MethodGet, "/api/v1/compare", key, ""), http.StatusForbidden)
+
+	var comparison config.LevelComparison
+	decodeResponse(t, doRequest(t, http.MethodGet, "/api/v1/compare", testAdminKey, ""), http.StatusOK, &comparison)
+	if len(comparison.Levels) != 2 || comparison.Levels[0].Users != 1 || comparison.Levels[0].ExclusiveCars != 3 || comparison.Levels[1].Cars != 1 {
+		t.Fatalf("unexpected levels %+v", comparison.Levels)
+	}
+	if len(comparison.Exclusive["Level 2"]) != 1 || len(comparison.Differences) != 0 {
+		t.Fatalf("expected all cars to be exclusive but got %+v", comparison)
+	}
+}
+
+func TestListen(t *testing.T) {
+	listener, err := Listen(ServerConfig{Enabled: true, Address: "127.0.0.1:0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	_, err = Listen(ServerConfig{Enabled: true, Address: listener.Addr().String()})
+	if err == nil {
+		t.Fatalf("expected an error when the address is already in use")
+	}
+
+	_, err = Listen(ServerConfig{Enabled: true, Address: "127.0.0.1:0", TlsCertFile: "cert.pem"})
+	if !errors.Is(err, ErrTlsConfigIncomplete) {
+		t.Fatalf("expected ErrTlsConfigIncomplete but got %v", err)
+	}
+
+	_, err = Listen(ServerConfig{Enabled: true, Address: "127.0.0.1:0", TlsCertFile: "missing.pem", TlsKeyFile: "missing.key"})
+	if err == nil {
+		t.Fatalf("expected an error for missing certificate files")
+	}
+}
+
+func TestServe(t *testing.T) {
+	setupTestApi(t)
+
+	config := ServerConfig{Enabled: true, Address: "127.0.0.1:0"}
+	listener, err := Listen(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- Serve(ctx, listener, config)
+	}()
+
+	response, err := http.Get("http://" + listener.Addr().String() + "/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200 but got %d", response.StatusCode)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expected clean shutdown but got %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("server did not shut down")
+	}
+}
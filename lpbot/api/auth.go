@@ -0,0 +1,215 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/khase/leaseplan-bot/lpbot/config"
+)
+
+const (
+	streamTokenValidity = time.Minute
+)
+
+var (
+	userMap   *config.UserMap
+	adminKeys []string
+
+	streamTokens = newStreamTokenStore()
+)
+
+type principal struct {
+	admin bool
+	user  *config.User
+}
+
+type authenticatedHandler func(w http.ResponseWriter, r *http.Request, caller *principal)
+
+func SetUserMap(users *config.UserMap) {
+	userMap = users
+}
+
+func SetAdminKeys(keys []string) {
+	adminKeys = keys
+}
+
+func getApiKey(r *http.Request) string {
+	authorization := r.Header.Get("Authorization")
+	if strings.HasPrefix(authorization, "Bearer ") {
+		return strings.TrimPrefix(authorization, "Bearer ")
+	}
+
+	return r.Header.Get("X-API-Key")
+}
+
+func isAdminKey(key string) bool {
+	for _, adminKey := range adminKeys {
+		if adminKey != "" && subtle.ConstantTimeCompare([]byte(adminKey), []byte(key)) == 1 {
+			return true
+		}
+	}
+
+	return false
+}
+
+func authenticate(r *http.Request) *principal {
+	key := getApiKey(r)
+	if key == "" {
+		return nil
+	}
+
+	if isAdminKey(key) {
+		return &principal{admin: true}
+	}
+
+	if userMap == nil {
+		return nil
+	}
+	user := userMap.FindUserByApiKey(key)
+	if user == nil || user.Banned {
+		return nil
+	}
+
+	return &principal{admin: user.IsAdmin, user: user}
+}
+
+// requireAdmin only lets requests with an admin key or the key of an admin
+// user pass.
+func requireAdmin(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		caller := authenticate(r)
+		if caller == nil {
+			writeError(w, http.StatusUnauthorized, "a valid api key is required")
+			return
+		}
+		if !caller.admin {
+			writeError(w, http.StatusForbidden, "admin access is required")
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+// requireUser resolves the user a request acts on. Users act on themselves,
+// admins may select any user with the `user` query parameter.
+func requireUser(handler authenticatedHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		caller := authenticate(r)
+		if caller == nil {
+			writeError(w, http.StatusUnauthorized, "a valid api key is required")
+			return
+		}
+
+		if query := r.URL.Query().Get("user"); query != "" {
+			if !caller.admin {
+				writeError(w, http.StatusForbidden, "admin access is required")
+				return
+			}
+			userId, err := strconv.ParseInt(query, 10, 64)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "invalid user id: "+query)
+				return
+			}
+			user := userMap.GetUser(userId)
+			if user == nil {
+				writeError(w, http.StatusNotFound, "user not found: "+query)
+				return
+			}
+			caller = &principal{admin: caller.admin, user: user}
+		}
+
+		if caller.user == nil {
+			writeError(w, http.StatusBadRequest, "admin keys have to select a user with ?user=<id>")
+			return
+		}
+
+		handler(w, r, caller)
+	}
+}
+
+type StreamToken struct {
+	Token     string    `json:"Token"`
+	ExpiresAt time.Time `json:"ExpiresAt"`
+}
+
+type streamTokenEntry struct {
+	caller    *principal
+	expiresAt time.Time
+}
+
+// streamTokenStore hands out short lived tokens for the event stream, as
+// browsers can not set headers for event streams. A token can only be used
+// once, so api keys never have to be put into urls.
+type streamTokenStore struct {
+	lock   sync.Mutex
+	tokens map[string]*streamTokenEntry
+}
+
+func newStreamTokenStore() *streamTokenStore {
+	return &streamTokenStore{tokens: make(map[string]*streamTokenEntry)}
+}
+
+func (store *streamTokenStore) issue(caller *principal, now time.Time) (*StreamToken, error) {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return nil, err
+	}
+	token := &StreamToken{Token: hex.EncodeToString(bytes), ExpiresAt: now.Add(streamTokenValidity)}
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	for key, entry := range store.tokens {
+		if !now.Before(entry.expiresAt) {
+			delete(store.tokens, key)
+		}
+	}
+	store.tokens[token.Token] = &streamTokenEntry{caller: caller, expiresAt: token.ExpiresAt}
+
+	return token, nil
+}
+
+// redeem returns the caller the token was issued for and invalidates it.
+func (store *streamTokenStore) redeem(token string, now time.Time) *principal {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	entry, exists := store.tokens[token]
+	if !exists {
+		return nil
+	}
+	delete(store.tokens, token)
+	if !now.Before(entry.expiresAt) {
+		return nil
+	}
+	if entry.caller.user != nil && entry.caller.user.Banned {
+		return nil
+	}
+
+	return entry.caller
+}
+
+// postStreamToken issues a token for a single connection to the event
+// stream, see getEvents.
+func postStreamToken(w http.ResponseWriter, r *http.Request) {
+	caller := authenticate(r)
+	if caller == nil {
+		writeError(w, http.StatusUnauthorized, "a valid api key is required")
+		return
+	}
+
+	token, err := streamTokens.issue(caller, time.Now())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "could not create token: "+err.Error())
+		return
+	}
+
+	writeJson(w, http.StatusCreated, token)
+}
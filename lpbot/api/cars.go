@@ -1,19 +1,149 @@
 package api
 
 import (
-	"encoding/json"
-	"io"
+	"errors"
+	"fmt"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 
+	"github.com/khase/leaseplan-bot/lpbot/config"
 	"github.com/khase/leaseplan-bot/lpbot/lpcon"
+	"github.com/khase/leaseplanabocarexporter/dto"
 )
 
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+var (
+	getAllCars = lpcon.GetCars
+)
+
+type CarEntry struct {
+	LevelKey string   `json:"LevelKey"`
+	Car      dto.Item `json:"Car"`
+}
+
+type CarPage struct {
+	Total    int        `json:"Total"`
+	Page     int        `json:"Page"`
+	PageSize int        `json:"PageSize"`
+	Items    []CarEntry `json:"Items"`
+}
+
+type carQuery struct {
+	LevelKey string
+	Brand    string
+	Fuel     string
+	Sort     string
+	Page     int
+	PageSize int
+}
+
 func getCars(w http.ResponseWriter, r *http.Request) {
-	bytes, err := json.Marshal(lpcon.GetCars())
-	if err == nil {
-		w.Write(bytes)
-	} else {
-		io.WriteString(w, "Something went wrong :(")
+	query, err := parseCarQuery(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	query.LevelKey = r.URL.Query().Get("level")
+
+	writeJson(w, http.StatusOK, query.apply(getAllCars()))
+}
+
+func parseCarQuery(r *http.Request) (*carQuery, error) {
+	values := r.URL.Query()
+	query := &carQuery{
+		Brand:    values.Get("brand"),
+		Fuel:     values.Get("fuel"),
+		Sort:     values.Get("sort"),
+		Page:     1,
+		PageSize: defaultPageSize,
+	}
+
+	if query.Sort != "" {
+		if _, _, err := config.ParseSortOrder(query.Sort); err != nil {
+			return nil, err
+		}
+	}
+
+	if page := values.Get("page"); page != "" {
+		value, err := strconv.Atoi(page)
+		if err != nil || value < 1 {
+			return nil, errors.New("page has to be a positive number")
+		}
+		query.Page = value
+	}
+	if pageSize := values.Get("pageSize"); pageSize != "" {
+		value, err := strconv.Atoi(pageSize)
+		if err != nil || value < 1 || value > maxPageSize {
+			return nil, fmt.Errorf("pageSize has to be between 1 and %d", maxPageSize)
+		}
+		query.PageSize = value
+	}
+
+	return query, nil
+}
+
+func (query *carQuery) matches(levelKey string, car *dto.Item) bool {
+	if query.LevelKey != "" && query.LevelKey != levelKey {
+		return false
+	}
+	if query.Brand != "" && !strings.EqualFold(string(car.RentalObject.CarLabel), query.Brand) {
+		return false
+	}
+	if query.Fuel != "" && !strings.EqualFold(string(car.RentalObject.KindOfFuel), query.Fuel) {
+		return false
+	}
+
+	return true
+}
+
+// apply filters, sorts and paginates the given cars. Without an explicit sort
+// key the cars are ordered by level key and ident so pages are stable.
+func (query *carQuery) apply(cars map[string][]dto.Item) *CarPage {
+	entries := make([]CarEntry, 0)
+	for levelKey, levelCars := range cars {
+		for index := range levelCars {
+			if query.matches(levelKey, &levelCars[index]) {
+				entries = append(entries, CarEntry{LevelKey: levelKey, Car: levelCars[index]})
+			}
+		}
+	}
+
+	// the sort order was validated by parseCarQuery
+	compare, _ := config.CarComparator(query.Sort)
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := &entries[i], &entries[j]
+		if compare != nil {
+			if result := compare(a.Car, b.Car); result != 0 {
+				return result < 0
+			}
+		}
+		if a.LevelKey != b.LevelKey {
+			return a.LevelKey < b.LevelKey
+		}
+		return a.Car.RentalObject.Ident < b.Car.RentalObject.Ident
+	})
+
+	page := &CarPage{
+		Total:    len(entries),
+		Page:     query.Page,
+		PageSize: query.PageSize,
+		Items:    []CarEntry{},
+	}
+
+	start := (query.Page - 1) * query.PageSize
+	if start < len(entries) {
+		end := start + query.PageSize
+		if end > len(entries) {
+			end = len(entries)
+		}
+		page.Items = entries[start:end]
 	}
 
+	return page
 }
@@ -0,0 +1,11 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/khase/leaseplan-bot/lpbot/config"
+)
+
+func getCompare(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, http.StatusOK, config.CompareLevels(getAllCars(), userMap))
+}
@@ -0,0 +1,232 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/khase/leaseplan-bot/lpbot/config"
+	"github.com/khase/leaseplanabocarexporter/dto"
+)
+
+const (
+	eventBufferSize       = 256
+	eventSubscriberBuffer = 32
+	eventHeartbeat        = 15 * time.Second
+)
+
+var (
+	events = newEventBroker(eventBufferSize)
+)
+
+// Event describes the changes of the car list of one level key.
+type Event struct {
+	Id        uint64     `json:"Id"`
+	LevelKey  string     `json:"LevelKey"`
+	Timestamp time.Time  `json:"Timestamp"`
+	Added     []dto.Item `json:"Added"`
+	Removed   []dto.Item `json:"Removed"`
+}
+
+type eventSubscriber struct {
+	levelKey string
+	events   chan *Event
+}
+
+// eventBroker hands out events to all subscribers and keeps the latest ones
+// in a ring buffer, so reconnecting clients can catch up.
+type eventBroker struct {
+	lock        sync.Mutex
+	lastId      uint64
+	buffer      []*Event
+	next        int
+	subscribers map[*eventSubscriber]struct{}
+}
+
+func newEventBroker(size int) *eventBroker {
+	return &eventBroker{
+		buffer:      make([]*Event, size),
+		subscribers: make(map[*eventSubscriber]struct{}),
+	}
+}
+
+// PublishFrame passes the changes of a data frame on to all clients of the
+// event stream.
+func PublishFrame(levelKey string, frame *config.DataFrame) {
+	events.publish(levelKey, frame)
+}
+
+func (broker *eventBroker) publish(levelKey string, frame *config.DataFrame) {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+
+	broker.lastId++
+	event := &Event{
+		Id:        broker.lastId,
+		LevelKey:  levelKey,
+		Timestamp: frame.Timestamp,
+		Added:     frame.Added,
+		Removed:   frame.Removed,
+	}
+	broker.buffer[broker.next] = event
+	broker.next = (broker.next + 1) % len(broker.buffer)
+
+	for subscriber := range broker.subscribers {
+		if !subscriber.accepts(event) {
+			continue
+		}
+
+		select {
+		case subscriber.events <- event:
+		default:
+			// the client does not keep up, it has to reconnect and catch up
+			// through the buffer
+			delete(broker.subscribers, subscriber)
+			close(subscriber.events)
+		}
+	}
+}
+
+// subscribe registers a new subscriber and returns the buffered events
+// following lastId. Ids unknown to the broker (e.g. from before a restart)
+// replay the whole buffer.
+func (broker *eventBroker) subscribe(levelKey string, lastId uint64, resume bool) (*eventSubscriber, []*Event) {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+
+	subscriber := &eventSubscriber{
+		levelKey: levelKey,
+		events:   make(chan *Event, eventSubscriberBuffer),
+	}
+	broker.subscribers[subscriber] = struct{}{}
+
+	if !resume {
+		return subscriber, nil
+	}
+	if lastId > broker.lastId {
+		lastId = 0
+	}
+
+	backlog := []*Event{}
+	for i := range broker.buffer {
+		event := broker.buffer[(broker.next+i)%len(broker.buffer)]
+		if event != nil && event.Id > lastId && subscriber.accepts(event) {
+			backlog = append(backlog, event)
+		}
+	}
+
+	return subscriber, backlog
+}
+
+func (broker *eventBroker) unsubscribe(subscriber *eventSubscriber) {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+
+	if _, exists := broker.subscribers[subscriber]; exists {
+		delete(broker.subscribers, subscriber)
+		close(subscriber.events)
+	}
+}
+
+func (subscriber *eventSubscriber) accepts(event *Event) bool {
+	return subscriber.levelKey == "" || subscriber.levelKey == event.LevelKey
+}
+
+// getEvents streams events as server-sent events. Admins receive all level
+// keys (or the one selected with `level`), users only their own one. Clients
+// which can not set headers authenticate with a token of postStreamToken.
+func getEvents(w http.ResponseWriter, r *http.Request) {
+	caller := authenticate(r)
+	if token := r.URL.Query().Get("token"); caller == nil && token != "" {
+		caller = streamTokens.redeem(token, time.Now())
+	}
+	if caller == nil {
+		writeError(w, http.StatusUnauthorized, "a valid api key is required")
+		return
+	}
+
+	levelKey := r.URL.Query().Get("level")
+	if !caller.admin {
+		if levelKey != "" && levelKey != caller.user.LeaseplanLevelKey {
+			writeError(w, http.StatusForbidden, "admin access is required")
+			return
+		}
+		// an empty level key would subscribe to all level keys
+		if caller.user.LeaseplanLevelKey == "" {
+			writeError(w, http.StatusConflict, "the user has no level key yet, log in to leaseplan first")
+			return
+		}
+		levelKey = caller.user.LeaseplanLevelKey
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+
+	lastEventId := r.Header.Get("Last-Event-ID")
+	if lastEventId == "" {
+		lastEventId = r.URL.Query().Get("lastEventId")
+	}
+	var lastId uint64
+	if lastEventId != "" {
+		var err error
+		lastId, err = strconv.ParseUint(lastEventId, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid event id: "+lastEventId)
+			return
+		}
+	}
+
+	subscriber, backlog := events.subscribe(levelKey, lastId, lastEventId != "")
+	defer events.unsubscribe(subscriber)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for _, event := range backlog {
+		if writeEvent(w, event) != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, open := <-subscriber.events:
+			if !open {
+				return
+			}
+			if writeEvent(w, event) != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event *Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: frame\ndata: %s\n\n", event.Id, data)
+	return err
+}
@@ -0,0 +1,208 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/khase/leaseplan-bot/lpbot/config"
+	"github.com/khase/leaseplanabocarexporter/dto"
+)
+
+func newTestFrame(ident string) *config.DataFrame {
+	return config.NewDataFrame([]dto.Item{}, []dto.Item{{RentalObject: dto.RentalObject{Ident: ident}}})
+}
+
+type eventStream struct {
+	response *http.Response
+	lines    chan string
+}
+
+func openEventStream(t *testing.T, server *httptest.Server, path string, key string, lastEventId string) *eventStream {
+	request, _ := http.NewRequest(http.MethodGet, server.URL+path, nil)
+	if key != "" {
+		request.Header.Set("Authorization", "Bearer "+key)
+	}
+	if lastEventId != "" {
+		request.Header.Set("Last-Event-ID", lastEventId)
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { response.Body.Close() })
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200 but got %d", response.StatusCode)
+	}
+	if contentType := response.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Fatalf("expected event stream but got %q", contentType)
+	}
+
+	stream := &eventStream{response: response, lines: make(chan string, 64)}
+	go func() {
+		scanner := bufio.NewScanner(response.Body)
+		for scanner.Scan() {
+			stream.lines <- scanner.Text()
+		}
+		close(stream.lines)
+	}()
+
+	return stream
+}
+
+func (stream *eventStream) next(t *testing.T) *Event {
+	for {
+		select {
+		case line, open := <-stream.lines:
+			if !open {
+				t.Fatalf("event stream closed")
+			}
+			if !strings.HasPrefix(line, "data: ") {
+				continue
+			}
+
+			event := new(Event)
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), event); err != nil {
+				t.Fatal(err)
+			}
+			return event
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no event received")
+		}
+	}
+}
+
+// waitForSubscribers waits until the given number of clients is connected so
+// published events are not missed.
+func waitForSubscribers(t *testing.T, count int) {
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		events.lock.Lock()
+		subscribers := len(events.subscribers)
+		events.lock.Unlock()
+		if subscribers == count {
+			return
+		}
+	}
+	t.Fatalf("expected %d subscribers", count)
+}
+
+func TestEventBrokerBuffer(t *testing.T) {
+	broker := newEventBroker(3)
+	for _, ident := range []string{"a", "b", "c", "d"} {
+		broker.publish("Level 1", newTestFrame(ident))
+	}
+
+	subscriber, backlog := broker.subscribe("", 2, true)
+	defer broker.unsubscribe(subscriber)
+	if len(backlog) != 2 || backlog[0].Id != 3 || backlog[1].Id != 4 {
+		t.Fatalf("expected events 3 and 4 but got %+v", backlog)
+	}
+
+	_, backlog = broker.subscribe("", 0, true)
+	if len(backlog) != 3 || backlog[0].Id != 2 {
+		t.Fatalf("expected the 3 buffered events but got %+v", backlog)
+	}
+
+	_, backlog = broker.subscribe("", 42, true)
+	if len(backlog) != 3 {
+		t.Fatalf("expected unknown ids to replay the buffer but got %+v", backlog)
+	}
+
+	_, backlog = broker.subscribe("Level 2", 0, true)
+	if len(backlog) != 0 {
+		t.Fatalf("expected no events of Level 2 but got %+v", backlog)
+	}
+}
+
+func TestEventBrokerSlowSubscriber(t *testing.T) {
+	broker := newEventBroker(eventBufferSize)
+	subscriber, _ := broker.subscribe("", 0, false)
+
+	for i := 0; i <= eventSubscriberBuffer; i++ {
+		broker.publish("Level 1", newTestFrame("a"))
+	}
+
+	if _, exists := broker.subscribers[subscriber]; exists {
+		t.Fatalf("expected slow subscriber to be dropped")
+	}
+	broker.unsubscribe(subscriber)
+}
+
+func TestEvents(t *testing.T) {
+	_, key := setupTestApi(t)
+	events = newEventBroker(eventBufferSize)
+
+	// registered first so the server is closed after all streams
+	server := httptest.NewServer(NewRouter())
+	t.Cleanup(server.Close)
+
+	expectError(t, doRequest(t, http.MethodGet, "/api/v1/events", "", ""), http.StatusUnauthorized)
+	expectError(t, doRequest(t, http.MethodGet, "/api/v1/events?level=Level+2", key, ""), http.StatusForbidden)
+	expectError(t, doRequest(t, http.MethodGet, "/api/v1/events?lastEventId=abc", key, ""), http.StatusBadRequest)
+	expectError(t, doRequest(t, http.MethodGet, "/api/v1/events?apiKey="+key, "", ""), http.StatusUnauthorized)
+	expectError(t, doRequest(t, http.MethodGet, "/api/v1/events?token=unknown", "", ""), http.StatusUnauthorized)
+
+	adminStream := openEventStream(t, server, "/api/v1/events", testAdminKey, "")
+	userStream := openEventStream(t, server, "/api/v1/events", key, "")
+	waitForSubscribers(t, 2)
+
+	PublishFrame("Level 2", newTestFrame("d"))
+	PublishFrame("Level 1", newTestFrame("a"))
+
+	if event := adminStream.next(t); event.Id != 1 || event.LevelKey != "Level 2" {
+		t.Fatalf("expected admin to receive event 1 of Level 2 but got %+v", event)
+	}
+	if event := adminStream.next(t); event.Id != 2 || event.LevelKey != "Level 1" {
+		t.Fatalf("expected admin to receive event 2 of Level 1 but got %+v", event)
+	}
+	if event := userStream.next(t); event.Id != 2 || len(event.Added) != 1 || event.Added[0].RentalObject.Ident != "a" {
+		t.Fatalf("expected user to only receive event 2 but got %+v", event)
+	}
+
+	resumedStream := openEventStream(t, server, "/api/v1/events", testAdminKey, "1")
+	if event := resumedStream.next(t); event.Id != 2 {
+		t.Fatalf("expected reconnecting client to receive missed event 2 but got %+v", event)
+	}
+}
+
+func TestEventsWithoutLevelKey(t *testing.T) {
+	user, key := setupTestApi(t)
+	user.LeaseplanLevelKey = ""
+
+	expectError(t, doRequest(t, http.MethodGet, "/api/v1/events", key, ""), http.StatusConflict)
+}
+
+func TestStreamToken(t *testing.T) {
+	_, key := setupTestApi(t)
+	events = newEventBroker(eventBufferSize)
+	server := httptest.NewServer(NewRouter())
+	t.Cleanup(server.Close)
+
+	expectError(t, doRequest(t, http.MethodPost, "/api/v1/events/token", "", ""), http.StatusUnauthorized)
+
+	var token StreamToken
+	decodeResponse(t, doRequest(t, http.MethodPost, "/api/v1/events/token", key, ""), http.StatusCreated, &token)
+	if token.Token == "" || !token.ExpiresAt.After(time.Now()) {
+		t.Fatalf("expected a valid token but got %+v", token)
+	}
+
+	stream := openEventStream(t, server, "/api/v1/events?token="+token.Token, "", "")
+	waitForSubscribers(t, 1)
+	PublishFrame("Level 2", newTestFrame("d"))
+	PublishFrame("Level 1", newTestFrame("a"))
+	if event := stream.next(t); event.LevelKey != "Level 1" {
+		t.Fatalf("expected the token to act as the user but got %+v", event)
+	}
+
+	expectError(t, doRequest(t, http.MethodGet, "/api/v1/events?token="+token.Token, "", ""), http.StatusUnauthorized)
+
+	expired, _ := streamTokens.issue(&principal{admin: true}, time.Now().Add(-2*streamTokenValidity))
+	if streamTokens.redeem(expired.Token, time.Now()) != nil {
+		t.Fatalf("expected expired token to be rejected")
+	}
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+type ErrorResponse struct {
+	Error ErrorDetails `json:"Error"`
+}
+
+type ErrorDetails struct {
+	Status  int    `json:"Status"`
+	Message string `json:"Message"`
+}
+
+// methods dispatches a request to the handler registered for its http method
+// and answers all other methods with 405.
+type methods map[string]http.HandlerFunc
+
+func (handlers methods) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	handler, exists := handlers[r.Method]
+	if !exists {
+		allowed := make([]string, 0, len(handlers))
+		for method := range handlers {
+			allowed = append(allowed, method)
+		}
+		sort.Strings(allowed)
+
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		writeError(w, http.StatusMethodNotAllowed, "method "+r.Method+" is not allowed")
+		return
+	}
+
+	handler(w, r)
+}
+
+func notFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusNotFound, "no such endpoint: "+r.URL.Path)
+}
+
+func readJson(w http.ResponseWriter, r *http.Request, target interface{}) bool {
+	err := json.NewDecoder(r.Body).Decode(target)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json body: "+err.Error())
+		return false
+	}
+
+	return true
+}
+
+func writeJson(w http.ResponseWriter, status int, data interface{}) {
+	bytes, err := json.Marshal(data)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "could not encode response: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(bytes)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	bytes, _ := json.Marshal(&ErrorResponse{
+		Error: ErrorDetails{
+			Status:  status,
+			Message: message,
+		},
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(bytes)
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/khase/leaseplan-bot/lpbot/config"
+)
+
+var (
+	getAssortmentHistory = config.GetAssortmentHistory
+)
+
+// getStats returns the daily stats of the cars visible on every level key.
+func getStats(w http.ResponseWriter, r *http.Request) {
+	since, err := parseStatsSince(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	result := make(map[string][]config.AssortmentSample)
+	for _, levelKey := range getWatcherKeys() {
+		result[levelKey] = config.DailySamples(getAssortmentHistory(levelKey), since)
+	}
+
+	writeJson(w, http.StatusOK, result)
+}
+
+func getUserStats(w http.ResponseWriter, r *http.Request, caller *principal) {
+	since, err := parseStatsSince(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	user := caller.user
+	writeJson(w, http.StatusOK, config.NewAssortmentReport(user.LeaseplanLevelKey, getAssortmentHistory(user.LeaseplanLevelKey), user.AssortmentHistory, since))
+}
+
+func parseStatsSince(r *http.Request) (time.Time, error) {
+	days := config.DefaultStatsDays
+	if value := r.URL.Query().Get("days"); value != "" {
+		var err error
+		days, err = strconv.Atoi(value)
+		if err != nil || days < 1 || days > config.MaxStatsDays {
+			return time.Time{}, fmt.Errorf("days has to be between 1 and %d", config.MaxStatsDays)
+		}
+	}
+
+	return time.Now().AddDate(0, 0, -days), nil
+}
@@ -0,0 +1,159 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/khase/leaseplan-bot/lpbot/config"
+	"github.com/khase/leaseplan-bot/lpbot/lpcon"
+)
+
+var (
+	registerUserWatcher   = lpcon.RegisterUserWatcher
+	unregisterUserWatcher = lpcon.UnregisterUserWatcher
+)
+
+type UserInfo struct {
+	UserId            int64  `json:"UserId"`
+	FriendlyName      string `json:"FriendlyName,omitempty"`
+	LeaseplanLevelKey string `json:"LeaseplanLevelKey,omitempty"`
+	WatcherActive     bool   `json:"WatcherActive"`
+	WatcherError      string `json:"WatcherError,omitempty"`
+	WatcherDelay      int32  `json:"WatcherDelay"`
+}
+
+type UserTemplates struct {
+	SummaryMessageTemplate string `json:"SummaryMessageTemplate"`
+	DetailMessageTemplate  string `json:"DetailMessageTemplate"`
+}
+
+type UserThrottle struct {
+	WatcherDelay int32 `json:"WatcherDelay"`
+}
+
+type UserWatcher struct {
+	Active bool   `json:"Active"`
+	Error  string `json:"Error,omitempty"`
+}
+
+func getUser(w http.ResponseWriter, r *http.Request, caller *principal) {
+	user := caller.user
+	writeJson(w, http.StatusOK, &UserInfo{
+		UserId:            user.UserId,
+		FriendlyName:      user.FriendlyName,
+		LeaseplanLevelKey: user.LeaseplanLevelKey,
+		WatcherActive:     user.WatcherActive,
+		WatcherError:      user.WatcherError,
+		WatcherDelay:      user.WatcherDelay,
+	})
+}
+
+func getUserFilters(w http.ResponseWriter, r *http.Request, caller *principal) {
+	writeJson(w, http.StatusOK, caller.user.GetFilters())
+}
+
+func putUserFilters(w http.ResponseWriter, r *http.Request, caller *principal) {
+	var filters []string
+	if !readJson(w, r, &filters) {
+		return
+	}
+
+	user := caller.user
+	user.SetFilters(filters)
+	user.Save()
+
+	writeJson(w, http.StatusOK, user.GetFilters())
+}
+
+func getUserTemplates(w http.ResponseWriter, r *http.Request, caller *principal) {
+	writeJson(w, http.StatusOK, &UserTemplates{
+		SummaryMessageTemplate: caller.user.SummaryMessageTemplate,
+		DetailMessageTemplate:  caller.user.DetailMessageTemplate,
+	})
+}
+
+func putUserTemplates(w http.ResponseWriter, r *http.Request, caller *principal) {
+	var templates UserTemplates
+	if !readJson(w, r, &templates) {
+		return
+	}
+
+	user := caller.user
+	err := user.SetMessageTemplates(templates.SummaryMessageTemplate, templates.DetailMessageTemplate)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, "templates can not be rendered: "+err.Error())
+		return
+	}
+	user.Save()
+
+	getUserTemplates(w, r, caller)
+}
+
+func getUserThrottle(w http.ResponseWriter, r *http.Request, caller *principal) {
+	writeJson(w, http.StatusOK, &UserThrottle{WatcherDelay: caller.user.WatcherDelay})
+}
+
+func putUserThrottle(w http.ResponseWriter, r *http.Request, caller *principal) {
+	var throttle UserThrottle
+	if !readJson(w, r, &throttle) {
+		return
+	}
+
+	user := caller.user
+	if throttle.WatcherDelay < 0 {
+		writeError(w, http.StatusUnprocessableEntity, "WatcherDelay must not be negative")
+		return
+	}
+	if !caller.admin && !user.IsAdmin && throttle.WatcherDelay < config.MinUserWatcherDelay {
+		writeError(w, http.StatusUnprocessableEntity, fmt.Sprintf("WatcherDelay has to be at least %d minutes", config.MinUserWatcherDelay))
+		return
+	}
+	user.WatcherDelay = throttle.WatcherDelay
+	user.Save()
+
+	getUserThrottle(w, r, caller)
+}
+
+func getUserWatcher(w http.ResponseWriter, r *http.Request, caller *principal) {
+	writeJson(w, http.StatusOK, &UserWatcher{Active: caller.user.WatcherActive, Error: caller.user.WatcherError})
+}
+
+func putUserWatcher(w http.ResponseWriter, r *http.Request, caller *principal) {
+	var watcher UserWatcher
+	if !readJson(w, r, &watcher) {
+		return
+	}
+
+	user := caller.user
+	if watcher.Active {
+		if user.Banned {
+			writeError(w, http.StatusConflict, "the user is banned")
+			return
+		}
+		user.StartWatcher()
+		user.Save()
+		registerUserWatcher(user)
+	} else {
+		user.StopWatcher()
+		user.Save()
+		unregisterUserWatcher(user)
+	}
+
+	getUserWatcher(w, r, caller)
+}
+
+func getUserCars(w http.ResponseWriter, r *http.Request, caller *principal) {
+	user := caller.user
+
+	query, err := parseCarQuery(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	query.LevelKey = user.LeaseplanLevelKey
+
+	cars := getAllCars()
+	cars[user.LeaseplanLevelKey] = config.FilterUpdateList(cars[user.LeaseplanLevelKey], user.GetFilters(), user.TemplateFuncs())
+
+	writeJson(w, http.StatusOK, query.apply(cars))
+}
@@ -0,0 +1,52 @@
+package lpbot
+
+import (
+	"fmt"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/khase/leaseplan-bot/lpbot/config"
+	"github.com/khase/leaseplan-bot/lpbot/tgcon"
+)
+
+var (
+	ApiKeyCmd = &tgcon.MessageCommand{
+		CommandTrigger:   "apikey",
+		ShortDescription: "erstellt einen Schlüssel für die http api",
+		Description:      "",
+		Execute: func(message *tgbotapi.Message) ([]tgbotapi.Chattable, error) {
+			return handleApiKeyCommand(message, UserMap.GetUser(message.From.ID))
+		},
+	}
+)
+
+func handleApiKeyCommand(message *tgbotapi.Message, user *config.User) ([]tgbotapi.Chattable, error) {
+	if user == nil {
+		return nil, tgcon.ErrCommandPermittedForUnknownUser
+	}
+
+	if message.CommandArguments() == "revoke" {
+		user.RevokeApiKey()
+		user.Save()
+
+		msg := tgbotapi.NewMessage(
+			message.Chat.ID,
+			"Dein API Schlüssel wurde gelöscht 🗑")
+		msg.ReplyToMessageID = message.MessageID
+
+		return []tgbotapi.Chattable{msg}, nil
+	}
+
+	key, err := user.GenerateApiKey()
+	if err != nil {
+		return nil, err
+	}
+	user.Save()
+
+	msg := tgbotapi.NewMessage(
+		message.Chat.ID,
+		fmt.Sprintf("Dein neuer API Schlüssel lautet:\n\n`%s`\n\nSende ihn als `Authorization: Bearer <Schlüssel>` Header mit. Ich speichere nur einen Hash, du kannst ihn also später nicht mehr abrufen. Ein vorheriger Schlüssel ist ab sofort ungültig, mit /apikey revoke kannst du ihn löschen.", key))
+	msg.ParseMode = "Markdown"
+	msg.ReplyToMessageID = message.MessageID
+
+	return []tgbotapi.Chattable{msg}, nil
+}
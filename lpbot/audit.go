@@ -0,0 +1,37 @@
+package lpbot
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/khase/leaseplan-bot/lpbot/config"
+)
+
+var (
+	auditLogger = log.New(io.Discard, "", log.LstdFlags)
+)
+
+// openAuditLog directs all following admin actions into the given file. An
+// empty path disables the audit log.
+func openAuditLog(path string) (*os.File, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	auditLogger = log.New(file, "", log.LstdFlags)
+	return file, nil
+}
+
+func audit(admin *config.User, format string, args ...interface{}) {
+	entry := fmt.Sprintf("%s(%d): %s", admin.FriendlyName, admin.UserId, fmt.Sprintf(format, args...))
+
+	log.Printf("Audit: %s", entry)
+	auditLogger.Println(entry)
+}
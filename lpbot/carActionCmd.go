@@ -0,0 +1,80 @@
+package lpbot
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/khase/leaseplan-bot/lpbot/config"
+	"github.com/khase/leaseplan-bot/lpbot/tgcon"
+)
+
+var (
+	CarActionCallback = &tgcon.CallbackCommand{
+		Prefix: config.CarActionCallbackPrefix,
+		Execute: func(query *tgbotapi.CallbackQuery, arguments string) (string, []tgbotapi.Chattable, error) {
+			subscriber, err := getCallbackSubscriber(query)
+			if err != nil {
+				return "", nil, err
+			}
+			return handleCarAction(subscriber, arguments)
+		},
+	}
+)
+
+// getCallbackSubscriber returns the subscription the buttons of a message
+// belong to. In groups and channels only their admins may use them.
+func getCallbackSubscriber(query *tgbotapi.CallbackQuery) (*config.User, error) {
+	user := UserMap.GetUser(query.From.ID)
+	if user != nil && user.Banned {
+		return nil, tgcon.ErrUserBanned
+	}
+	if query.Message == nil {
+		return nil, tgcon.ErrCommandNotImplemented
+	}
+
+	subscriber := UserMap.GetUser(query.Message.Chat.ID)
+	if subscriber == nil {
+		return nil, tgcon.ErrCommandPermittedForUnknownUser
+	}
+	if subscriber.IsChat() && (user == nil || !user.IsAdmin) && !isChatAdmin(query.Message.Chat.ID, query.From.ID) {
+		return nil, tgcon.ErrChatAdminRequired
+	}
+
+	return subscriber, nil
+}
+
+func handleCarAction(user *config.User, arguments string) (string, []tgbotapi.Chattable, error) {
+	action, ident, _ := strings.Cut(arguments, ":")
+	car, exists := user.FindCar(ident)
+	if !exists && !(action == config.CarActionWatch && user.IsWatching(ident)) {
+		return "Dieses Auto ist nicht mehr verfügbar 😢", nil, nil
+	}
+
+	var text string
+	switch action {
+	case config.CarActionWatch:
+		if user.IsWatching(ident) {
+			user.UnwatchCar(ident)
+			text = "Du beobachtest das Auto nicht mehr 🔕"
+		} else if err := user.WatchCar(car); errors.Is(err, config.ErrWatchlistFull) {
+			return fmt.Sprintf("Du beobachtest bereits %d Autos, entferne zuerst welche mit /watch remove", config.MaxWatchedCars), nil, nil
+		} else {
+			text = fmt.Sprintf("Ich sage dir Bescheid, sobald sich %s ändert 🔔", car.OfferTypeName)
+		}
+	case config.CarActionIgnore:
+		user.IgnoreModel(car)
+		user.SaveUserCache()
+		text = fmt.Sprintf("Du bekommst keine Updates mehr zu %s 🙈 (/filter)", config.CarModel(car))
+	case config.CarActionSnooze:
+		user.SnoozeCar(car, config.SnoozeDuration)
+		user.SaveUserCache()
+		text = "Ich erinnere dich in 24 Stunden wieder an dieses Auto 💤"
+	default:
+		return "", nil, tgcon.ErrCommandNotImplemented
+	}
+	user.Save()
+
+	return text, nil, nil
+}
@@ -0,0 +1,261 @@
+package lpbot
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/khase/leaseplan-bot/lpbot/config"
+	"github.com/khase/leaseplan-bot/lpbot/lpcon"
+	"github.com/khase/leaseplan-bot/lpbot/tgcon"
+)
+
+const (
+	groupUsage   = "Verwendung (in der Gruppe):\n/group - zeigt den Status der Gruppe\n/group subscribe - die Gruppe bekommt ab sofort Updates mit den Autos die du sehen kannst\n/group unsubscribe - beendet die Updates für die Gruppe\n\nFilter und Formate stellen Admins der Gruppe mit den üblichen Kommandos (/filter, /setsummarymessageformat, /throttle, ...) direkt in der Gruppe ein."
+	channelUsage = "Verwendung:\n/channel list - zeigt deine Kanäle und Gruppen\n/channel add <@kanal oder id> - der Kanal bekommt ab sofort Updates mit den Autos die du sehen kannst\n/channel edit <kanal> - die folgenden Kommandos (/filter, /setsummarymessageformat, /throttle, ...) stellen den Kanal ein\n/channel edit off - die Kommandos stellen wieder deine eigenen Updates ein\n/channel remove <kanal> - beendet die Updates für den Kanal\n\nFüge mich vorher als Admin mit dem Recht Nachrichten zu senden zu deinem Kanal hinzu."
+)
+
+var (
+	GroupCmd = &tgcon.MessageCommand{
+		CommandTrigger:   "group",
+		ShortDescription: "richtet Updates für eine Gruppe ein",
+		Description:      groupUsage,
+		GroupCommand:     true,
+		Execute: func(message *tgbotapi.Message) ([]tgbotapi.Chattable, error) {
+			return handleGroupCommand(message, UserMap.GetUser(message.From.ID))
+		},
+	}
+	ChannelCmd = &tgcon.MessageCommand{
+		CommandTrigger:   "channel",
+		ShortDescription: "richtet Updates für einen Kanal ein",
+		Description:      channelUsage,
+		Execute: func(message *tgbotapi.Message) ([]tgbotapi.Chattable, error) {
+			return handleChannelCommand(message, UserMap.GetUser(message.From.ID))
+		},
+	}
+)
+
+// getSubscriber returns the subscription a command configures: the group in
+// group chats, the chat a user currently edits or the user himself.
+func getSubscriber(message *tgbotapi.Message) *config.User {
+	if !message.Chat.IsPrivate() {
+		return UserMap.GetUser(message.Chat.ID)
+	}
+
+	user := UserMap.GetUser(message.From.ID)
+	if user != nil && user.EditingChatId != 0 {
+		chat := UserMap.GetUser(user.EditingChatId)
+		if chat != nil && chat.OwnerId == user.UserId {
+			return chat
+		}
+	}
+
+	return user
+}
+
+// checkChatPermission only lets the admins of a group (and bot admins) use
+// group commands.
+func checkChatPermission(message *tgbotapi.Message, cmd *tgcon.MessageCommand, user *config.User) error {
+	if !cmd.GroupCommand {
+		return tgcon.ErrCommandOnlyInPrivateChat
+	}
+	if cmd != GroupCmd && UserMap.GetUser(message.Chat.ID) == nil {
+		return tgcon.ErrChatNotSubscribed
+	}
+	if user != nil && user.IsAdmin {
+		return nil
+	}
+	if !isChatAdmin(message.Chat.ID, message.From.ID) {
+		return tgcon.ErrChatAdminRequired
+	}
+
+	return nil
+}
+
+func isChatAdmin(chatId int64, userId int64) bool {
+	member, err := tgConnector.GetTgBotApi().GetChatMember(tgbotapi.GetChatMemberConfig{
+		ChatConfigWithUser: tgbotapi.ChatConfigWithUser{ChatID: chatId, UserID: userId},
+	})
+	if err != nil {
+		return false
+	}
+
+	return member.IsCreator() || member.IsAdministrator()
+}
+
+func handleGroupCommand(message *tgbotapi.Message, user *config.User) ([]tgbotapi.Chattable, error) {
+	if message.Chat.IsPrivate() {
+		return replyText(message, "Füge mich zu einer Gruppe hinzu und sende dort /group subscribe. Für Kanäle gibt es /channel.\n\n"+groupUsage), nil
+	}
+
+	chat := UserMap.GetUser(message.Chat.ID)
+	switch strings.TrimSpace(message.CommandArguments()) {
+	case "":
+		if chat == nil {
+			return replyText(message, "Diese Gruppe bekommt keine Updates.\n\n"+groupUsage), nil
+		}
+		return replyText(message, getChatStatus(chat)), nil
+	case "subscribe":
+		if user == nil {
+			return nil, tgcon.ErrCommandPermittedForUnknownUser
+		}
+		return subscribeChat(message, user, message.Chat.ID, message.Chat.Type, message.Chat.Title)
+	case "unsubscribe":
+		if chat == nil {
+			return replyText(message, "Diese Gruppe bekommt keine Updates."), nil
+		}
+		return unsubscribeChat(message, chat)
+	}
+
+	return replyText(message, groupUsage), nil
+}
+
+func handleChannelCommand(message *tgbotapi.Message, user *config.User) ([]tgbotapi.Chattable, error) {
+	if user == nil {
+		return nil, tgcon.ErrCommandPermittedForUnknownUser
+	}
+
+	args := strings.Fields(message.CommandArguments())
+	if len(args) == 0 {
+		return replyText(message, channelUsage), nil
+	}
+
+	switch {
+	case args[0] == "list":
+		lines := []string{"Deine Kanäle und Gruppen:"}
+		for _, chat := range UserMap.GetChatSubscriptions(user) {
+			line := fmt.Sprintf("%s %s (%d, %s)", getUserStatusIcon(chat), chat.FriendlyName, chat.UserId, chat.ChatType)
+			if chat.UserId == user.EditingChatId {
+				line += " ✏️"
+			}
+			lines = append(lines, line)
+		}
+		if len(lines) == 1 {
+			lines = append(lines, "keine")
+		}
+		return replyLines(message, lines), nil
+	case args[0] == "add" && len(args) == 2:
+		chat, err := tgConnector.GetTgBotApi().GetChat(tgbotapi.ChatInfoConfig{ChatConfig: getChatConfig(args[1])})
+		if err != nil {
+			return replyText(message, "Ich konnte den Kanal nicht finden. Füge mich zuerst als Admin hinzu.\n\n"+channelUsage), nil
+		}
+		if chat.Type != config.ChatTypeChannel {
+			return replyText(message, "Das ist kein Kanal. Gruppen richtest du mit /group subscribe direkt in der Gruppe ein."), nil
+		}
+		if !canPostToChannel(chat.ID) {
+			return replyText(message, "Ich bin in diesem Kanal kein Admin mit dem Recht Nachrichten zu senden."), nil
+		}
+		if !isChatAdmin(chat.ID, user.UserId) {
+			return replyText(message, "Nur Admins des Kanals können ihn einrichten 👮"), nil
+		}
+		return subscribeChat(message, user, chat.ID, chat.Type, chat.Title)
+	case args[0] == "edit" && len(args) == 2 && args[1] == "off":
+		user.EditingChatId = 0
+		user.Save()
+
+		return replyText(message, "Die Kommandos stellen ab sofort wieder deine eigenen Updates ein."), nil
+	case (args[0] == "edit" || args[0] == "remove") && len(args) == 2:
+		chat := findOwnedChat(user, args[1])
+		if chat == nil {
+			return replyText(message, fmt.Sprintf("Du hast keinen Kanal %s eingerichtet. Deine Kanäle zeigt /channel list.", args[1])), nil
+		}
+
+		if args[0] == "remove" {
+			return unsubscribeChat(message, chat)
+		}
+		user.EditingChatId = chat.UserId
+		user.Save()
+
+		return replyText(message, fmt.Sprintf("Die folgenden Kommandos stellen %s ein. Zurück zu deinen eigenen Updates geht es mit /channel edit off.", chat.FriendlyName)), nil
+	}
+
+	return replyText(message, channelUsage), nil
+}
+
+func subscribeChat(message *tgbotapi.Message, owner *config.User, chatId int64, chatType string, title string) ([]tgbotapi.Chattable, error) {
+	chat, err := UserMap.CreateChatSubscription(chatId, chatType, title, owner)
+	if errors.Is(err, config.ErrChatAlreadySubscribed) {
+		return replyText(message, "Dieser Chat bekommt bereits Updates."), nil
+	} else if errors.Is(err, config.ErrNoLevelKey) {
+		return replyText(message, "Ich kenne dein Leaseplan Level noch nicht. Richte zuerst deinen eigenen Zugang ein (/login, /setToken oder /connect)."), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	chat.StartWatcher()
+	chat.Save()
+	lpcon.RegisterUserWatcher(chat)
+
+	return replyText(message, fmt.Sprintf("%s bekommt ab sofort Updates mit den Autos die %s sehen kann 🎉", chat.FriendlyName, owner.FriendlyName)), nil
+}
+
+func unsubscribeChat(message *tgbotapi.Message, chat *config.User) ([]tgbotapi.Chattable, error) {
+	lpcon.UnregisterUserWatcher(chat)
+	err := UserMap.RemoveChatSubscription(chat.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	return replyText(message, fmt.Sprintf("%s bekommt keine Updates mehr 👋", chat.FriendlyName)), nil
+}
+
+func getChatStatus(chat *config.User) string {
+	owner := chat.GetOwner()
+	ownerName := strconv.FormatInt(chat.OwnerId, 10)
+	if owner != nil {
+		ownerName = owner.FriendlyName
+	}
+
+	status := "pausiert (/resume)"
+	if chat.WatcherActive {
+		status = "aktiv"
+	}
+
+	return fmt.Sprintf("%s bekommt Updates mit den Autos die %s sehen kann.\nStatus: %s\nFilter: %d\nDrosselung: %d Minuten", chat.FriendlyName, ownerName, status, len(chat.Filters), chat.WatcherDelay)
+}
+
+func getChatConfig(query string) tgbotapi.ChatConfig {
+	chatId, err := strconv.ParseInt(query, 10, 64)
+	if err == nil {
+		return tgbotapi.ChatConfig{ChatID: chatId}
+	}
+
+	return tgbotapi.ChatConfig{SuperGroupUsername: "@" + strings.TrimPrefix(query, "@")}
+}
+
+func canPostToChannel(chatId int64) bool {
+	bot := tgConnector.GetTgBotApi()
+	member, err := bot.GetChatMember(tgbotapi.GetChatMemberConfig{
+		ChatConfigWithUser: tgbotapi.ChatConfigWithUser{ChatID: chatId, UserID: bot.Self.ID},
+	})
+	if err != nil {
+		return false
+	}
+
+	return member.IsAdministrator() && member.CanPostMessages
+}
+
+// findOwnedChat looks up a chat of the user by id, title or @username.
+func findOwnedChat(user *config.User, query string) *config.User {
+	chats := UserMap.GetChatSubscriptions(user)
+	for _, chat := range chats {
+		if strconv.FormatInt(chat.UserId, 10) == query || strings.EqualFold(chat.FriendlyName, query) {
+			return chat
+		}
+	}
+
+	if strings.HasPrefix(query, "@") {
+		resolved, err := tgConnector.GetTgBotApi().GetChat(tgbotapi.ChatInfoConfig{ChatConfig: getChatConfig(query)})
+		if err == nil {
+			for _, chat := range chats {
+				if chat.UserId == resolved.ID {
+					return chat
+				}
+			}
+		}
+	}
+
+	return nil
+}
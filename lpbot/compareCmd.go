@@ -0,0 +1,99 @@
+package lpbot
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/khase/leaseplan-bot/lpbot/config"
+	"github.com/khase/leaseplan-bot/lpbot/lpcon"
+	"github.com/khase/leaseplan-bot/lpbot/tgcon"
+)
+
+const (
+	compareUsage = "Verwendung:\n/compare - vergleicht die Autos aller Stufen: Benutzer, Autos, exklusive Autos und die größten BGV Unterschiede\n/compare <Stufe> - listet die Autos, die nur auf dieser Stufe sichtbar sind"
+
+	// maxCompareDifferences limits the BGV differences listed by /compare
+	maxCompareDifferences = 15
+)
+
+var (
+	CompareCmd = &tgcon.MessageCommand{
+		CommandTrigger:   "compare",
+		ShortDescription: "vergleicht die Autos der Leaseplan Stufen",
+		Description:      compareUsage,
+		AdminOnly:        true,
+		Execute: func(message *tgbotapi.Message) ([]tgbotapi.Chattable, error) {
+			return handleCompareCommand(message)
+		},
+	}
+)
+
+func handleCompareCommand(message *tgbotapi.Message) ([]tgbotapi.Chattable, error) {
+	comparison := config.CompareLevels(lpcon.GetCars(), UserMap)
+	if len(comparison.Levels) < 2 {
+		return replyText(message, "Zum Vergleichen braucht es mindestens zwei Stufen mit Benutzern oder Autos."), nil
+	}
+
+	levelKey := strings.TrimSpace(message.CommandArguments())
+	if levelKey != "" {
+		return handleCompareLevel(message, comparison, levelKey), nil
+	}
+
+	lines := []string{"Stufen:"}
+	for _, level := range comparison.Levels {
+		lines = append(lines, fmt.Sprintf("%s: %d Benutzer, %d Autos, davon %d exklusiv", level.LevelKey, level.Users, level.Cars, level.ExclusiveCars))
+	}
+
+	lines = append(lines, "", "BGV Unterschiede:")
+	if len(comparison.Differences) == 0 {
+		lines = append(lines, "Alle Autos kosten auf jeder Stufe gleich viel.")
+	}
+	for i, difference := range comparison.Differences {
+		if i >= maxCompareDifferences {
+			lines = append(lines, fmt.Sprintf("… und %d weitere", len(comparison.Differences)-maxCompareDifferences))
+			break
+		}
+		lines = append(lines, fmt.Sprintf("%s (%s): %s, Δ %d€", difference.Name, difference.Ident, formatSalaryWaivers(difference.SalaryWaivers), difference.Spread))
+	}
+
+	return replyLines(message, lines), nil
+}
+
+func handleCompareLevel(message *tgbotapi.Message, comparison *config.LevelComparison, levelKey string) []tgbotapi.Chattable {
+	exists := false
+	for _, level := range comparison.Levels {
+		exists = exists || level.LevelKey == levelKey
+	}
+	if !exists {
+		return replyText(message, fmt.Sprintf("Die Stufe \"%s\" kenne ich leider nicht 😨", levelKey))
+	}
+
+	cars := comparison.Exclusive[levelKey]
+	if len(cars) == 0 {
+		return replyText(message, fmt.Sprintf("Auf %s gibt es keine exklusiven Autos.", levelKey))
+	}
+
+	lines := []string{fmt.Sprintf("%d Autos gibt es nur auf %s:", len(cars), levelKey)}
+	for _, car := range cars {
+		lines = append(lines, fmt.Sprintf("%s (%s), BGV: %d€", config.CarModel(car), car.RentalObject.Ident, car.SalaryWaiver))
+	}
+
+	return replyLines(message, lines)
+}
+
+func formatSalaryWaivers(salaryWaivers map[string]int64) string {
+	levelKeys := make([]string, 0, len(salaryWaivers))
+	for levelKey := range salaryWaivers {
+		levelKeys = append(levelKeys, levelKey)
+	}
+	sort.Strings(levelKeys)
+
+	values := make([]string, 0, len(levelKeys))
+	for _, levelKey := range levelKeys {
+		values = append(values, fmt.Sprintf("%s %d€", levelKey, salaryWaivers[levelKey]))
+	}
+
+	return strings.Join(values, ", ")
+}
@@ -0,0 +1,257 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	DefaultCacheDir  = "cache"
+	DefaultSendDelay = 5
+)
+
+var (
+	ErrInvalidConfig = errors.New("invalid config")
+
+	// sendDelay is the time changes are held back for non admin users, it is
+	// changed by reloads while updates read it, so it is accessed atomically
+	sendDelay = int64(DefaultSendDelay * time.Minute)
+)
+
+// BotConfig holds all settings of the bot. The yaml keys are the keys of the
+// config file and of the viper flags.
+type BotConfig struct {
+	TelegramApiToken string `yaml:"telegramApiToken"`
+	Debug            bool   `yaml:"debug"`
+
+	UserDataFile string `yaml:"userDataFile"`
+	CreateNew    bool   `yaml:"new"`
+	AuditLogFile string `yaml:"auditLogFile"`
+	CacheDir     string `yaml:"cacheDir"`
+
+	WatcherDelay      int `yaml:"watcherDelay"`
+	WatcherPageSize   int `yaml:"watcherPageSize"`
+	TokenWarningHours int `yaml:"tokenWarningHours"`
+	SendDelay         int `yaml:"sendDelay"`
+
+	ApiEnabled   bool     `yaml:"apiEnabled"`
+	ApiAddress   string   `yaml:"apiAddress"`
+	ApiTlsCert   string   `yaml:"apiTlsCert"`
+	ApiTlsKey    string   `yaml:"apiTlsKey"`
+	ApiAdminKeys []string `yaml:"apiAdminKeys"`
+
+	SmtpHost     string `yaml:"smtpHost"`
+	SmtpPort     int    `yaml:"smtpPort"`
+	SmtpUsername string `yaml:"smtpUsername"`
+	SmtpPassword string `yaml:"smtpPassword"`
+	SmtpFrom     string `yaml:"smtpFrom"`
+	SmtpStartTls bool   `yaml:"smtpStartTls"`
+}
+
+// LoadBotConfig reads the settings from viper, i.e. from the flags, the
+// environment and the config file.
+func LoadBotConfig() *BotConfig {
+	return &BotConfig{
+		TelegramApiToken: viper.GetString("telegramApiToken"),
+		Debug:            viper.GetBool("debug"),
+
+		UserDataFile: viper.GetString("userDataFile"),
+		CreateNew:    viper.GetBool("new"),
+		AuditLogFile: viper.GetString("auditLogFile"),
+		CacheDir:     viper.GetString("cacheDir"),
+
+		WatcherDelay:      viper.GetInt("watcherDelay"),
+		WatcherPageSize:   viper.GetInt("watcherPageSize"),
+		TokenWarningHours: viper.GetInt("tokenWarningHours"),
+		SendDelay:         viper.GetInt("sendDelay"),
+
+		ApiEnabled:   viper.GetBool("apiEnabled"),
+		ApiAddress:   viper.GetString("apiAddress"),
+		ApiTlsCert:   viper.GetString("apiTlsCert"),
+		ApiTlsKey:    viper.GetString("apiTlsKey"),
+		ApiAdminKeys: viper.GetStringSlice("apiAdminKeys"),
+
+		SmtpHost:     viper.GetString("smtpHost"),
+		SmtpPort:     viper.GetInt("smtpPort"),
+		SmtpUsername: viper.GetString("smtpUsername"),
+		SmtpPassword: viper.GetString("smtpPassword"),
+		SmtpFrom:     viper.GetString("smtpFrom"),
+		SmtpStartTls: viper.GetBool("smtpStartTls"),
+	}
+}
+
+// Validate checks all settings and reports every problem found. With create
+// the cache directory is created if it does not exist yet, otherwise nothing
+// is written, e.g. to only check a config.
+func (botConfig *BotConfig) Validate(create bool) error {
+	problems := []string{}
+	addProblem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if botConfig.TelegramApiToken == "" {
+		addProblem("telegramApiToken is required (flag -t)")
+	}
+
+	if botConfig.UserDataFile == "" {
+		addProblem("userDataFile is required")
+	} else if _, err := os.Stat(botConfig.UserDataFile); err != nil && !(errors.Is(err, os.ErrNotExist) && botConfig.CreateNew) {
+		addProblem("userDataFile can not be read (use --new to create it): %s", err)
+	}
+	if botConfig.CacheDir == "" {
+		addProblem("cacheDir is required")
+	} else if create {
+		if err := checkWritableDir(botConfig.CacheDir); err != nil {
+			addProblem("cacheDir is not writable: %s", err)
+		}
+	} else if err := checkCreatableDir(botConfig.CacheDir); err != nil {
+		addProblem("cacheDir can not be used: %s", err)
+	}
+
+	if botConfig.WatcherDelay < 1 {
+		addProblem("watcherDelay must be at least 1 minute but is %d", botConfig.WatcherDelay)
+	}
+	if botConfig.WatcherPageSize < 1 {
+		addProblem("watcherPageSize must be at least 1 but is %d", botConfig.WatcherPageSize)
+	}
+	if botConfig.TokenWarningHours < 0 {
+		addProblem("tokenWarningHours must not be negative but is %d", botConfig.TokenWarningHours)
+	}
+	if botConfig.SendDelay < 0 {
+		addProblem("sendDelay must not be negative but is %d", botConfig.SendDelay)
+	}
+
+	if botConfig.ApiEnabled {
+		if err := checkAddress(botConfig.ApiAddress); err != nil {
+			addProblem("apiAddress %q is invalid: %s", botConfig.ApiAddress, err)
+		}
+		if (botConfig.ApiTlsCert == "") != (botConfig.ApiTlsKey == "") {
+			addProblem("apiTlsCert and apiTlsKey have to be set together")
+		}
+	}
+
+	if botConfig.SmtpHost != "" {
+		if botConfig.SmtpPort < 1 || botConfig.SmtpPort > 65535 {
+			addProblem("smtpPort must be between 1 and 65535 but is %d", botConfig.SmtpPort)
+		}
+		if botConfig.SmtpFrom == "" {
+			addProblem("smtpFrom is required when smtpHost is set")
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%w:\n  %s", ErrInvalidConfig, strings.Join(problems, "\n  "))
+	}
+
+	return nil
+}
+
+// checkWritableDir creates the directory if needed and writes a temporary
+// file to it.
+func checkWritableDir(dir string) error {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(dir, ".writecheck")
+	if err != nil {
+		return err
+	}
+	file.Close()
+
+	return os.Remove(file.Name())
+}
+
+// checkCreatableDir checks without writing anything that the directory exists
+// or that its nearest existing parent is a directory it can be created in.
+func checkCreatableDir(dir string) error {
+	path := dir
+	for {
+		info, err := os.Stat(path)
+		if err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("%s is not a directory", path)
+			}
+			return nil
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+
+		parent := filepath.Dir(path)
+		if parent == path {
+			return err
+		}
+		path = parent
+	}
+}
+
+func checkAddress(address string) error {
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	portNumber, err := strconv.Atoi(port)
+	if err != nil || portNumber < 1 || portNumber > 65535 {
+		return fmt.Errorf("port must be between 1 and 65535")
+	}
+
+	return nil
+}
+
+// Redacted returns a copy of the config without the token, passwords and
+// keys, e.g. for printing it.
+func (botConfig *BotConfig) Redacted() *BotConfig {
+	redacted := *botConfig
+	for _, secret := range []*string{&redacted.TelegramApiToken, &redacted.SmtpPassword} {
+		if *secret != "" {
+			*secret = "<redacted>"
+		}
+	}
+	redacted.ApiAdminKeys = make([]string, len(botConfig.ApiAdminKeys))
+	for i := range redacted.ApiAdminKeys {
+		redacted.ApiAdminKeys[i] = "<redacted>"
+	}
+
+	return &redacted
+}
+
+func (botConfig *BotConfig) GetSmtpConfig() SmtpConfig {
+	return SmtpConfig{
+		Host:     botConfig.SmtpHost,
+		Port:     botConfig.SmtpPort,
+		Username: botConfig.SmtpUsername,
+		Password: botConfig.SmtpPassword,
+		From:     botConfig.SmtpFrom,
+		StartTls: botConfig.SmtpStartTls,
+	}
+}
+
+// SetCacheDir selects the directory the caches and stats of the users are
+// stored in.
+func SetCacheDir(dir string) {
+	cacheBasePath = dir
+}
+
+// SetSendDelay sets the time changes are held back for non admin users
+// before they are sent, in minutes.
+func SetSendDelay(minutes int) {
+	atomic.StoreInt64(&sendDelay, int64(time.Duration(minutes)*time.Minute))
+}
+
+func GetSendDelay() int {
+	return int(getSendDelay() / time.Minute)
+}
+
+func getSendDelay() time.Duration {
+	return time.Duration(atomic.LoadInt64(&sendDelay))
+}
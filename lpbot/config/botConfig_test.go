@@ -0,0 +1,92 @@
+package config_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/khase/leaseplan-bot/lpbot/config"
+)
+
+func getValidBotConfig(t *testing.T) *config.BotConfig {
+	dir := t.TempDir()
+	return &config.BotConfig{
+		TelegramApiToken:  "token",
+		UserDataFile:      filepath.Join(dir, "test.userdata"),
+		CreateNew:         true,
+		CacheDir:          filepath.Join(dir, "cache"),
+		WatcherDelay:      15,
+		WatcherPageSize:   20,
+		TokenWarningHours: 24,
+		SendDelay:         5,
+		ApiEnabled:        true,
+		ApiAddress:        ":2112",
+		ApiAdminKeys:      []string{"key"},
+		SmtpPort:          587,
+		SmtpPassword:      "password",
+	}
+}
+
+func TestBotConfigValidate(t *testing.T) {
+	botConfig := getValidBotConfig(t)
+	if err := botConfig.Validate(false); err != nil {
+		t.Fatalf("expected the config to be valid but got %v", err)
+	}
+	if _, err := os.Stat(botConfig.CacheDir); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected a check not to create the cache dir but got %v", err)
+	}
+	if err := botConfig.Validate(true); err != nil {
+		t.Fatalf("expected the config to be valid but got %v", err)
+	}
+	if _, err := os.Stat(botConfig.CacheDir); err != nil {
+		t.Fatalf("expected the cache dir to be created but got %v", err)
+	}
+
+	botConfig.TelegramApiToken = ""
+	botConfig.WatcherDelay = 0
+	botConfig.ApiAddress = "localhost"
+	botConfig.ApiTlsCert = "cert.pem"
+	botConfig.SmtpHost = "mail"
+	err := botConfig.Validate(false)
+	if !errors.Is(err, config.ErrInvalidConfig) {
+		t.Fatalf("expected the config to be invalid but got %v", err)
+	}
+	for _, key := range []string{"telegramApiToken", "watcherDelay", "apiAddress", "apiTlsCert", "smtpFrom"} {
+		if !strings.Contains(err.Error(), key) {
+			t.Errorf("expected a problem with %s in %q", key, err)
+		}
+	}
+
+	botConfig = getValidBotConfig(t)
+	botConfig.CreateNew = false
+	if err := botConfig.Validate(false); err == nil || !strings.Contains(err.Error(), "userDataFile") {
+		t.Fatalf("expected a missing userdata file to be reported but got %v", err)
+	}
+
+	botConfig = getValidBotConfig(t)
+	botConfig.CacheDir = filepath.Join(botConfig.UserDataFile, "cache")
+	os.WriteFile(botConfig.UserDataFile, []byte{}, 0600)
+	for _, create := range []bool{false, true} {
+		if err := botConfig.Validate(create); err == nil || !strings.Contains(err.Error(), "cacheDir") {
+			t.Fatalf("expected an unusable cache dir to be reported with create %t but got %v", create, err)
+		}
+	}
+}
+
+func TestBotConfigRedacted(t *testing.T) {
+	botConfig := getValidBotConfig(t)
+	redacted := botConfig.Redacted()
+
+	if redacted.TelegramApiToken != "<redacted>" || redacted.SmtpPassword != "<redacted>" || redacted.ApiAdminKeys[0] != "<redacted>" {
+		t.Fatalf("expected the secrets to be redacted")
+	}
+	botConfig.SmtpPassword = ""
+	if botConfig.Redacted().SmtpPassword != "" {
+		t.Fatalf("expected unset secrets to stay empty")
+	}
+	if botConfig.TelegramApiToken != "token" || botConfig.ApiAdminKeys[0] != "key" {
+		t.Fatalf("expected the config itself to be unchanged")
+	}
+}
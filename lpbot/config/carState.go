@@ -0,0 +1,357 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/khase/leaseplanabocarexporter/dto"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	CarActionCallbackPrefix = "car"
+
+	CarActionWatch  = "watch"
+	CarActionIgnore = "ignore"
+	CarActionSnooze = "snooze"
+
+	SnoozeDuration = 24 * time.Hour
+
+	MaxWatchedCars = 50
+)
+
+var (
+	ErrInvalidCarReference = errors.New("neither a portal url nor an ident of a car")
+	ErrWatchlistFull       = errors.New("too many watched cars")
+
+	// carIdentPattern matches the idents of leaseplan, e.g.
+	// CCUNZEZE2022052522095673CB8DCB
+	carIdentPattern = regexp.MustCompile(`^[A-Z0-9]{20,40}$`)
+)
+
+// WatchedCar is a car the user follows regardless of his filters. It stores
+// the last seen state to report changes.
+type WatchedCar struct {
+	Ident            string    `yaml:"Ident"`
+	OfferIdent       string    `yaml:"OfferIdent,omitempty"`
+	Name             string    `yaml:"Name,omitempty"`
+	SalaryWaiver     int64     `yaml:"SalaryWaiver,omitempty"`
+	PriceProducer1   float64   `yaml:"PriceProducer1,omitempty"`
+	DateRegistration time.Time `yaml:"DateRegistration,omitempty"`
+	Available        bool      `yaml:"Available,omitempty"`
+	Added            time.Time `yaml:"Added,omitempty"`
+}
+
+func newWatchedCar(car dto.Item) *WatchedCar {
+	watched := &WatchedCar{Ident: car.RentalObject.Ident, Added: time.Now()}
+	watched.update(car)
+
+	return watched
+}
+
+func (watched *WatchedCar) update(car dto.Item) {
+	watched.OfferIdent = car.Ident
+	watched.Name = carName(car)
+	watched.SalaryWaiver = car.SalaryWaiver
+	watched.PriceProducer1 = car.RentalObject.PriceProducer1
+	watched.DateRegistration = car.RentalObject.DateRegistration.Time
+	watched.Available = true
+}
+
+// getChanges describes how the car differs from the last seen state.
+func (watched *WatchedCar) getChanges(car dto.Item) []string {
+	changes := []string{}
+	if watched.SalaryWaiver != car.SalaryWaiver {
+		changes = append(changes, fmt.Sprintf("BGV: %d€ -> %d€", watched.SalaryWaiver, car.SalaryWaiver))
+	}
+	if watched.PriceProducer1 != car.RentalObject.PriceProducer1 {
+		changes = append(changes, fmt.Sprintf("BLP: %.2f€ -> %.2f€", watched.PriceProducer1, car.RentalObject.PriceProducer1))
+	}
+	if !watched.DateRegistration.Equal(car.RentalObject.DateRegistration.Time) {
+		changes = append(changes, fmt.Sprintf("Verfügbar: %s -> %s", watched.DateRegistration.Format("02.01.2006"), car.RentalObject.DateRegistration.Format("02.01.2006")))
+	}
+
+	return changes
+}
+
+// Url returns the portal url of the car, which is unknown for cars that were
+// added by ident and have not been seen yet.
+func (watched *WatchedCar) Url() string {
+	if watched.OfferIdent == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s/offer-details/%s/%s", portalBaseUrl, watched.OfferIdent, watched.Ident)
+}
+
+// IsWatching reports whether the car with the given RentalObject.Ident is on
+// the watchlist.
+func (user *User) IsWatching(ident string) bool {
+	user.mutex().Lock()
+	defer user.mutex().Unlock()
+
+	_, exists := user.WatchedCars[ident]
+	return exists
+}
+
+// WatchCar adds the car to the watchlist of the user.
+func (user *User) WatchCar(car dto.Item) error {
+	user.mutex().Lock()
+	defer user.mutex().Unlock()
+
+	if user.WatchedCars == nil {
+		user.WatchedCars = map[string]*WatchedCar{}
+	}
+	if _, exists := user.WatchedCars[car.RentalObject.Ident]; !exists && len(user.WatchedCars) >= MaxWatchedCars {
+		return ErrWatchlistFull
+	}
+	user.WatchedCars[car.RentalObject.Ident] = newWatchedCar(car)
+
+	return nil
+}
+
+// WatchUnavailableCar adds a car that is currently not visible to the user,
+// he is notified once it appears.
+func (user *User) WatchUnavailableCar(ident string, offerIdent string) error {
+	user.mutex().Lock()
+	defer user.mutex().Unlock()
+
+	if user.WatchedCars == nil {
+		user.WatchedCars = map[string]*WatchedCar{}
+	}
+	if _, exists := user.WatchedCars[ident]; !exists && len(user.WatchedCars) >= MaxWatchedCars {
+		return ErrWatchlistFull
+	}
+	user.WatchedCars[ident] = &WatchedCar{Ident: ident, OfferIdent: offerIdent, Name: ident, Added: time.Now()}
+
+	return nil
+}
+
+// GetWatchedCar returns a copy of the watched car with the given
+// RentalObject.Ident.
+func (user *User) GetWatchedCar(ident string) (*WatchedCar, bool) {
+	user.mutex().Lock()
+	defer user.mutex().Unlock()
+
+	watched, exists := user.WatchedCars[ident]
+	if !exists {
+		return nil, false
+	}
+	copied := *watched
+
+	return &copied, true
+}
+
+// GetWatchedCars returns copies of the watchlist ordered by the time the cars
+// were added.
+func (user *User) GetWatchedCars() []*WatchedCar {
+	user.mutex().Lock()
+	watched := copyWatchedCars(user.WatchedCars)
+	user.mutex().Unlock()
+
+	sort.Slice(watched, func(i, j int) bool {
+		if watched[i].Added.Equal(watched[j].Added) {
+			return watched[i].Ident < watched[j].Ident
+		}
+		return watched[i].Added.Before(watched[j].Added)
+	})
+
+	return watched
+}
+
+func copyWatchedCars(watchedCars map[string]*WatchedCar) []*WatchedCar {
+	copies := make([]*WatchedCar, 0, len(watchedCars))
+	for _, watched := range watchedCars {
+		copied := *watched
+		copies = append(copies, &copied)
+	}
+
+	return copies
+}
+
+// ParseCarReference extracts the RentalObject.Ident and, if present, the
+// offer ident from a portal url or returns a plain ident as is. Idents have
+// to look like those of leaseplan, so e.g. misspelled subcommands are not
+// taken as ident.
+func ParseCarReference(reference string) (ident string, offerIdent string, err error) {
+	reference = strings.TrimSpace(reference)
+	if strings.Contains(reference, "://") {
+		parsed, err := url.Parse(reference)
+		if err != nil {
+			return "", "", fmt.Errorf("%w: %s", ErrInvalidCarReference, err)
+		}
+		segments := strings.Split(strings.Trim(parsed.Path, "/"), "/")
+		if len(segments) != 3 || segments[0] != "offer-details" || !carIdentPattern.MatchString(segments[1]) || !carIdentPattern.MatchString(segments[2]) {
+			return "", "", fmt.Errorf("%w: %s", ErrInvalidCarReference, reference)
+		}
+
+		return segments[2], segments[1], nil
+	}
+
+	if !carIdentPattern.MatchString(reference) {
+		return "", "", fmt.Errorf("%w: %s", ErrInvalidCarReference, reference)
+	}
+
+	return reference, "", nil
+}
+
+func (user *User) UnwatchCar(ident string) {
+	user.mutex().Lock()
+	defer user.mutex().Unlock()
+
+	delete(user.WatchedCars, ident)
+}
+
+// SnoozeCar hides the car for the given duration. It is dropped from the last
+// frame as well, so it will be reported as new once the snooze expired.
+func (user *User) SnoozeCar(car dto.Item, duration time.Duration) {
+	user.mutex().Lock()
+	defer user.mutex().Unlock()
+
+	if user.SnoozedCars == nil {
+		user.SnoozedCars = map[string]time.Time{}
+	}
+	user.SnoozedCars[car.RentalObject.Ident] = time.Now().Add(duration)
+
+	user.LastFrame = user.LastFrame.withCurrent(removeCars(user.LastFrame.Current, func(item dto.Item) bool {
+		return item.RentalObject.Ident == car.RentalObject.Ident
+	}))
+}
+
+// IgnoreModel adds a filter hiding all cars of the same label and model
+// specification and returns it.
+func (user *User) IgnoreModel(car dto.Item) string {
+	filter := fmt.Sprintf("ne (carModel .) %s", strconv.Quote(CarModel(car)))
+
+	user.mutex().Lock()
+	defer user.mutex().Unlock()
+
+	user.addFilter(filter)
+	user.LastFrame = user.LastFrame.withCurrent(FilterUpdateList(user.LastFrame.Current, []string{filter}))
+
+	return filter
+}
+
+// FindCar looks up a car of the last frame by its RentalObject.Ident.
+func (user *User) FindCar(ident string) (dto.Item, bool) {
+	user.mutex().Lock()
+	frame := user.LastFrame
+	user.mutex().Unlock()
+
+	for _, car := range frame.Current {
+		if car.RentalObject.Ident == ident {
+			return car, true
+		}
+	}
+
+	return dto.Item{}, false
+}
+
+// removeSnoozedCars drops all cars that are currently snoozed and forgets
+// expired snoozes. The caller has to hold the lock of the user.
+func (user *User) removeSnoozedCars(update []dto.Item) []dto.Item {
+	for ident, until := range user.SnoozedCars {
+		if time.Now().After(until) {
+			delete(user.SnoozedCars, ident)
+		}
+	}
+	if len(user.SnoozedCars) == 0 {
+		return update
+	}
+
+	return removeCars(update, func(item dto.Item) bool {
+		_, snoozed := user.SnoozedCars[item.RentalObject.Ident]
+		return snoozed
+	})
+}
+
+// CheckWatchedCars compares the watchlist with all cars visible to the user
+// and returns an alert for each change.
+func (user *User) CheckWatchedCars(update []dto.Item) []string {
+	user.mutex().Lock()
+	defer user.mutex().Unlock()
+
+	if len(user.WatchedCars) == 0 {
+		return nil
+	}
+
+	cars := make(map[string]dto.Item, len(update))
+	for _, car := range update {
+		cars[car.RentalObject.Ident] = car
+	}
+
+	alerts := []string{}
+	idents := maps.Keys(user.WatchedCars)
+	slices.Sort(idents)
+	for _, ident := range idents {
+		watched := user.WatchedCars[ident]
+		car, exists := cars[ident]
+		switch {
+		case !exists && watched.Available:
+			watched.Available = false
+			alerts = append(alerts, fmt.Sprintf("👀 %s ist nicht mehr verfügbar 😢\n%s", watched.Name, watched.Url()))
+		case exists && !watched.Available:
+			watched.update(car)
+			alerts = append(alerts, fmt.Sprintf("👀 %s ist wieder verfügbar 🎉\n%s", watched.Name, watched.Url()))
+		case exists:
+			changes := watched.getChanges(car)
+			if len(changes) > 0 {
+				watched.update(car)
+				alerts = append(alerts, fmt.Sprintf("👀 %s hat sich geändert:\n%s\n%s", watched.Name, strings.Join(changes, "\n"), watched.Url()))
+			}
+		}
+	}
+
+	return alerts
+}
+
+func (user *User) sendWatchAlerts(update []dto.Item, bot *tgbotapi.BotAPI) {
+	alerts := user.CheckWatchedCars(update)
+	if len(alerts) == 0 {
+		return
+	}
+
+	user.Save()
+	texts := make([]MessageText, 0, len(alerts))
+	for _, alert := range alerts {
+		texts = append(texts, MessageText{Text: alert})
+	}
+	user.sendAlerts(bot, "watch", texts)
+}
+
+// CarModel returns the label and model specification of a car, e.g. to
+// filter a whole model.
+func CarModel(car dto.Item) string {
+	model := car.RentalObject.CarModell
+	if car.RentalObject.CarModellspec != nil && *car.RentalObject.CarModellspec != "" {
+		model = *car.RentalObject.CarModellspec
+	}
+
+	return strings.TrimSpace(string(car.RentalObject.CarLabel) + " " + model)
+}
+
+func carName(car dto.Item) string {
+	if car.OfferTypeName != "" {
+		return car.OfferTypeName
+	}
+
+	return CarModel(car)
+}
+
+func removeCars(cars []dto.Item, remove func(dto.Item) bool) []dto.Item {
+	result := make([]dto.Item, 0, len(cars))
+	for _, car := range cars {
+		if !remove(car) {
+			result = append(result, car)
+		}
+	}
+
+	return result
+}
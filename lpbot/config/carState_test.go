@@ -0,0 +1,211 @@
+package config_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/khase/leaseplan-bot/lpbot/config"
+	"github.com/khase/leaseplanabocarexporter/dto"
+)
+
+func TestIgnoreModel(t *testing.T) {
+	i4 := newTestCar(testCar{Ident: "1", Label: "BMW", Model: "i4", Spec: "i4 eDrive40", SalaryWaiver: 500})
+	// same model but another specification, so it is not hidden
+	m50 := newTestCar(testCar{Ident: "2", Label: "BMW", Model: "i4", Spec: "i4 M50", SalaryWaiver: 400})
+	user := newTestUser(t, i4, m50, newTestCar(testCar{Ident: "3", Label: "BMW", Model: "i4", Spec: "i4 eDrive40", SalaryWaiver: 450}))
+
+	filter := user.IgnoreModel(i4)
+	if len(user.Filters) != 1 || user.Filters[0] != filter {
+		t.Fatalf("expected the model filter to be added but got %v", user.Filters)
+	}
+	if len(user.LastFrame.Current) != 1 || user.LastFrame.Current[0].RentalObject.Ident != "2" {
+		t.Fatalf("expected only car 2 to be left but got %+v", user.LastFrame.Current)
+	}
+
+	filtered := config.FilterUpdateList([]dto.Item{i4, newTestCar(testCar{Ident: "4", Label: "VW", Spec: "ID.3", SalaryWaiver: 300})}, user.Filters)
+	if len(filtered) != 1 || filtered[0].RentalObject.Ident != "4" {
+		t.Fatalf("expected the filter to hide the model but got %+v", filtered)
+	}
+}
+
+func TestSnoozeCar(t *testing.T) {
+	car := newTestCar(testCar{Ident: "1", Label: "BMW", Spec: "i4", SalaryWaiver: 500})
+	user := newTestUser(t, car)
+
+	user.SnoozeCar(car, time.Hour)
+	if _, exists := user.FindCar("1"); exists {
+		t.Fatalf("expected the snoozed car to be dropped from the last frame")
+	}
+	if until := user.SnoozedCars["1"]; until.Before(time.Now().Add(59 * time.Minute)) {
+		t.Fatalf("expected the car to be snoozed for an hour but got %s", until)
+	}
+}
+
+func TestCarActionsDuringUpdate(t *testing.T) {
+	config.SetCacheDir(t.TempDir())
+	defer config.SetCacheDir(config.DefaultCacheDir)
+	cars := []dto.Item{
+		newTestCar(testCar{Ident: "1", Label: "BMW", Spec: "i4"}),
+		newTestCar(testCar{Ident: "2", Label: "Tesla", Spec: "Model 3"}),
+	}
+	user := newTestUser(t, cars...)
+	user.WatcherDelay = 0
+
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			user.Update(context.Background(), cars, nil)
+		}
+	}()
+	user.SnoozeCar(cars[0], time.Hour)
+	user.Save()
+	user.IgnoreModel(cars[1])
+	user.Save()
+	<-done
+
+	if _, exists := user.FindCar("1"); exists {
+		t.Fatalf("expected the snoozed car to be dropped from the last frame")
+	}
+	if _, exists := user.FindCar("2"); exists {
+		t.Fatalf("expected the ignored car to be dropped from the last frame")
+	}
+}
+
+func TestCheckWatchedCars(t *testing.T) {
+	car := newTestCar(testCar{Ident: "1", Label: "BMW", Spec: "i4", SalaryWaiver: 500})
+	user := newTestUser(t, car)
+	user.WatchCar(car)
+	if !user.IsWatching("1") {
+		t.Fatalf("expected car 1 to be watched")
+	}
+
+	if alerts := user.CheckWatchedCars([]dto.Item{car}); len(alerts) != 0 {
+		t.Fatalf("expected no alerts but got %v", alerts)
+	}
+
+	changed := newTestCar(testCar{Ident: "1", Label: "BMW", Spec: "i4", SalaryWaiver: 450})
+	if alerts := user.CheckWatchedCars([]dto.Item{changed}); len(alerts) != 1 || !strings.Contains(alerts[0], "BGV: 500€ -> 450€") {
+		t.Fatalf("expected a BGV change alert but got %v", alerts)
+	}
+
+	if alerts := user.CheckWatchedCars([]dto.Item{}); len(alerts) != 1 || !strings.Contains(alerts[0], "nicht mehr verfügbar") {
+		t.Fatalf("expected a removal alert but got %v", alerts)
+	}
+	if alerts := user.CheckWatchedCars([]dto.Item{}); len(alerts) != 0 {
+		t.Fatalf("expected removals to be reported once but got %v", alerts)
+	}
+
+	if alerts := user.CheckWatchedCars([]dto.Item{changed}); len(alerts) != 1 || !strings.Contains(alerts[0], "wieder verfügbar") {
+		t.Fatalf("expected a reappearance alert but got %v", alerts)
+	}
+
+	user.UnwatchCar("1")
+	if alerts := user.CheckWatchedCars([]dto.Item{}); len(alerts) != 0 {
+		t.Fatalf("expected no alerts for unwatched cars but got %v", alerts)
+	}
+}
+
+func TestWatchlistDuringCheck(t *testing.T) {
+	cars := []dto.Item{
+		newTestCar(testCar{Ident: "1", Label: "BMW", Spec: "i4", SalaryWaiver: 500}),
+		newTestCar(testCar{Ident: "2", Label: "Tesla", Spec: "Model 3", SalaryWaiver: 400}),
+	}
+	user := newTestUser(t, cars...)
+	user.WatchCar(cars[0])
+
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			changed := newTestCar(testCar{Ident: "1", Label: "BMW", Spec: "i4", SalaryWaiver: int64(500 - i)})
+			user.CheckWatchedCars([]dto.Item{changed, cars[1]})
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		user.WatchCar(cars[1])
+		user.GetWatchedCars()
+		user.Save()
+		user.UnwatchCar("2")
+	}
+	<-done
+
+	if watched, exists := user.GetWatchedCar("1"); !exists || watched.SalaryWaiver != 481 {
+		t.Fatalf("expected the last change of car 1 to be stored but got %+v", watched)
+	}
+}
+
+func TestParseCarReference(t *testing.T) {
+	tests := []struct {
+		reference  string
+		ident      string
+		offerIdent string
+		valid      bool
+	}{
+		{"https://www.leaseplan-abocar.de/offer-details/CCUNZEZE202205252209572081C3F9/CCUNZEZE2022052522095673CB8DCB", "CCUNZEZE2022052522095673CB8DCB", "CCUNZEZE202205252209572081C3F9", true},
+		{"https://www.leaseplan-abocar.de/offer-details/CCUNZEZE202205252209572081C3F9/CCUNZEZE2022052522095673CB8DCB/", "CCUNZEZE2022052522095673CB8DCB", "CCUNZEZE202205252209572081C3F9", true},
+		{" CCUNZEZE2022052522095673CB8DCB ", "CCUNZEZE2022052522095673CB8DCB", "", true},
+		{"https://www.leaseplan-abocar.de/offers", "", "", false},
+		{"https://www.leaseplan-abocar.de/offer-details/OFFER1/CAR1", "", "", false},
+		{"CAR 1", "", "", false},
+		{"remove", "", "", false},
+		{"list", "", "", false},
+		{"", "", "", false},
+	}
+
+	for _, test := range tests {
+		ident, offerIdent, err := config.ParseCarReference(test.reference)
+		if !test.valid {
+			if !errors.Is(err, config.ErrInvalidCarReference) {
+				t.Fatalf("expected %q to be invalid but got %v", test.reference, err)
+			}
+			continue
+		}
+		if err != nil || ident != test.ident || offerIdent != test.offerIdent {
+			t.Fatalf("expected %q to reference %s/%s but got %s/%s, %v", test.reference, test.offerIdent, test.ident, offerIdent, ident, err)
+		}
+	}
+}
+
+func TestWatchUnavailableCar(t *testing.T) {
+	user := newTestUser(t)
+	if err := user.WatchUnavailableCar("1", ""); err != nil {
+		t.Fatal(err)
+	}
+	if user.WatchedCars["1"].Url() != "" {
+		t.Fatalf("expected no url for a car that has not been seen yet")
+	}
+
+	alerts := user.CheckWatchedCars([]dto.Item{newTestCar(testCar{Ident: "1", Label: "BMW", Spec: "i4", SalaryWaiver: 500})})
+	if len(alerts) != 1 || !strings.Contains(alerts[0], "wieder verfügbar") {
+		t.Fatalf("expected an alert once the car appears but got %v", alerts)
+	}
+	if watched := user.WatchedCars["1"]; watched.Url() != "https://www.leaseplan-abocar.de/offer-details/offer-1/1" || watched.SalaryWaiver != 500 {
+		t.Fatalf("expected the watched car to be updated but got %+v", watched)
+	}
+}
+
+func TestWatchlistLimit(t *testing.T) {
+	user := newTestUser(t)
+	for i := 0; i < config.MaxWatchedCars; i++ {
+		if err := user.WatchUnavailableCar(fmt.Sprintf("%d", i), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := user.WatchUnavailableCar("too many", ""); !errors.Is(err, config.ErrWatchlistFull) {
+		t.Fatalf("expected ErrWatchlistFull but got %v", err)
+	}
+	if err := user.WatchUnavailableCar("0", ""); err != nil {
+		t.Fatalf("expected watched cars to be updatable but got %v", err)
+	}
+
+	watched := user.GetWatchedCars()
+	if len(watched) != config.MaxWatchedCars || watched[len(watched)-1].Ident != "0" {
+		t.Fatalf("expected the watchlist to be ordered by the time cars were added")
+	}
+}
@@ -0,0 +1,99 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	ChatTypeGroup      = "group"
+	ChatTypeSupergroup = "supergroup"
+	ChatTypeChannel    = "channel"
+)
+
+var (
+	ErrChatAlreadySubscribed = errors.New("chat is already subscribed")
+	ErrNoLevelKey            = errors.New("owner has no leaseplan level key yet")
+)
+
+// IsChat reports whether the user is the subscription of a group or channel
+// rather than a telegram user.
+func (user *User) IsChat() bool {
+	return user.ChatType != ""
+}
+
+// GetOwner returns the user a chat subscription belongs to.
+func (user *User) GetOwner() *User {
+	if !user.IsChat() || user.UserMap == nil {
+		return nil
+	}
+
+	return user.UserMap.GetUser(user.OwnerId)
+}
+
+// SyncOwner applies the level key of the owner to a chat subscription, so it
+// follows the cars visible to its owner.
+func (user *User) SyncOwner() {
+	owner := user.GetOwner()
+	if owner != nil && owner.LeaseplanLevelKey != "" {
+		user.LeaseplanLevelKey = owner.LeaseplanLevelKey
+	}
+}
+
+// CreateChatSubscription creates the subscription of a group or channel. It
+// is keyed by the chat id and gets its own filters and templates, while the
+// cars are those visible to the owner.
+func (userMap *UserMap) CreateChatSubscription(chatId int64, chatType string, title string, owner *User) (*User, error) {
+	if owner.LeaseplanLevelKey == "" {
+		return nil, ErrNoLevelKey
+	}
+
+	userMap.lock.Lock()
+	if _, exists := userMap.Users[chatId]; exists {
+		userMap.lock.Unlock()
+		return nil, ErrChatAlreadySubscribed
+	}
+
+	chat := NewUser(userMap, chatId, title)
+	chat.ChatType = chatType
+	chat.OwnerId = owner.UserId
+	chat.EULA = true
+	chat.LeaseplanLevelKey = owner.LeaseplanLevelKey
+	userMap.Users[chatId] = chat
+	userMap.lock.Unlock()
+
+	return chat, userMap.Save()
+}
+
+// RemoveChatSubscription deletes the subscription of a group or channel.
+func (userMap *UserMap) RemoveChatSubscription(chatId int64) error {
+	userMap.lock.Lock()
+	chat, exists := userMap.Users[chatId]
+	if !exists || !chat.IsChat() {
+		userMap.lock.Unlock()
+		return fmt.Errorf("%w: %d", ErrUserNotFound, chatId)
+	}
+
+	delete(userMap.Users, chatId)
+	for _, user := range userMap.Users {
+		if user.EditingChatId == chatId {
+			user.EditingChatId = 0
+		}
+	}
+	userMap.lock.Unlock()
+
+	return userMap.Save()
+}
+
+// GetChatSubscriptions returns the subscriptions owned by the user sorted by
+// chat id.
+func (userMap *UserMap) GetChatSubscriptions(owner *User) []*User {
+	chats := []*User{}
+	for _, user := range userMap.SortedUsers() {
+		if user.IsChat() && user.OwnerId == owner.UserId {
+			chats = append(chats, user)
+		}
+	}
+
+	return chats
+}
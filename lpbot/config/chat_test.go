@@ -0,0 +1,71 @@
+package config_test
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/khase/leaseplan-bot/lpbot/config"
+)
+
+func TestCreateChatSubscription(t *testing.T) {
+	userMap := config.NewUserMap(filepath.Join(t.TempDir(), "test.userdata"))
+	owner, _ := userMap.CreateNewUser(1, "owner")
+
+	_, err := userMap.CreateChatSubscription(-100, config.ChatTypeGroup, "Gruppe", owner)
+	if !errors.Is(err, config.ErrNoLevelKey) {
+		t.Fatalf("expected ErrNoLevelKey but got %v", err)
+	}
+
+	owner.LeaseplanLevelKey = "Level 1"
+	chat, err := userMap.CreateChatSubscription(-100, config.ChatTypeGroup, "Gruppe", owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !chat.IsChat() || chat.GetOwner() != owner || chat.LeaseplanLevelKey != "Level 1" || !chat.EULA {
+		t.Fatalf("expected a group of the owner on Level 1 but got %+v", chat)
+	}
+	if owner.IsChat() || owner.GetOwner() != nil {
+		t.Fatalf("expected the owner not to be a chat")
+	}
+
+	_, err = userMap.CreateChatSubscription(-100, config.ChatTypeGroup, "Gruppe", owner)
+	if !errors.Is(err, config.ErrChatAlreadySubscribed) {
+		t.Fatalf("expected ErrChatAlreadySubscribed but got %v", err)
+	}
+
+	owner.LeaseplanLevelKey = "Level 2"
+	chat.SyncOwner()
+	if chat.LeaseplanLevelKey != "Level 2" {
+		t.Fatalf("expected the chat to follow the owner to Level 2 but got %s", chat.LeaseplanLevelKey)
+	}
+}
+
+func TestChatSubscriptions(t *testing.T) {
+	userMap := config.NewUserMap(filepath.Join(t.TempDir(), "test.userdata"))
+	owner, _ := userMap.CreateNewUser(1, "owner")
+	other, _ := userMap.CreateNewUser(2, "other")
+	owner.LeaseplanLevelKey = "Level 1"
+	other.LeaseplanLevelKey = "Level 1"
+
+	userMap.CreateChatSubscription(-200, config.ChatTypeChannel, "Kanal", owner)
+	userMap.CreateChatSubscription(-300, config.ChatTypeSupergroup, "Gruppe", owner)
+	userMap.CreateChatSubscription(-400, config.ChatTypeGroup, "Andere", other)
+
+	chats := userMap.GetChatSubscriptions(owner)
+	if len(chats) != 2 || chats[0].UserId != -300 || chats[1].UserId != -200 {
+		t.Fatalf("expected chats -300 and -200 but got %+v", chats)
+	}
+
+	owner.EditingChatId = -200
+	if err := userMap.RemoveChatSubscription(-200); err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := userMap.Users[-200]; exists || owner.EditingChatId != 0 {
+		t.Fatalf("expected chat -200 to be removed and no longer edited")
+	}
+
+	if err := userMap.RemoveChatSubscription(1); !errors.Is(err, config.ErrUserNotFound) {
+		t.Fatalf("expected users not to be removed as chats but got %v", err)
+	}
+}
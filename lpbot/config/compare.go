@@ -0,0 +1,146 @@
+package config
+
+import (
+	"sort"
+
+	"github.com/khase/leaseplanabocarexporter/dto"
+	"golang.org/x/exp/maps"
+)
+
+// LevelComparison compares the cars visible on the different leaseplan
+// levels. Cars are matched by the ident of their rental object, as the same
+// car is offered on every level it is visible on.
+type LevelComparison struct {
+	Levels      []*LevelSummary       `json:"Levels"`
+	Exclusive   map[string][]dto.Item `json:"Exclusive"`
+	Differences []*PriceDifference    `json:"Differences"`
+}
+
+// LevelSummary counts the cars and users of a level.
+type LevelSummary struct {
+	LevelKey      string `json:"LevelKey"`
+	Users         int    `json:"Users"`
+	Cars          int    `json:"Cars"`
+	ExclusiveCars int    `json:"ExclusiveCars"`
+}
+
+// PriceDifference lists the salary waivers of a car visible on several
+// levels with different salary waivers.
+type PriceDifference struct {
+	Ident         string           `json:"Ident"`
+	Name          string           `json:"Name"`
+	SalaryWaivers map[string]int64 `json:"SalaryWaivers"`
+	Spread        int64            `json:"Spread"`
+}
+
+// CompareLevels compares the cars of all levels. The levels are ordered by
+// key, the exclusive cars by name and the differences by their spread, the
+// largest first. Chats are not counted as users.
+func CompareLevels(cars map[string][]dto.Item, users *UserMap) *LevelComparison {
+	summaries := map[string]*LevelSummary{}
+	getSummary := func(levelKey string) *LevelSummary {
+		summary, exists := summaries[levelKey]
+		if !exists {
+			summary = &LevelSummary{LevelKey: levelKey}
+			summaries[levelKey] = summary
+		}
+		return summary
+	}
+
+	if users != nil {
+		for _, user := range users.SortedUsers() {
+			if !user.IsChat() && user.LeaseplanLevelKey != "" {
+				getSummary(user.LeaseplanLevelKey).Users++
+			}
+		}
+	}
+
+	offers := map[string]map[string]dto.Item{}
+	for levelKey, levelCars := range cars {
+		getSummary(levelKey).Cars = len(levelCars)
+		for _, car := range levelCars {
+			ident := car.RentalObject.Ident
+			if offers[ident] == nil {
+				offers[ident] = map[string]dto.Item{}
+			}
+			offers[ident][levelKey] = car
+		}
+	}
+
+	comparison := &LevelComparison{
+		Levels:      []*LevelSummary{},
+		Exclusive:   map[string][]dto.Item{},
+		Differences: []*PriceDifference{},
+	}
+	for ident, levels := range offers {
+		if len(levels) == 1 {
+			for levelKey, car := range levels {
+				comparison.Exclusive[levelKey] = append(comparison.Exclusive[levelKey], car)
+				getSummary(levelKey).ExclusiveCars++
+			}
+			continue
+		}
+
+		if difference := getPriceDifference(ident, levels); difference != nil {
+			comparison.Differences = append(comparison.Differences, difference)
+		}
+	}
+
+	for _, summary := range summaries {
+		comparison.Levels = append(comparison.Levels, summary)
+	}
+	sort.Slice(comparison.Levels, func(i, j int) bool {
+		return comparison.Levels[i].LevelKey < comparison.Levels[j].LevelKey
+	})
+	for _, exclusive := range comparison.Exclusive {
+		sort.Slice(exclusive, func(i, j int) bool {
+			if result := carComparators[SortByModel](exclusive[i], exclusive[j]); result != 0 {
+				return result < 0
+			}
+			return exclusive[i].RentalObject.Ident < exclusive[j].RentalObject.Ident
+		})
+	}
+	sort.Slice(comparison.Differences, func(i, j int) bool {
+		a, b := comparison.Differences[i], comparison.Differences[j]
+		if a.Spread != b.Spread {
+			return a.Spread > b.Spread
+		}
+		return a.Ident < b.Ident
+	})
+
+	return comparison
+}
+
+// getPriceDifference returns nil if the car has the same salary waiver on
+// all levels.
+func getPriceDifference(ident string, levels map[string]dto.Item) *PriceDifference {
+	difference := &PriceDifference{
+		Ident:         ident,
+		SalaryWaivers: map[string]int64{},
+	}
+
+	levelKeys := maps.Keys(levels)
+	sort.Strings(levelKeys)
+
+	var min, max int64
+	for i, levelKey := range levelKeys {
+		car := levels[levelKey]
+		if i == 0 {
+			difference.Name = carName(car)
+			min, max = car.SalaryWaiver, car.SalaryWaiver
+		}
+		difference.SalaryWaivers[levelKey] = car.SalaryWaiver
+		if car.SalaryWaiver < min {
+			min = car.SalaryWaiver
+		}
+		if car.SalaryWaiver > max {
+			max = car.SalaryWaiver
+		}
+	}
+	if min == max {
+		return nil
+	}
+	difference.Spread = max - min
+
+	return difference
+}
@@ -0,0 +1,62 @@
+package config_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/khase/leaseplan-bot/lpbot/config"
+	"github.com/khase/leaseplanabocarexporter/dto"
+)
+
+func TestCompareLevels(t *testing.T) {
+	users := config.NewUserMap(filepath.Join(t.TempDir(), "test.userdata"))
+	basic, _ := users.CreateNewUser(1, "basic")
+	basic.LeaseplanLevelKey = "Basic"
+	premium, _ := users.CreateNewUser(2, "premium")
+	premium.LeaseplanLevelKey = "Premium"
+	other, _ := users.CreateNewUser(3, "other")
+	other.LeaseplanLevelKey = "Premium"
+	chat, err := users.CreateChatSubscription(-100, "group", "chat", premium)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chat.LeaseplanLevelKey = "Premium"
+
+	comparison := config.CompareLevels(map[string][]dto.Item{
+		"Basic": {
+			newTestCar(testCar{Ident: "1", Label: "BMW", Model: "320d", Fuel: "Diesel", HP: 190, BLP: 50000, SalaryWaiver: 500}),
+			newTestCar(testCar{Ident: "2", Label: "BMW", Model: "520d", Fuel: "Diesel", HP: 190, BLP: 60000, SalaryWaiver: 600}),
+			newTestCar(testCar{Ident: "3", Label: "BMW", Model: "X1", Fuel: "Diesel", HP: 150, BLP: 40000, SalaryWaiver: 400}),
+		},
+		"Premium": {
+			newTestCar(testCar{Ident: "1", Label: "BMW", Model: "320d", Fuel: "Diesel", HP: 190, BLP: 50000, SalaryWaiver: 450}),
+			newTestCar(testCar{Ident: "2", Label: "BMW", Model: "520d", Fuel: "Diesel", HP: 190, BLP: 60000, SalaryWaiver: 600}),
+			newTestCar(testCar{Ident: "5", Label: "BMW", Model: "M3", Fuel: "Diesel", HP: 510, BLP: 90000, SalaryWaiver: 900}),
+			newTestCar(testCar{Ident: "4", Label: "BMW", Model: "i4", Fuel: "Diesel", HP: 340, BLP: 70000, SalaryWaiver: 700}),
+		},
+	}, users)
+
+	if len(comparison.Levels) != 2 {
+		t.Fatalf("expected 2 levels but got %d", len(comparison.Levels))
+	}
+	basicLevel, premiumLevel := comparison.Levels[0], comparison.Levels[1]
+	if basicLevel.LevelKey != "Basic" || basicLevel.Users != 1 || basicLevel.Cars != 3 || basicLevel.ExclusiveCars != 1 {
+		t.Fatalf("unexpected basic level %+v", basicLevel)
+	}
+	if premiumLevel.LevelKey != "Premium" || premiumLevel.Users != 2 || premiumLevel.Cars != 4 || premiumLevel.ExclusiveCars != 2 {
+		t.Fatalf("unexpected premium level %+v", premiumLevel)
+	}
+
+	exclusive := comparison.Exclusive["Premium"]
+	if len(exclusive) != 2 || exclusive[0].RentalObject.Ident != "4" || exclusive[1].RentalObject.Ident != "5" {
+		t.Fatalf("expected the exclusive premium cars ordered by name but got %+v", exclusive)
+	}
+
+	if len(comparison.Differences) != 1 {
+		t.Fatalf("expected only cars with different salary waivers but got %d", len(comparison.Differences))
+	}
+	difference := comparison.Differences[0]
+	if difference.Ident != "1" || difference.Spread != 50 || difference.SalaryWaivers["Basic"] != 500 || difference.SalaryWaivers["Premium"] != 450 {
+		t.Fatalf("unexpected difference %+v", difference)
+	}
+}
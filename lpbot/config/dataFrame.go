@@ -7,6 +7,8 @@ import (
 	"html/template"
 	"math/rand"
 	"os"
+	"sort"
+	texttemplate "text/template"
 	"time"
 
 	"github.com/Masterminds/sprig"
@@ -16,6 +18,22 @@ import (
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
+var (
+	templateFuncs = template.FuncMap{
+		"portalUrl":  portalUrl,
+		"offerUrl":   offerUrl,
+		"taxPrice":   TaxPrice,
+		"netCost":    NetCost,
+		"carModel":   CarModel,
+		"hpCost":     HpCost,
+		"bgvRatio":   BgvRatio,
+		"discount":   noDiscount,
+		"searchText": SearchText,
+		"italic":     italic,
+		"bold":       bold,
+	}
+)
+
 type DataFrame struct {
 	Timestamp time.Time  `yaml:"Timestamp,omitempty"`
 	Previous  []dto.Item `yaml:"Previous,omitempty"`
@@ -24,6 +42,8 @@ type DataFrame struct {
 	Removed   []dto.Item `yaml:"Removed,omitempty"`
 
 	HasChanges bool `yaml:"HasChanges,omitempty"`
+
+	groupBy string
 }
 
 func NewEmptyDataFrame() *DataFrame {
@@ -53,6 +73,15 @@ func NewDataFrame(previous []dto.Item, current []dto.Item) *DataFrame {
 	return frame
 }
 
+// withCurrent returns a copy of the frame with other current cars. Frames are
+// handed to pending notifications, so they are replaced instead of changed.
+func (dataFrame *DataFrame) withCurrent(current []dto.Item) *DataFrame {
+	frame := *dataFrame
+	frame.Current = current
+
+	return &frame
+}
+
 func LoadDataFrameFile(path string) (*DataFrame, error) {
 	frame := NewEmptyDataFrame()
 	frame.Timestamp = time.Now().Add(-24 * time.Hour)
@@ -100,9 +129,19 @@ func getItemDiff(previous []dto.Item, current []dto.Item) (added []dto.Item, rem
 		}
 	}
 
+	// map iteration is random, so sort for a stable output
+	sortByIdent(added)
+	sortByIdent(removed)
+
 	return added, removed
 }
 
+func sortByIdent(cars []dto.Item) {
+	sort.Slice(cars, func(i, j int) bool {
+		return cars[i].RentalObject.Ident < cars[j].RentalObject.Ident
+	})
+}
+
 func (dataFrame *DataFrame) SaveToFile(path string) error {
 	data, err := yaml.Marshal(dataFrame)
 	if err != nil {
@@ -118,14 +157,15 @@ func (dataFrame *DataFrame) SaveToFile(path string) error {
 }
 
 func (dataFrame *DataFrame) GetMessages(user *User) ([]tgbotapi.Chattable, error) {
-	return dataFrame.getMessagesInternal(user, 0)
+	return dataFrame.getMessagesInternal(user, 0, user.DetailMode)
 }
 
 func (dataFrame *DataFrame) GetTestMessages(user *User, testLength int) ([]tgbotapi.Chattable, error) {
-	return dataFrame.getMessagesInternal(user, testLength)
+	return dataFrame.getMessagesInternal(user, testLength, user.DetailMode)
 }
 
-func (dataFrame *DataFrame) getMessagesInternal(user *User, testLength int) ([]tgbotapi.Chattable, error) {
+func (dataFrame *DataFrame) getMessagesInternal(user *User, testLength int, detailMode string) ([]tgbotapi.Chattable, error) {
+	dataFrame = dataFrame.PreparedFor(user)
 	messages := make([]tgbotapi.Chattable, 0)
 	if !user.IgnoreRemoved || len(dataFrame.Added) > 0 {
 		summaryMessage, err := dataFrame.getSummaryMessage(user)
@@ -136,7 +176,7 @@ func (dataFrame *DataFrame) getMessagesInternal(user *User, testLength int) ([]t
 	}
 
 	if !user.IgnoreDetails {
-		detailMessages, err := dataFrame.getDetailMessages(user, testLength)
+		detailMessages, err := dataFrame.getDetailMessages(user, testLength, detailMode)
 		if err != nil {
 			return nil, err
 		}
@@ -149,7 +189,7 @@ func (dataFrame *DataFrame) getMessagesInternal(user *User, testLength int) ([]t
 }
 
 func (dataFrame *DataFrame) getSummaryMessage(user *User) (tgbotapi.Chattable, error) {
-	summary, err := dataFrame.getSummaryText(user.SummaryMessageTemplate)
+	summary, err := dataFrame.getSummaryText(user.SummaryMessageTemplate, user.TemplateFuncs())
 	if err != nil {
 		return tgbotapi.MessageConfig{}, err
 	}
@@ -158,8 +198,8 @@ func (dataFrame *DataFrame) getSummaryMessage(user *User) (tgbotapi.Chattable, e
 	return msg, nil
 }
 
-func (dataFrame *DataFrame) getSummaryText(template string) (string, error) {
-	summaryString, err := fillTemplate(template, dataFrame)
+func (dataFrame *DataFrame) getSummaryText(template string, funcs ...template.FuncMap) (string, error) {
+	summaryString, err := fillTemplate(template, dataFrame, funcs...)
 	if err != nil {
 		return "", err
 	}
@@ -167,89 +207,114 @@ func (dataFrame *DataFrame) getSummaryText(template string) (string, error) {
 	return summaryString, nil
 }
 
-func (dataFrame *DataFrame) getDetailMessages(user *User, testLength int) ([]tgbotapi.Chattable, error) {
-	added, err := getCarsDetailsTexts(dataFrame.Added, user.DetailMessageTemplate)
+func (dataFrame *DataFrame) getDetailMessages(user *User, testLength int, detailMode string) ([]tgbotapi.Chattable, error) {
+	addedCars, err := dataFrame.fillTestCars(dataFrame.Added, testLength)
+	if err != nil {
+		return nil, err
+	}
+	removedCars, err := dataFrame.fillTestCars(dataFrame.Removed, testLength)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(added) < testLength {
-		if len(dataFrame.Current) <= 0 {
-			return nil, errors.New("Test impossible, no data yet")
-		}
-		for i := len(added); i < testLength; i++ {
-			line, err := getCarDetails(&dataFrame.Current[rand.Intn(len(dataFrame.Current))], user.DetailMessageTemplate)
-			if err != nil {
-				return nil, err
-			}
-			added = append(added, line)
-		}
+	if user.GroupDetails {
+		return getGroupedDetailMessages(user, addedCars, removedCars)
+	}
+	if detailMode == DetailModeRich {
+		return getRichDetailMessages(user, addedCars, removedCars)
 	}
 
-	removed, err := getCarsDetailsTexts(dataFrame.Removed, user.DetailMessageTemplate)
+	added, err := getCarsDetailsTexts(addedCars, user.DetailMessageTemplate, user.TemplateFuncs())
+	if err != nil {
+		return nil, err
+	}
+	removed, err := getCarsDetailsTexts(removedCars, user.DetailMessageTemplate, user.TemplateFuncs())
 	if err != nil {
 		return nil, err
 	}
 
-	if len(removed) < testLength {
-		if len(dataFrame.Current) <= 0 {
-			return nil, errors.New("Test impossible, no data yet")
-		}
-		for i := len(removed); i < testLength; i++ {
-			line, err := getCarDetails(&dataFrame.Current[rand.Intn(len(dataFrame.Current))], user.DetailMessageTemplate)
-			if err != nil {
-				return nil, err
-			}
-			removed = append(removed, line)
-		}
+	return getTextDetailMessages(user, added, getCarsActionButtons(addedCars, user), removed), nil
+}
+
+// fillTestCars adds random current cars until the test length is reached.
+func (dataFrame *DataFrame) fillTestCars(cars []dto.Item, testLength int) ([]dto.Item, error) {
+	if len(cars) >= testLength {
+		return cars, nil
+	}
+	if len(dataFrame.Current) <= 0 {
+		return nil, errors.New("Test impossible, no data yet")
 	}
 
-	messages := make([]tgbotapi.Chattable, 0)
-	buf := new(bytes.Buffer)
+	result := append([]dto.Item{}, cars...)
+	for i := len(cars); i < testLength; i++ {
+		result = append(result, dataFrame.Current[rand.Intn(len(dataFrame.Current))])
+	}
+
+	return result, nil
+}
+
+// getTextDetailMessages lists the cars in markdown messages of up to 3500
+// bytes. The buttons of the added lines are shown below the message listing
+// them.
+func getTextDetailMessages(user *User, added []string, addedButtons [][]tgbotapi.InlineKeyboardButton, removed []string) []tgbotapi.Chattable {
+	buffer := &detailMessageBuffer{chatId: user.UserId}
 
 	if len(added) > 0 {
-		buf.WriteString("Added:\n")
-		for _, line := range added {
-			messages = addMessageLine(buf, line, user.UserId, messages)
+		buffer.WriteString("Added:\n")
+		for i, line := range added {
+			buffer.addLine(line, addedButtons[i])
 		}
 	}
 	if !user.IgnoreRemoved && len(removed) > 0 {
 		if len(added) > 0 {
-			buf.WriteString("\n")
+			buffer.WriteString("\n")
 		}
-		buf.WriteString("Removed:\n")
+		buffer.WriteString("Removed:\n")
 		for _, line := range removed {
-			messages = addMessageLine(buf, line, user.UserId, messages)
+			buffer.addLine(line, nil)
 		}
 	}
 
-	if buf.Len() > 0 {
-		messages = append(messages, createMessageAndResetBuffer(buf, user.UserId))
+	if buffer.Len() > 0 {
+		buffer.flush()
 	}
-	return messages, nil
+	return buffer.messages
+}
+
+type detailMessageBuffer struct {
+	bytes.Buffer
+
+	chatId   int64
+	keyboard [][]tgbotapi.InlineKeyboardButton
+	messages []tgbotapi.Chattable
 }
 
-func addMessageLine(buffer *bytes.Buffer, line string, userId int64, messages []tgbotapi.Chattable) []tgbotapi.Chattable {
+func (buffer *detailMessageBuffer) addLine(line string, buttons []tgbotapi.InlineKeyboardButton) {
 	if buffer.Len()+len(line) > 3500 {
-		messages = append(messages, createMessageAndResetBuffer(buffer, userId))
+		buffer.flush()
 	}
 	buffer.WriteString(fmt.Sprintf("%s\n", line))
-
-	return messages
+	if len(buttons) > 0 {
+		buffer.keyboard = append(buffer.keyboard, buttons)
+	}
 }
 
-func createMessageAndResetBuffer(buffer *bytes.Buffer, userId int64) tgbotapi.MessageConfig {
-	msg := tgbotapi.NewMessage(userId, buffer.String())
+func (buffer *detailMessageBuffer) flush() {
+	msg := tgbotapi.NewMessage(buffer.chatId, buffer.String())
 	msg.ParseMode = "Markdown"
-	buffer.Reset()
+	if len(buffer.keyboard) > 0 {
+		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(buffer.keyboard...)
+	}
+	buffer.messages = append(buffer.messages, msg)
 
-	return msg
+	buffer.Reset()
+	buffer.keyboard = nil
 }
 
-func getCarsDetailsTexts(cars []dto.Item, template string) ([]string, error) {
+func getCarsDetailsTexts(cars []dto.Item, template string, funcs ...template.FuncMap) ([]string, error) {
 	result := make([]string, 0)
 	for _, car := range cars {
-		detailString, err := getCarDetails(&car, template)
+		detailString, err := getCarDetails(&car, template, funcs...)
 		if err != nil {
 			return nil, err
 		}
@@ -259,8 +324,13 @@ func getCarsDetailsTexts(cars []dto.Item, template string) ([]string, error) {
 	return result, nil
 }
 
-func getCarDetails(car *dto.Item, template string) (string, error) {
-	detailString, err := fillTemplate(template, car)
+// GetCarDetails renders a car with the given detail template.
+func GetCarDetails(car dto.Item, template string, funcs ...template.FuncMap) (string, error) {
+	return getCarDetails(&car, template, funcs...)
+}
+
+func getCarDetails(car *dto.Item, template string, funcs ...template.FuncMap) (string, error) {
+	detailString, err := fillTemplate(template, car, funcs...)
 	if err != nil {
 		return "", err
 	}
@@ -268,18 +338,17 @@ func getCarDetails(car *dto.Item, template string) (string, error) {
 	return detailString, nil
 }
 
-func fillTemplate(templateString string, input interface{}) (string, error) {
-	tmpl, err := template.
+// fillTemplate renders the template with the sprig and bot functions. The
+// given funcs overwrite them, e.g. with functions bound to a user.
+func fillTemplate(templateString string, input interface{}, funcs ...template.FuncMap) (string, error) {
+	tmpl := template.
 		New("Template").
 		Funcs(sprig.FuncMap()).
-		Funcs(template.FuncMap{
-			"portalUrl": portalUrl,
-			"taxPrice":  taxPrice,
-			"netCost":   netCost,
-			"italic":    italic,
-			"bold":      bold,
-		}).
-		Parse(templateString)
+		Funcs(templateFuncs)
+	for _, funcMap := range funcs {
+		tmpl = tmpl.Funcs(funcMap)
+	}
+	tmpl, err := tmpl.Parse(templateString)
 
 	if err != nil {
 		return "", err
@@ -295,7 +364,34 @@ func fillTemplate(templateString string, input interface{}) (string, error) {
 	return buf.String(), nil
 }
 
-func taxPrice(car dto.Item) float64 {
+// fillTextTemplate works like fillTemplate without escaping html, e.g. for
+// email subjects and plain text parts.
+func fillTextTemplate(templateString string, input interface{}, funcs ...template.FuncMap) (string, error) {
+	tmpl := texttemplate.
+		New("Template").
+		Funcs(sprig.TxtFuncMap()).
+		Funcs(texttemplate.FuncMap(templateFuncs))
+	for _, funcMap := range funcs {
+		tmpl = tmpl.Funcs(texttemplate.FuncMap(funcMap))
+	}
+	tmpl, err := tmpl.Parse(templateString)
+
+	if err != nil {
+		return "", err
+	}
+
+	buf := new(bytes.Buffer)
+
+	err = tmpl.Execute(buf, input)
+	if err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// TaxPrice returns the monthly taxable benefit of the given car.
+func TaxPrice(car dto.Item) float64 {
 	taxRate := 0.01 // -> Diesel / Benzin
 	if car.RentalObject.KindOfFuel == "Plug-in-Hybrid" {
 		taxRate = 0.005
@@ -310,9 +406,11 @@ func taxPrice(car dto.Item) float64 {
 	return car.RentalObject.PriceProducer1 * taxRate
 }
 
-func netCost(car dto.Item) float64 {
+// NetCost approximates the monthly net cost of the given car based on its
+// salary waiver and TaxPrice.
+func NetCost(car dto.Item) float64 {
 	taxFactor := 0.42
-	return (taxPrice(car) * taxFactor) + (float64(car.SalaryWaiver) * (1 - taxFactor))
+	return (TaxPrice(car) * taxFactor) + (float64(car.SalaryWaiver) * (1 - taxFactor))
 }
 
 func italic(text string) string {
@@ -324,5 +422,9 @@ func bold(text string) string {
 }
 
 func portalUrl(car dto.Item) string {
-	return fmt.Sprintf("[%s](https://www.leaseplan-abocar.de/offer-details/%s/%s)", car.OfferTypeName, car.Ident, car.RentalObject.Ident)
+	return fmt.Sprintf("[%s](%s)", car.OfferTypeName, offerUrl(car))
+}
+
+func offerUrl(car dto.Item) string {
+	return fmt.Sprintf("%s/offer-details/%s/%s", portalBaseUrl, car.Ident, car.RentalObject.Ident)
 }
@@ -155,3 +155,77 @@ func TestTaxNetto(t *testing.T) {
 		}
 	}
 }
+
+func TestRichMessages(t *testing.T) {
+	user := &config.User{
+		UserId:                 123,
+		DetailMode:             config.DetailModeRich,
+		IgnoreRemoved:          true,
+		SummaryMessageTemplate: "{{ len .Added }}",
+		DetailMessageTemplate:  "{{ .OfferTypeName }}",
+	}
+	car := func(ident string, images ...string) dto.Item {
+		return dto.Item{Ident: "o" + ident, OfferTypeName: ident, ImageLinks: images, RentalObject: dto.RentalObject{Ident: ident}}
+	}
+
+	frame := config.NewDataFrame([]dto.Item{}, []dto.Item{car("a", "https://example.com/a.jpg"), car("b")})
+	messages, err := frame.GetMessages(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 3 {
+		t.Fatalf("expected summary, photo and text message but got %d", len(messages))
+	}
+	var photo tgbotapi.PhotoConfig
+	for _, message := range messages[1:] {
+		if config, ok := message.(tgbotapi.PhotoConfig); ok {
+			photo = config
+		} else if config, ok := message.(tgbotapi.MessageConfig); !ok || !strings.Contains(config.Text, "b") {
+			t.Fatalf("expected car without image as text but got %+v", message)
+		}
+	}
+	if photo.Caption != "a" || photo.File != tgbotapi.FileURL("https://example.com/a.jpg") {
+		t.Fatalf("expected photo of car a but got %+v", photo)
+	}
+	keyboard, ok := photo.ReplyMarkup.(tgbotapi.InlineKeyboardMarkup)
+	if !ok || *keyboard.InlineKeyboard[0][0].URL != "https://www.leaseplan-abocar.de/offer-details/oa/a" {
+		t.Fatalf("expected portal button but got %+v", photo.ReplyMarkup)
+	}
+
+	cars := []dto.Item{}
+	for i := 0; i < 12; i++ {
+		cars = append(cars, car(strings.Repeat("c", i+1), "/images/car.jpg"))
+	}
+	messages, err = config.NewDataFrame([]dto.Item{}, cars).GetMessages(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mediaGroups, photos, buttons := 0, 0, 0
+	for _, message := range messages[1:] {
+		switch config := message.(type) {
+		case tgbotapi.MediaGroupConfig:
+			mediaGroups++
+			photos += len(config.Media)
+			if media := config.Media[0].(tgbotapi.InputMediaPhoto); media.Media != tgbotapi.FileURL("https://www.leaseplan-abocar.de/images/car.jpg") {
+				t.Fatalf("expected relative image links to be resolved but got %+v", media.Media)
+			}
+		case tgbotapi.MessageConfig:
+			for _, row := range config.ReplyMarkup.(tgbotapi.InlineKeyboardMarkup).InlineKeyboard {
+				if row[0].URL != nil {
+					buttons++
+				}
+			}
+		}
+	}
+	if mediaGroups != 2 || photos != 12 || buttons != 12 {
+		t.Fatalf("expected 12 photos with buttons in 2 albums but got %d photos, %d buttons, %d albums", photos, buttons, mediaGroups)
+	}
+
+	texts, err := frame.GetMessageTexts(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(texts) != 2 || !strings.Contains(texts[1].Text, "a") {
+		t.Fatalf("expected other channels to get text messages but got %+v", texts)
+	}
+}
@@ -0,0 +1,385 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"html"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/khase/leaseplanabocarexporter/dto"
+)
+
+const (
+	NotifierEmail = "email"
+
+	DefaultEmailSubjectTemplate = "Leaseplan-Bot: {{ len .Added }} neue, {{ len .Removed }} entfernte Angebote"
+	DefaultEmailHtmlTemplate    = `<h2>{{ len .Previous }} &rarr; {{ len .Current }} Angebote (+{{ len .Added }}, -{{ len .Removed }})</h2>
+{{ with .Added }}<h3>Neu</h3>
+<ul>
+{{ range . }}<li><a href="{{ offerUrl . }}">{{ .OfferTypeName }}</a><br>PS: {{ .RentalObject.PowerHP }}, Antrieb: {{ .RentalObject.KindOfFuel }}<br>BLP: {{ .RentalObject.PriceProducer1 }}€, BGV: {{ .SalaryWaiver }}€, Netto: ~{{ round ( netCost . ) 2 }}€<br>Verfügbar: {{ .RentalObject.DateRegistration.Format "02.01.2006" }}</li>
+{{ end }}</ul>
+{{ end }}{{ with .Removed }}<h3>Entfernt</h3>
+<ul>
+{{ range . }}<li><a href="{{ offerUrl . }}">{{ .OfferTypeName }}</a></li>
+{{ end }}</ul>
+{{ end }}`
+
+	emailConfirmationValidity = time.Hour
+	emailConfirmationInterval = 5 * time.Minute
+	emailConfirmationAttempts = 5
+	smtpTimeout               = 30 * time.Second
+)
+
+var (
+	ErrEmailNotConfigured       = errors.New("no smtp server configured")
+	ErrInvalidEmailAddress      = errors.New("invalid email address")
+	ErrInvalidConfirmationCode  = errors.New("invalid confirmation code")
+	ErrNoConfirmationPending    = errors.New("no email confirmation pending")
+	ErrConfirmationThrottled    = errors.New("a confirmation code was requested too recently")
+	ErrTooManyAttempts          = errors.New("too many invalid confirmation codes")
+	ErrSmtpStartTlsNotSupported = errors.New("smtp server does not support STARTTLS")
+
+	smtpConfig SmtpConfig
+)
+
+// SmtpConfig holds the settings of the smtp server emails are sent with.
+type SmtpConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	StartTls bool
+}
+
+// Email is a rendered email consisting of a plain text and a html part.
+type Email struct {
+	Subject string
+	Text    string
+	Html    string
+}
+
+func SetSmtpConfig(config SmtpConfig) {
+	smtpConfig = config
+}
+
+// IsEmailAvailable reports whether a smtp server is configured.
+func IsEmailAvailable() bool {
+	return smtpConfig.Host != "" && smtpConfig.From != ""
+}
+
+// EmailNotifier sends the changes of a frame as multipart email rendered with
+// the email templates of the user.
+type EmailNotifier struct {
+	Smtp    SmtpConfig
+	Address string
+}
+
+func (notifier *EmailNotifier) Name() string {
+	return NotifierEmail
+}
+
+func (notifier *EmailNotifier) Notify(ctx context.Context, user *User, frame *DataFrame) error {
+	email, err := frame.GetEmail(user)
+	if err != nil {
+		return err
+	}
+
+	return sendEmail(notifier.Smtp, notifier.Address, email)
+}
+
+func (notifier *EmailNotifier) Alert(ctx context.Context, user *User, text MessageText) error {
+	subject, _, _ := strings.Cut(text.Text, "\n")
+	htmlBody := "<p>" + strings.ReplaceAll(html.EscapeString(text.Text), "\n", "<br>") + "</p>"
+	if text.Markdown {
+		htmlBody = MarkdownToHtml(text.Text)
+	}
+
+	return sendEmail(notifier.Smtp, notifier.Address, &Email{
+		Subject: "Leaseplan-Bot: " + subject,
+		Text:    text.Text,
+		Html:    htmlBody,
+	})
+}
+
+// GetEmail renders the frame into an email. The text part consists of the
+// summary and detail messages, the html part and the subject use the email
+// templates of the user.
+func (dataFrame *DataFrame) GetEmail(user *User) (*Email, error) {
+	frame := *dataFrame.PreparedFor(user)
+	if user.IgnoreRemoved {
+		frame.Removed = nil
+	}
+
+	subject, err := fillTextTemplate(user.GetEmailSubjectTemplate(), &frame, user.TemplateFuncs())
+	if err != nil {
+		return nil, err
+	}
+
+	html, err := fillTemplate(user.GetEmailHtmlTemplate(), &frame, user.TemplateFuncs())
+	if err != nil {
+		return nil, err
+	}
+
+	text := new(bytes.Buffer)
+	summary, err := fillTextTemplate(user.SummaryMessageTemplate, &frame, user.TemplateFuncs())
+	if err != nil {
+		return nil, err
+	}
+	text.WriteString(summary + "\n")
+
+	if !user.IgnoreDetails {
+		for _, section := range []struct {
+			title string
+			cars  []dto.Item
+		}{{"Added", frame.Added}, {"Removed", frame.Removed}} {
+			if len(section.cars) == 0 {
+				continue
+			}
+			fmt.Fprintf(text, "\n%s:\n", section.title)
+			for _, car := range section.cars {
+				line, err := fillTextTemplate(user.DetailMessageTemplate, car, user.TemplateFuncs())
+				if err != nil {
+					return nil, err
+				}
+				text.WriteString(line + "\n")
+			}
+		}
+	}
+
+	return &Email{
+		Subject: strings.TrimSpace(subject),
+		Text:    text.String(),
+		Html:    html,
+	}, nil
+}
+
+// sendEmail delivers a multipart email to a single recipient.
+func sendEmail(config SmtpConfig, to string, email *Email) error {
+	if config.Host == "" || config.From == "" {
+		return ErrEmailNotConfigured
+	}
+
+	message, err := buildEmailMessage(config.From, to, email)
+	if err != nil {
+		return err
+	}
+
+	connection, err := net.DialTimeout("tcp", net.JoinHostPort(config.Host, strconv.Itoa(config.Port)), smtpTimeout)
+	if err != nil {
+		return err
+	}
+	connection.SetDeadline(time.Now().Add(smtpTimeout))
+
+	client, err := smtp.NewClient(connection, config.Host)
+	if err != nil {
+		connection.Close()
+		return err
+	}
+	defer client.Close()
+
+	if config.StartTls {
+		if supported, _ := client.Extension("STARTTLS"); !supported {
+			return ErrSmtpStartTlsNotSupported
+		}
+		err = client.StartTLS(&tls.Config{ServerName: config.Host})
+		if err != nil {
+			return err
+		}
+	}
+
+	if config.Username != "" {
+		err = client.Auth(smtp.PlainAuth("", config.Username, config.Password, config.Host))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = client.Mail(config.From)
+	if err != nil {
+		return err
+	}
+	err = client.Rcpt(to)
+	if err != nil {
+		return err
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(message)
+	if err != nil {
+		return err
+	}
+	err = writer.Close()
+	if err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+func buildEmailMessage(from string, to string, email *Email) ([]byte, error) {
+	body := new(bytes.Buffer)
+	parts := multipart.NewWriter(body)
+
+	for _, part := range []struct {
+		contentType string
+		content     string
+	}{{"text/plain", email.Text}, {"text/html", email.Html}} {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", part.contentType+"; charset=utf-8")
+		header.Set("Content-Transfer-Encoding", "quoted-printable")
+
+		writer, err := parts.CreatePart(header)
+		if err != nil {
+			return nil, err
+		}
+		encoder := quotedprintable.NewWriter(writer)
+		encoder.Write([]byte(part.content))
+		encoder.Close()
+	}
+	parts.Close()
+
+	message := new(bytes.Buffer)
+	fmt.Fprintf(message, "From: %s\r\n", from)
+	fmt.Fprintf(message, "To: %s\r\n", to)
+	fmt.Fprintf(message, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", email.Subject))
+	fmt.Fprintf(message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(message, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(message, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", parts.Boundary())
+	message.Write(body.Bytes())
+
+	return message.Bytes(), nil
+}
+
+func (user *User) GetEmailSubjectTemplate() string {
+	if user.EmailSubjectTemplate == "" {
+		return DefaultEmailSubjectTemplate
+	}
+
+	return user.EmailSubjectTemplate
+}
+
+func (user *User) GetEmailHtmlTemplate() string {
+	if user.EmailHtmlTemplate == "" {
+		return DefaultEmailHtmlTemplate
+	}
+
+	return user.EmailHtmlTemplate
+}
+
+// SetEmailTemplates validates and sets the email templates of the user. Empty
+// templates select the defaults.
+func (user *User) SetEmailTemplates(subjectTemplate string, htmlTemplate string) error {
+	testUser := user.clone()
+	testUser.EmailSubjectTemplate = subjectTemplate
+	testUser.EmailHtmlTemplate = htmlTemplate
+
+	testFrame := NewDataFrame(nil, testUser.LastFrame.Current)
+	_, err := testFrame.GetEmail(testUser)
+	if err != nil {
+		return err
+	}
+
+	user.EmailSubjectTemplate = subjectTemplate
+	user.EmailHtmlTemplate = htmlTemplate
+	return nil
+}
+
+// RequestEmailVerification sends a confirmation code to the address. The
+// address is used once the code is confirmed with ConfirmEmail. A new code
+// can only be requested every 5 minutes, so the bot can not be used to flood
+// other addresses with mails.
+func (user *User) RequestEmailVerification(address string) error {
+	if !IsEmailAvailable() {
+		return ErrEmailNotConfigured
+	}
+
+	parsed, err := mail.ParseAddress(address)
+	if err != nil {
+		return ErrInvalidEmailAddress
+	}
+
+	if wait := time.Until(user.EmailConfirmationRequested.Add(emailConfirmationInterval)); wait > 0 {
+		return fmt.Errorf("%w, try again in %s", ErrConfirmationThrottled, wait.Round(time.Second))
+	}
+	// failed deliveries count as well, they reached the smtp server anyway
+	user.EmailConfirmationRequested = time.Now()
+
+	number, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return err
+	}
+	code := fmt.Sprintf("%06d", number.Int64())
+
+	err = sendEmail(smtpConfig, parsed.Address, &Email{
+		Subject: "Leaseplan-Bot: Bestätigungscode",
+		Text:    fmt.Sprintf("Dein Bestätigungscode lautet %s\n\nSende dem Bot /email confirm %s um Benachrichtigungen an diese Adresse zu erhalten. Der Code ist eine Stunde gültig.", code, code),
+		Html:    fmt.Sprintf("<p>Dein Bestätigungscode lautet <b>%s</b></p><p>Sende dem Bot <code>/email confirm %s</code> um Benachrichtigungen an diese Adresse zu erhalten. Der Code ist eine Stunde gültig.</p>", code, code),
+	})
+	if err != nil {
+		return err
+	}
+
+	user.EmailPendingAddress = parsed.Address
+	user.EmailConfirmationCode = HashApiKey(code)
+	user.EmailConfirmationExpiry = time.Now().Add(emailConfirmationValidity)
+	user.EmailConfirmationFailures = 0
+
+	return nil
+}
+
+// ConfirmEmail checks the confirmation code and switches the notifications of
+// the user to the pending address. After 5 invalid codes the pending address
+// is dropped and a new code has to be requested.
+func (user *User) ConfirmEmail(code string) error {
+	if user.EmailPendingAddress == "" || time.Now().After(user.EmailConfirmationExpiry) {
+		return ErrNoConfirmationPending
+	}
+	if subtle.ConstantTimeCompare([]byte(HashApiKey(strings.TrimSpace(code))), []byte(user.EmailConfirmationCode)) != 1 {
+		user.EmailConfirmationFailures++
+		if user.EmailConfirmationFailures >= emailConfirmationAttempts {
+			user.clearEmailConfirmation()
+			return ErrTooManyAttempts
+		}
+		return ErrInvalidConfirmationCode
+	}
+
+	user.Notifier = NotifierEmail
+	user.EmailAddress = user.EmailPendingAddress
+	user.clearEmailConfirmation()
+
+	return nil
+}
+
+func (user *User) clearEmailConfirmation() {
+	user.EmailPendingAddress = ""
+	user.EmailConfirmationCode = ""
+	user.EmailConfirmationExpiry = time.Time{}
+	user.EmailConfirmationFailures = 0
+}
+
+// DisableEmail switches the notifications of the user back to telegram.
+func (user *User) DisableEmail() {
+	if user.Notifier == NotifierEmail {
+		user.Notifier = ""
+	}
+	user.EmailAddress = ""
+}
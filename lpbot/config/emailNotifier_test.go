@@ -0,0 +1,293 @@
+package config_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/khase/leaseplan-bot/lpbot/config"
+	"github.com/khase/leaseplanabocarexporter/dto"
+)
+
+type testMail struct {
+	auth string
+	from string
+	to   string
+	data []byte
+}
+
+// startTestSmtpServer runs a minimal smtp server accepting every mail and
+// returns its port and a channel receiving the delivered mails.
+func startTestSmtpServer(t *testing.T) (int, chan *testMail) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	mails := make(chan *testMail, 10)
+	go func() {
+		for {
+			connection, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveTestSmtpConnection(textproto.NewConn(connection), mails)
+		}
+	}()
+
+	return listener.Addr().(*net.TCPAddr).Port, mails
+}
+
+func serveTestSmtpConnection(connection *textproto.Conn, mails chan *testMail) {
+	defer connection.Close()
+
+	mail := new(testMail)
+	connection.PrintfLine("220 localhost ESMTP")
+	for {
+		line, err := connection.ReadLine()
+		if err != nil {
+			return
+		}
+
+		command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch command {
+		case "EHLO", "HELO":
+			connection.PrintfLine("250-localhost\r\n250-AUTH PLAIN\r\n250 8BITMIME")
+		case "AUTH":
+			mail.auth = line
+			connection.PrintfLine("235 2.7.0 Authentication successful")
+		case "MAIL":
+			mail.from = line
+			connection.PrintfLine("250 OK")
+		case "RCPT":
+			mail.to = line
+			connection.PrintfLine("250 OK")
+		case "DATA":
+			connection.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			mail.data, err = connection.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			connection.PrintfLine("250 OK")
+			mails <- mail
+			mail = new(testMail)
+		case "QUIT":
+			connection.PrintfLine("221 Bye")
+			return
+		default:
+			connection.PrintfLine("250 OK")
+		}
+	}
+}
+
+// parseTestMail returns the decoded subject and the parts of a multipart mail
+// by content type.
+func parseTestMail(t *testing.T, data []byte) (string, map[string]string) {
+	message, err := mail.ReadMessage(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(message.Header.Get("Subject"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(message.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("expected a multipart/alternative mail but got %q (%v)", mediaType, err)
+	}
+
+	parts := map[string]string{}
+	reader := multipart.NewReader(message.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+
+		contentType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		content, _ := io.ReadAll(part)
+		parts[contentType] = string(content)
+	}
+
+	return subject, parts
+}
+
+func receiveTestMail(t *testing.T, mails chan *testMail) *testMail {
+	select {
+	case mail := <-mails:
+		return mail
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no mail received")
+		return nil
+	}
+}
+
+// useTestSmtpServer sends the mails of the test to a local smtp server.
+func useTestSmtpServer(t *testing.T) chan *testMail {
+	port, mails := startTestSmtpServer(t)
+	config.SetSmtpConfig(config.SmtpConfig{
+		Host:     "127.0.0.1",
+		Port:     port,
+		Username: "bot",
+		Password: "secret",
+		From:     "bot@example.com",
+	})
+	t.Cleanup(func() { config.SetSmtpConfig(config.SmtpConfig{}) })
+
+	return mails
+}
+
+func TestEmailVerification(t *testing.T) {
+	mails := useTestSmtpServer(t)
+	user := newTestUser(t)
+
+	err := user.RequestEmailVerification("no address")
+	if !errors.Is(err, config.ErrInvalidEmailAddress) {
+		t.Fatalf("expected ErrInvalidEmailAddress but got %v", err)
+	}
+
+	err = user.RequestEmailVerification("Test <test@example.com>")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mail := receiveTestMail(t, mails)
+	if !strings.Contains(mail.to, "<test@example.com>") || !strings.Contains(mail.from, "<bot@example.com>") || !strings.HasPrefix(mail.auth, "AUTH PLAIN") {
+		t.Fatalf("unexpected smtp envelope %+v", mail)
+	}
+	_, parts := parseTestMail(t, mail.data)
+	code := regexp.MustCompile(`\d{6}`).FindString(parts["text/plain"])
+
+	err = user.ConfirmEmail("000000" + code)
+	if !errors.Is(err, config.ErrInvalidConfirmationCode) {
+		t.Fatalf("expected ErrInvalidConfirmationCode but got %v", err)
+	}
+	if user.Notifier == config.NotifierEmail {
+		t.Fatalf("expected notifier to stay unchanged")
+	}
+
+	err = user.ConfirmEmail(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Notifier != config.NotifierEmail || user.EmailAddress != "test@example.com" {
+		t.Fatalf("expected email to be selected but got %q (%q)", user.Notifier, user.EmailAddress)
+	}
+
+	err = user.ConfirmEmail(code)
+	if !errors.Is(err, config.ErrNoConfirmationPending) {
+		t.Fatalf("expected a code to be usable once but got %v", err)
+	}
+
+	user.DisableEmail()
+	if user.GetNotifier(nil).Name() != config.NotifierTelegram {
+		t.Fatalf("expected telegram to be selected after disabling email")
+	}
+}
+
+func TestEmailVerificationLimits(t *testing.T) {
+	mails := useTestSmtpServer(t)
+	user := newTestUser(t)
+
+	err := user.RequestEmailVerification("test@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiveTestMail(t, mails)
+
+	err = user.RequestEmailVerification("other@example.com")
+	if !errors.Is(err, config.ErrConfirmationThrottled) {
+		t.Fatalf("expected a second request to be throttled but got %v", err)
+	}
+	if user.EmailPendingAddress != "test@example.com" {
+		t.Fatalf("expected the pending address to stay unchanged but got %q", user.EmailPendingAddress)
+	}
+
+	for attempt := 1; attempt < 5; attempt++ {
+		if err := user.ConfirmEmail("wrong"); !errors.Is(err, config.ErrInvalidConfirmationCode) {
+			t.Fatalf("expected attempt %d to fail with ErrInvalidConfirmationCode but got %v", attempt, err)
+		}
+	}
+	if err := user.ConfirmEmail("wrong"); !errors.Is(err, config.ErrTooManyAttempts) {
+		t.Fatalf("expected the 5th invalid code to invalidate the confirmation but got %v", err)
+	}
+	if err := user.ConfirmEmail("wrong"); !errors.Is(err, config.ErrNoConfirmationPending) {
+		t.Fatalf("expected no confirmation to be pending but got %v", err)
+	}
+
+	user.EmailConfirmationRequested = time.Now().Add(-10 * time.Minute)
+	if err := user.RequestEmailVerification("test@example.com"); err != nil {
+		t.Fatalf("expected a new code after the interval but got %v", err)
+	}
+	receiveTestMail(t, mails)
+}
+
+func TestEmailNotifier(t *testing.T) {
+	mails := useTestSmtpServer(t)
+	user := newTestUser(t)
+	user.Notifier = config.NotifierEmail
+	user.EmailAddress = "test@example.com"
+
+	frame := config.NewDataFrame(
+		[]dto.Item{{Ident: "1", OfferTypeName: "Alt", RentalObject: dto.RentalObject{Ident: "a"}}},
+		[]dto.Item{{Ident: "2", OfferTypeName: "Neu & Schön", RentalObject: dto.RentalObject{Ident: "b", PowerHP: 300}}})
+	err := user.GetNotifier(nil).Notify(context.Background(), user, frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subject, parts := parseTestMail(t, receiveTestMail(t, mails).data)
+	if subject != "Leaseplan-Bot: 1 neue, 1 entfernte Angebote" {
+		t.Fatalf("unexpected subject %q", subject)
+	}
+	if !strings.Contains(parts["text/plain"], "1 -> 1 (+1, -1)") || !strings.Contains(parts["text/plain"], "Added:\n[Neu & Schön]") || !strings.Contains(parts["text/plain"], "Removed:\n[Alt]") {
+		t.Fatalf("unexpected text part %q", parts["text/plain"])
+	}
+	if !strings.Contains(parts["text/html"], `<a href="https://www.leaseplan-abocar.de/offer-details/2/b">Neu &amp; Schön</a>`) || !strings.Contains(parts["text/html"], "PS: 300") {
+		t.Fatalf("unexpected html part %q", parts["text/html"])
+	}
+
+	user.IgnoreRemoved = true
+	user.IgnoreDetails = true
+	err = user.SetEmailTemplates("{{ len .Added }} neu", "<p>{{ len .Removed }}</p>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	email, err := frame.GetEmail(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if email.Subject != "1 neu" || email.Html != "<p>0</p>" || strings.Contains(email.Text, "Added:") {
+		t.Fatalf("expected the custom templates and settings to be used but got %+v", email)
+	}
+
+	err = user.SetEmailTemplates("{{ .Unknown }}", "")
+	if err == nil || user.EmailSubjectTemplate != "{{ len .Added }} neu" {
+		t.Fatalf("expected invalid templates to be rejected")
+	}
+}
+
+func TestEmailNotConfigured(t *testing.T) {
+	userMap := config.NewUserMap(filepath.Join(t.TempDir(), "test.userdata"))
+	user, _ := userMap.CreateNewUser(1, "test")
+
+	err := user.RequestEmailVerification("test@example.com")
+	if !errors.Is(err, config.ErrEmailNotConfigured) {
+		t.Fatalf("expected ErrEmailNotConfigured but got %v", err)
+	}
+}
@@ -0,0 +1,13 @@
+package config
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+// AllowPrivateAddresses lets the notifiers connect to the local test servers
+// until the test is done.
+func AllowPrivateAddresses(t testing.TB) {
+	atomic.StoreInt32(&privateAddressesAllowed, 1)
+	t.Cleanup(func() { atomic.StoreInt32(&privateAddressesAllowed, 0) })
+}
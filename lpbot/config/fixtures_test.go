@@ -0,0 +1,59 @@
+package config_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/khase/leaseplan-bot/lpbot/config"
+	"github.com/khase/leaseplanabocarexporter/dto"
+)
+
+// testCar describes a car of the tests, fields left out stay empty.
+type testCar struct {
+	Ident        string
+	Label        string
+	Model        string
+	Spec         string
+	Fuel         string
+	HP           int64
+	BLP          float64
+	SalaryWaiver int64
+}
+
+// newTestCar returns the car of an offer named after its label and model, or
+// its model specification if set.
+func newTestCar(car testCar) dto.Item {
+	name := car.Model
+	if car.Spec != "" {
+		name = car.Spec
+	}
+	item := dto.Item{
+		Ident:         "offer-" + car.Ident,
+		OfferTypeName: car.Label + " " + name,
+		SalaryWaiver:  car.SalaryWaiver,
+		RentalObject: dto.RentalObject{
+			Ident:          car.Ident,
+			CarLabel:       dto.CarLabel(car.Label),
+			CarModell:      car.Model,
+			KindOfFuel:     dto.KindOfFuel(car.Fuel),
+			PowerHP:        car.HP,
+			PriceProducer1: car.BLP,
+		},
+	}
+	if car.Spec != "" {
+		item.RentalObject.CarModellspec = &car.Spec
+	}
+
+	return item
+}
+
+// newTestUser returns a user on "Level 1" saved to a temporary directory,
+// the given cars are his current cars.
+func newTestUser(t *testing.T, cars ...dto.Item) *config.User {
+	userMap := config.NewUserMap(filepath.Join(t.TempDir(), "test.userdata"))
+	user, _ := userMap.CreateNewUser(1, "test")
+	user.LeaseplanLevelKey = "Level 1"
+	user.LastFrame = config.NewDataFrame([]dto.Item{}, cars)
+
+	return user
+}
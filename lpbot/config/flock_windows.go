@@ -0,0 +1,11 @@
+package config
+
+import (
+	"os"
+)
+
+// lockFile does not lock on windows, running the bot and the command line
+// tools on the same userdata is not detected there.
+func lockFile(file *os.File) error {
+	return nil
+}
@@ -0,0 +1,244 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/khase/leaseplanabocarexporter/dto"
+)
+
+const (
+	GroupByOffer = "offer"
+	GroupByModel = "model"
+	GroupByBrand = "brand"
+	GroupByFuel  = "fuel"
+
+	DefaultGroupMessageTemplate = "{{ .Count }}x {{ .Key }}\n  BGV: {{ .BgvRange }}\n  Verfügbar: {{ .AvailabilityRange }}"
+)
+
+var (
+	ErrInvalidGroupKey = errors.New("invalid group key")
+
+	// GroupKeys lists the predefined keys accepted by SetGroupBy. Any other
+	// key is used as template expression like a filter.
+	GroupKeys = []string{GroupByOffer, GroupByModel, GroupByBrand, GroupByFuel}
+
+	groupKeyFuncs = map[string]func(car dto.Item) string{
+		GroupByOffer: carName,
+		GroupByModel: CarModel,
+		GroupByBrand: func(car dto.Item) string {
+			return string(car.RentalObject.CarLabel)
+		},
+		GroupByFuel: func(car dto.Item) string {
+			return string(car.RentalObject.KindOfFuel)
+		},
+	}
+)
+
+// CarGroup aggregates all cars sharing the same group key.
+type CarGroup struct {
+	Key             string
+	Cars            []dto.Item
+	Count           int
+	MinSalaryWaiver int64
+	MaxSalaryWaiver int64
+	FirstAvailable  time.Time
+	LastAvailable   time.Time
+}
+
+func (group *CarGroup) add(car dto.Item) {
+	available := car.RentalObject.DateRegistration.Time
+	if group.Count == 0 || car.SalaryWaiver < group.MinSalaryWaiver {
+		group.MinSalaryWaiver = car.SalaryWaiver
+	}
+	if group.Count == 0 || car.SalaryWaiver > group.MaxSalaryWaiver {
+		group.MaxSalaryWaiver = car.SalaryWaiver
+	}
+	if group.Count == 0 || available.Before(group.FirstAvailable) {
+		group.FirstAvailable = available
+	}
+	if group.Count == 0 || available.After(group.LastAvailable) {
+		group.LastAvailable = available
+	}
+
+	group.Cars = append(group.Cars, car)
+	group.Count++
+}
+
+// BgvRange formats the salary waivers of the group, e.g. "329€ - 389€".
+func (group *CarGroup) BgvRange() string {
+	if group.MinSalaryWaiver == group.MaxSalaryWaiver {
+		return fmt.Sprintf("%d€", group.MinSalaryWaiver)
+	}
+
+	return fmt.Sprintf("%d€ - %d€", group.MinSalaryWaiver, group.MaxSalaryWaiver)
+}
+
+// AvailabilityRange formats the availability dates of the group, e.g.
+// "15.01.2023 - 05.04.2023".
+func (group *CarGroup) AvailabilityRange() string {
+	first := group.FirstAvailable.Format("02.01.2006")
+	last := group.LastAvailable.Format("02.01.2006")
+	if first == last {
+		return first
+	}
+
+	return fmt.Sprintf("%s - %s", first, last)
+}
+
+// GroupCars groups the cars by the given key, see SetGroupBy. The groups keep
+// the order in which their first car appears.
+func GroupCars(cars []dto.Item, groupBy string) ([]*CarGroup, error) {
+	keyFunc, err := getGroupKeyFunc(groupBy)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := []*CarGroup{}
+	groupsByKey := map[string]*CarGroup{}
+	for _, car := range cars {
+		key, err := keyFunc(car)
+		if err != nil {
+			return nil, err
+		}
+
+		group, exists := groupsByKey[key]
+		if !exists {
+			group = &CarGroup{Key: key}
+			groupsByKey[key] = group
+			groups = append(groups, group)
+		}
+		group.add(car)
+	}
+
+	return groups, nil
+}
+
+func getGroupKeyFunc(groupBy string) (func(car dto.Item) (string, error), error) {
+	if groupBy == "" {
+		groupBy = GroupByOffer
+	}
+	if keyFunc, exists := groupKeyFuncs[groupBy]; exists {
+		return func(car dto.Item) (string, error) {
+			return keyFunc(car), nil
+		}, nil
+	}
+
+	keyTemplate := fmt.Sprintf("{{%s}}", groupBy)
+	if _, err := fillTextTemplate(keyTemplate, dto.Item{}); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidGroupKey, err)
+	}
+
+	return func(car dto.Item) (string, error) {
+		key, err := fillTextTemplate(keyTemplate, car)
+		return strings.TrimSpace(key), err
+	}, nil
+}
+
+// AddedGroups groups the added cars by the group key the frame was prepared
+// with, see PreparedFor.
+func (dataFrame *DataFrame) AddedGroups() ([]*CarGroup, error) {
+	return GroupCars(dataFrame.Added, dataFrame.groupBy)
+}
+
+// RemovedGroups groups the removed cars like AddedGroups.
+func (dataFrame *DataFrame) RemovedGroups() ([]*CarGroup, error) {
+	return GroupCars(dataFrame.Removed, dataFrame.groupBy)
+}
+
+// PreparedFor returns a copy of the frame as the user sees it: sorted by the
+// order and grouped by the group key of the user.
+func (dataFrame *DataFrame) PreparedFor(user *User) *DataFrame {
+	frame := *dataFrame.SortedFor(user)
+	frame.groupBy = user.GroupBy
+
+	return &frame
+}
+
+// SetGroupBy selects the key the cars are grouped by. Either one of GroupKeys
+// or a template expression evaluated against each car, an empty key groups
+// by offer.
+func (user *User) SetGroupBy(groupBy string) error {
+	groupBy = strings.TrimSpace(groupBy)
+	if _, exists := groupKeyFuncs[strings.ToLower(groupBy)]; exists {
+		groupBy = strings.ToLower(groupBy)
+	}
+	if _, err := getGroupKeyFunc(groupBy); err != nil {
+		return err
+	}
+	if groupBy == GroupByOffer {
+		groupBy = ""
+	}
+	user.GroupBy = groupBy
+
+	return nil
+}
+
+// SetGroupMessageTemplate replaces the template of grouped detail lines if it
+// can be rendered. On error the previous template is kept.
+func (user *User) SetGroupMessageTemplate(groupTemplate string) error {
+	group := &CarGroup{}
+	group.add(dto.Item{})
+	if _, err := fillTemplate(groupTemplate, group, user.TemplateFuncs()); err != nil {
+		return err
+	}
+	user.GroupMessageTemplate = groupTemplate
+
+	return nil
+}
+
+func (user *User) GetGroupMessageTemplate() string {
+	if user.GroupMessageTemplate == "" {
+		return DefaultGroupMessageTemplate
+	}
+
+	return user.GroupMessageTemplate
+}
+
+// getGroupedDetailMessages lists one line per group. Groups of a single car
+// are rendered with the detail template and keep their action buttons.
+func getGroupedDetailMessages(user *User, added []dto.Item, removed []dto.Item) ([]tgbotapi.Chattable, error) {
+	addedTexts, addedButtons, err := getGroupsDetailsTexts(user, added)
+	if err != nil {
+		return nil, err
+	}
+	removedTexts, _, err := getGroupsDetailsTexts(user, removed)
+	if err != nil {
+		return nil, err
+	}
+
+	return getTextDetailMessages(user, addedTexts, addedButtons, removedTexts), nil
+}
+
+func getGroupsDetailsTexts(user *User, cars []dto.Item) ([]string, [][]tgbotapi.InlineKeyboardButton, error) {
+	groups, err := GroupCars(cars, user.GroupBy)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	texts := []string{}
+	buttons := [][]tgbotapi.InlineKeyboardButton{}
+	for _, group := range groups {
+		if group.Count == 1 {
+			text, err := getCarDetails(&group.Cars[0], user.DetailMessageTemplate, user.TemplateFuncs())
+			if err != nil {
+				return nil, nil, err
+			}
+			texts = append(texts, text)
+			buttons = append(buttons, getCarActionButtons(group.Cars[0], user))
+			continue
+		}
+
+		text, err := fillTemplate(user.GetGroupMessageTemplate(), group, user.TemplateFuncs())
+		if err != nil {
+			return nil, nil, err
+		}
+		texts = append(texts, text)
+		buttons = append(buttons, nil)
+	}
+
+	return texts, buttons, nil
+}
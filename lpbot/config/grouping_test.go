@@ -0,0 +1,91 @@
+package config_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/khase/leaseplan-bot/lpbot/config"
+)
+
+func TestGroupCars(t *testing.T) {
+	frame, err := config.LoadDataFrameFile("../../testdata/sorting.dataframe.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	groups, err := config.GroupCars(frame.Current, config.GroupByBrand)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{}
+	for _, group := range groups {
+		keys = append(keys, group.Key)
+	}
+	if len(keys) != 4 || keys[0] != "BMW" || keys[1] != "Seat" || keys[2] != "Audi" || keys[3] != "VW" {
+		t.Fatalf("expected the groups in order of appearance but got %v", keys)
+	}
+
+	bmw := groups[0]
+	if bmw.Count != 2 || len(bmw.Cars) != 2 {
+		t.Fatalf("expected 2 BMWs but got %d", bmw.Count)
+	}
+	if bmw.MinSalaryWaiver != 549 || bmw.MaxSalaryWaiver != 599 || bmw.BgvRange() != "549€ - 599€" {
+		t.Fatalf("unexpected salary waivers %d - %d", bmw.MinSalaryWaiver, bmw.MaxSalaryWaiver)
+	}
+	if bmw.AvailabilityRange() != "31.01.2023 - 01.03.2023" {
+		t.Fatalf("unexpected availability %s", bmw.AvailabilityRange())
+	}
+	if groups[1].BgvRange() != "329€" || groups[1].AvailabilityRange() != "05.04.2023" {
+		t.Fatalf("expected single values for a single car but got %s, %s", groups[1].BgvRange(), groups[1].AvailabilityRange())
+	}
+
+	groups, err = config.GroupCars(frame.Current, "gt .RentalObject.PowerHP 200")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 2 || groups[0].Key != "false" || groups[0].Count != 2 || groups[1].Key != "true" || groups[1].Count != 3 {
+		t.Fatalf("expected the cars grouped by the expression")
+	}
+}
+
+func TestDataFrameGroups(t *testing.T) {
+	frame, err := config.LoadDataFrameFile("../../testdata/sorting.dataframe.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := config.NewUser(nil, 123, "groups")
+	user.SummaryMessageTemplate = "{{ range .AddedGroups }}{{ .Key }}: {{ .Count }}\n{{ end }}{{ range .RemovedGroups }}-{{ .Key }}\n{{ end }}"
+	if err := user.SetGroupBy("Fuel"); err != nil || user.GroupBy != config.GroupByFuel {
+		t.Fatalf("expected the group key fuel but got %q, %v", user.GroupBy, err)
+	}
+
+	messages, err := frame.GetMessages(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	summary := renderMessages(t, messages[:1])
+	if summary != "Benzin: 1\nElektro: 1\nDiesel: 2\n-Elektro\n" {
+		t.Fatalf("unexpected summary %q", summary)
+	}
+}
+
+func TestSetGroupBy(t *testing.T) {
+	user := &config.User{}
+	if err := user.SetGroupBy("{{ broken"); !errors.Is(err, config.ErrInvalidGroupKey) || user.GroupBy != "" {
+		t.Fatalf("expected invalid group keys to be rejected but got %v", err)
+	}
+	if err := user.SetGroupBy(".RentalObject.CarLabel"); err != nil || user.GroupBy != ".RentalObject.CarLabel" {
+		t.Fatalf("expected expressions to be accepted but got %q, %v", user.GroupBy, err)
+	}
+	if err := user.SetGroupBy(config.GroupByOffer); err != nil || user.GroupBy != "" {
+		t.Fatalf("expected the default group key to be reset")
+	}
+
+	if err := user.SetGroupMessageTemplate("{{ .Unknown }}"); err == nil || user.GetGroupMessageTemplate() != config.DefaultGroupMessageTemplate {
+		t.Fatalf("expected broken group templates to be rejected")
+	}
+	if err := user.SetGroupMessageTemplate("{{ .Count }}x {{ .Key }}"); err != nil || user.GroupMessageTemplate != "{{ .Count }}x {{ .Key }}" {
+		t.Fatalf("expected the group template to be set but got %v", err)
+	}
+}
@@ -0,0 +1,109 @@
+package config
+
+import (
+	"html"
+	"strings"
+)
+
+// markdownFormat renders the elements of the legacy telegram markdown used in
+// the message templates.
+type markdownFormat struct {
+	text   func(text string) string
+	bold   func(text string) string
+	italic func(text string) string
+	code   func(text string) string
+	pre    func(text string) string
+	link   func(text string, url string) string
+}
+
+var (
+	htmlMarkdownFormat = markdownFormat{
+		text: func(text string) string {
+			return strings.ReplaceAll(html.EscapeString(text), "\n", "<br>")
+		},
+		bold:   func(text string) string { return "<b>" + html.EscapeString(text) + "</b>" },
+		italic: func(text string) string { return "<i>" + html.EscapeString(text) + "</i>" },
+		code:   func(text string) string { return "<code>" + html.EscapeString(text) + "</code>" },
+		pre:    func(text string) string { return "<pre>" + html.EscapeString(text) + "</pre>" },
+		link: func(text string, url string) string {
+			return "<a href=\"" + html.EscapeString(url) + "\">" + html.EscapeString(text) + "</a>"
+		},
+	}
+	commonMarkFormat = markdownFormat{
+		// lines are separated by hard breaks as single newlines would be joined
+		text:   func(text string) string { return strings.ReplaceAll(text, "\n", "  \n") },
+		bold:   func(text string) string { return "**" + text + "**" },
+		italic: func(text string) string { return "_" + text + "_" },
+		code:   func(text string) string { return "`" + text + "`" },
+		pre:    func(text string) string { return "```\n" + text + "\n```" },
+		link:   func(text string, url string) string { return "[" + text + "](" + url + ")" },
+	}
+)
+
+// MarkdownToHtml converts telegram markdown into html.
+func MarkdownToHtml(markdown string) string {
+	return convertMarkdown(markdown, htmlMarkdownFormat)
+}
+
+// MarkdownToCommonMark converts telegram markdown into CommonMark as
+// understood by most push services.
+func MarkdownToCommonMark(markdown string) string {
+	return convertMarkdown(markdown, commonMarkFormat)
+}
+
+func convertMarkdown(markdown string, format markdownFormat) string {
+	result := new(strings.Builder)
+	plain := new(strings.Builder)
+	flush := func() {
+		if plain.Len() > 0 {
+			result.WriteString(format.text(plain.String()))
+			plain.Reset()
+		}
+	}
+
+	for i := 0; i < len(markdown); {
+		rest := markdown[i:]
+
+		if strings.HasPrefix(rest, "```") {
+			if end := strings.Index(rest[3:], "```"); end >= 0 {
+				flush()
+				result.WriteString(format.pre(strings.Trim(rest[3:3+end], "\n")))
+				i += end + 6
+				continue
+			}
+		}
+
+		switch rest[0] {
+		case '*', '_', '`':
+			if end := strings.IndexByte(rest[1:], rest[0]); end > 0 {
+				flush()
+				content := rest[1 : 1+end]
+				switch rest[0] {
+				case '*':
+					result.WriteString(format.bold(content))
+				case '_':
+					result.WriteString(format.italic(content))
+				default:
+					result.WriteString(format.code(content))
+				}
+				i += end + 2
+				continue
+			}
+		case '[':
+			if textEnd := strings.Index(rest, "]("); textEnd > 0 {
+				if urlEnd := strings.IndexByte(rest[textEnd:], ')'); urlEnd > 0 {
+					flush()
+					result.WriteString(format.link(rest[1:textEnd], rest[textEnd+2:textEnd+urlEnd]))
+					i += textEnd + urlEnd + 1
+					continue
+				}
+			}
+		}
+
+		plain.WriteByte(markdown[i])
+		i++
+	}
+	flush()
+
+	return result.String()
+}
@@ -0,0 +1,37 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/khase/leaseplan-bot/lpbot/config"
+)
+
+func TestMarkdownToHtml(t *testing.T) {
+	tests := map[string]string{
+		"plain <text> & more":                    "plain &lt;text&gt; &amp; more",
+		"*bold* and _italic_\nnext line":         "<b>bold</b> and <i>italic</i><br>next line",
+		"[BMW i4](https://example.com/?a=1&b=2)": `<a href="https://example.com/?a=1&amp;b=2">BMW i4</a>`,
+		"`code` and ```\npre\n```":               "<code>code</code> and <pre>pre</pre>",
+		"2 * 3 = 6 and [not a link":              "2 * 3 = 6 and [not a link",
+		"BLP: 42000€, BGV: 400€, Netto: ~180.5€": "BLP: 42000€, BGV: 400€, Netto: ~180.5€",
+	}
+
+	for markdown, expected := range tests {
+		if html := config.MarkdownToHtml(markdown); html != expected {
+			t.Errorf("expected %q to be converted to %q but got %q", markdown, expected, html)
+		}
+	}
+}
+
+func TestMarkdownToCommonMark(t *testing.T) {
+	tests := map[string]string{
+		"*bold* and _italic_\nnext line":    "**bold** and _italic_  \nnext line",
+		"[BMW i4](https://example.com)\nPS": "[BMW i4](https://example.com)  \nPS",
+	}
+
+	for markdown, expected := range tests {
+		if commonMark := config.MarkdownToCommonMark(markdown); commonMark != expected {
+			t.Errorf("expected %q to be converted to %q but got %q", markdown, expected, commonMark)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package config
+
+import (
+	"context"
+	"log"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/khase/leaseplan-bot/lpbot/tgcon"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	NotifierTelegram = "telegram"
+	NotifierWebhook  = "webhook"
+)
+
+var (
+	totalNotificationErrors = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "notify_total_errors",
+			Help: "The total number of notifications that could not be delivered",
+		},
+		[]string{
+			"username",
+			"notifier",
+		})
+)
+
+// Notifier delivers the changes of a data frame to a user. Alert delivers a
+// single message besides the frames, e.g. about watched cars.
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, user *User, frame *DataFrame) error
+	Alert(ctx context.Context, user *User, text MessageText) error
+}
+
+// TelegramNotifier sends the summary and detail messages of a frame to the
+// telegram chat of the user.
+type TelegramNotifier struct {
+	Bot *tgbotapi.BotAPI
+}
+
+func (notifier *TelegramNotifier) Name() string {
+	return NotifierTelegram
+}
+
+func (notifier *TelegramNotifier) Notify(ctx context.Context, user *User, frame *DataFrame) error {
+	messages, err := frame.GetMessages(user)
+	if err != nil {
+		return err
+	}
+
+	totalMessagesSent.WithLabelValues(user.FriendlyName).Add(float64(len(messages)))
+	for _, message := range messages {
+		err = tgcon.Send(notifier.Bot, message)
+		if err == nil {
+			continue
+		}
+
+		// telegram fails photos it can not fetch, their cars are listed as
+		// text instead
+		fallback := getPhotoFallbackMessages(user, message)
+		if len(fallback) == 0 {
+			return err
+		}
+		log.Printf("Update for %s(%d): sending photos failed, falling back to text: %s", user.FriendlyName, user.UserId, err)
+		for _, fallbackMessage := range fallback {
+			err = tgcon.Send(notifier.Bot, fallbackMessage)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (notifier *TelegramNotifier) Alert(ctx context.Context, user *User, text MessageText) error {
+	message := tgbotapi.NewMessage(user.UserId, text.Text)
+	if text.Markdown {
+		message.ParseMode = "Markdown"
+	}
+
+	return tgcon.Send(notifier.Bot, message)
+}
+
+// sendAlerts delivers the alerts in the background with the notifier of the
+// user, like the notifications of the frames.
+func (user *User) sendAlerts(bot *tgbotapi.BotAPI, kind string, alerts []MessageText) {
+	if len(alerts) == 0 {
+		return
+	}
+
+	notifier := user.GetNotifier(bot)
+	pendingMessages.Add(1)
+	go func() {
+		defer pendingMessages.Done()
+		for _, alert := range alerts {
+			err := notifier.Alert(context.Background(), user, alert)
+			if err != nil {
+				totalNotificationErrors.WithLabelValues(user.FriendlyName, notifier.Name()).Inc()
+				log.Printf("Update for %s(%d): %s alert via %s failed: %s", user.FriendlyName, user.UserId, kind, notifier.Name(), err)
+			}
+		}
+		totalMessagesSent.WithLabelValues(user.FriendlyName).Add(float64(len(alerts)))
+	}()
+}
+
+// GetNotifier returns the notifier selected by the user. Telegram is used
+// unless another channel is configured.
+func (user *User) GetNotifier(bot *tgbotapi.BotAPI) Notifier {
+	switch user.Notifier {
+	case NotifierWebhook:
+		return &WebhookNotifier{Url: user.WebhookUrl, Secret: user.WebhookSecret}
+	case NotifierEmail:
+		return &EmailNotifier{Smtp: smtpConfig, Address: user.EmailAddress}
+	case NotifierNtfy:
+		return &NtfyNotifier{TopicUrl: user.NtfyTopicUrl, Token: user.NtfyToken}
+	case NotifierGotify:
+		return &GotifyNotifier{ServerUrl: user.GotifyServerUrl, Token: user.GotifyToken}
+	case NotifierMatrix:
+		return &MatrixNotifier{HomeserverUrl: user.MatrixHomeserverUrl, RoomId: user.MatrixRoomId, AccessToken: user.MatrixAccessToken}
+	default:
+		return &TelegramNotifier{Bot: bot}
+	}
+}
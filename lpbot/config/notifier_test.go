@@ -0,0 +1,99 @@
+package config_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/khase/leaseplan-bot/lpbot/config"
+	"github.com/khase/leaseplanabocarexporter/dto"
+)
+
+type telegramRequest struct {
+	method string
+	text   string
+}
+
+// startTelegramServer answers the bot api like telegram, except for photos
+// which fail as if telegram could not fetch the image.
+func startTelegramServer(t *testing.T) (*tgbotapi.BotAPI, func() []telegramRequest) {
+	var lock sync.Mutex
+	requests := []telegramRequest{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		lock.Lock()
+		requests = append(requests, telegramRequest{method: method, text: r.FormValue("text")})
+		lock.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if method == "sendPhoto" || method == "sendMediaGroup" {
+			w.Write([]byte(`{"ok": false, "error_code": 400, "description": "Bad Request: wrong file identifier/HTTP URL specified"}`))
+			return
+		}
+		w.Write([]byte(`{"ok": true, "result": {"message_id": 1, "date": 0, "chat": {"id": 123}}}`))
+	}))
+	t.Cleanup(server.Close)
+
+	bot := &tgbotapi.BotAPI{Token: "token", Client: server.Client(), Buffer: 100}
+	bot.SetAPIEndpoint(server.URL + "/bot%s/%s")
+
+	return bot, func() []telegramRequest {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]telegramRequest{}, requests...)
+	}
+}
+
+func TestTelegramNotifierPhotoFallback(t *testing.T) {
+	bot, getRequests := startTelegramServer(t)
+	user := &config.User{
+		UserId:                 123,
+		DetailMode:             config.DetailModeRich,
+		IgnoreRemoved:          true,
+		SummaryMessageTemplate: "{{ len .Added }}",
+		DetailMessageTemplate:  "{{ .OfferTypeName }}",
+	}
+	car := func(ident string, images ...string) dto.Item {
+		return dto.Item{Ident: "o" + ident, OfferTypeName: "car " + ident, ImageLinks: images, RentalObject: dto.RentalObject{Ident: ident}}
+	}
+	frame := config.NewDataFrame([]dto.Item{}, []dto.Item{
+		car("a", "https://example.com/a.jpg"),
+		car("b", "https://example.com/b.jpg"),
+		car("c", "https://example.com/c.jpg"),
+		car("d"),
+	})
+
+	// an album of a, b and c, then d as text
+	err := user.GetNotifier(bot).Notify(context.Background(), user, frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	texts := []string{}
+	for _, request := range getRequests() {
+		if request.method == "sendMessage" {
+			texts = append(texts, request.text)
+		}
+	}
+	listed := strings.Join(texts, "\n")
+	for _, name := range []string{"car a", "car b", "car c", "car d"} {
+		if !strings.Contains(listed, name) {
+			t.Fatalf("expected %s to be sent as text after the album failed but got %q", name, texts)
+		}
+	}
+
+	// a single photo
+	frame = config.NewDataFrame([]dto.Item{}, []dto.Item{car("e", "https://example.com/e.jpg")})
+	err = user.GetNotifier(bot).Notify(context.Background(), user, frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests := getRequests()
+	if last := requests[len(requests)-1]; last.method != "sendMessage" || !strings.Contains(last.text, "car e") {
+		t.Fatalf("expected the photo to be sent as text after it failed but got %+v", last)
+	}
+}
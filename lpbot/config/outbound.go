@@ -0,0 +1,119 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+var (
+	ErrPrivateAddress   = errors.New("url points to a private or local address")
+	ErrUnresolvableHost = errors.New("host of the url can not be resolved")
+
+	// privateAddressesAllowed disables the address checks if not 0, for
+	// tests with local servers only
+	privateAddressesAllowed int32
+
+	// nonPublicNetworks are not covered by the checks of net.IP: "this
+	// network" and the shared address space of carrier grade NAT
+	nonPublicNetworks = []*net.IPNet{mustParseCIDR("0.0.0.0/8"), mustParseCIDR("100.64.0.0/10")}
+	// nat64Network embeds an IPv4 address in its last 4 bytes (RFC 6052)
+	nat64Network = mustParseCIDR("64:ff9b::/96")
+)
+
+func mustParseCIDR(cidr string) *net.IPNet {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+
+	return network
+}
+
+// newOutboundClient returns a client for urls given by users. It only connects
+// to public addresses, the check is done on the address actually dialed, so
+// redirects and dns changes after the validation are covered as well.
+func newOutboundClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network string, address string, conn syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if !isPublicAddress(net.ParseIP(host)) {
+				return fmt.Errorf("%w: %s", ErrPrivateAddress, host)
+			}
+			return nil
+		},
+	}
+
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			// a proxy would hide the address of the target from the dialer
+			Proxy:               nil,
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: timeout,
+			MaxIdleConns:        10,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+}
+
+// validateOutboundUrl resolves the host of the url and rejects it if it points
+// to a private or local address. It gives users early feedback, the client of
+// newOutboundClient checks the addresses again on every connection.
+func validateOutboundUrl(ctx context.Context, target *url.URL) error {
+	host := target.Hostname()
+	if ip := net.ParseIP(host); ip != nil {
+		if !isPublicAddress(ip) {
+			return fmt.Errorf("%w: %s", ErrPrivateAddress, host)
+		}
+		return nil
+	}
+
+	addresses, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrUnresolvableHost, err)
+	}
+	for _, address := range addresses {
+		if !isPublicAddress(address.IP) {
+			return fmt.Errorf("%w: %s resolves to %s", ErrPrivateAddress, host, address.IP)
+		}
+	}
+
+	return nil
+}
+
+func isPublicAddress(ip net.IP) bool {
+	if atomic.LoadInt32(&privateAddressesAllowed) != 0 {
+		return true
+	}
+
+	if ip == nil {
+		return false
+	}
+	if nat64Network.Contains(ip) {
+		return isPublicAddress(ip[len(ip)-net.IPv4len:])
+	}
+	for _, network := range nonPublicNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+
+	return !ip.IsLoopback() &&
+		!ip.IsPrivate() &&
+		!ip.IsLinkLocalUnicast() &&
+		!ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() &&
+		!ip.IsMulticast() &&
+		!ip.IsUnspecified()
+}
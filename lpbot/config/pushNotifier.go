@@ -0,0 +1,324 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	NotifierMatrix = "matrix"
+	NotifierNtfy   = "ntfy"
+	NotifierGotify = "gotify"
+
+	pushTitle = "Leaseplan-Bot"
+)
+
+var (
+	ErrInvalidPushUrl   = errors.New("push url has to be an absolute http(s) url")
+	ErrMissingPushToken = errors.New("push token is required")
+	ErrInvalidRoomId    = errors.New("matrix room id has to look like !room:server")
+
+	pushClient = newOutboundClient(10 * time.Second)
+)
+
+// MessageText is the text of a single message along with whether it is
+// formatted with telegram markdown.
+type MessageText struct {
+	Text     string
+	Markdown bool
+}
+
+// GetMessageTexts returns the texts of the telegram messages of the frame so
+// other channels can deliver the same content.
+func (dataFrame *DataFrame) GetMessageTexts(user *User) ([]MessageText, error) {
+	// photos are only supported by telegram
+	messages, err := dataFrame.getMessagesInternal(user, 0, DetailModeText)
+	if err != nil {
+		return nil, err
+	}
+
+	texts := make([]MessageText, 0, len(messages))
+	for _, message := range messages {
+		if config, ok := message.(tgbotapi.MessageConfig); ok {
+			texts = append(texts, MessageText{Text: config.Text, Markdown: config.ParseMode == "Markdown"})
+		}
+	}
+
+	return texts, nil
+}
+
+// NtfyNotifier publishes every message to a ntfy topic.
+type NtfyNotifier struct {
+	TopicUrl string
+	Token    string
+}
+
+func (notifier *NtfyNotifier) Name() string {
+	return NotifierNtfy
+}
+
+func (notifier *NtfyNotifier) Notify(ctx context.Context, user *User, frame *DataFrame) error {
+	texts, err := frame.GetMessageTexts(user)
+	if err != nil {
+		return err
+	}
+
+	return notifier.send(ctx, texts)
+}
+
+func (notifier *NtfyNotifier) Alert(ctx context.Context, user *User, text MessageText) error {
+	return notifier.send(ctx, []MessageText{text})
+}
+
+func (notifier *NtfyNotifier) send(ctx context.Context, texts []MessageText) error {
+	for _, text := range texts {
+		headers := map[string]string{"Title": pushTitle}
+		body := text.Text
+		if text.Markdown {
+			headers["Markdown"] = "yes"
+			body = MarkdownToCommonMark(text.Text)
+		}
+		if notifier.Token != "" {
+			headers["Authorization"] = "Bearer " + notifier.Token
+		}
+
+		err := sendPushRequest(ctx, http.MethodPost, notifier.TopicUrl, headers, []byte(body))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GotifyNotifier sends every message to a gotify server using an application
+// token.
+type GotifyNotifier struct {
+	ServerUrl string
+	Token     string
+}
+
+type gotifyMessage struct {
+	Title    string                 `json:"title"`
+	Message  string                 `json:"message"`
+	Priority int                    `json:"priority"`
+	Extras   map[string]interface{} `json:"extras,omitempty"`
+}
+
+func (notifier *GotifyNotifier) Name() string {
+	return NotifierGotify
+}
+
+func (notifier *GotifyNotifier) Notify(ctx context.Context, user *User, frame *DataFrame) error {
+	texts, err := frame.GetMessageTexts(user)
+	if err != nil {
+		return err
+	}
+
+	return notifier.send(ctx, texts)
+}
+
+func (notifier *GotifyNotifier) Alert(ctx context.Context, user *User, text MessageText) error {
+	return notifier.send(ctx, []MessageText{text})
+}
+
+func (notifier *GotifyNotifier) send(ctx context.Context, texts []MessageText) error {
+	for _, text := range texts {
+		message := &gotifyMessage{Title: pushTitle, Message: text.Text, Priority: 5}
+		if text.Markdown {
+			message.Message = MarkdownToCommonMark(text.Text)
+			message.Extras = map[string]interface{}{
+				"client::display": map[string]string{"contentType": "text/markdown"},
+			}
+		}
+
+		body, err := json.Marshal(message)
+		if err != nil {
+			return err
+		}
+
+		err = sendPushRequest(ctx, http.MethodPost, strings.TrimSuffix(notifier.ServerUrl, "/")+"/message", map[string]string{
+			"Content-Type": "application/json",
+			"X-Gotify-Key": notifier.Token,
+		}, body)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MatrixNotifier posts every message into a matrix room using the client
+// server api.
+type MatrixNotifier struct {
+	HomeserverUrl string
+	RoomId        string
+	AccessToken   string
+}
+
+type matrixMessage struct {
+	MsgType       string `json:"msgtype"`
+	Body          string `json:"body"`
+	Format        string `json:"format,omitempty"`
+	FormattedBody string `json:"formatted_body,omitempty"`
+}
+
+func (notifier *MatrixNotifier) Name() string {
+	return NotifierMatrix
+}
+
+func (notifier *MatrixNotifier) Notify(ctx context.Context, user *User, frame *DataFrame) error {
+	texts, err := frame.GetMessageTexts(user)
+	if err != nil {
+		return err
+	}
+
+	return notifier.send(ctx, texts)
+}
+
+func (notifier *MatrixNotifier) Alert(ctx context.Context, user *User, text MessageText) error {
+	return notifier.send(ctx, []MessageText{text})
+}
+
+func (notifier *MatrixNotifier) send(ctx context.Context, texts []MessageText) error {
+	for i, text := range texts {
+		message := &matrixMessage{MsgType: "m.text", Body: text.Text}
+		if text.Markdown {
+			message.Format = "org.matrix.custom.html"
+			message.FormattedBody = MarkdownToHtml(text.Text)
+		}
+
+		body, err := json.Marshal(message)
+		if err != nil {
+			return err
+		}
+
+		transactionId := fmt.Sprintf("lpbot-%d-%d", time.Now().UnixNano(), i)
+		requestUrl := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/m.room.message/%s",
+			strings.TrimSuffix(notifier.HomeserverUrl, "/"), url.PathEscape(notifier.RoomId), transactionId)
+
+		err = sendPushRequest(ctx, http.MethodPut, requestUrl, map[string]string{
+			"Content-Type":  "application/json",
+			"Authorization": "Bearer " + notifier.AccessToken,
+		}, body)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func sendPushRequest(ctx context.Context, method string, url string, headers map[string]string, body []byte) error {
+	request, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for key, value := range headers {
+		request.Header.Set(key, value)
+	}
+
+	response, err := pushClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		// the body is only logged, it is not meant to be shown to users
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 512))
+		log.Printf("Push request to %s failed with %s: %s", request.URL.Host, response.Status, strings.TrimSpace(string(message)))
+		return fmt.Errorf("%s responded with %s", request.URL.Host, response.Status)
+	}
+
+	return nil
+}
+
+// validatePushUrl only accepts absolute http(s) urls of public addresses, see
+// ErrPrivateAddress.
+func validatePushUrl(pushUrl string) error {
+	parsed, err := url.Parse(pushUrl)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return ErrInvalidPushUrl
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return validateOutboundUrl(ctx, parsed)
+}
+
+// SetNtfy switches the notifications of the user to a ntfy topic, the token
+// is optional.
+func (user *User) SetNtfy(topicUrl string, token string) error {
+	if err := validatePushUrl(topicUrl); err != nil {
+		return err
+	}
+
+	user.Notifier = NotifierNtfy
+	user.NtfyTopicUrl = topicUrl
+	user.NtfyToken = token
+	return nil
+}
+
+// SetGotify switches the notifications of the user to a gotify server.
+func (user *User) SetGotify(serverUrl string, token string) error {
+	if err := validatePushUrl(serverUrl); err != nil {
+		return err
+	}
+	if token == "" {
+		return ErrMissingPushToken
+	}
+
+	user.Notifier = NotifierGotify
+	user.GotifyServerUrl = serverUrl
+	user.GotifyToken = token
+	return nil
+}
+
+// SetMatrix switches the notifications of the user to a matrix room.
+func (user *User) SetMatrix(homeserverUrl string, roomId string, accessToken string) error {
+	if err := validatePushUrl(homeserverUrl); err != nil {
+		return err
+	}
+	if !strings.HasPrefix(roomId, "!") || !strings.Contains(roomId, ":") {
+		return ErrInvalidRoomId
+	}
+	if accessToken == "" {
+		return ErrMissingPushToken
+	}
+
+	user.Notifier = NotifierMatrix
+	user.MatrixHomeserverUrl = homeserverUrl
+	user.MatrixRoomId = roomId
+	user.MatrixAccessToken = accessToken
+	return nil
+}
+
+// DisablePush removes all push service settings and switches the
+// notifications of the user back to telegram if a push service was selected.
+func (user *User) DisablePush() {
+	switch user.Notifier {
+	case NotifierNtfy, NotifierGotify, NotifierMatrix:
+		user.Notifier = ""
+	}
+
+	user.NtfyTopicUrl = ""
+	user.NtfyToken = ""
+	user.GotifyServerUrl = ""
+	user.GotifyToken = ""
+	user.MatrixHomeserverUrl = ""
+	user.MatrixRoomId = ""
+	user.MatrixAccessToken = ""
+}
@@ -0,0 +1,187 @@
+package config_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/khase/leaseplan-bot/lpbot/config"
+	"github.com/khase/leaseplanabocarexporter/dto"
+)
+
+type pushRequest struct {
+	method string
+	path   string
+	header http.Header
+	body   string
+}
+
+// startPushServer records all requests and answers them with the given
+// status code.
+func startPushServer(t *testing.T, status int) (*httptest.Server, *[]pushRequest) {
+	config.AllowPrivateAddresses(t)
+	requests := &[]pushRequest{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		*requests = append(*requests, pushRequest{method: r.Method, path: r.URL.EscapedPath(), header: r.Header, body: string(body)})
+		w.WriteHeader(status)
+		w.Write([]byte("internal details"))
+	}))
+	t.Cleanup(server.Close)
+
+	return server, requests
+}
+
+// newPushTestFrame returns a frame with one new car and sets a markdown
+// detail template for it.
+func newPushTestFrame(user *config.User) *config.DataFrame {
+	user.IgnoreRemoved = true
+	user.DetailMessageTemplate = "{{ portalUrl . }} *{{ .RentalObject.PowerHP }}PS*"
+
+	return config.NewDataFrame(nil, []dto.Item{newTestCar(testCar{Ident: "a", Label: "BMW", Model: "i4", Fuel: "Elektro", HP: 340, BLP: 60000, SalaryWaiver: 600})})
+}
+
+func TestNtfyNotifier(t *testing.T) {
+	server, requests := startPushServer(t, http.StatusOK)
+	user := newTestUser(t)
+	frame := newPushTestFrame(user)
+
+	err := user.SetNtfy(server.URL+"/leaseplan", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = user.GetNotifier(nil).Notify(context.Background(), user, frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(*requests) != 2 {
+		t.Fatalf("expected summary and detail message but got %d requests", len(*requests))
+	}
+	summary, details := (*requests)[0], (*requests)[1]
+	if summary.method != http.MethodPost || summary.path != "/leaseplan" || summary.header.Get("Authorization") != "Bearer secret" || summary.header.Get("Markdown") != "" {
+		t.Fatalf("unexpected summary request %+v", summary)
+	}
+	if summary.body != "0 -> 1 (+1, -0)" {
+		t.Fatalf("unexpected summary %q", summary.body)
+	}
+	if details.header.Get("Markdown") != "yes" || !strings.Contains(details.body, "[BMW i4](https://www.leaseplan-abocar.de/offer-details/offer-a/a) **340PS**") {
+		t.Fatalf("unexpected detail request %+v", details)
+	}
+}
+
+func TestGotifyNotifier(t *testing.T) {
+	server, requests := startPushServer(t, http.StatusOK)
+	user := newTestUser(t)
+	frame := newPushTestFrame(user)
+
+	err := user.SetGotify(server.URL+"/", "apptoken")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = user.GetNotifier(nil).Notify(context.Background(), user, frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	details := (*requests)[1]
+	if details.path != "/message" || details.header.Get("X-Gotify-Key") != "apptoken" {
+		t.Fatalf("unexpected request %+v", details)
+	}
+
+	var message struct {
+		Title   string
+		Message string
+		Extras  map[string]map[string]string
+	}
+	json.Unmarshal([]byte(details.body), &message)
+	if message.Extras["client::display"]["contentType"] != "text/markdown" || !strings.Contains(message.Message, "**340PS**") {
+		t.Fatalf("expected markdown message but got %+v", message)
+	}
+}
+
+func TestMatrixNotifier(t *testing.T) {
+	server, requests := startPushServer(t, http.StatusOK)
+	user := newTestUser(t)
+	frame := newPushTestFrame(user)
+
+	err := user.SetMatrix(server.URL, "!room:example.com", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = user.GetNotifier(nil).Notify(context.Background(), user, frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	details := (*requests)[1]
+	if details.method != http.MethodPut || !strings.HasPrefix(details.path, "/_matrix/client/v3/rooms/%21room:example.com/send/m.room.message/") || details.header.Get("Authorization") != "Bearer token" {
+		t.Fatalf("unexpected request %+v", details)
+	}
+
+	var message map[string]string
+	json.Unmarshal([]byte(details.body), &message)
+	if message["format"] != "org.matrix.custom.html" || !strings.Contains(message["formatted_body"], `<a href="https://www.leaseplan-abocar.de/offer-details/offer-a/a">BMW i4</a> <b>340PS</b>`) {
+		t.Fatalf("expected html message but got %+v", message)
+	}
+}
+
+func TestPushNotifierErrors(t *testing.T) {
+	server, _ := startPushServer(t, http.StatusForbidden)
+	user := newTestUser(t)
+	frame := newPushTestFrame(user)
+
+	err := user.SetNtfy(server.URL+"/leaseplan", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = user.GetNotifier(nil).Notify(context.Background(), user, frame)
+	if err == nil || !strings.Contains(err.Error(), "403") || strings.Contains(err.Error(), "internal details") {
+		t.Fatalf("expected only the status to be reported but got %v", err)
+	}
+
+	if err := user.SetGotify("gotify.example.com", "token"); !errors.Is(err, config.ErrInvalidPushUrl) {
+		t.Fatalf("expected ErrInvalidPushUrl but got %v", err)
+	}
+	if err := user.SetMatrix(server.URL, "#room:example.com", "token"); !errors.Is(err, config.ErrInvalidRoomId) {
+		t.Fatalf("expected ErrInvalidRoomId but got %v", err)
+	}
+
+	user.DisablePush()
+	if user.GetNotifier(nil).Name() != config.NotifierTelegram || user.NtfyTopicUrl != "" {
+		t.Fatalf("expected telegram to be selected after disabling push")
+	}
+}
+
+func TestPushPrivateAddresses(t *testing.T) {
+	user := newTestUser(t)
+	frame := newPushTestFrame(user)
+
+	for _, url := range []string{"http://127.0.0.1/leaseplan", "http://192.168.1.10/", "http://169.254.169.254/latest", "http://[::1]:8080/"} {
+		if err := user.SetNtfy(url, ""); !errors.Is(err, config.ErrPrivateAddress) {
+			t.Fatalf("expected private address %q to be rejected but got %v", url, err)
+		}
+	}
+	if user.Notifier != "" {
+		t.Fatalf("expected notifier to stay unchanged")
+	}
+
+	// every connection is checked, e.g. after redirects or when the host
+	// resolves to another address later on
+	requests := 0
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer target.Close()
+
+	notifier := &config.NtfyNotifier{TopicUrl: target.URL + "/leaseplan"}
+	err := notifier.Notify(context.Background(), user, frame)
+	if !errors.Is(err, config.ErrPrivateAddress) || requests != 0 {
+		t.Fatalf("expected the connection to the private address to be refused but got %d requests (%v)", requests, err)
+	}
+}
@@ -0,0 +1,74 @@
+package config
+
+import (
+	"fmt"
+)
+
+// ReplayResult describes what a user would have been sent for a recorded
+// frame.
+type ReplayResult struct {
+	File     string        `json:"File"`
+	Previous int           `json:"Previous"`
+	Current  int           `json:"Current"`
+	Added    int           `json:"Added"`
+	Removed  int           `json:"Removed"`
+	Rejected []RejectedCar `json:"Rejected"`
+	Notes    []string      `json:"Notes"`
+	Messages []MessageText `json:"Messages"`
+}
+
+// RejectedCar is a new car the user was not notified about because of a
+// filter.
+type RejectedCar struct {
+	Ident  string `json:"Ident"`
+	Name   string `json:"Name"`
+	Filter string `json:"Filter"`
+}
+
+// ReplayFrame applies the filters, ranking and templates of the user to the
+// unfiltered cars of a recorded frame like an update of the watcher would.
+// Throttling and snoozed cars are not taken into account.
+func ReplayFrame(frame *DataFrame, user *User) (*ReplayResult, error) {
+	funcs := user.TemplateFuncs()
+	unfiltered := NewDataFrame(frame.Previous, frame.Current)
+	filtered := NewDataFrame(FilterUpdateList(frame.Previous, user.Filters, funcs), FilterUpdateList(frame.Current, user.Filters, funcs))
+	filtered.Timestamp = frame.Timestamp
+	filtered.ApplyRanking(user)
+
+	result := &ReplayResult{
+		Previous: len(filtered.Previous),
+		Current:  len(filtered.Current),
+		Added:    len(filtered.Added),
+		Removed:  len(filtered.Removed),
+		Rejected: []RejectedCar{},
+		Notes:    []string{},
+		Messages: []MessageText{},
+	}
+	for _, car := range unfiltered.Added {
+		if filter, rejected := RejectingFilter(car, user.Filters, funcs); rejected {
+			result.Rejected = append(result.Rejected, RejectedCar{Ident: car.RentalObject.Ident, Name: carName(car), Filter: filter})
+		}
+	}
+
+	if user.Banned {
+		result.Notes = append(result.Notes, "the user is banned")
+	}
+	if !user.WatcherActive {
+		result.Notes = append(result.Notes, "the watcher of the user is paused")
+	}
+	if user.RankTopN > 0 {
+		result.Notes = append(result.Notes, fmt.Sprintf("only new cars among the best %d by %s are reported", user.RankTopN, user.RankMetric))
+	}
+	if !filtered.HasChanges {
+		result.Notes = append(result.Notes, "no changes after applying the filters, no message would be sent")
+		return result, nil
+	}
+
+	messages, err := filtered.GetMessageTexts(user)
+	if err != nil {
+		return nil, err
+	}
+	result.Messages = messages
+
+	return result, nil
+}
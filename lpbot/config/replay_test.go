@@ -0,0 +1,42 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/khase/leaseplan-bot/lpbot/config"
+)
+
+func TestReplayFrame(t *testing.T) {
+	frame, err := config.LoadDataFrameFile("../../testdata/sorting.dataframe.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := config.NewUser(nil, 123, "replay")
+	user.WatcherActive = true
+	user.AddFilter(`ne .RentalObject.KindOfFuel "Benzin"`)
+
+	result, err := config.ReplayFrame(frame, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Previous != 3 || result.Current != 4 || result.Added != 3 || result.Removed != 2 {
+		t.Fatalf("unexpected counts %+v", result)
+	}
+	if len(result.Rejected) != 1 || result.Rejected[0].Ident != "CARE" || result.Rejected[0].Filter != `ne .RentalObject.KindOfFuel "Benzin"` {
+		t.Fatalf("expected the Seat to be rejected by the fuel filter but got %+v", result.Rejected)
+	}
+	if len(result.Messages) != 2 || result.Messages[0].Text != "3 -> 4 (+3, -2)" || len(result.Notes) != 0 {
+		t.Fatalf("expected the summary and detail message but got %+v, notes %v", result.Messages, result.Notes)
+	}
+
+	user.WatcherActive = false
+	user.AddFilter(`eq .RentalObject.KindOfFuel "Wasserstoff"`)
+	result, err = config.ReplayFrame(frame, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Messages) != 0 || len(result.Notes) != 2 || len(result.Rejected) != 4 {
+		t.Fatalf("expected no messages and an explanation but got %+v", result)
+	}
+}
@@ -0,0 +1,186 @@
+package config
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/khase/leaseplan-bot/lpbot/tgcon"
+	"github.com/khase/leaseplanabocarexporter/dto"
+)
+
+const (
+	DetailModeText = "text"
+	DetailModeRich = "rich"
+
+	portalBaseUrl = "https://www.leaseplan-abocar.de"
+
+	// limits of the telegram bot api
+	maxCaptionLength  = 1024
+	maxMediaGroupSize = 10
+
+	maxButtonLabelLength = 24
+)
+
+type richCar struct {
+	car      dto.Item
+	imageUrl string
+	caption  string
+}
+
+// getRichDetailMessages sends every added car with an image as photo. Photos
+// are batched into albums, which can not carry buttons, so the portal links
+// of an album follow as inline buttons in a separate message. Cars without
+// image and removed cars are sent as text.
+func getRichDetailMessages(user *User, added []dto.Item, removed []dto.Item) ([]tgbotapi.Chattable, error) {
+	richCars := []richCar{}
+	textCars := []dto.Item{}
+	for _, car := range added {
+		imageUrl := carImageUrl(car)
+		if imageUrl == "" {
+			textCars = append(textCars, car)
+			continue
+		}
+
+		caption, err := getCarDetails(&car, user.DetailMessageTemplate, user.TemplateFuncs())
+		if err != nil {
+			return nil, err
+		}
+		if utf8.RuneCountInString(caption) > maxCaptionLength {
+			textCars = append(textCars, car)
+			continue
+		}
+		richCars = append(richCars, richCar{car: car, imageUrl: imageUrl, caption: caption})
+	}
+
+	messages := []tgbotapi.Chattable{}
+	for start := 0; start < len(richCars); start += maxMediaGroupSize {
+		end := start + maxMediaGroupSize
+		if end > len(richCars) {
+			end = len(richCars)
+		}
+		messages = append(messages, getPhotoMessages(user, richCars[start:end])...)
+	}
+
+	addedTexts, err := getCarsDetailsTexts(textCars, user.DetailMessageTemplate, user.TemplateFuncs())
+	if err != nil {
+		return nil, err
+	}
+	removedTexts, err := getCarsDetailsTexts(removed, user.DetailMessageTemplate, user.TemplateFuncs())
+	if err != nil {
+		return nil, err
+	}
+
+	return append(messages, getTextDetailMessages(user, addedTexts, getCarsActionButtons(textCars, user), removedTexts)...), nil
+}
+
+func getPhotoMessages(user *User, cars []richCar) []tgbotapi.Chattable {
+	chatId := user.UserId
+	if len(cars) == 1 {
+		photo := tgbotapi.NewPhoto(chatId, tgbotapi.FileURL(cars[0].imageUrl))
+		photo.Caption = cars[0].caption
+		photo.ParseMode = "Markdown"
+		photo.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonURL("Zum Angebot", offerUrl(cars[0].car))),
+			getCarActionButtons(cars[0].car, user),
+		)
+
+		return []tgbotapi.Chattable{photo}
+	}
+
+	media := []interface{}{}
+	rows := [][]tgbotapi.InlineKeyboardButton{}
+	for _, car := range cars {
+		photo := tgbotapi.NewInputMediaPhoto(tgbotapi.FileURL(car.imageUrl))
+		photo.Caption = car.caption
+		photo.ParseMode = "Markdown"
+		media = append(media, photo)
+		rows = append(rows,
+			tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonURL(getCarButtonLabel(car.car), offerUrl(car.car))),
+			getCarActionButtons(car.car, user),
+		)
+	}
+
+	links := tgbotapi.NewMessage(chatId, "Zu den Angeboten:")
+	links.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+
+	return []tgbotapi.Chattable{tgbotapi.NewMediaGroup(chatId, media), links}
+}
+
+// getPhotoFallbackMessages lists the cars of a photo or album of
+// getPhotoMessages as text, for when telegram can not fetch the images. Other
+// messages have no fallback.
+func getPhotoFallbackMessages(user *User, message tgbotapi.Chattable) []tgbotapi.Chattable {
+	switch photo := message.(type) {
+	case tgbotapi.PhotoConfig:
+		messages := getTextDetailMessages(user, []string{photo.Caption}, [][]tgbotapi.InlineKeyboardButton{nil}, nil)
+		// keeps the link to the offer and the action buttons
+		if text, ok := messages[0].(tgbotapi.MessageConfig); ok {
+			text.ReplyMarkup = photo.ReplyMarkup
+			messages[0] = text
+		}
+		return messages
+	case tgbotapi.MediaGroupConfig:
+		// the buttons follow in a message of their own
+		captions := []string{}
+		for _, media := range photo.Media {
+			if mediaPhoto, ok := media.(tgbotapi.InputMediaPhoto); ok {
+				captions = append(captions, mediaPhoto.Caption)
+			}
+		}
+		if len(captions) == 0 {
+			return nil
+		}
+		return getTextDetailMessages(user, captions, make([][]tgbotapi.InlineKeyboardButton, len(captions)), nil)
+	}
+
+	return nil
+}
+
+// carImageUrl returns the first image of the offer that telegram can fetch.
+func carImageUrl(car dto.Item) string {
+	for _, link := range car.ImageLinks {
+		if strings.HasPrefix(link, "https://") || strings.HasPrefix(link, "http://") {
+			return link
+		}
+		if strings.HasPrefix(link, "/") {
+			return portalBaseUrl + link
+		}
+	}
+
+	return ""
+}
+
+func getCarButtonLabel(car dto.Item) string {
+	label := []rune(carName(car))
+	if len(label) > maxButtonLabelLength {
+		return string(label[:maxButtonLabelLength-1]) + "…"
+	}
+
+	return string(label)
+}
+
+// getCarActionButtons returns the buttons to watch, ignore or snooze a car.
+// They are handled by the callback with the prefix CarActionCallbackPrefix.
+func getCarActionButtons(car dto.Item, user *User) []tgbotapi.InlineKeyboardButton {
+	ident := car.RentalObject.Ident
+	watchLabel := "🔔 " + getCarButtonLabel(car)
+	if user.IsWatching(ident) {
+		watchLabel = "🔕 " + getCarButtonLabel(car)
+	}
+
+	return tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(watchLabel, tgcon.NewCallbackData(CarActionCallbackPrefix, CarActionWatch+":"+ident)),
+		tgbotapi.NewInlineKeyboardButtonData("🙈 Modell", tgcon.NewCallbackData(CarActionCallbackPrefix, CarActionIgnore+":"+ident)),
+		tgbotapi.NewInlineKeyboardButtonData("💤 24h", tgcon.NewCallbackData(CarActionCallbackPrefix, CarActionSnooze+":"+ident)),
+	)
+}
+
+func getCarsActionButtons(cars []dto.Item, user *User) [][]tgbotapi.InlineKeyboardButton {
+	buttons := make([][]tgbotapi.InlineKeyboardButton, 0, len(cars))
+	for _, car := range cars {
+		buttons = append(buttons, getCarActionButtons(car, user))
+	}
+
+	return buttons
+}
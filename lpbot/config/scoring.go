@@ -0,0 +1,298 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/khase/leaseplanabocarexporter/dto"
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	MetricHpCost   = "hpcost"
+	MetricBgvRatio = "bgvratio"
+	MetricDiscount = "discount"
+
+	// maxModelHistorySize limits the remembered salary waivers per model
+	maxModelHistorySize = 200
+)
+
+var (
+	ErrUnknownMetric = errors.New("unknown metric")
+
+	// Metrics lists all metrics a user can rank cars by. Lower values are
+	// better for all of them.
+	Metrics = []string{MetricHpCost, MetricBgvRatio, MetricDiscount}
+
+	modelHistory     = &ModelHistory{Levels: map[string]map[string][]ModelPrice{}}
+	modelHistoryOnce sync.Once
+)
+
+// ModelPrice is a salary waiver seen for a car.
+type ModelPrice struct {
+	Ident        string    `yaml:"Ident"`
+	SalaryWaiver int64     `yaml:"SalaryWaiver"`
+	Seen         time.Time `yaml:"Seen"`
+}
+
+// ModelHistory remembers the salary waivers of the last cars of each model
+// per leaseplan level, as salary waivers differ between levels.
+type ModelHistory struct {
+	lock sync.Mutex
+
+	Levels map[string]map[string][]ModelPrice `yaml:"Levels"`
+}
+
+func getModelHistoryPath() string {
+	return fmt.Sprintf("%s/modelhistory.yaml", cacheBasePath)
+}
+
+func getModelHistory() *ModelHistory {
+	modelHistoryOnce.Do(func() {
+		data, err := os.ReadFile(getModelHistoryPath())
+		if err != nil {
+			return
+		}
+		err = yaml.Unmarshal(data, modelHistory)
+		if err != nil {
+			log.Printf("Failed loading model history: %s", err)
+		}
+		if modelHistory.Levels == nil {
+			modelHistory.Levels = map[string]map[string][]ModelPrice{}
+		}
+	})
+
+	return modelHistory
+}
+
+// RecordModelHistory adds all cars visible on a level to the model history
+// and saves it.
+func RecordModelHistory(levelKey string, cars []dto.Item) {
+	history := getModelHistory()
+	history.lock.Lock()
+	defer history.lock.Unlock()
+
+	models := history.Levels[levelKey]
+	if models == nil {
+		models = map[string][]ModelPrice{}
+		history.Levels[levelKey] = models
+	}
+
+	for _, car := range cars {
+		model := CarModel(car)
+		prices := models[model]
+		known := false
+		for i := range prices {
+			if prices[i].Ident == car.RentalObject.Ident {
+				prices[i].SalaryWaiver = car.SalaryWaiver
+				prices[i].Seen = time.Now()
+				known = true
+				break
+			}
+		}
+		if !known {
+			prices = append(prices, ModelPrice{Ident: car.RentalObject.Ident, SalaryWaiver: car.SalaryWaiver, Seen: time.Now()})
+		}
+		if len(prices) > maxModelHistorySize {
+			prices = prices[len(prices)-maxModelHistorySize:]
+		}
+		models[model] = prices
+	}
+
+	data, err := yaml.Marshal(history)
+	if err == nil {
+		os.MkdirAll(cacheBasePath, os.ModePerm)
+		err = os.WriteFile(getModelHistoryPath(), data, 0600)
+	}
+	if err != nil {
+		log.Printf("Failed saving model history: %s", err)
+	}
+}
+
+// averageSalaryWaiver returns the mean salary waiver of all other cars of the
+// same model seen on the level.
+func (history *ModelHistory) averageSalaryWaiver(levelKey string, car dto.Item) (float64, bool) {
+	history.lock.Lock()
+	defer history.lock.Unlock()
+
+	sum, count := 0.0, 0
+	for _, price := range history.Levels[levelKey][CarModel(car)] {
+		if price.Ident == car.RentalObject.Ident {
+			continue
+		}
+		sum += float64(price.SalaryWaiver)
+		count++
+	}
+	if count == 0 {
+		return 0, false
+	}
+
+	return sum / float64(count), true
+}
+
+// HpCost returns the approximate monthly net cost per horse power, 0 for cars
+// without horse power.
+func HpCost(car dto.Item) float64 {
+	cost, _ := hpCost(car)
+	return cost
+}
+
+func hpCost(car dto.Item) (float64, bool) {
+	if car.RentalObject.PowerHP <= 0 {
+		return 0, false
+	}
+
+	return NetCost(car) / float64(car.RentalObject.PowerHP), true
+}
+
+// BgvRatio returns the salary waiver in percent of the gross list price, 0
+// for cars without list price.
+func BgvRatio(car dto.Item) float64 {
+	ratio, _ := bgvRatio(car)
+	return ratio
+}
+
+func bgvRatio(car dto.Item) (float64, bool) {
+	if car.RentalObject.PriceProducer1 <= 0 {
+		return 0, false
+	}
+
+	return float64(car.SalaryWaiver) / car.RentalObject.PriceProducer1 * 100, true
+}
+
+// Discount returns how many percent the salary waiver of the car is below the
+// average of the same model seen on the level. Cheaper cars have a positive
+// discount, cars without history 0.
+func Discount(levelKey string, car dto.Item) float64 {
+	average, exists := getModelHistory().averageSalaryWaiver(levelKey, car)
+	if !exists || average == 0 {
+		return 0
+	}
+
+	return (average - float64(car.SalaryWaiver)) / average * 100
+}
+
+// noDiscount is used for discount in templates rendered without a user, it
+// is replaced by TemplateFuncs.
+func noDiscount(car dto.Item) float64 {
+	return 0
+}
+
+// TemplateFuncs returns the template functions that depend on the user, like
+// discount which uses the model history of his level.
+func (user *User) TemplateFuncs() template.FuncMap {
+	levelKey := user.LeaseplanLevelKey
+	return template.FuncMap{
+		"discount": func(car dto.Item) float64 {
+			return Discount(levelKey, car)
+		},
+	}
+}
+
+// Score returns the value of the metric for the car, lower is better. Cars
+// missing the data of the metric score +Inf, so they are ranked last.
+func (user *User) Score(metric string, car dto.Item) (float64, error) {
+	switch metric {
+	case MetricHpCost:
+		return knownScore(hpCost(car)), nil
+	case MetricBgvRatio:
+		return knownScore(bgvRatio(car)), nil
+	case MetricDiscount:
+		return -Discount(user.LeaseplanLevelKey, car), nil
+	}
+
+	return 0, fmt.Errorf("%w: %s", ErrUnknownMetric, metric)
+}
+
+func knownScore(score float64, known bool) float64 {
+	if !known {
+		return math.Inf(1)
+	}
+
+	return score
+}
+
+// RankCars sorts the cars by the metric, best first. Cars with the same score
+// are ordered by their ident.
+func (user *User) RankCars(cars []dto.Item, metric string) error {
+	scores := make(map[string]float64, len(cars))
+	for _, car := range cars {
+		score, err := user.Score(metric, car)
+		if err != nil {
+			return err
+		}
+		scores[car.RentalObject.Ident] = score
+	}
+
+	sort.SliceStable(cars, func(i, j int) bool {
+		a, b := scores[cars[i].RentalObject.Ident], scores[cars[j].RentalObject.Ident]
+		if a == b {
+			return cars[i].RentalObject.Ident < cars[j].RentalObject.Ident
+		}
+		return a < b
+	})
+
+	return nil
+}
+
+// SetRanking selects the metric new cars are ranked by. With topN > 0 only
+// cars among the best topN of all current cars are reported.
+func (user *User) SetRanking(metric string, topN int) error {
+	metric = strings.ToLower(metric)
+	if metric != "" && !slices.Contains(Metrics, metric) {
+		return fmt.Errorf("%w: %s", ErrUnknownMetric, metric)
+	}
+	if topN < 0 || (metric == "" && topN > 0) {
+		return fmt.Errorf("%w: top %d", ErrUnknownMetric, topN)
+	}
+
+	user.RankMetric = metric
+	user.RankTopN = topN
+
+	return nil
+}
+
+// ApplyRanking orders the changes of the frame by the metric of the user and
+// drops cars outside of his top N.
+func (dataFrame *DataFrame) ApplyRanking(user *User) {
+	if user.RankMetric == "" {
+		return
+	}
+
+	user.RankCars(dataFrame.Added, user.RankMetric)
+	user.RankCars(dataFrame.Removed, user.RankMetric)
+	if user.RankTopN <= 0 {
+		return
+	}
+
+	dataFrame.Added = keepTopCars(user, dataFrame.Added, dataFrame.Current)
+	dataFrame.Removed = keepTopCars(user, dataFrame.Removed, dataFrame.Previous)
+	dataFrame.HasChanges = len(dataFrame.Added) > 0 || len(dataFrame.Removed) > 0
+}
+
+// keepTopCars keeps the cars that are among the top N of all cars.
+func keepTopCars(user *User, cars []dto.Item, all []dto.Item) []dto.Item {
+	ranked := append([]dto.Item{}, all...)
+	user.RankCars(ranked, user.RankMetric)
+	if len(ranked) > user.RankTopN {
+		ranked = ranked[:user.RankTopN]
+	}
+
+	top := make(map[string]bool, len(ranked))
+	for _, car := range ranked {
+		top[car.RentalObject.Ident] = true
+	}
+
+	return removeCars(cars, func(car dto.Item) bool {
+		return !top[car.RentalObject.Ident]
+	})
+}
@@ -0,0 +1,129 @@
+package config_test
+
+import (
+	"math"
+	"os"
+	"testing"
+
+	"github.com/khase/leaseplan-bot/lpbot/config"
+	"github.com/khase/leaseplanabocarexporter/dto"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// useTempCache runs the test in a temporary directory, so the model history
+// is not written into the source tree.
+func useTempCache(t *testing.T) {
+	wd, _ := os.Getwd()
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestScores(t *testing.T) {
+	car := newTestCar(testCar{Ident: "1", Label: "BMW", Model: "320d", Fuel: "Diesel", HP: 200, BLP: 50000, SalaryWaiver: 400})
+
+	if hpCost := config.HpCost(car); math.Abs(hpCost-(config.NetCost(car)/200)) > 0.0001 {
+		t.Fatalf("expected net cost per hp but got %f", hpCost)
+	}
+	if ratio := config.BgvRatio(car); ratio != 0.8 {
+		t.Fatalf("expected a bgv ratio of 0.8%% but got %f", ratio)
+	}
+	if config.HpCost(dto.Item{}) != 0 || config.BgvRatio(dto.Item{}) != 0 {
+		t.Fatalf("expected templates to show 0 for cars without hp or blp")
+	}
+}
+
+func TestRankUnknownScores(t *testing.T) {
+	unknownHp := newTestCar(testCar{Ident: "a", Label: "BMW", Model: "320d", HP: 0, BLP: 50000, SalaryWaiver: 300})
+	unknownBlp := newTestCar(testCar{Ident: "b", Label: "BMW", Model: "330e", HP: 290, BLP: 0, SalaryWaiver: 300})
+	cars := []dto.Item{unknownHp, unknownBlp, newTestCar(testCar{Ident: "c", Label: "BMW", Model: "M340i", HP: 374, BLP: 70000, SalaryWaiver: 700})}
+	user := &config.User{}
+
+	if score, _ := user.Score(config.MetricHpCost, unknownHp); !math.IsInf(score, 1) {
+		t.Fatalf("expected a car without hp to score +Inf but got %f", score)
+	}
+	if err := user.RankCars(cars, config.MetricHpCost); err != nil {
+		t.Fatal(err)
+	}
+	if cars[2].RentalObject.Ident != "a" {
+		t.Fatalf("expected the car without hp to be ranked last but got %+v", cars)
+	}
+	if err := user.RankCars(cars, config.MetricBgvRatio); err != nil {
+		t.Fatal(err)
+	}
+	if cars[2].RentalObject.Ident != "b" {
+		t.Fatalf("expected the car without blp to be ranked last but got %+v", cars)
+	}
+
+	// unknown cars are not among the top N of cars with known scores
+	if err := user.SetRanking(config.MetricHpCost, 2); err != nil {
+		t.Fatal(err)
+	}
+	frame := config.NewDataFrame([]dto.Item{}, cars)
+	frame.ApplyRanking(user)
+	if len(frame.Added) != 2 || frame.Added[0].RentalObject.Ident != "b" || frame.Added[1].RentalObject.Ident != "c" {
+		t.Fatalf("expected only the cars with hp to be in the top 2 but got %+v", frame.Added)
+	}
+}
+
+func TestDiscount(t *testing.T) {
+	useTempCache(t)
+
+	cheap := newTestCar(testCar{Ident: "3", Label: "BMW", Model: "320d", Fuel: "Diesel", HP: 190, BLP: 50000, SalaryWaiver: 300})
+	config.RecordModelHistory("Level 1", []dto.Item{
+		newTestCar(testCar{Ident: "1", Label: "BMW", Model: "320d", Fuel: "Diesel", HP: 190, BLP: 50000, SalaryWaiver: 400}),
+		newTestCar(testCar{Ident: "2", Label: "BMW", Model: "320d", Fuel: "Diesel", HP: 190, BLP: 50000, SalaryWaiver: 500}),
+		cheap,
+	})
+
+	if discount := config.Discount("Level 1", cheap); math.Abs(discount-100.0/3) > 0.0001 {
+		t.Fatalf("expected a discount against the other cars of the model but got %f", discount)
+	}
+	if discount := config.Discount("Level 2", cheap); discount != 0 {
+		t.Fatalf("expected no discount without history of the level but got %f", discount)
+	}
+
+	user := &config.User{LeaseplanLevelKey: "Level 1", DetailMessageTemplate: "{{ round (discount .) 1 }}"}
+	messages, err := config.GetSearchMessages(user, "", []dto.Item{cheap})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := messages[0].(tgbotapi.MessageConfig).Text; text != "\n33.3\n" {
+		t.Fatalf("expected templates to use the discount of the level of the user but got %q", text)
+	}
+}
+
+func TestApplyRanking(t *testing.T) {
+	cars := []dto.Item{
+		newTestCar(testCar{Ident: "a", Label: "BMW", Model: "320d", Fuel: "Diesel", HP: 190, BLP: 50000, SalaryWaiver: 500}),
+		newTestCar(testCar{Ident: "b", Label: "BMW", Model: "330e", Fuel: "Diesel", HP: 290, BLP: 50000, SalaryWaiver: 400}),
+		newTestCar(testCar{Ident: "c", Label: "BMW", Model: "M340i", Fuel: "Diesel", HP: 374, BLP: 70000, SalaryWaiver: 700}),
+		newTestCar(testCar{Ident: "d", Label: "BMW", Model: "318d", Fuel: "Diesel", HP: 150, BLP: 40000, SalaryWaiver: 450}),
+	}
+	user := &config.User{}
+	if err := user.SetRanking("bgvratio", 2); err != nil {
+		t.Fatal(err)
+	}
+
+	frame := config.NewDataFrame(cars[:1], cars)
+	frame.ApplyRanking(user)
+	if len(frame.Added) != 1 || frame.Added[0].RentalObject.Ident != "b" || !frame.HasChanges {
+		t.Fatalf("expected only car b to be in the top 2 but got %+v", frame.Added)
+	}
+
+	user.SetRanking("bgvratio", 0)
+	frame = config.NewDataFrame(cars[:1], cars)
+	frame.ApplyRanking(user)
+	if len(frame.Added) != 3 || frame.Added[0].RentalObject.Ident != "b" || frame.Added[1].RentalObject.Ident != "c" || frame.Added[2].RentalObject.Ident != "d" {
+		t.Fatalf("expected cars to be ranked by bgv ratio but got %+v", frame.Added)
+	}
+
+	if err := user.SetRanking("price", 0); err == nil {
+		t.Fatalf("expected unknown metrics to be rejected")
+	}
+	if err := user.SetRanking("", 3); err == nil {
+		t.Fatalf("expected a top N without metric to be rejected")
+	}
+}
@@ -0,0 +1,341 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/khase/leaseplanabocarexporter/dto"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	MaxSavedSearches = 10
+	// MaxRecentSearches is the number of queries kept for the page buttons
+	// of /search results
+	MaxRecentSearches = 20
+)
+
+var (
+	ErrInvalidSearchQuery = errors.New("invalid search query")
+	ErrSearchExists       = errors.New("a search with this name already exists")
+	ErrSearchNotFound     = errors.New("search not found")
+	ErrTooManySearches    = errors.New("too many saved searches")
+
+	searchComparisonPattern = regexp.MustCompile(`^([a-z]+)(>=|<=|>|<|=)([0-9]+(?:[.,][0-9]+)?)$`)
+	searchComparisons       = map[string]string{
+		">=": "ge",
+		"<=": "le",
+		">":  "gt",
+		"<":  "lt",
+		"=":  "eq",
+	}
+	searchFields = map[string]string{
+		"hp":      ".RentalObject.PowerHP",
+		"ps":      ".RentalObject.PowerHP",
+		"blp":     ".RentalObject.PriceProducer1",
+		"bgv":     ".SalaryWaiver",
+		"netcost": "netCost .",
+		"netto":   "netCost .",
+		"tax":     "taxPrice .",
+	}
+)
+
+// SearchQuery is a parsed /search query. Words have to occur in the search
+// text of a car, comparisons like "hp>300" limit numeric fields and
+// "sort:netcost" orders the result.
+type SearchQuery struct {
+	Query   string
+	Filters []string
+	Order   string
+}
+
+// ParseSearchQuery translates a query like "bmw elektro hp>300 sort:netcost"
+// into filters, which are evaluated like the filters of a user.
+func ParseSearchQuery(query string) (*SearchQuery, error) {
+	search := &SearchQuery{Query: strings.Join(strings.Fields(query), " "), Filters: []string{}}
+	for _, token := range strings.Fields(strings.ToLower(query)) {
+		if strings.HasPrefix(token, "sort:") {
+			order := strings.TrimPrefix(token, "sort:")
+			if _, _, err := ParseSortOrder(order); err != nil {
+				return nil, fmt.Errorf("%w: %s", ErrInvalidSearchQuery, err)
+			}
+			search.Order = order
+			continue
+		}
+
+		if match := searchComparisonPattern.FindStringSubmatch(token); match != nil {
+			field, exists := searchFields[match[1]]
+			if !exists {
+				return nil, fmt.Errorf("%w: unknown field %s", ErrInvalidSearchQuery, match[1])
+			}
+			value := strings.Replace(match[3], ",", ".", 1)
+			search.Filters = append(search.Filters, fmt.Sprintf("%s (float64 (%s)) (float64 %s)", searchComparisons[match[2]], field, value))
+			continue
+		}
+
+		if strings.ContainsAny(token, "<>=:\"") {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidSearchQuery, token)
+		}
+		search.Filters = append(search.Filters, fmt.Sprintf("contains %s (searchText .)", strconv.Quote(token)))
+	}
+
+	if len(search.Filters) == 0 && search.Order == "" {
+		return nil, fmt.Errorf("%w: empty query", ErrInvalidSearchQuery)
+	}
+
+	return search, nil
+}
+
+// Find returns all matching cars, sorted by the order of the query or by
+// model.
+func (search *SearchQuery) Find(cars []dto.Item) []dto.Item {
+	result := FilterUpdateList(cars, search.Filters)
+
+	order := search.Order
+	if order == "" {
+		order = SortByModel
+	}
+	SortCars(result, order)
+
+	return result
+}
+
+// SearchText returns the lower case texts a search query is matched against.
+func SearchText(car dto.Item) string {
+	texts := []string{car.OfferTypeName, string(car.RentalObject.CarLabel), car.RentalObject.CarModell, string(car.RentalObject.KindOfFuel)}
+	if car.RentalObject.CarModellspec != nil {
+		texts = append(texts, *car.RentalObject.CarModellspec)
+	}
+
+	return strings.ToLower(strings.Join(texts, " "))
+}
+
+// SavedSearch is a named search query. The user is notified about cars that
+// newly match it.
+type SavedSearch struct {
+	Name    string   `yaml:"Name"`
+	Query   string   `yaml:"Query"`
+	Matches []string `yaml:"Matches,omitempty"`
+}
+
+// SaveSearch stores a query under the given name. All cars currently matching
+// are remembered, so only cars added later are reported.
+func (user *User) SaveSearch(name string, query string, cars []dto.Item) (*SavedSearch, error) {
+	search, err := ParseSearchQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	matches := getIdents(search.Find(cars))
+
+	user.mutex().Lock()
+	defer user.mutex().Unlock()
+
+	if user.getSavedSearch(name) != nil {
+		return nil, ErrSearchExists
+	}
+	if len(user.SavedSearches) >= MaxSavedSearches {
+		return nil, ErrTooManySearches
+	}
+
+	saved := &SavedSearch{Name: name, Query: search.Query, Matches: matches}
+	user.SavedSearches = append(user.SavedSearches, saved)
+	copied := *saved
+
+	return &copied, nil
+}
+
+// GetSavedSearch returns a copy of the saved search with the given name.
+func (user *User) GetSavedSearch(name string) *SavedSearch {
+	user.mutex().Lock()
+	defer user.mutex().Unlock()
+
+	saved := user.getSavedSearch(name)
+	if saved == nil {
+		return nil
+	}
+	copied := *saved
+
+	return &copied
+}
+
+func (user *User) getSavedSearch(name string) *SavedSearch {
+	for _, saved := range user.SavedSearches {
+		if strings.EqualFold(saved.Name, name) {
+			return saved
+		}
+	}
+
+	return nil
+}
+
+// GetSavedSearches returns copies of all saved searches.
+func (user *User) GetSavedSearches() []*SavedSearch {
+	user.mutex().Lock()
+	defer user.mutex().Unlock()
+
+	return copySavedSearches(user.SavedSearches)
+}
+
+func copySavedSearches(savedSearches []*SavedSearch) []*SavedSearch {
+	if savedSearches == nil {
+		return nil
+	}
+
+	copies := make([]*SavedSearch, 0, len(savedSearches))
+	for _, saved := range savedSearches {
+		copied := *saved
+		copies = append(copies, &copied)
+	}
+
+	return copies
+}
+
+func (user *User) DeleteSavedSearch(name string) error {
+	user.mutex().Lock()
+	defer user.mutex().Unlock()
+
+	index := slices.IndexFunc(user.SavedSearches, func(saved *SavedSearch) bool { return strings.EqualFold(saved.Name, name) })
+	if index < 0 {
+		return ErrSearchNotFound
+	}
+	user.SavedSearches = slices.Delete(user.SavedSearches, index, index+1)
+
+	return nil
+}
+
+// CheckSavedSearches runs all saved searches against the cars visible to the
+// user and returns the cars that newly match, keyed by the search name. It
+// reports whether the remembered matches changed.
+func (user *User) CheckSavedSearches(update []dto.Item) (map[string][]dto.Item, bool) {
+	user.mutex().Lock()
+	defer user.mutex().Unlock()
+
+	newMatches := map[string][]dto.Item{}
+	changed := false
+	for _, saved := range user.SavedSearches {
+		search, err := ParseSearchQuery(saved.Query)
+		if err != nil {
+			continue
+		}
+
+		matches := search.Find(update)
+		for _, car := range matches {
+			if !slices.Contains(saved.Matches, car.RentalObject.Ident) {
+				newMatches[saved.Name] = append(newMatches[saved.Name], car)
+			}
+		}
+		idents := getIdents(matches)
+		if !slices.Equal(saved.Matches, idents) {
+			saved.Matches = idents
+			changed = true
+		}
+	}
+
+	return newMatches, changed
+}
+
+func (user *User) sendSearchAlerts(update []dto.Item, bot *tgbotapi.BotAPI) {
+	savedSearches := user.GetSavedSearches()
+	if len(savedSearches) == 0 {
+		return
+	}
+
+	newMatches, changed := user.CheckSavedSearches(update)
+	if changed {
+		user.Save()
+	}
+	texts := []MessageText{}
+	for _, saved := range savedSearches {
+		cars := newMatches[saved.Name]
+		if len(cars) == 0 {
+			continue
+		}
+
+		messages, err := GetSearchMessages(user, fmt.Sprintf("🔎 Neue Treffer für %s (%s):", saved.Name, saved.Query), cars)
+		if err != nil {
+			log.Printf("Update for %s(%d): search %s failed: %s", user.FriendlyName, user.UserId, saved.Name, err)
+			continue
+		}
+		for _, message := range messages {
+			msg := message.(tgbotapi.MessageConfig)
+			texts = append(texts, MessageText{Text: msg.Text, Markdown: msg.ParseMode == "Markdown"})
+		}
+	}
+	user.sendAlerts(bot, "search", texts)
+}
+
+// SearchKey returns a short key of the query, used to reference it in the
+// page buttons of search results.
+func SearchKey(query string) string {
+	sum := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(sum[:4])
+}
+
+// RememberSearch keeps the query for the page buttons of its results and
+// returns its key. Only the last MaxRecentSearches queries are kept.
+func (user *User) RememberSearch(query string) string {
+	key := SearchKey(query)
+
+	user.mutex().Lock()
+	defer user.mutex().Unlock()
+
+	user.RecentSearches = slices.DeleteFunc(user.RecentSearches, func(recent string) bool { return recent == query })
+	user.RecentSearches = append(user.RecentSearches, query)
+	if len(user.RecentSearches) > MaxRecentSearches {
+		user.RecentSearches = user.RecentSearches[len(user.RecentSearches)-MaxRecentSearches:]
+	}
+
+	return key
+}
+
+// GetSearchByKey returns the recent or saved query with the given key.
+func (user *User) GetSearchByKey(key string) (string, bool) {
+	user.mutex().Lock()
+	defer user.mutex().Unlock()
+
+	for _, query := range user.RecentSearches {
+		if SearchKey(query) == key {
+			return query, true
+		}
+	}
+	for _, saved := range user.SavedSearches {
+		if SearchKey(saved.Query) == key {
+			return saved.Query, true
+		}
+	}
+
+	return "", false
+}
+
+// GetSearchMessages renders the cars with the detail template of the user.
+func GetSearchMessages(user *User, header string, cars []dto.Item) ([]tgbotapi.Chattable, error) {
+	lines, err := getCarsDetailsTexts(cars, user.DetailMessageTemplate, user.TemplateFuncs())
+	if err != nil {
+		return nil, err
+	}
+
+	buffer := &detailMessageBuffer{chatId: user.UserId}
+	buffer.WriteString(header + "\n")
+	for _, line := range lines {
+		buffer.addLine(line, nil)
+	}
+	buffer.flush()
+
+	return buffer.messages, nil
+}
+
+func getIdents(cars []dto.Item) []string {
+	idents := make([]string, 0, len(cars))
+	for _, car := range cars {
+		idents = append(idents, car.RentalObject.Ident)
+	}
+
+	return idents
+}
@@ -0,0 +1,149 @@
+package config_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/khase/leaseplan-bot/lpbot/config"
+	"github.com/khase/leaseplanabocarexporter/dto"
+)
+
+var searchTestCars = []dto.Item{
+	newTestCar(testCar{Ident: "1", Label: "BMW", Model: "i4 eDrive40", Fuel: "Elektro", HP: 340, BLP: 60000, SalaryWaiver: 600}),
+	newTestCar(testCar{Ident: "2", Label: "BMW", Model: "320d", Fuel: "Diesel", HP: 190, BLP: 40000, SalaryWaiver: 400}),
+	newTestCar(testCar{Ident: "3", Label: "BMW", Model: "iX1 xDrive30", Fuel: "Elektro", HP: 313, BLP: 50000, SalaryWaiver: 500}),
+	newTestCar(testCar{Ident: "4", Label: "VW", Model: "ID.3", Fuel: "Elektro", HP: 204, BLP: 30000, SalaryWaiver: 300}),
+}
+
+func getSearchIdents(t *testing.T, query string) []string {
+	search, err := config.ParseSearchQuery(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idents := []string{}
+	for _, car := range search.Find(searchTestCars) {
+		idents = append(idents, car.RentalObject.Ident)
+	}
+	return idents
+}
+
+func TestSearch(t *testing.T) {
+	tests := map[string][]string{
+		"bmw":                               {"2", "1", "3"},
+		"BMW elektro":                       {"1", "3"},
+		"bmw elektro hp>320":                {"1"},
+		"elektro bgv<=500 sort:bgv":         {"4", "3"},
+		"elektro sort:-hp":                  {"1", "3", "4"},
+		"elektro sort:netcost":              {"4", "3", "1"},
+		"id.3 blp>=30000 blp<30000,5":       {"4"},
+		"sort:-bgv":                         {"1", "3", "2", "4"},
+		"mercedes":                          {},
+		"elektro hp=204":                    {"4"},
+		"elektro   hp>0  sort:availability": {"1", "3", "4"},
+	}
+
+	for query, expected := range tests {
+		idents := getSearchIdents(t, query)
+		if len(idents) != len(expected) {
+			t.Fatalf("expected %q to find %v but got %v", query, expected, idents)
+		}
+		for i := range idents {
+			if idents[i] != expected[i] {
+				t.Fatalf("expected %q to find %v but got %v", query, expected, idents)
+			}
+		}
+	}
+
+	for _, query := range []string{"", "sort:price", "weight>2", "hp>abc"} {
+		if _, err := config.ParseSearchQuery(query); !errors.Is(err, config.ErrInvalidSearchQuery) {
+			t.Fatalf("expected %q to be invalid but got %v", query, err)
+		}
+	}
+}
+
+func TestSavedSearch(t *testing.T) {
+	user := newTestUser(t)
+	saved, err := user.SaveSearch("stromer", "elektro hp>300", searchTestCars[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved.Matches) != 1 {
+		t.Fatalf("expected the current match to be remembered but got %v", saved.Matches)
+	}
+	if _, err := user.SaveSearch("Stromer", "bmw", searchTestCars); !errors.Is(err, config.ErrSearchExists) {
+		t.Fatalf("expected ErrSearchExists but got %v", err)
+	}
+
+	newMatches, changed := user.CheckSavedSearches(searchTestCars)
+	if !changed || len(newMatches["stromer"]) != 1 || newMatches["stromer"][0].RentalObject.Ident != "3" {
+		t.Fatalf("expected car 3 to be a new match but got %+v", newMatches)
+	}
+	if newMatches, changed = user.CheckSavedSearches(searchTestCars); changed || len(newMatches) != 0 {
+		t.Fatalf("expected no new matches but got %+v", newMatches)
+	}
+
+	if err := user.DeleteSavedSearch("STROMER"); err != nil || user.GetSavedSearch("stromer") != nil {
+		t.Fatalf("expected the search to be deleted but got %v", err)
+	}
+	if err := user.DeleteSavedSearch("stromer"); !errors.Is(err, config.ErrSearchNotFound) {
+		t.Fatalf("expected ErrSearchNotFound but got %v", err)
+	}
+}
+
+func TestSavedSearchesDuringCheck(t *testing.T) {
+	user := newTestUser(t)
+	if _, err := user.SaveSearch("stromer", "elektro", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			user.CheckSavedSearches(searchTestCars[:i%len(searchTestCars)])
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		user.SaveSearch("bmw", "bmw", searchTestCars)
+		user.GetSavedSearches()
+		user.Save()
+		user.DeleteSavedSearch("bmw")
+	}
+	<-done
+
+	if saved := user.GetSavedSearches(); len(saved) != 1 || saved[0].Name != "stromer" {
+		t.Fatalf("expected only the search stromer to be left but got %+v", saved)
+	}
+}
+
+func TestRecentSearches(t *testing.T) {
+	user := newTestUser(t)
+	if _, err := user.SaveSearch("stromer", "elektro hp>300", searchTestCars); err != nil {
+		t.Fatal(err)
+	}
+
+	first := user.RememberSearch("bmw")
+	for i := 0; i < config.MaxRecentSearches; i++ {
+		user.RememberSearch(fmt.Sprintf("bmw hp>%d", i))
+	}
+	if query, found := user.GetSearchByKey(first); found {
+		t.Fatalf("expected the first search to be dropped but got %q", query)
+	}
+	if len(user.RecentSearches) != config.MaxRecentSearches {
+		t.Fatalf("expected %d recent searches but got %d", config.MaxRecentSearches, len(user.RecentSearches))
+	}
+
+	key := user.RememberSearch("vw")
+	if query, found := user.GetSearchByKey(key); !found || query != "vw" {
+		t.Fatalf("expected the key to reference vw but got %q", query)
+	}
+	if user.RememberSearch("vw") != key || len(user.RecentSearches) != config.MaxRecentSearches {
+		t.Fatalf("expected a repeated search to be kept once but got %v", user.RecentSearches)
+	}
+	// saved searches stay available after they dropped out of the recent ones
+	if query, found := user.GetSearchByKey(config.SearchKey("elektro hp>300")); !found || query != "elektro hp>300" {
+		t.Fatalf("expected the saved search to be found but got %q", query)
+	}
+}
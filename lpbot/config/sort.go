@@ -0,0 +1,142 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/khase/leaseplanabocarexporter/dto"
+)
+
+const (
+	SortByModel        = "model"
+	SortByBlp          = "blp"
+	SortByBgv          = "bgv"
+	SortByNetCost      = "netcost"
+	SortByHp           = "hp"
+	SortByAvailability = "availability"
+)
+
+var (
+	ErrUnknownSortOrder = errors.New("unknown sort order")
+
+	// SortOrders lists all keys accepted by SortCars.
+	SortOrders = []string{SortByModel, SortByBlp, SortByBgv, SortByNetCost, SortByHp, SortByAvailability}
+
+	carComparators = map[string]func(a dto.Item, b dto.Item) int{
+		SortByModel: func(a dto.Item, b dto.Item) int {
+			return strings.Compare(strings.ToLower(carName(a)), strings.ToLower(carName(b)))
+		},
+		SortByBlp: func(a dto.Item, b dto.Item) int {
+			return compareFloat(a.RentalObject.PriceProducer1, b.RentalObject.PriceProducer1)
+		},
+		SortByBgv: func(a dto.Item, b dto.Item) int {
+			return compareFloat(float64(a.SalaryWaiver), float64(b.SalaryWaiver))
+		},
+		SortByNetCost: func(a dto.Item, b dto.Item) int {
+			return compareFloat(NetCost(a), NetCost(b))
+		},
+		SortByHp: func(a dto.Item, b dto.Item) int {
+			return compareFloat(float64(a.RentalObject.PowerHP), float64(b.RentalObject.PowerHP))
+		},
+		SortByAvailability: func(a dto.Item, b dto.Item) int {
+			return compareFloat(float64(a.RentalObject.DateRegistration.Unix()), float64(b.RentalObject.DateRegistration.Unix()))
+		},
+	}
+)
+
+// ParseSortOrder splits a sort order like "-bgv" into its key and direction.
+func ParseSortOrder(order string) (key string, descending bool, err error) {
+	key = strings.ToLower(strings.TrimSpace(order))
+	if strings.HasPrefix(key, "-") {
+		key, descending = key[1:], true
+	}
+	if _, exists := carComparators[key]; !exists {
+		return "", false, fmt.Errorf("%w: %s", ErrUnknownSortOrder, order)
+	}
+
+	return key, descending, nil
+}
+
+// CarComparator returns a function comparing two cars by the given order,
+// see ParseSortOrder. The result is negative if a comes first, positive if b
+// comes first and 0 if both are equal in this order.
+func CarComparator(order string) (func(a dto.Item, b dto.Item) int, error) {
+	key, descending, err := ParseSortOrder(order)
+	if err != nil {
+		return nil, err
+	}
+
+	compare := carComparators[key]
+	if !descending {
+		return compare, nil
+	}
+	return func(a dto.Item, b dto.Item) int { return compare(b, a) }, nil
+}
+
+// SortCars sorts the cars in place by the given order, see ParseSortOrder.
+// Equal cars are ordered by their ident, so the result is deterministic.
+func SortCars(cars []dto.Item, order string) error {
+	compare, err := CarComparator(order)
+	if err != nil {
+		return err
+	}
+
+	sort.SliceStable(cars, func(i, j int) bool {
+		result := compare(cars[i], cars[j])
+		if result == 0 {
+			return cars[i].RentalObject.Ident < cars[j].RentalObject.Ident
+		}
+		return result < 0
+	})
+
+	return nil
+}
+
+// SetSortOrder selects the order of the cars in messages, an empty order
+// keeps the default order.
+func (user *User) SetSortOrder(order string) error {
+	if order != "" {
+		key, descending, err := ParseSortOrder(order)
+		if err != nil {
+			return err
+		}
+		if descending {
+			key = "-" + key
+		}
+		order = key
+	}
+	user.SortOrder = order
+
+	return nil
+}
+
+// SortedFor returns a copy of the frame with the cars sorted by the order of
+// the user. The frame itself is not modified.
+func (dataFrame *DataFrame) SortedFor(user *User) *DataFrame {
+	if user.SortOrder == "" {
+		return dataFrame
+	}
+
+	frame := *dataFrame
+	for _, cars := range []*[]dto.Item{&frame.Added, &frame.Removed, &frame.Current} {
+		sorted := append([]dto.Item{}, *cars...)
+		if SortCars(sorted, user.SortOrder) == nil {
+			*cars = sorted
+		}
+	}
+
+	return &frame
+}
+
+func compareFloat(a float64, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,121 @@
+package config_test
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/khase/leaseplan-bot/lpbot/config"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files in testdata/golden")
+
+// renderMessages joins the texts of all messages so they can be compared
+// against a golden file.
+func renderMessages(t *testing.T, messages []tgbotapi.Chattable) string {
+	texts := []string{}
+	for _, message := range messages {
+		msg, ok := message.(tgbotapi.MessageConfig)
+		if !ok {
+			t.Fatalf("expected text messages but got %T", message)
+		}
+		texts = append(texts, msg.Text)
+	}
+
+	return strings.Join(texts, "\n---\n")
+}
+
+func checkGolden(t *testing.T, name string, actual string) {
+	path := filepath.Join("../../testdata/golden", name+".golden")
+	if *updateGolden {
+		os.MkdirAll(filepath.Dir(path), os.ModePerm)
+		if err := os.WriteFile(path, []byte(actual), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("could not read golden file, run the tests with -update to create it: %s", err)
+	}
+	if string(expected) != actual {
+		t.Fatalf("messages differ from %s:\n%s", path, actual)
+	}
+}
+
+func TestGoldenMessages(t *testing.T) {
+	for _, test := range []struct {
+		dataFrame string
+		order     string
+		groupBy   string
+	}{
+		{"dataframe", "", ""},
+		{"nochange.dataframe", "", ""},
+		{"sorting.dataframe", "", ""},
+		{"sorting.dataframe", config.SortByModel, ""},
+		{"sorting.dataframe", config.SortByBlp, ""},
+		{"sorting.dataframe", config.SortByBgv, ""},
+		{"sorting.dataframe", "-" + config.SortByBgv, ""},
+		{"sorting.dataframe", config.SortByNetCost, ""},
+		{"sorting.dataframe", config.SortByHp, ""},
+		{"sorting.dataframe", config.SortByAvailability, ""},
+		{"sorting.dataframe", "", config.GroupByFuel},
+		{"sorting.dataframe", "-" + config.SortByBgv, config.GroupByFuel},
+	} {
+		name := strings.TrimSuffix(test.dataFrame, ".dataframe")
+		if test.order != "" {
+			name = fmt.Sprintf("%s.%s", name, strings.TrimPrefix(test.order, "-"))
+		}
+		if strings.HasPrefix(test.order, "-") {
+			name += "-desc"
+		}
+		if test.groupBy != "" {
+			name = fmt.Sprintf("%s.grouped-%s", name, test.groupBy)
+		}
+
+		t.Run(name, func(t *testing.T) {
+			frame, err := config.LoadDataFrameFile(fmt.Sprintf("../../testdata/%s.yaml", test.dataFrame))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			user := config.NewUser(nil, 123, "golden")
+			if err := user.SetSortOrder(test.order); err != nil {
+				t.Fatal(err)
+			}
+			if test.groupBy != "" {
+				user.GroupDetails = true
+				if err := user.SetGroupBy(test.groupBy); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// rendering twice must not change the output
+			for i := 0; i < 2; i++ {
+				messages, err := frame.GetMessages(user)
+				if err != nil {
+					t.Fatal(err)
+				}
+				checkGolden(t, name, renderMessages(t, messages))
+			}
+		})
+	}
+}
+
+func TestSetSortOrder(t *testing.T) {
+	user := &config.User{}
+	if err := user.SetSortOrder(" -BGV "); err != nil || user.SortOrder != "-bgv" {
+		t.Fatalf("expected the sort order -bgv but got %q, %v", user.SortOrder, err)
+	}
+	if err := user.SetSortOrder("price"); err == nil || user.SortOrder != "-bgv" {
+		t.Fatalf("expected unknown sort orders to be rejected")
+	}
+	if err := user.SetSortOrder(""); err != nil || user.SortOrder != "" {
+		t.Fatalf("expected the sort order to be reset")
+	}
+}
@@ -0,0 +1,296 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/khase/leaseplanabocarexporter/dto"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// DefaultStatsDays is the number of days shown by /stats by default
+	DefaultStatsDays = 30
+	// MaxStatsDays limits how long the samples of the polls are kept
+	MaxStatsDays   = 90
+	statsRetention = MaxStatsDays * 24 * time.Hour
+	// maxStatsBrands limits the brands listed in the stats message
+	maxStatsBrands = 10
+)
+
+var (
+	assortmentStats     = &AssortmentStats{Levels: map[string][]AssortmentSample{}}
+	assortmentStatsOnce sync.Once
+)
+
+// AssortmentSample aggregates the cars of a single poll.
+type AssortmentSample struct {
+	Time                time.Time      `yaml:"Time" json:"Time"`
+	Count               int            `yaml:"Count" json:"Count"`
+	AverageSalaryWaiver float64        `yaml:"AverageSalaryWaiver" json:"AverageSalaryWaiver"`
+	Fuels               map[string]int `yaml:"Fuels,omitempty" json:"Fuels"`
+	Brands              map[string]int `yaml:"Brands,omitempty" json:"Brands"`
+}
+
+// AssortmentStats keeps the samples of every poll per leaseplan level.
+type AssortmentStats struct {
+	lock sync.Mutex
+
+	Levels map[string][]AssortmentSample `yaml:"Levels"`
+}
+
+// AssortmentReport is the daily evolution of the cars visible on the level
+// of a user and of the cars left after the filters of the user.
+type AssortmentReport struct {
+	LevelKey string             `json:"LevelKey"`
+	Visible  []AssortmentSample `json:"Visible"`
+	Filtered []AssortmentSample `json:"Filtered"`
+}
+
+func NewAssortmentSample(cars []dto.Item, timestamp time.Time) AssortmentSample {
+	sample := AssortmentSample{
+		Time:   timestamp,
+		Count:  len(cars),
+		Fuels:  map[string]int{},
+		Brands: map[string]int{},
+	}
+
+	var salaryWaivers int64
+	for _, car := range cars {
+		salaryWaivers += car.SalaryWaiver
+		sample.Fuels[string(car.RentalObject.KindOfFuel)]++
+		sample.Brands[string(car.RentalObject.CarLabel)]++
+	}
+	if len(cars) > 0 {
+		sample.AverageSalaryWaiver = float64(salaryWaivers) / float64(len(cars))
+	}
+
+	return sample
+}
+
+func getAssortmentStatsPath() string {
+	return fmt.Sprintf("%s/stats.yaml", cacheBasePath)
+}
+
+func getAssortmentStats() *AssortmentStats {
+	assortmentStatsOnce.Do(func() {
+		data, err := os.ReadFile(getAssortmentStatsPath())
+		if err != nil {
+			return
+		}
+		err = yaml.Unmarshal(data, assortmentStats)
+		if err != nil {
+			log.Printf("Failed loading stats: %s", err)
+		}
+		if assortmentStats.Levels == nil {
+			assortmentStats.Levels = map[string][]AssortmentSample{}
+		}
+	})
+
+	return assortmentStats
+}
+
+// RecordAssortment adds a sample of all cars visible on a level and saves
+// the stats.
+func RecordAssortment(levelKey string, cars []dto.Item) {
+	stats := getAssortmentStats()
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+
+	stats.Levels[levelKey] = addSample(stats.Levels[levelKey], NewAssortmentSample(cars, time.Now()))
+
+	data, err := yaml.Marshal(stats)
+	if err == nil {
+		os.MkdirAll(cacheBasePath, os.ModePerm)
+		err = os.WriteFile(getAssortmentStatsPath(), data, 0600)
+	}
+	if err != nil {
+		log.Printf("Failed saving stats: %s", err)
+	}
+}
+
+// GetAssortmentHistory returns a copy of the samples recorded for a level.
+func GetAssortmentHistory(levelKey string) []AssortmentSample {
+	stats := getAssortmentStats()
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+
+	return append([]AssortmentSample{}, stats.Levels[levelKey]...)
+}
+
+// addSample appends the sample and drops all samples older than the
+// retention.
+func addSample(samples []AssortmentSample, sample AssortmentSample) []AssortmentSample {
+	samples = append(samples, sample)
+
+	oldest := sample.Time.Add(-statsRetention)
+	for len(samples) > 0 && samples[0].Time.Before(oldest) {
+		samples = samples[1:]
+	}
+
+	return samples
+}
+
+// DailySamples reduces the samples to the last sample of each day since the
+// given time.
+func DailySamples(samples []AssortmentSample, since time.Time) []AssortmentSample {
+	result := []AssortmentSample{}
+	for _, sample := range samples {
+		if sample.Time.Before(since) {
+			continue
+		}
+		if len(result) > 0 && sameDay(result[len(result)-1].Time, sample.Time) {
+			result[len(result)-1] = sample
+			continue
+		}
+		result = append(result, sample)
+	}
+
+	return result
+}
+
+func sameDay(a time.Time, b time.Time) bool {
+	a, b = a.Local(), b.Local()
+	return a.Year() == b.Year() && a.YearDay() == b.YearDay()
+}
+
+func NewAssortmentReport(levelKey string, visible []AssortmentSample, filtered []AssortmentSample, since time.Time) *AssortmentReport {
+	return &AssortmentReport{
+		LevelKey: levelKey,
+		Visible:  DailySamples(visible, since),
+		Filtered: DailySamples(filtered, since),
+	}
+}
+
+func getUserStatsPath(userId int64) string {
+	return fmt.Sprintf("%s/%d.stats", cacheBasePath, userId)
+}
+
+func (user *User) loadAssortmentHistory() {
+	data, err := os.ReadFile(getUserStatsPath(user.UserId))
+	if err != nil {
+		return
+	}
+
+	err = yaml.Unmarshal(data, &user.AssortmentHistory)
+	if err != nil {
+		log.Printf("Failed loading stats for %s: %s", user.FriendlyName, err)
+	}
+}
+
+// recordAssortment adds a sample of the filtered cars of the user and saves
+// it next to the user cache.
+func (user *User) recordAssortment(cars []dto.Item) {
+	user.AssortmentHistory = addSample(user.AssortmentHistory, NewAssortmentSample(cars, time.Now()))
+
+	data, err := yaml.Marshal(user.AssortmentHistory)
+	if err == nil {
+		os.MkdirAll(cacheBasePath, os.ModePerm)
+		err = os.WriteFile(getUserStatsPath(user.UserId), data, 0600)
+	}
+	if err != nil {
+		log.Printf("Failed saving stats for %s: %s", user.FriendlyName, err)
+	}
+}
+
+// GetAssortmentReport returns the daily stats of the last days.
+func (user *User) GetAssortmentReport(days int) *AssortmentReport {
+	since := time.Now().AddDate(0, 0, -days)
+	return NewAssortmentReport(user.LeaseplanLevelKey, GetAssortmentHistory(user.LeaseplanLevelKey), user.AssortmentHistory, since)
+}
+
+// GetStatsMessages renders the report as chart with a caption, followed by
+// the fuel types and brands of the latest sample.
+func GetStatsMessages(user *User, report *AssortmentReport, days int) ([]tgbotapi.Chattable, error) {
+	if len(report.Visible) == 0 && len(report.Filtered) == 0 {
+		return []tgbotapi.Chattable{tgbotapi.NewMessage(user.UserId, "Für deinen Zugang gibt es noch keine Statistik. Sie wird bei jeder Abfrage von Leaseplan ergänzt.")}, nil
+	}
+
+	chart, err := RenderAssortmentChart(report)
+	if err != nil {
+		return nil, err
+	}
+
+	photo := tgbotapi.NewPhoto(user.UserId, tgbotapi.FileBytes{Name: "stats.png", Bytes: chart})
+	photo.Caption = getStatsCaption(report, days)
+
+	latest := latestSample(report.Filtered)
+	title := "deiner gefilterten Autos"
+	if latest == nil {
+		latest, title = latestSample(report.Visible), "aller sichtbaren Autos"
+	}
+	details := fmt.Sprintf("Stand %s (%s):\n\nAntriebe:\n%s\nMarken:\n%s",
+		title,
+		latest.Time.Local().Format("02.01.2006 15:04"),
+		formatCounts(latest.Fuels, 0),
+		formatCounts(latest.Brands, maxStatsBrands))
+
+	return []tgbotapi.Chattable{photo, tgbotapi.NewMessage(user.UserId, details)}, nil
+}
+
+func getStatsCaption(report *AssortmentReport, days int) string {
+	caption := fmt.Sprintf("📊 Dein Angebot der letzten %d Tage\noben: Anzahl, unten: ⌀ BGV\n", days)
+	for _, series := range []struct {
+		icon    string
+		name    string
+		samples []AssortmentSample
+	}{
+		{"🟦", "sichtbar", report.Visible},
+		{"🟩", "gefiltert", report.Filtered},
+	} {
+		latest := latestSample(series.samples)
+		if latest == nil {
+			caption += fmt.Sprintf("\n%s %s: keine Daten", series.icon, series.name)
+			continue
+		}
+		caption += fmt.Sprintf("\n%s %s: %d Autos, ⌀ BGV %.0f€", series.icon, series.name, latest.Count, latest.AverageSalaryWaiver)
+	}
+
+	return caption
+}
+
+func latestSample(samples []AssortmentSample) *AssortmentSample {
+	if len(samples) == 0 {
+		return nil
+	}
+
+	return &samples[len(samples)-1]
+}
+
+// formatCounts lists the counts in descending order, limited to the given
+// number of lines if it is greater than zero.
+func formatCounts(counts map[string]int, limit int) string {
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if counts[names[i]] != counts[names[j]] {
+			return counts[names[i]] > counts[names[j]]
+		}
+		return names[i] < names[j]
+	})
+
+	lines := []string{}
+	for i, name := range names {
+		if limit > 0 && i >= limit {
+			lines = append(lines, fmt.Sprintf("  … und %d weitere", len(names)-limit))
+			break
+		}
+		if name == "" {
+			name = "unbekannt"
+		}
+		lines = append(lines, fmt.Sprintf("  %s: %d", name, counts[names[i]]))
+	}
+	if len(lines) == 0 {
+		return "  keine\n"
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
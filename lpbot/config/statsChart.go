@@ -0,0 +1,198 @@
+package config
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"time"
+)
+
+const (
+	chartWidth       = 800
+	chartHeight      = 500
+	chartPadding     = 24
+	chartPanelGap    = 32
+	chartGridLines   = 4
+	chartLineWidth   = 3
+	chartPointRadius = 4
+)
+
+var (
+	chartBackground    = color.RGBA{255, 255, 255, 255}
+	chartGridColor     = color.RGBA{225, 225, 225, 255}
+	chartAxisColor     = color.RGBA{120, 120, 120, 255}
+	chartVisibleColor  = color.RGBA{33, 110, 220, 255}
+	chartFilteredColor = color.RGBA{60, 170, 70, 255}
+)
+
+type chartSeries struct {
+	color   color.Color
+	samples []AssortmentSample
+}
+
+type chartPanel struct {
+	bounds image.Rectangle
+	min    float64
+	max    float64
+}
+
+// RenderAssortmentChart draws the report as png. The upper panel shows the
+// number of cars, the lower one the average salary waiver, both per day with
+// the visible cars in blue and the filtered cars in green. There are no
+// labels, the values are part of the message caption.
+func RenderAssortmentChart(report *AssortmentReport) ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, chartWidth, chartHeight))
+	draw.Draw(img, img.Bounds(), &image.Uniform{chartBackground}, image.Point{}, draw.Src)
+
+	series := []chartSeries{
+		{color: chartVisibleColor, samples: report.Visible},
+		{color: chartFilteredColor, samples: report.Filtered},
+	}
+	first, days := chartDays(series)
+
+	panelHeight := (chartHeight - 2*chartPadding - chartPanelGap) / 2
+	panels := []struct {
+		value func(sample AssortmentSample) float64
+		top   int
+	}{
+		{func(sample AssortmentSample) float64 { return float64(sample.Count) }, chartPadding},
+		{func(sample AssortmentSample) float64 { return sample.AverageSalaryWaiver }, chartPadding + panelHeight + chartPanelGap},
+	}
+	for _, panelConfig := range panels {
+		panel := &chartPanel{
+			bounds: image.Rect(chartPadding, panelConfig.top, chartWidth-chartPadding, panelConfig.top+panelHeight),
+			min:    0,
+			max:    1,
+		}
+		for _, line := range series {
+			for _, sample := range line.samples {
+				panel.max = math.Max(panel.max, panelConfig.value(sample))
+			}
+		}
+		panel.max *= 1.1
+
+		panel.drawGrid(img)
+		for _, line := range series {
+			points := []image.Point{}
+			for _, sample := range line.samples {
+				day := dayIndex(first, sample.Time)
+				points = append(points, panel.point(day, days, panelConfig.value(sample)))
+			}
+			drawPolyline(img, points, line.color)
+		}
+	}
+
+	buffer := new(bytes.Buffer)
+	if err := png.Encode(buffer, img); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// chartDays returns the first day of all series and the number of days
+// covered.
+func chartDays(series []chartSeries) (time.Time, int) {
+	var first, last time.Time
+	for _, line := range series {
+		for _, sample := range line.samples {
+			if first.IsZero() || sample.Time.Before(first) {
+				first = sample.Time
+			}
+			if last.IsZero() || sample.Time.After(last) {
+				last = sample.Time
+			}
+		}
+	}
+
+	return first, dayIndex(first, last) + 1
+}
+
+func dayIndex(first time.Time, date time.Time) int {
+	first, date = first.Local(), date.Local()
+	firstDay := time.Date(first.Year(), first.Month(), first.Day(), 0, 0, 0, 0, time.UTC)
+	day := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+
+	return int(day.Sub(firstDay).Hours() / 24)
+}
+
+func (panel *chartPanel) drawGrid(img *image.RGBA) {
+	bounds := panel.bounds
+	for i := 0; i <= chartGridLines; i++ {
+		y := bounds.Min.Y + i*bounds.Dy()/chartGridLines
+		drawLine(img, image.Pt(bounds.Min.X, y), image.Pt(bounds.Max.X, y), chartGridColor, 1)
+	}
+	drawLine(img, image.Pt(bounds.Min.X, bounds.Min.Y), image.Pt(bounds.Min.X, bounds.Max.Y), chartAxisColor, 1)
+	drawLine(img, image.Pt(bounds.Min.X, bounds.Max.Y), image.Pt(bounds.Max.X, bounds.Max.Y), chartAxisColor, 1)
+}
+
+// point maps a day and value to pixels, a single day is drawn in the middle.
+func (panel *chartPanel) point(day int, days int, value float64) image.Point {
+	bounds := panel.bounds
+	x := bounds.Min.X + bounds.Dx()/2
+	if days > 1 {
+		x = bounds.Min.X + day*bounds.Dx()/(days-1)
+	}
+	y := bounds.Max.Y - int((value-panel.min)/(panel.max-panel.min)*float64(bounds.Dy()))
+
+	return image.Pt(x, y)
+}
+
+func drawPolyline(img *image.RGBA, points []image.Point, c color.Color) {
+	for i := 1; i < len(points); i++ {
+		drawLine(img, points[i-1], points[i], c, chartLineWidth)
+	}
+	for _, point := range points {
+		drawDot(img, point, c, chartPointRadius)
+	}
+}
+
+// drawLine draws a line of the given width using bresenham's algorithm.
+func drawLine(img *image.RGBA, from image.Point, to image.Point, c color.Color, width int) {
+	dx, dy := abs(to.X-from.X), -abs(to.Y-from.Y)
+	stepX, stepY := 1, 1
+	if from.X > to.X {
+		stepX = -1
+	}
+	if from.Y > to.Y {
+		stepY = -1
+	}
+
+	x, y, err := from.X, from.Y, dx+dy
+	for {
+		drawDot(img, image.Pt(x, y), c, width/2)
+		if x == to.X && y == to.Y {
+			return
+		}
+		doubleErr := 2 * err
+		if doubleErr >= dy {
+			err += dy
+			x += stepX
+		}
+		if doubleErr <= dx {
+			err += dx
+			y += stepY
+		}
+	}
+}
+
+func drawDot(img *image.RGBA, center image.Point, c color.Color, radius int) {
+	for x := -radius; x <= radius; x++ {
+		for y := -radius; y <= radius; y++ {
+			if x*x+y*y <= radius*radius {
+				img.Set(center.X+x, center.Y+y, c)
+			}
+		}
+	}
+}
+
+func abs(value int) int {
+	if value < 0 {
+		return -value
+	}
+
+	return value
+}
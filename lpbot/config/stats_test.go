@@ -0,0 +1,146 @@
+package config_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/khase/leaseplan-bot/lpbot/config"
+	"github.com/khase/leaseplanabocarexporter/dto"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestAssortmentSample(t *testing.T) {
+	frame, err := config.LoadDataFrameFile("../../testdata/sorting.dataframe.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sample := config.NewAssortmentSample(frame.Current, time.Now())
+	if sample.Count != 5 || sample.Fuels["Diesel"] != 2 || sample.Fuels["Elektro"] != 2 || sample.Brands["BMW"] != 2 {
+		t.Fatalf("unexpected sample %+v", sample)
+	}
+	if sample.AverageSalaryWaiver != (549+599+329+655+389)/5.0 {
+		t.Fatalf("unexpected average salary waiver %f", sample.AverageSalaryWaiver)
+	}
+}
+
+func TestDailySamples(t *testing.T) {
+	day := time.Date(2023, 3, 1, 8, 0, 0, 0, time.Local)
+	samples := []config.AssortmentSample{
+		{Time: day.AddDate(0, 0, -10), Count: 1},
+		{Time: day, Count: 2},
+		{Time: day.Add(6 * time.Hour), Count: 3},
+		{Time: day.AddDate(0, 0, 1), Count: 4},
+	}
+
+	daily := config.DailySamples(samples, day.AddDate(0, 0, -1))
+	if len(daily) != 2 || daily[0].Count != 3 || daily[1].Count != 4 {
+		t.Fatalf("expected the last sample of each day but got %+v", daily)
+	}
+}
+
+func TestRecordAssortment(t *testing.T) {
+	frame, err := config.LoadDataFrameFile("../../testdata/sorting.dataframe.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	useTempCache(t)
+
+	config.RecordAssortment("Stats Level", frame.Previous)
+	config.RecordAssortment("Stats Level", frame.Current)
+
+	history := config.GetAssortmentHistory("Stats Level")
+	if len(history) != 2 || history[0].Count != 3 || history[1].Count != 5 {
+		t.Fatalf("expected a sample per poll but got %+v", history)
+	}
+
+	user := config.NewUser(nil, 123, "stats")
+	user.LeaseplanLevelKey = "Stats Level"
+	report := user.GetAssortmentReport(config.DefaultStatsDays)
+	if report.LevelKey != "Stats Level" || len(report.Visible) != 1 || report.Visible[0].Count != 5 || len(report.Filtered) != 0 {
+		t.Fatalf("expected the last sample of today but got %+v", report)
+	}
+}
+
+func TestClearCacheDropsStats(t *testing.T) {
+	useTempCache(t)
+	car := newTestCar(testCar{Ident: "1", Label: "BMW", Spec: "i4"})
+	// the car is known already, so the update sends no messages
+	user := newTestUser(t, car)
+	user.WatcherDelay = 0
+	user.Update(context.Background(), []dto.Item{car}, nil)
+	if report := user.GetAssortmentReport(config.DefaultStatsDays); len(report.Filtered) != 1 {
+		t.Fatalf("expected the filtered cars to be recorded but got %+v", report)
+	}
+	if _, err := os.Stat(filepath.Join(config.DefaultCacheDir, "1.stats")); err != nil {
+		t.Fatal(err)
+	}
+
+	user.ClearCache()
+	if _, err := os.Stat(filepath.Join(config.DefaultCacheDir, "1.stats")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the stats to be removed but got %v", err)
+	}
+	if report := user.GetAssortmentReport(config.DefaultStatsDays); len(report.Filtered) != 0 {
+		t.Fatalf("expected no filtered stats but got %+v", report)
+	}
+}
+
+func TestStatsMessages(t *testing.T) {
+	user := config.NewUser(nil, 123, "stats")
+
+	messages, err := config.GetStatsMessages(user, &config.AssortmentReport{}, config.DefaultStatsDays)
+	if err != nil || len(messages) != 1 {
+		t.Fatalf("expected a hint without stats but got %v, %v", messages, err)
+	}
+
+	frame, err := config.LoadDataFrameFile("../../testdata/sorting.dataframe.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(2023, 3, 1, 8, 0, 0, 0, time.Local)
+	report := &config.AssortmentReport{
+		Visible: []config.AssortmentSample{
+			config.NewAssortmentSample(frame.Previous, day),
+			config.NewAssortmentSample(frame.Current, day.AddDate(0, 0, 2)),
+		},
+		Filtered: []config.AssortmentSample{
+			config.NewAssortmentSample(frame.Added, day.AddDate(0, 0, 2)),
+		},
+	}
+
+	messages, err = config.GetStatsMessages(user, report, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("expected a chart and the details but got %d messages", len(messages))
+	}
+
+	photo, ok := messages[0].(tgbotapi.PhotoConfig)
+	if !ok {
+		t.Fatalf("expected a photo but got %T", messages[0])
+	}
+	if !strings.Contains(photo.Caption, "letzten 7 Tage") || !strings.Contains(photo.Caption, "sichtbar: 5 Autos") || !strings.Contains(photo.Caption, "gefiltert: 4 Autos") {
+		t.Fatalf("unexpected caption %q", photo.Caption)
+	}
+	chart, err := png.Decode(bytes.NewReader(photo.File.(tgbotapi.FileBytes).Bytes))
+	if err != nil {
+		t.Fatalf("expected a png chart: %s", err)
+	}
+	if chart.Bounds().Dx() != 800 || chart.Bounds().Dy() != 500 {
+		t.Fatalf("unexpected chart size %v", chart.Bounds())
+	}
+
+	details := messages[1].(tgbotapi.MessageConfig).Text
+	if !strings.Contains(details, "gefilterten Autos") || !strings.Contains(details, "Diesel: 2") || !strings.Contains(details, "Seat: 1") {
+		t.Fatalf("unexpected details %q", details)
+	}
+}
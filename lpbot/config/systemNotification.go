@@ -1,37 +1,60 @@
 package config
 
-import "time"
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+const (
+	NotificationActionPauseWatcher = "pauseWatcher"
+	NotificationActionClearCache   = "clearCache"
+
+	// NotificationRetention is the time a notification is delivered after
+	// its publish time. Afterwards its deliveries are reduced to counters.
+	NotificationRetention = 7 * 24 * time.Hour
+)
 
 var (
-	SystemNotifications = [2]SystemNotification{
-		{
-			time.Date(2023, 04, 03, 23, 20, 0, 0, time.Now().Local().Location()),
-			"Sicher ist dir schon aufgefallen, dass ich die letzte Zeit offline war 😔\n\nLeider will Leaseplan keine Bots gegen ihre API laufen haben und geht dagegen auch immer stärker aktiv vor. Durch ein angezogenes Rate Limit bin ich ins rampenlicht geraten und einige der User wurden entsprechend auch auf Leaseplan gesperrt. Das ist an der Stelle nicht weiter schlimm, die entsprechenden User können einfach über den Support wieder aktiviert werden. Als reaktion darauf haben wir ein paar änderungen in der Abruflogik vorgenommen um wieder etwas mehr unter dem Radar zu fliegen.\n\nWenn du mit so einem Vorfall keine Probleme hast akzeptiere bitte die /eula true und starte deine Notifications wieder mit /resume.\n\nÜbrigens gibt es jetzt auch einen Community Channel in dem ihr mir Probleme oder Wünsche mitteilen könnt https://t.me/+jCYLQd2KkOdiNmRi 😉",
-			func(u *User) bool {
-				return u.WatcherActive
-			},
-			func(u *User) {
-				u.WatcherActive = false
-			},
-		},
-		{
-			time.Date(2025, 06, 25, 23, 00, 0, 0, time.Now().Local().Location()),
-			"Sicher ist dir schon aufgefallen, dass ich die letzte Zeit offline war 😔\n\nLeider wurde die Leaseplan API etwas angepasst.\nIch habe den Code soweit angepasst, dass die bisherigen Funktionen wieder erfolgreich funktionieren.\n\nLeider wurde der Login Prozess dahingehend angepasst, dass die mir bekannten Token für eure Logins abgelaufen sind😓.\n❗Entsprechend müsst ihr euch erneut einloggen wenn ihr weiterhin updates erhalten wollt und euren watcher erneut aktivieren. Hierfür einfach folgende Befehle abschicken:\n\n/login email passwort\n/resume\n\nIch musste auch den cache leeren wodurch eure erste Nachricht euch alle aktuellen Fahrzeuge als neu markiert.",
-			func(u *User) bool {
-				return u.WatcherActive
-			},
-			func(u *User) {
-				u.WatcherActive = false
-			},
-		},
-	}
+	ErrUnknownNotificationOption = errors.New("unknown notification option")
+	ErrUnknownNotificationAction = errors.New("unknown notification action")
 )
 
 type SystemNotification struct {
-	Publish       time.Time
-	Message       string
-	UserCondition func(*User) bool
-	UserAction    func(*User)
+	Id         int                    `yaml:"Id"`
+	Publish    time.Time              `yaml:"Publish"`
+	Message    string                 `yaml:"Message"`
+	Conditions NotificationConditions `yaml:"Conditions,omitempty"`
+	Actions    []string               `yaml:"Actions,omitempty"`
+	CreatedBy  string                 `yaml:"CreatedBy,omitempty"`
+
+	Deliveries []NotificationDelivery `yaml:"Deliveries,omitempty"`
+	// counters of the deliveries removed by Prune
+	SentCount    int `yaml:"SentCount,omitempty"`
+	SkippedCount int `yaml:"SkippedCount,omitempty"`
+	FailedCount  int `yaml:"FailedCount,omitempty"`
+}
+
+// NotificationConditions describe which users a notification targets. Unset
+// conditions match every user.
+type NotificationConditions struct {
+	WatcherActive *bool  `yaml:"WatcherActive,omitempty"`
+	LevelKey      string `yaml:"LevelKey,omitempty"`
+	HasFilters    *bool  `yaml:"HasFilters,omitempty"`
+	Language      string `yaml:"Language,omitempty"`
+}
+
+// NotificationDelivery tracks that a notification has been processed for a
+// user. Sent is false if the user did not match the conditions.
+type NotificationDelivery struct {
+	UserId int64     `yaml:"UserId"`
+	Time   time.Time `yaml:"Time"`
+	Sent   bool      `yaml:"Sent,omitempty"`
+	Error  string    `yaml:"Error,omitempty"`
 }
 
 func NewSystemNotification(publish time.Time, message string) *SystemNotification {
@@ -39,6 +62,130 @@ func NewSystemNotification(publish time.Time, message string) *SystemNotificatio
 
 	notification.Publish = publish
 	notification.Message = message
+	notification.Actions = make([]string, 0)
+	notification.Deliveries = make([]NotificationDelivery, 0)
 
 	return notification
 }
+
+// SetOption applies a single key=value option as used by the admin command
+// (at, watcher, level, filters, lang, action).
+func (notification *SystemNotification) SetOption(key string, value string) error {
+	switch key {
+	case "at":
+		publish, err := time.ParseInLocation("2006-01-02T15:04", value, time.Local)
+		if err != nil {
+			return err
+		}
+		notification.Publish = publish
+	case "watcher":
+		watcherActive, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		notification.Conditions.WatcherActive = &watcherActive
+	case "level":
+		notification.Conditions.LevelKey = value
+	case "filters":
+		hasFilters, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		notification.Conditions.HasFilters = &hasFilters
+	case "lang":
+		notification.Conditions.Language = value
+	case "action":
+		for _, action := range strings.Split(value, ",") {
+			if action != NotificationActionPauseWatcher && action != NotificationActionClearCache {
+				return fmt.Errorf("%w: %s", ErrUnknownNotificationAction, action)
+			}
+			notification.Actions = append(notification.Actions, action)
+		}
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownNotificationOption, key)
+	}
+
+	return nil
+}
+
+func (notification *SystemNotification) IsDue(now time.Time) bool {
+	return !notification.Publish.After(now)
+}
+
+// IsExpired reports whether the retention of the notification is over.
+func (notification *SystemNotification) IsExpired(now time.Time) bool {
+	return now.After(notification.Publish.Add(NotificationRetention))
+}
+
+// IsPending reports whether the notification still has to be processed for
+// the user. Notifications published before the user registered and expired
+// notifications are skipped.
+func (notification *SystemNotification) IsPending(user *User) bool {
+	if !notification.Publish.After(user.LastSystemnotification) || notification.IsExpired(time.Now()) {
+		return false
+	}
+
+	return !slices.ContainsFunc(notification.Deliveries, func(d NotificationDelivery) bool { return d.UserId == user.UserId })
+}
+
+func (notification *SystemNotification) Matches(user *User) bool {
+	conditions := notification.Conditions
+	if conditions.WatcherActive != nil && *conditions.WatcherActive != user.WatcherActive {
+		return false
+	}
+	if conditions.LevelKey != "" && conditions.LevelKey != user.LeaseplanLevelKey {
+		return false
+	}
+	if conditions.HasFilters != nil && *conditions.HasFilters != (len(user.Filters) > 0) {
+		return false
+	}
+	if conditions.Language != "" && !strings.HasPrefix(user.Language, conditions.Language) {
+		return false
+	}
+
+	return true
+}
+
+func (notification *SystemNotification) RecordDelivery(user *User, sent bool, err error) {
+	delivery := NotificationDelivery{
+		UserId: user.UserId,
+		Time:   time.Now(),
+		Sent:   sent,
+	}
+	if err != nil {
+		delivery.Error = err.Error()
+	}
+
+	notification.Deliveries = append(notification.Deliveries, delivery)
+}
+
+// Prune replaces the deliveries of an expired notification by counters, so
+// they do not grow with every user forever. It reports whether anything
+// changed.
+func (notification *SystemNotification) Prune(now time.Time) bool {
+	if !notification.IsExpired(now) || len(notification.Deliveries) == 0 {
+		return false
+	}
+
+	sent, skipped, failed := notification.CountDeliveries()
+	notification.SentCount, notification.SkippedCount, notification.FailedCount = sent, skipped, failed
+	notification.Deliveries = nil
+
+	return true
+}
+
+func (notification *SystemNotification) CountDeliveries() (sent int, skipped int, failed int) {
+	sent, skipped, failed = notification.SentCount, notification.SkippedCount, notification.FailedCount
+	for _, delivery := range notification.Deliveries {
+		switch {
+		case delivery.Error != "":
+			failed++
+		case delivery.Sent:
+			sent++
+		default:
+			skipped++
+		}
+	}
+
+	return sent, skipped, failed
+}
@@ -0,0 +1,88 @@
+package config_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/khase/leaseplan-bot/lpbot/config"
+)
+
+func TestSystemNotificationTargeting(t *testing.T) {
+	user := config.NewUser(nil, 123, "test")
+	user.LastSystemnotification = time.Now().Add(-time.Hour)
+	user.LeaseplanLevelKey = "Level 1"
+	user.Language = "de"
+	user.WatcherActive = true
+
+	notification := config.NewSystemNotification(time.Now(), "Hallo")
+	for _, option := range [][2]string{{"watcher", "true"}, {"level", "Level 1"}, {"lang", "de"}, {"filters", "false"}} {
+		if err := notification.SetOption(option[0], option[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !notification.IsPending(user) || !notification.Matches(user) {
+		t.Fatalf("expected notification to target the user")
+	}
+
+	user.AddFilter("gt .RentalObject.PowerHP 300")
+	if notification.Matches(user) {
+		t.Fatalf("expected notification not to target users with filters")
+	}
+
+	notification.RecordDelivery(user, false, nil)
+	if notification.IsPending(user) {
+		t.Fatalf("expected notification to be processed for the user")
+	}
+}
+
+func TestSystemNotificationSkipsNewUsers(t *testing.T) {
+	notification := config.NewSystemNotification(time.Now().Add(-time.Hour), "Hallo")
+	user := config.NewUser(nil, 123, "test")
+
+	if notification.IsPending(user) {
+		t.Fatalf("expected notifications published before registration to be skipped")
+	}
+}
+
+func TestSystemNotificationOptions(t *testing.T) {
+	notification := config.NewSystemNotification(time.Now(), "Hallo")
+
+	if err := notification.SetOption("action", "pauseWatcher,clearCache"); err != nil {
+		t.Fatal(err)
+	}
+	if len(notification.Actions) != 2 {
+		t.Fatalf("expected 2 actions but got %d", len(notification.Actions))
+	}
+	if err := notification.SetOption("action", "deleteUser"); !errors.Is(err, config.ErrUnknownNotificationAction) {
+		t.Fatalf("expected ErrUnknownNotificationAction but got %v", err)
+	}
+	if err := notification.SetOption("color", "red"); !errors.Is(err, config.ErrUnknownNotificationOption) {
+		t.Fatalf("expected ErrUnknownNotificationOption but got %v", err)
+	}
+}
+
+func TestSystemNotificationPrune(t *testing.T) {
+	user := config.NewUser(nil, 123, "test")
+	user.LastSystemnotification = time.Now().Add(-30 * 24 * time.Hour)
+
+	notification := config.NewSystemNotification(time.Now().Add(-24*time.Hour), "Hallo")
+	notification.RecordDelivery(user, true, nil)
+	notification.RecordDelivery(config.NewUser(nil, 456, "other"), false, errors.New("blocked"))
+	if notification.Prune(time.Now()) {
+		t.Fatalf("expected notifications within the retention to be kept")
+	}
+
+	if !notification.Prune(time.Now().Add(config.NotificationRetention)) || len(notification.Deliveries) != 0 {
+		t.Fatalf("expected the deliveries of expired notifications to be pruned")
+	}
+	if sent, skipped, failed := notification.CountDeliveries(); sent != 1 || skipped != 0 || failed != 1 {
+		t.Fatalf("expected the counts to be kept but got %d, %d, %d", sent, skipped, failed)
+	}
+
+	expired := config.NewSystemNotification(time.Now().Add(-config.NotificationRetention-time.Hour), "Hallo")
+	if expired.IsPending(user) {
+		t.Fatalf("expected expired notifications not to be sent anymore")
+	}
+}
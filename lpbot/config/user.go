@@ -1,22 +1,39 @@
 package config
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 	"fmt"
+	"html/template"
 	"log"
 	"os"
+	"reflect"
 	"strconv"
+	"sync"
 	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/khase/leaseplanabocarexporter/dto"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/exp/maps"
 	"golang.org/x/exp/slices"
 	"gopkg.in/yaml.v2"
 )
 
+const (
+	// MinUserWatcherDelay is the smallest throttle in minutes non admin users may choose
+	MinUserWatcherDelay = 15
+)
+
 var (
-	cacheBasePath            = "cache"
+	pendingMessages sync.WaitGroup
+	userLockInit    sync.Mutex
+
+	cacheBasePath            = DefaultCacheDir
 	userLeaseplanCarsVisible = promauto.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "lpcon_cars_visible",
@@ -46,33 +63,87 @@ var (
 type User struct {
 	UserMap *UserMap `yaml:"-"`
 
+	// lock guards the state changed by Update, like the last frame, against
+	// bot commands, the api and reloads. It is a pointer, as yaml copies the
+	// user while marshalling it, see mutex.
+	lock *sync.Mutex
+
 	UserId       int64  `yaml:"UserId,omitempty"`
 	FriendlyName string `yaml:"FriendlyName,omitempty"`
+	Language     string `yaml:"Language,omitempty"`
 	EULA         bool   `yaml:"EULA"`
 
-	LeaseplanToken    string `yaml:"LeaseplanToken,omitempty"`
-	LeaseplanLevelKey string `yaml:"LeaseplanLevelKey,omitempty"`
+	LeaseplanToken       string    `yaml:"LeaseplanToken,omitempty"`
+	LeaseplanTokenExpiry time.Time `yaml:"LeaseplanTokenExpiry,omitempty"`
+	TokenExpiryWarned    bool      `yaml:"TokenExpiryWarned,omitempty"`
+	LeaseplanLevelKey    string    `yaml:"LeaseplanLevelKey,omitempty"`
 
+	// groups and channels are stored as users keyed by their chat id
+	ChatType      string `yaml:"ChatType,omitempty"`
+	OwnerId       int64  `yaml:"OwnerId,omitempty"`
+	EditingChatId int64  `yaml:"EditingChatId,omitempty"`
+
+	ApiKeyHash             string    `yaml:"ApiKeyHash,omitempty"`
 	IsAdmin                bool      `yaml:"IsAdmin,omitempty"`
+	Banned                 bool      `yaml:"Banned,omitempty"`
 	LastSystemnotification time.Time `yaml:"LastSystemnotification,omitempty"`
 
-	WatcherActive bool   `yaml:"WatcherActive"`
-	WatcherError  string `yaml:"WatcherError,omitempty"`
-	WatcherDelay  int32  `yaml:"WatcherDelay,omitempty"`
+	WatcherActive        bool   `yaml:"WatcherActive"`
+	WatcherAuthSuspended bool   `yaml:"WatcherAuthSuspended,omitempty"`
+	WatcherError         string `yaml:"WatcherError,omitempty"`
+	WatcherDelay         int32  `yaml:"WatcherDelay,omitempty"`
 
 	SummaryMessageTemplate string `yaml:"SummaryMessageTemplate,omitempty"`
 	DetailMessageTemplate  string `yaml:"DetailMessageTemplate,omitempty"`
 
-	IgnoreDetails bool `yaml:"IgnoreDetails,omitempty"`
-	IgnoreRemoved bool `yaml:"IgnoreRemoved,omitempty"`
+	Notifier      string `yaml:"Notifier,omitempty"`
+	WebhookUrl    string `yaml:"WebhookUrl,omitempty"`
+	WebhookSecret string `yaml:"WebhookSecret,omitempty"`
+
+	EmailAddress               string    `yaml:"EmailAddress,omitempty"`
+	EmailPendingAddress        string    `yaml:"EmailPendingAddress,omitempty"`
+	EmailConfirmationCode      string    `yaml:"EmailConfirmationCode,omitempty"`
+	EmailConfirmationExpiry    time.Time `yaml:"EmailConfirmationExpiry,omitempty"`
+	EmailConfirmationFailures  int       `yaml:"EmailConfirmationFailures,omitempty"`
+	EmailConfirmationRequested time.Time `yaml:"EmailConfirmationRequested,omitempty"`
+	EmailSubjectTemplate       string    `yaml:"EmailSubjectTemplate,omitempty"`
+	EmailHtmlTemplate          string    `yaml:"EmailHtmlTemplate,omitempty"`
+
+	NtfyTopicUrl        string `yaml:"NtfyTopicUrl,omitempty"`
+	NtfyToken           string `yaml:"NtfyToken,omitempty"`
+	GotifyServerUrl     string `yaml:"GotifyServerUrl,omitempty"`
+	GotifyToken         string `yaml:"GotifyToken,omitempty"`
+	MatrixHomeserverUrl string `yaml:"MatrixHomeserverUrl,omitempty"`
+	MatrixRoomId        string `yaml:"MatrixRoomId,omitempty"`
+	MatrixAccessToken   string `yaml:"MatrixAccessToken,omitempty"`
+
+	IgnoreDetails bool   `yaml:"IgnoreDetails,omitempty"`
+	DetailMode    string `yaml:"DetailMode,omitempty"`
+	IgnoreRemoved bool   `yaml:"IgnoreRemoved,omitempty"`
 
 	Filters []string `yaml:"Filters,omitempty"`
 
-	LastFrame *DataFrame `yaml:"-"`
+	WatchedCars map[string]*WatchedCar `yaml:"WatchedCars,omitempty"`
+	SnoozedCars map[string]time.Time   `yaml:"SnoozedCars,omitempty"`
+
+	GroupBy              string `yaml:"GroupBy,omitempty"`
+	GroupDetails         bool   `yaml:"GroupDetails,omitempty"`
+	GroupMessageTemplate string `yaml:"GroupMessageTemplate,omitempty"`
+
+	SortOrder  string `yaml:"SortOrder,omitempty"`
+	RankMetric string `yaml:"RankMetric,omitempty"`
+	RankTopN   int    `yaml:"RankTopN,omitempty"`
+
+	SavedSearches  []*SavedSearch `yaml:"SavedSearches,omitempty"`
+	RecentSearches []string       `yaml:"RecentSearches,omitempty"`
+
+	LastFrame         *DataFrame         `yaml:"-"`
+	AssortmentHistory []AssortmentSample `yaml:"-"`
 }
 
 func NewUser(userMap *UserMap, userId int64, friendlyName string) *User {
 	user := new(User)
+	user.lock = new(sync.Mutex)
 	user.LastSystemnotification = time.Now()
 	user.UserMap = userMap
 	user.UserId = userId
@@ -80,6 +151,7 @@ func NewUser(userMap *UserMap, userId int64, friendlyName string) *User {
 	user.EULA = false
 	user.LeaseplanToken = ""
 	user.WatcherActive = false
+	user.WatcherAuthSuspended = false
 	user.WatcherError = ""
 	user.WatcherDelay = 15
 	user.IgnoreDetails = false
@@ -93,7 +165,9 @@ func NewUser(userMap *UserMap, userId int64, friendlyName string) *User {
 }
 
 func (user *User) GetHumanReadableUserInfo() (string, error) {
+	user.mutex().Lock()
 	data, err := yaml.Marshal(user)
+	user.mutex().Unlock()
 	if err != nil {
 		return "", err
 	}
@@ -101,6 +175,60 @@ func (user *User) GetHumanReadableUserInfo() (string, error) {
 	return string(data), nil
 }
 
+// clone returns a copy of the user with a lock of its own. The state changed
+// by updates and car actions is copied, other maps and slices are shared.
+func (user *User) clone() *User {
+	user.mutex().Lock()
+	defer user.mutex().Unlock()
+
+	clone := new(User)
+	copyUserFields(clone, user)
+	clone.Filters = slices.Clone(user.Filters)
+	clone.SnoozedCars = maps.Clone(user.SnoozedCars)
+	clone.SavedSearches = copySavedSearches(user.SavedSearches)
+	clone.RecentSearches = slices.Clone(user.RecentSearches)
+	if user.WatchedCars != nil {
+		clone.WatchedCars = make(map[string]*WatchedCar, len(user.WatchedCars))
+		for ident, watched := range user.WatchedCars {
+			copied := *watched
+			clone.WatchedCars[ident] = &copied
+		}
+	}
+
+	return clone
+}
+
+// copyUserFields copies all exported fields, the lock of dst is kept.
+func copyUserFields(dst *User, src *User) {
+	dstValue, srcValue := reflect.ValueOf(dst).Elem(), reflect.ValueOf(src).Elem()
+	for i := 0; i < dstValue.NumField(); i++ {
+		if dstValue.Type().Field(i).IsExported() {
+			dstValue.Field(i).Set(srcValue.Field(i))
+		}
+	}
+}
+
+// Redacted returns a copy of the user without its tokens and secrets, e.g.
+// for printing it.
+func (user *User) Redacted() *User {
+	redacted := user.clone()
+	for _, secret := range []*string{
+		&redacted.LeaseplanToken,
+		&redacted.ApiKeyHash,
+		&redacted.WebhookSecret,
+		&redacted.EmailConfirmationCode,
+		&redacted.NtfyToken,
+		&redacted.GotifyToken,
+		&redacted.MatrixAccessToken,
+	} {
+		if *secret != "" {
+			*secret = "<redacted>"
+		}
+	}
+
+	return redacted
+}
+
 func (user *User) GetHumanReadableFilterList() (string, error) {
 	data, err := yaml.Marshal(user.Filters)
 	if err != nil {
@@ -122,27 +250,142 @@ func (user *User) LoadUserCache() {
 	} else {
 		fmt.Printf("Loaded usercache for %s: %d -> %d (+%d, -%d)\n", user.FriendlyName, len(user.LastFrame.Previous), len(user.LastFrame.Current), len(user.LastFrame.Added), len(user.LastFrame.Removed))
 	}
+	user.loadAssortmentHistory()
 }
 
 func (user *User) SaveUserCache() {
+	user.mutex().Lock()
+	frame := user.LastFrame
+	user.mutex().Unlock()
+
 	os.MkdirAll(cacheBasePath, os.ModePerm)
-	user.LastFrame.SaveToFile(fmt.Sprintf("%s/%d.lastframe", cacheBasePath, user.UserId))
+	frame.SaveToFile(fmt.Sprintf("%s/%d.lastframe", cacheBasePath, user.UserId))
+}
+
+// ClearCache drops the last known frame so the next update reports all
+// current cars as new. The stats of the user are dropped as well.
+func (user *User) ClearCache() {
+	frame := NewEmptyDataFrame()
+	frame.Timestamp = time.Now().Add(-24 * time.Hour)
+	user.mutex().Lock()
+	user.LastFrame = frame
+	user.AssortmentHistory = nil
+	user.mutex().Unlock()
+
+	os.Remove(fmt.Sprintf("%s/%d.lastframe", cacheBasePath, user.UserId))
+	os.Remove(getUserStatsPath(user.UserId))
 }
 
 func (user *User) StartWatcher() {
 	user.WatcherActive = true
+	user.WatcherAuthSuspended = false
 	user.WatcherError = ""
 }
 
 func (user *User) StopWatcher() {
 	user.WatcherActive = false
+	user.WatcherAuthSuspended = false
+}
+
+// SuspendWatcher disables the watcher because the leaseplan token can not be
+// used anymore. In contrast to StopWatcher the watcher is restored on the
+// next successful login if it was active before.
+func (user *User) SuspendWatcher(reason string) {
+	if user.WatcherActive {
+		user.WatcherAuthSuspended = true
+	}
+	user.WatcherActive = false
+	user.WatcherError = reason
+}
+
+// SetLeaseplanToken stores a new token and reports whether the watcher should
+// be (re)started, which is the case for the very first login and for watchers
+// that have been suspended due to an invalid token. A manual /pause is kept.
+func (user *User) SetLeaseplanToken(token string, expiry time.Time) bool {
+	firstLogin := user.LeaseplanToken == ""
+	restore := firstLogin || user.WatcherActive || user.WatcherAuthSuspended
+
+	user.LeaseplanToken = token
+	user.LeaseplanTokenExpiry = expiry
+	user.TokenExpiryWarned = false
+	user.WatcherError = ""
+
+	if restore {
+		user.StartWatcher()
+	}
+
+	return restore
+}
+
+// GenerateApiKey creates a new random api key for the user. Only its hash is
+// stored, so the returned key can not be recovered later on.
+func (user *User) GenerateApiKey() (string, error) {
+	data := make([]byte, 24)
+	_, err := rand.Read(data)
+	if err != nil {
+		return "", err
+	}
+
+	key := "lpb_" + hex.EncodeToString(data)
+	user.ApiKeyHash = HashApiKey(key)
+
+	return key, nil
+}
+
+func (user *User) RevokeApiKey() {
+	user.ApiKeyHash = ""
+}
+
+func (user *User) CheckApiKey(key string) bool {
+	if user.ApiKeyHash == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(user.ApiKeyHash), []byte(HashApiKey(key))) == 1
+}
+
+func HashApiKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+func (user *User) Ban() {
+	user.StopWatcher()
+	user.Banned = true
+}
+
+func (user *User) Unban() {
+	user.Banned = false
 }
 
 func (user *User) AcceptEULA() {
 	user.EULA = true
 }
 
+// SetMessageTemplates replaces both message templates if they can be rendered
+// against the last known frame. On error the previous templates are kept.
+func (user *User) SetMessageTemplates(summaryTemplate string, detailTemplate string) error {
+	oldSummary, oldDetail := user.SummaryMessageTemplate, user.DetailMessageTemplate
+	user.SummaryMessageTemplate = summaryTemplate
+	user.DetailMessageTemplate = detailTemplate
+
+	_, err := user.LastFrame.GetTestMessages(user, 1)
+	if err != nil {
+		user.SummaryMessageTemplate, user.DetailMessageTemplate = oldSummary, oldDetail
+		return err
+	}
+
+	return nil
+}
+
 func (user *User) AddFilter(filter string) {
+	user.mutex().Lock()
+	defer user.mutex().Unlock()
+
+	user.addFilter(filter)
+}
+
+func (user *User) addFilter(filter string) {
 	if user.Filters == nil {
 		user.Filters = make([]string, 0)
 	}
@@ -156,6 +399,9 @@ func (user *User) AddFilter(filter string) {
 }
 
 func (user *User) RemoveFilter(filter string) {
+	user.mutex().Lock()
+	defer user.mutex().Unlock()
+
 	if user.Filters == nil {
 		return
 	}
@@ -168,62 +414,125 @@ func (user *User) RemoveFilter(filter string) {
 	user.Filters = append(user.Filters[:index], user.Filters[index+1:]...)
 }
 
-func (user *User) Update(update []dto.Item, bot *tgbotapi.BotAPI) {
+// SetFilters replaces all filters of the user, duplicates are dropped.
+func (user *User) SetFilters(filters []string) {
+	user.mutex().Lock()
+	defer user.mutex().Unlock()
+
+	user.Filters = make([]string, 0, len(filters))
+	for _, filter := range filters {
+		user.addFilter(filter)
+	}
+}
+
+// GetFilters returns a copy of the filters of the user.
+func (user *User) GetFilters() []string {
+	user.mutex().Lock()
+	defer user.mutex().Unlock()
+
+	return slices.Clone(user.Filters)
+}
+
+// WaitForPendingMessages blocks until all delayed update messages have been
+// sent. Messages are flushed immediately once the update context is done.
+func WaitForPendingMessages() {
+	pendingMessages.Wait()
+}
+
+func (user *User) Update(ctx context.Context, update []dto.Item, bot *tgbotapi.BotAPI) {
+	// watched cars and saved searches are independent of filters and throttling
+	user.sendWatchAlerts(update, bot)
+	user.sendSearchAlerts(update, bot)
+
+	user.mutex().Lock()
 	elapsed := time.Since(user.LastFrame.Timestamp)
 	if elapsed.Minutes() < float64(user.WatcherDelay) {
+		user.mutex().Unlock()
 		log.Printf("Update for %s(%d): dropped (user throtteling, elapsed time %.2f / %d minutes)", user.FriendlyName, user.UserId, elapsed.Minutes(), user.WatcherDelay)
 		return
 	}
 
 	userLeaseplanCarsVisible.WithLabelValues(user.FriendlyName).Set(float64(len(update)))
-	filteredUpdate := FilterUpdateList(update, user.Filters)
+	filteredUpdate := FilterUpdateList(user.removeSnoozedCars(update), user.Filters, user.TemplateFuncs())
 	userLeaseplanCarsOfInterest.WithLabelValues(user.FriendlyName).Set(float64(len(filteredUpdate)))
+	user.recordAssortment(filteredUpdate)
 
 	frame := NewDataFrame(user.LastFrame.Current, filteredUpdate)
+	frame.ApplyRanking(user)
+	if frame.HasChanges {
+		user.LastFrame = frame
+	}
+	user.mutex().Unlock()
 	log.Printf("Update for %s(%d): found differences: +%d, -%d", user.FriendlyName, user.UserId, len(frame.Added), len(frame.Removed))
 
 	if frame.HasChanges {
-		messages, err := frame.GetMessages(user)
-		if err != nil {
-			log.Printf("Update for %s(%d): got an error: %s", user.FriendlyName, user.UserId, err)
-		}
-
-		totalMessagesSent.WithLabelValues(user.FriendlyName).Add(float64(len(messages)))
+		notifier := user.GetNotifier(bot)
+		delay := getSendDelay()
+		pendingMessages.Add(1)
 		go func() {
+			defer pendingMessages.Done()
 			if !user.IsAdmin {
-				time.Sleep(5 * time.Minute)
+				select {
+				case <-ctx.Done():
+					log.Printf("Update for %s(%d): flushing pending %s notification", user.FriendlyName, user.UserId, notifier.Name())
+				case <-time.After(delay):
+				}
 			}
-			for _, message := range messages {
-				bot.Send(message)
+
+			// pending notifications are still delivered on shutdown
+			err := notifier.Notify(context.Background(), user, frame)
+			if err != nil {
+				totalNotificationErrors.WithLabelValues(user.FriendlyName, notifier.Name()).Inc()
+				log.Printf("Update for %s(%d): %s notification failed: %s", user.FriendlyName, user.UserId, notifier.Name(), err)
 			}
 		}()
 
-		user.LastFrame = frame
 		user.SaveUserCache()
 	}
 }
 
-func FilterUpdateList(updateList []dto.Item, filters []string) []dto.Item {
-	result := make([]dto.Item, 0)
+// mutex returns the lock of the user. Users created by NewUser or loaded from
+// the userdata file have one already, others get it on first use.
+func (user *User) mutex() *sync.Mutex {
+	userLockInit.Lock()
+	defer userLockInit.Unlock()
 
-	// prime filter templates
-	filterTemplates := make([]string, 0)
-	for _, filter := range filters {
-		filterTemplate := fmt.Sprintf("{{%s}}", filter)
-		filterTemplates = append(filterTemplates, filterTemplate)
+	if user.lock == nil {
+		user.lock = new(sync.Mutex)
+	}
+
+	return user.lock
+}
+
+// Current returns the user of the user map with the same id. Reloads replace
+// changed users, so long running goroutines use it to pick up the changes.
+func (user *User) Current() *User {
+	if user.UserMap == nil {
+		return user
+	}
+	if current := user.UserMap.GetUser(user.UserId); current != nil {
+		return current
 	}
 
-ITEMLOOP:
+	return user
+}
+
+// takeRuntimeState moves the state of a running user that is not stored in
+// the userdata file, like its last frame, to the reloaded user.
+func (user *User) takeRuntimeState(running *User) {
+	running.mutex().Lock()
+	defer running.mutex().Unlock()
+
+	user.LastFrame = running.LastFrame
+	user.AssortmentHistory = running.AssortmentHistory
+}
+
+func FilterUpdateList(updateList []dto.Item, filters []string, funcs ...template.FuncMap) []dto.Item {
+	result := make([]dto.Item, 0)
 	for _, item := range updateList {
-		for _, filterTemplate := range filterTemplates {
-			resultString, err := fillTemplate(filterTemplate, item)
-			if err == nil {
-				resultBool, err := strconv.ParseBool(resultString)
-				if err == nil && resultBool == false {
-					// skip this car and not include in result list
-					continue ITEMLOOP
-				}
-			}
+		if _, rejected := RejectingFilter(item, filters, funcs...); rejected {
+			// skip this car and not include in result list
+			continue
 		}
 
 		result = append(result, item)
@@ -231,3 +540,20 @@ ITEMLOOP:
 
 	return result
 }
+
+// RejectingFilter returns the first filter evaluating to false for the car.
+// Filters failing to render do not reject a car.
+func RejectingFilter(car dto.Item, filters []string, funcs ...template.FuncMap) (string, bool) {
+	for _, filter := range filters {
+		resultString, err := fillTemplate(fmt.Sprintf("{{%s}}", filter), car, funcs...)
+		if err != nil {
+			continue
+		}
+		resultBool, err := strconv.ParseBool(resultString)
+		if err == nil && resultBool == false {
+			return filter, true
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,40 @@
+package config
+
+import (
+	"errors"
+	"os"
+)
+
+var (
+	ErrUserDataLocked = errors.New("userdata is locked by another process")
+)
+
+// UserDataLock is an exclusive lock on a userdata file. The bot holds it as
+// long as it is running, so the command line tools do not change the file
+// while the bot would overwrite the changes with its own state.
+type UserDataLock struct {
+	file *os.File
+}
+
+// LockUserData locks the userdata file using <userDataFile>.lock. It does not
+// wait for the lock but returns ErrUserDataLocked if it is held by another
+// process.
+func LockUserData(userDataFile string) (*UserDataLock, error) {
+	file, err := os.OpenFile(userDataFile+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	err = lockFile(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &UserDataLock{file: file}, nil
+}
+
+// Unlock releases the lock, the lock file is kept.
+func (lock *UserDataLock) Unlock() error {
+	return lock.file.Close()
+}
@@ -1,23 +1,46 @@
 package config
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"log"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 
+	"golang.org/x/exp/slices"
 	"gopkg.in/yaml.v2"
 )
 
+var (
+	ErrUserNotFound  = errors.New("user not found")
+	ErrUserAmbiguous = errors.New("user name is ambiguous")
+)
+
+type UserMapChanges struct {
+	Added   []*User
+	Removed []*User
+	Changed []*User
+}
+
 type UserMap struct {
-	Path  string          `yaml:"-"`
-	Users map[int64]*User `yaml:"Users,omitempty"`
+	// lock guards Users, which is read and written by the watchers, the
+	// telegram handlers, the api and reloads at the same time
+	lock sync.RWMutex
+
+	Path          string                `yaml:"-"`
+	Users         map[int64]*User       `yaml:"Users,omitempty"`
+	Notifications []*SystemNotification `yaml:"Notifications,omitempty"`
 }
 
 func NewUserMap(userDataFile string) *UserMap {
 	userMap := new(UserMap)
 	userMap.Path = userDataFile
 	userMap.Users = make(map[int64]*User)
+	userMap.Notifications = make([]*SystemNotification, 0)
 	return userMap
 }
 
@@ -33,13 +56,21 @@ func LoadUserMap(userDataFile string) (*UserMap, error) {
 	return userMap, nil
 }
 
-func (userMap *UserMap) LoadFromFile(userDataFile string) error {
-	strData, err := os.ReadFile(userDataFile)
+// ReadUserMap reads the userdata file without loading the caches of the
+// users, e.g. for command line tools running next to the bot.
+func ReadUserMap(userDataFile string) (*UserMap, error) {
+	userMap := NewUserMap(userDataFile)
+	err := userMap.readFile(userDataFile)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	userMap.fixUserBackReference()
+
+	return userMap, nil
+}
 
-	err = yaml.Unmarshal(strData, userMap)
+func (userMap *UserMap) LoadFromFile(userDataFile string) error {
+	err := userMap.readFile(userDataFile)
 	if err != nil {
 		return err
 	}
@@ -53,21 +84,94 @@ func (userMap *UserMap) LoadFromFile(userDataFile string) error {
 	return nil
 }
 
-func (userMap *UserMap) Reload() error {
+func (userMap *UserMap) readFile(userDataFile string) error {
+	strData, err := os.ReadFile(userDataFile)
+	if err != nil {
+		return err
+	}
+
+	return yaml.Unmarshal(strData, userMap)
+}
+
+// Reload re-reads the userdata file and merges it into the loaded users.
+// Known users are updated in place so running watchers and pending updates
+// keep working on the same *User.
+func (userMap *UserMap) Reload() (*UserMapChanges, error) {
 	if userMap.Path == "" {
-		return errors.New("Path of userMap has not been set")
+		return nil, errors.New("Path of userMap has not been set")
 	}
 
-	err := userMap.LoadFromFile(userMap.Path)
+	loaded := NewUserMap(userMap.Path)
+	err := loaded.readFile(userMap.Path)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	userMap.lock.Lock()
+	defer userMap.lock.Unlock()
+
+	changes := new(UserMapChanges)
+	for userId, user := range loaded.Users {
+		user.UserMap = userMap
+		user.mutex()
+
+		existing, exists := userMap.Users[userId]
+		if !exists {
+			user.LoadUserCache()
+			userMap.Users[userId] = user
+			changes.Added = append(changes.Added, user)
+			continue
+		}
+
+		if sameUserData(existing, user) {
+			continue
+		}
+
+		// the running user is replaced instead of changed, as pending
+		// notifications and the watcher may still read it
+		user.takeRuntimeState(existing)
+		userMap.Users[userId] = user
+		changes.Changed = append(changes.Changed, user)
+	}
+
+	for userId, existing := range userMap.Users {
+		if _, exists := loaded.Users[userId]; !exists {
+			delete(userMap.Users, userId)
+			changes.Removed = append(changes.Removed, existing)
+		}
+	}
+
+	userMap.Notifications = loaded.Notifications
+
+	return changes, nil
+}
+
+func sameUserData(running *User, loaded *User) bool {
+	running.mutex().Lock()
+	dataA, errA := yaml.Marshal(running)
+	running.mutex().Unlock()
+	dataB, errB := yaml.Marshal(loaded)
+
+	return errA == nil && errB == nil && bytes.Equal(dataA, dataB)
 }
 
 func (userMap *UserMap) SaveToFile(userDataFile string) error {
+	userMap.lock.RLock()
+	// users are locked in order of their ids while they are marshalled, so
+	// updates and car actions can not change them meanwhile
+	userIds := make([]int64, 0, len(userMap.Users))
+	for userId := range userMap.Users {
+		userIds = append(userIds, userId)
+	}
+	sort.Slice(userIds, func(i, j int) bool { return userIds[i] < userIds[j] })
+	for _, userId := range userIds {
+		userMap.Users[userId].mutex().Lock()
+	}
 	data, err := yaml.Marshal(userMap)
+	for _, userId := range userIds {
+		userMap.Users[userId].mutex().Unlock()
+	}
+	userMap.lock.RUnlock()
 	if err != nil {
 		return err
 	}
@@ -94,13 +198,16 @@ func (userMap *UserMap) Save() error {
 }
 
 func (userMap *UserMap) CreateNewUser(userId int64, friendlyName string) (*User, error) {
+	userMap.lock.Lock()
 	_, exists := userMap.Users[userId]
 	if exists {
+		userMap.lock.Unlock()
 		return nil, errors.New(fmt.Sprintf("User with ID: %d does already exist", userId))
 	}
 
 	newUser := NewUser(userMap, userId, friendlyName)
 	userMap.Users[userId] = newUser
+	userMap.lock.Unlock()
 
 	err := userMap.Save()
 	if err != nil {
@@ -110,9 +217,155 @@ func (userMap *UserMap) CreateNewUser(userId int64, friendlyName string) (*User,
 	return newUser, nil
 }
 
+// FindUser looks up a user by its telegram id or its (case insensitive)
+// friendly name.
+func (userMap *UserMap) FindUser(query string) (*User, error) {
+	userMap.lock.RLock()
+	defer userMap.lock.RUnlock()
+
+	userId, err := strconv.ParseInt(query, 10, 64)
+	if err == nil {
+		user, exists := userMap.Users[userId]
+		if exists {
+			return user, nil
+		}
+	}
+
+	var found *User
+	for _, user := range userMap.Users {
+		if !strings.EqualFold(user.FriendlyName, query) {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("%w: %s", ErrUserAmbiguous, query)
+		}
+		found = user
+	}
+
+	if found == nil {
+		return nil, fmt.Errorf("%w: %s", ErrUserNotFound, query)
+	}
+
+	return found, nil
+}
+
+func (userMap *UserMap) FindUserByApiKey(key string) *User {
+	userMap.lock.RLock()
+	defer userMap.lock.RUnlock()
+
+	for _, user := range userMap.Users {
+		if user.CheckApiKey(key) {
+			return user
+		}
+	}
+
+	return nil
+}
+
+// SortedUsers returns all users ordered by their telegram id.
+func (userMap *UserMap) SortedUsers() []*User {
+	userMap.lock.RLock()
+	defer userMap.lock.RUnlock()
+
+	users := make([]*User, 0, len(userMap.Users))
+	for _, user := range userMap.Users {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].UserId < users[j].UserId })
+
+	return users
+}
+
+// GetUser returns the user with the telegram id or nil if it does not exist.
+func (userMap *UserMap) GetUser(userId int64) *User {
+	userMap.lock.RLock()
+	defer userMap.lock.RUnlock()
+
+	return userMap.Users[userId]
+}
+
+// SetUser adds the user or replaces the user with the same telegram id.
+func (userMap *UserMap) SetUser(user *User) {
+	userMap.lock.Lock()
+	defer userMap.lock.Unlock()
+
+	user.UserMap = userMap
+	userMap.Users[user.UserId] = user
+}
+
+// DeleteUser removes the user and reports whether it existed.
+func (userMap *UserMap) DeleteUser(userId int64) bool {
+	userMap.lock.Lock()
+	defer userMap.lock.Unlock()
+
+	_, exists := userMap.Users[userId]
+	delete(userMap.Users, userId)
+
+	return exists
+}
+
+func (userMap *UserMap) CountUsers() int {
+	userMap.lock.RLock()
+	defer userMap.lock.RUnlock()
+
+	return len(userMap.Users)
+}
+
+// AddNotification stores a new system notification and assigns its id.
+func (userMap *UserMap) AddNotification(notification *SystemNotification) {
+	userMap.lock.Lock()
+	defer userMap.lock.Unlock()
+
+	notification.Id = 1
+	for _, existing := range userMap.Notifications {
+		if existing.Id >= notification.Id {
+			notification.Id = existing.Id + 1
+		}
+	}
+
+	userMap.Notifications = append(userMap.Notifications, notification)
+}
+
+// GetNotifications returns a copy of the list of system notifications, the
+// notifications themselves are shared.
+func (userMap *UserMap) GetNotifications() []*SystemNotification {
+	userMap.lock.RLock()
+	defer userMap.lock.RUnlock()
+
+	return slices.Clone(userMap.Notifications)
+}
+
+func (userMap *UserMap) FindNotification(id int) *SystemNotification {
+	userMap.lock.RLock()
+	defer userMap.lock.RUnlock()
+
+	for _, notification := range userMap.Notifications {
+		if notification.Id == id {
+			return notification
+		}
+	}
+
+	return nil
+}
+
+func (userMap *UserMap) RemoveNotification(id int) bool {
+	userMap.lock.Lock()
+	defer userMap.lock.Unlock()
+
+	for index, notification := range userMap.Notifications {
+		if notification.Id == id {
+			userMap.Notifications = append(userMap.Notifications[:index], userMap.Notifications[index+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
 func (userMap *UserMap) fixUserBackReference() error {
 	for _, user := range userMap.Users {
 		user.UserMap = userMap
+		user.mutex()
 	}
 
 	return nil
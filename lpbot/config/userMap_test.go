@@ -0,0 +1,183 @@
+package config_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/khase/leaseplan-bot/lpbot/config"
+	"github.com/khase/leaseplanabocarexporter/dto"
+)
+
+func TestUserMapReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.userdata")
+
+	userMap := config.NewUserMap(path)
+	kept, _ := userMap.CreateNewUser(1, "kept")
+	changed, _ := userMap.CreateNewUser(2, "changed")
+	userMap.CreateNewUser(3, "removed")
+
+	onDisk, err := config.LoadUserMap(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	onDisk.Users[2].WatcherDelay = 60
+	delete(onDisk.Users, 3)
+	onDisk.CreateNewUser(4, "added")
+
+	changes, err := userMap.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(changes.Added) != 1 || len(changes.Removed) != 1 || len(changes.Changed) != 1 {
+		t.Fatalf("expected 1 added, 1 removed and 1 changed user but got %d, %d, %d", len(changes.Added), len(changes.Removed), len(changes.Changed))
+	}
+	if userMap.GetUser(1) != kept {
+		t.Fatalf("expected unchanged users to be kept")
+	}
+	// changed users are replaced, so goroutines still using them see no changes
+	reloaded := userMap.GetUser(2)
+	if reloaded == changed || changed.Current() != reloaded || changes.Changed[0] != reloaded {
+		t.Fatalf("expected the changed user to be replaced")
+	}
+	if reloaded.WatcherDelay != 60 || changed.WatcherDelay != 15 {
+		t.Fatalf("expected WatcherDelay to be reloaded as 60 but got %d (was %d)", reloaded.WatcherDelay, changed.WatcherDelay)
+	}
+	if _, exists := userMap.Users[3]; exists {
+		t.Fatalf("expected user 3 to be removed")
+	}
+}
+
+func TestUserMapReloadKeepsRuntimeState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.userdata")
+
+	userMap := config.NewUserMap(path)
+	user, _ := userMap.CreateNewUser(1, "running")
+	frame := config.NewEmptyDataFrame()
+	user.LastFrame = frame
+	user.AssortmentHistory = []config.AssortmentSample{{Count: 3}}
+
+	onDisk, err := config.ReadUserMap(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	onDisk.GetUser(1).Filters = []string{"lt .SalaryWaiver 500"}
+	onDisk.Save()
+
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			userMap.GetUser(1)
+			userMap.SortedUsers()
+		}
+	}()
+	if _, err := userMap.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	reloaded := user.Current()
+	if reloaded.LastFrame != frame || len(reloaded.AssortmentHistory) != 1 || reloaded.UserMap != userMap {
+		t.Fatalf("expected the runtime state of the user to be kept")
+	}
+	if len(reloaded.Filters) != 1 || len(user.Filters) != 0 {
+		t.Fatalf("expected the filters to be reloaded into the new user but got %v", reloaded.Filters)
+	}
+}
+
+func TestUserMapReloadDuringUpdate(t *testing.T) {
+	dir := t.TempDir()
+	config.SetCacheDir(dir)
+	defer config.SetCacheDir(config.DefaultCacheDir)
+	path := filepath.Join(dir, "test.userdata")
+	userMap := config.NewUserMap(path)
+	cars := []dto.Item{newTestCar(testCar{Ident: "1", Label: "BMW", Model: "i4"})}
+	user, _ := userMap.CreateNewUser(1, "running")
+	user.WatcherDelay = 0
+	user.LastFrame = config.NewDataFrame(nil, cars)
+
+	onDisk, err := config.ReadUserMap(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			user.Update(context.Background(), cars, nil)
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		onDisk.GetUser(1).WatcherDelay = int32(i + 1)
+		onDisk.Save()
+		if _, err := userMap.Reload(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+
+	if user.Current().WatcherDelay != 20 || user.WatcherDelay != 0 {
+		t.Fatalf("expected only the reloaded user to change")
+	}
+}
+
+func TestFindUser(t *testing.T) {
+	userMap := config.NewUserMap(filepath.Join(t.TempDir(), "test.userdata"))
+	userMap.CreateNewUser(1, "Anna")
+	userMap.CreateNewUser(2, "Ben")
+	userMap.CreateNewUser(3, "ben")
+
+	user, err := userMap.FindUser("anna")
+	if err != nil || user.UserId != 1 {
+		t.Fatalf("expected to find user 1 by name but got %v, %v", user, err)
+	}
+
+	user, err = userMap.FindUser("2")
+	if err != nil || user.UserId != 2 {
+		t.Fatalf("expected to find user 2 by id but got %v, %v", user, err)
+	}
+
+	_, err = userMap.FindUser("BEN")
+	if !errors.Is(err, config.ErrUserAmbiguous) {
+		t.Fatalf("expected ErrUserAmbiguous but got %v", err)
+	}
+
+	_, err = userMap.FindUser("carl")
+	if !errors.Is(err, config.ErrUserNotFound) {
+		t.Fatalf("expected ErrUserNotFound but got %v", err)
+	}
+}
+
+func TestUserMapNotifications(t *testing.T) {
+	userMap := config.NewUserMap(filepath.Join(t.TempDir(), "test.userdata"))
+	first := config.NewSystemNotification(time.Now(), "first")
+	userMap.AddNotification(first)
+
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			for _, notification := range userMap.GetNotifications() {
+				userMap.FindNotification(notification.Id)
+			}
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		notification := config.NewSystemNotification(time.Now(), "temporary")
+		userMap.AddNotification(notification)
+		if notification.Id != 2 {
+			t.Fatalf("expected the next free id 2 but got %d", notification.Id)
+		}
+		userMap.RemoveNotification(notification.Id)
+	}
+	<-done
+
+	if notifications := userMap.GetNotifications(); len(notifications) != 1 || notifications[0] != first || userMap.FindNotification(1) != first {
+		t.Fatalf("expected only the first notification to be left but got %+v", notifications)
+	}
+}
@@ -0,0 +1,188 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/khase/leaseplanabocarexporter/dto"
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	ErrInvalidUserData  = errors.New("invalid user data")
+	ErrUnknownUserField = errors.New("unknown user field")
+
+	// readOnlyUserFields can not be changed with SetField, the user id is
+	// the key of the user in the userdata.
+	readOnlyUserFields = []string{"UserId"}
+
+	knownNotifiers  = []string{"", NotifierTelegram, NotifierWebhook, NotifierEmail, NotifierNtfy, NotifierGotify, NotifierMatrix}
+	knownChatTypes  = []string{"", ChatTypeGroup, ChatTypeSupergroup, ChatTypeChannel}
+	knownDetailMode = []string{"", DetailModeText, DetailModeRich}
+)
+
+// Validate checks the settings of the user like the bot commands do before
+// changing them. It is used for changes which bypass the commands, e.g. by
+// the command line tools.
+func (user *User) Validate() error {
+	if user.UserId == 0 {
+		return fmt.Errorf("%w: UserId is missing", ErrInvalidUserData)
+	}
+	if user.WatcherDelay < 0 {
+		return fmt.Errorf("%w: WatcherDelay must not be negative", ErrInvalidUserData)
+	}
+	if user.RankTopN < 0 {
+		return fmt.Errorf("%w: RankTopN must not be negative", ErrInvalidUserData)
+	}
+	if !slices.Contains(knownNotifiers, user.Notifier) {
+		return fmt.Errorf("%w: unknown Notifier %s", ErrInvalidUserData, user.Notifier)
+	}
+	if !slices.Contains(knownChatTypes, user.ChatType) {
+		return fmt.Errorf("%w: unknown ChatType %s", ErrInvalidUserData, user.ChatType)
+	}
+	if !slices.Contains(knownDetailMode, user.DetailMode) {
+		return fmt.Errorf("%w: unknown DetailMode %s", ErrInvalidUserData, user.DetailMode)
+	}
+
+	for _, filter := range user.Filters {
+		_, err := template.New("filter").Funcs(user.TemplateFuncs()).Parse(fmt.Sprintf("{{%s}}", filter))
+		if err != nil {
+			return fmt.Errorf("%w: Filters: %s", ErrInvalidUserData, err)
+		}
+	}
+
+	// the setters validate their input, so they are applied to a copy
+	// rendering a frame with a single empty car
+	testUser := user.clone()
+	testUser.LastFrame = NewDataFrame([]dto.Item{{}}, []dto.Item{{}})
+	testUser.LastFrame.Added = []dto.Item{{}}
+	testUser.LastFrame.Removed = []dto.Item{{}}
+
+	checks := []struct {
+		field string
+		check func() error
+	}{
+		{"SummaryMessageTemplate/DetailMessageTemplate", func() error {
+			return testUser.SetMessageTemplates(user.SummaryMessageTemplate, user.DetailMessageTemplate)
+		}},
+		{"GroupBy", func() error { return testUser.SetGroupBy(user.GroupBy) }},
+		{"GroupMessageTemplate", func() error {
+			if user.GroupMessageTemplate == "" {
+				return nil
+			}
+			return testUser.SetGroupMessageTemplate(user.GroupMessageTemplate)
+		}},
+		{"SortOrder", func() error { return testUser.SetSortOrder(user.SortOrder) }},
+		{"RankMetric/RankTopN", func() error { return testUser.SetRanking(user.RankMetric, user.RankTopN) }},
+		{"EmailSubjectTemplate/EmailHtmlTemplate", func() error {
+			return testUser.SetEmailTemplates(user.EmailSubjectTemplate, user.EmailHtmlTemplate)
+		}},
+	}
+	for _, check := range checks {
+		if err := check.check(); err != nil {
+			return fmt.Errorf("%w: %s: %s", ErrInvalidUserData, check.field, err)
+		}
+	}
+
+	return nil
+}
+
+// UserFields lists the yaml keys of the settings which can be changed with
+// SetField.
+func UserFields() []string {
+	fields := []string{}
+	userType := reflect.TypeOf(User{})
+	for i := 0; i < userType.NumField(); i++ {
+		name := userFieldName(userType.Field(i))
+		if name != "" && !slices.Contains(readOnlyUserFields, name) {
+			fields = append(fields, name)
+		}
+	}
+
+	return fields
+}
+
+func userFieldName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	if name == "-" || !field.IsExported() {
+		return ""
+	}
+
+	return name
+}
+
+// SetField changes a single setting by its yaml key, the key is case
+// insensitive. Strings are taken as they are, numbers, booleans and times
+// (RFC 3339) are parsed and lists or maps are read as yaml. The user is only
+// changed if it is valid afterwards, see Validate.
+func (user *User) SetField(key string, value string) error {
+	updated := user.clone()
+	userValue := reflect.ValueOf(updated).Elem()
+
+	var field reflect.Value
+	for i := 0; i < userValue.NumField(); i++ {
+		name := userFieldName(userValue.Type().Field(i))
+		if name != "" && strings.EqualFold(name, key) && !slices.Contains(readOnlyUserFields, name) {
+			field = userValue.Field(i)
+			break
+		}
+	}
+	if !field.IsValid() {
+		return fmt.Errorf("%w: %s", ErrUnknownUserField, key)
+	}
+
+	if err := setFieldValue(field, value); err != nil {
+		return fmt.Errorf("%w: %s: %s", ErrInvalidUserData, key, err)
+	}
+	if err := updated.Validate(); err != nil {
+		return err
+	}
+	user.mutex().Lock()
+	copyUserFields(user, updated)
+	user.mutex().Unlock()
+
+	return nil
+}
+
+func setFieldValue(field reflect.Value, value string) error {
+	if _, isTime := field.Interface().(time.Time); isTime {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return err
+		}
+		field.Set(reflect.ValueOf(parsed))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(parsed)
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		parsed, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(parsed)
+	default:
+		// do not merge into the maps shared with the original user
+		target := reflect.New(field.Type())
+		if err := yaml.UnmarshalStrict([]byte(value), target.Interface()); err != nil {
+			return err
+		}
+		field.Set(target.Elem())
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package config_test
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/khase/leaseplan-bot/lpbot/config"
+)
+
+func TestUserSetField(t *testing.T) {
+	user := config.NewUser(nil, 123, "fields")
+
+	if err := user.SetField("summarymessagetemplate", "{{ len .Added }} neu"); err != nil || user.SummaryMessageTemplate != "{{ len .Added }} neu" {
+		t.Fatalf("expected the template to be set but got %q, %v", user.SummaryMessageTemplate, err)
+	}
+	if err := user.SetField("SummaryMessageTemplate", "{{ .Broken"); !errors.Is(err, config.ErrInvalidUserData) || user.SummaryMessageTemplate != "{{ len .Added }} neu" {
+		t.Fatalf("expected broken templates to be rejected but got %v", err)
+	}
+	if err := user.SetField("DetailMessageTemplate", "{{ .Unknown }}"); !errors.Is(err, config.ErrInvalidUserData) {
+		t.Fatalf("expected templates failing to render to be rejected but got %v", err)
+	}
+
+	if err := user.SetField("WatcherDelay", "30"); err != nil || user.WatcherDelay != 30 {
+		t.Fatalf("expected the delay to be parsed but got %d, %v", user.WatcherDelay, err)
+	}
+	if err := user.SetField("WatcherDelay", "-1"); !errors.Is(err, config.ErrInvalidUserData) || user.WatcherDelay != 30 {
+		t.Fatalf("expected negative delays to be rejected but got %v", err)
+	}
+	if err := user.SetField("IgnoreRemoved", "yes"); !errors.Is(err, config.ErrInvalidUserData) {
+		t.Fatalf("expected invalid booleans to be rejected but got %v", err)
+	}
+
+	if err := user.SetField("Filters", `["lt .SalaryWaiver 500", "eq .RentalObject.KindOfFuel \"Elektro\""]`); err != nil || len(user.Filters) != 2 {
+		t.Fatalf("expected the filters to be read as yaml but got %v, %v", user.Filters, err)
+	}
+	if err := user.SetField("Filters", `["lt .SalaryWaiver"]`); err != nil {
+		t.Fatalf("expected filters failing to render to be accepted but got %v", err)
+	}
+	if err := user.SetField("Filters", `["(lt"]`); !errors.Is(err, config.ErrInvalidUserData) {
+		t.Fatalf("expected filters which do not parse to be rejected but got %v", err)
+	}
+
+	if err := user.SetField("SortOrder", "-bgv"); err != nil || user.SortOrder != "-bgv" {
+		t.Fatalf("expected the sort order to be set but got %q, %v", user.SortOrder, err)
+	}
+	if err := user.SetField("SortOrder", "color"); !errors.Is(err, config.ErrInvalidUserData) || user.SortOrder != "-bgv" {
+		t.Fatalf("expected unknown sort orders to be rejected but got %v", err)
+	}
+	if err := user.SetField("Notifier", "pigeon"); !errors.Is(err, config.ErrInvalidUserData) {
+		t.Fatalf("expected unknown notifiers to be rejected but got %v", err)
+	}
+
+	if err := user.SetField("UserId", "1"); !errors.Is(err, config.ErrUnknownUserField) || user.UserId != 123 {
+		t.Fatalf("expected the user id to be read only but got %v", err)
+	}
+	if err := user.SetField("LastFrame", "{}"); !errors.Is(err, config.ErrUnknownUserField) {
+		t.Fatalf("expected fields not stored in the userdata to be rejected but got %v", err)
+	}
+}
+
+func TestLockUserData(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.userdata")
+
+	lock, err := config.LockUserData(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := config.LockUserData(path); !errors.Is(err, config.ErrUserDataLocked) {
+		t.Fatalf("expected the userdata to be locked but got %v", err)
+	}
+
+	lock.Unlock()
+	lock, err = config.LockUserData(path)
+	if err != nil {
+		t.Fatalf("expected the lock to be released but got %v", err)
+	}
+	lock.Unlock()
+}
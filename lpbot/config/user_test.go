@@ -2,7 +2,9 @@ package config_test
 
 import (
 	"log"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/khase/leaseplan-bot/lpbot/config"
 	"github.com/khase/leaseplanabocarexporter/dto"
@@ -39,3 +41,66 @@ func TestFilter(t *testing.T) {
 		t.Fatalf("expected list not to contain cars from Volvo")
 	}
 }
+
+func TestLoginRestoresSuspendedWatcher(t *testing.T) {
+	user := config.NewUser(nil, 123, "test")
+	user.SetLeaseplanToken("token", time.Time{})
+	if !user.WatcherActive {
+		t.Fatalf("expected first login to activate the watcher")
+	}
+
+	user.SuspendWatcher("token expired")
+	if user.WatcherActive || !user.WatcherAuthSuspended {
+		t.Fatalf("expected watcher to be suspended")
+	}
+
+	if !user.SetLeaseplanToken("new token", time.Time{}) || !user.WatcherActive {
+		t.Fatalf("expected login to restore the suspended watcher")
+	}
+
+	user.StopWatcher()
+	if user.SetLeaseplanToken("newer token", time.Time{}) || user.WatcherActive {
+		t.Fatalf("expected login to keep a manually paused watcher paused")
+	}
+}
+
+func TestApiKey(t *testing.T) {
+	user := config.NewUser(nil, 123, "test")
+	if user.CheckApiKey("") {
+		t.Fatalf("expected users without api key to reject every key")
+	}
+
+	key, err := user.GenerateApiKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !user.CheckApiKey(key) || user.CheckApiKey(key+"x") {
+		t.Fatalf("expected only the generated key to be accepted")
+	}
+	if strings.Contains(user.ApiKeyHash, key) {
+		t.Fatalf("expected the key not to be stored in plain text")
+	}
+
+	user.RevokeApiKey()
+	if user.CheckApiKey(key) {
+		t.Fatalf("expected revoked key to be rejected")
+	}
+}
+
+func TestUserRedacted(t *testing.T) {
+	user := config.NewUser(nil, 123, "test")
+	user.SetLeaseplanToken("token", time.Time{})
+	user.NtfyToken = "ntfy token"
+
+	redacted := user.Redacted()
+	info, err := redacted.GetHumanReadableUserInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(info, "token\n") || strings.Contains(info, "ntfy token") || redacted.GotifyToken != "" {
+		t.Fatalf("expected the secrets to be redacted but got %s", info)
+	}
+	if user.LeaseplanToken != "token" || user.NtfyToken != "ntfy token" {
+		t.Fatalf("expected the user itself to keep its secrets")
+	}
+}
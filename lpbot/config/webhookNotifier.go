@@ -0,0 +1,181 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/khase/leaseplanabocarexporter/dto"
+)
+
+const (
+	WebhookSignatureHeader = "X-Leaseplan-Bot-Signature"
+
+	webhookAttempts   = 3
+	webhookRetryDelay = 2 * time.Second
+)
+
+var (
+	ErrInvalidWebhookUrl = errors.New("webhook url has to be an absolute http(s) url")
+
+	webhookClient = newOutboundClient(10 * time.Second)
+)
+
+// WebhookPayload is the json body posted to webhooks.
+type WebhookPayload struct {
+	UserId    int64      `json:"UserId"`
+	LevelKey  string     `json:"LevelKey"`
+	Timestamp time.Time  `json:"Timestamp"`
+	Added     []dto.Item `json:"Added"`
+	Removed   []dto.Item `json:"Removed"`
+
+	// Alert is the text of an alert, e.g. about a watched car, without
+	// added or removed cars
+	Alert string `json:"Alert,omitempty"`
+}
+
+// WebhookNotifier posts the changes of a frame as json to an url. The body is
+// signed with HMAC-SHA256 using the secret of the user, failed deliveries are
+// retried with an increasing delay.
+type WebhookNotifier struct {
+	Url    string
+	Secret string
+
+	// RetryDelay is the delay before the first retry, defaults to 2 seconds
+	RetryDelay time.Duration
+}
+
+func (notifier *WebhookNotifier) Name() string {
+	return NotifierWebhook
+}
+
+func (notifier *WebhookNotifier) Notify(ctx context.Context, user *User, frame *DataFrame) error {
+	frame = frame.SortedFor(user)
+	body, err := json.Marshal(&WebhookPayload{
+		UserId:    user.UserId,
+		LevelKey:  user.LeaseplanLevelKey,
+		Timestamp: frame.Timestamp,
+		Added:     frame.Added,
+		Removed:   frame.Removed,
+	})
+	if err != nil {
+		return err
+	}
+
+	return notifier.deliver(ctx, body)
+}
+
+func (notifier *WebhookNotifier) Alert(ctx context.Context, user *User, text MessageText) error {
+	body, err := json.Marshal(&WebhookPayload{
+		UserId:    user.UserId,
+		LevelKey:  user.LeaseplanLevelKey,
+		Timestamp: time.Now(),
+		Added:     []dto.Item{},
+		Removed:   []dto.Item{},
+		Alert:     text.Text,
+	})
+	if err != nil {
+		return err
+	}
+
+	return notifier.deliver(ctx, body)
+}
+
+// deliver posts the body and retries failed deliveries with an increasing
+// delay.
+func (notifier *WebhookNotifier) deliver(ctx context.Context, body []byte) error {
+	delay := notifier.RetryDelay
+	if delay <= 0 {
+		delay = webhookRetryDelay
+	}
+	for attempt := 1; ; attempt++ {
+		retry, err := notifier.post(ctx, body)
+		if err == nil || !retry || attempt == webhookAttempts {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// post sends the body once and reports whether a failed delivery may succeed
+// when retried.
+func (notifier *WebhookNotifier) post(ctx context.Context, body []byte) (bool, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, notifier.Url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(WebhookSignatureHeader, SignWebhookPayload(notifier.Secret, body))
+
+	response, err := webhookClient.Do(request)
+	if err != nil {
+		return !errors.Is(err, ErrPrivateAddress), err
+	}
+	response.Body.Close()
+
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return false, nil
+	}
+
+	retry := response.StatusCode >= 500 || response.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("webhook responded with %s", response.Status)
+}
+
+// SignWebhookPayload returns the value of the signature header for a body.
+func SignWebhookPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// SetWebhook switches the notifications of the user to the given url and
+// returns the newly generated signing secret. Urls pointing to private or
+// local addresses are rejected, see ErrPrivateAddress.
+func (user *User) SetWebhook(webhookUrl string) (string, error) {
+	parsed, err := url.Parse(webhookUrl)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return "", ErrInvalidWebhookUrl
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := validateOutboundUrl(ctx, parsed); err != nil {
+		return "", err
+	}
+
+	secret := make([]byte, 32)
+	_, err = rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+
+	user.Notifier = NotifierWebhook
+	user.WebhookUrl = webhookUrl
+	user.WebhookSecret = hex.EncodeToString(secret)
+
+	return user.WebhookSecret, nil
+}
+
+// DisableWebhook switches the notifications of the user back to telegram.
+func (user *User) DisableWebhook() {
+	if user.Notifier == NotifierWebhook {
+		user.Notifier = ""
+	}
+	user.WebhookUrl = ""
+	user.WebhookSecret = ""
+}
@@ -0,0 +1,216 @@
+package config_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/khase/leaseplan-bot/lpbot/config"
+	"github.com/khase/leaseplanabocarexporter/dto"
+)
+
+func TestWebhookNotifier(t *testing.T) {
+	config.AllowPrivateAddresses(t)
+	user := newTestUser(t)
+
+	var payload config.WebhookPayload
+	var signature string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		signature = r.Header.Get(config.WebhookSignatureHeader)
+		if signature != config.SignWebhookPayload(user.WebhookSecret, body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.Unmarshal(body, &payload)
+	}))
+	defer server.Close()
+
+	secret, err := user.SetWebhook(server.URL + "/hook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret == "" || user.Notifier != config.NotifierWebhook {
+		t.Fatalf("expected the webhook to be selected")
+	}
+
+	frame := config.NewDataFrame(
+		[]dto.Item{{RentalObject: dto.RentalObject{Ident: "a"}}},
+		[]dto.Item{{RentalObject: dto.RentalObject{Ident: "b"}}})
+	err = user.GetNotifier(nil).Notify(context.Background(), user, frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if payload.UserId != 1 || payload.LevelKey != "Level 1" {
+		t.Fatalf("unexpected payload %+v", payload)
+	}
+	if len(payload.Added) != 1 || payload.Added[0].RentalObject.Ident != "b" || len(payload.Removed) != 1 || payload.Removed[0].RentalObject.Ident != "a" {
+		t.Fatalf("expected payload to contain the diff but got %+v", payload)
+	}
+
+	user.DisableWebhook()
+	if user.GetNotifier(nil).Name() != config.NotifierTelegram {
+		t.Fatalf("expected telegram to be selected after disabling the webhook")
+	}
+}
+
+func TestWebhookNotifierRetries(t *testing.T) {
+	config.AllowPrivateAddresses(t)
+	user := newTestUser(t)
+
+	responses := []int{http.StatusInternalServerError, http.StatusTooManyRequests, http.StatusOK}
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(responses[requests])
+		requests++
+	}))
+	defer server.Close()
+
+	notifier := &config.WebhookNotifier{Url: server.URL, Secret: "secret", RetryDelay: time.Millisecond}
+	err := notifier.Notify(context.Background(), user, config.NewEmptyDataFrame())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests != 3 {
+		t.Fatalf("expected 3 attempts but got %d", requests)
+	}
+
+	requests = 0
+	responses = []int{http.StatusBadRequest}
+	err = notifier.Notify(context.Background(), user, config.NewEmptyDataFrame())
+	if err == nil || requests != 1 {
+		t.Fatalf("expected client errors to fail without retry but got %d attempts (%v)", requests, err)
+	}
+
+	requests = 0
+	responses = []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway}
+	err = notifier.Notify(context.Background(), user, config.NewEmptyDataFrame())
+	if err == nil || requests != 3 {
+		t.Fatalf("expected delivery to fail after 3 attempts but got %d attempts (%v)", requests, err)
+	}
+}
+
+func TestSetWebhookValidation(t *testing.T) {
+	user := newTestUser(t)
+
+	for _, url := range []string{"", "example.com/hook", "ftp://example.com", "https://"} {
+		_, err := user.SetWebhook(url)
+		if !errors.Is(err, config.ErrInvalidWebhookUrl) {
+			t.Fatalf("expected %q to be rejected but got %v", url, err)
+		}
+	}
+	for _, url := range []string{"http://127.0.0.1:8080/hook", "http://10.0.0.1/hook", "http://169.254.169.254/latest", "http://[::1]/hook", "http://0.0.0.0/hook",
+		"http://0.1.2.3/hook", "http://100.64.0.1/hook", "http://100.127.255.254/hook", "http://[::ffff:100.64.0.1]/hook", "http://[64:ff9b::a00:1]/hook", "http://[64:ff9b::7f00:1]/hook"} {
+		_, err := user.SetWebhook(url)
+		if !errors.Is(err, config.ErrPrivateAddress) {
+			t.Fatalf("expected private address %q to be rejected but got %v", url, err)
+		}
+	}
+	if user.Notifier != "" {
+		t.Fatalf("expected notifier to stay unchanged")
+	}
+	// public addresses next to the blocked ranges
+	for _, url := range []string{"http://100.128.0.1/hook", "http://1.0.0.1/hook", "http://[64:ff9b::808:808]/hook"} {
+		if _, err := user.SetWebhook(url); err != nil {
+			t.Fatalf("expected public address %q to be accepted but got %v", url, err)
+		}
+	}
+}
+
+func TestWebhookNotifierPrivateAddress(t *testing.T) {
+	user := newTestUser(t)
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer server.Close()
+
+	// e.g. a public host name resolving to a private address later on
+	notifier := &config.WebhookNotifier{Url: server.URL, Secret: "secret", RetryDelay: time.Millisecond}
+	err := notifier.Notify(context.Background(), user, config.NewEmptyDataFrame())
+	if !errors.Is(err, config.ErrPrivateAddress) || requests != 0 {
+		t.Fatalf("expected the connection to the private address to be refused but got %d requests (%v)", requests, err)
+	}
+}
+
+func TestWebhookWatchAlerts(t *testing.T) {
+	config.AllowPrivateAddresses(t)
+	user := newTestUser(t)
+
+	var lock sync.Mutex
+	alerts := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload config.WebhookPayload
+		json.NewDecoder(r.Body).Decode(&payload)
+		if payload.Alert != "" {
+			lock.Lock()
+			alerts = append(alerts, payload.Alert)
+			lock.Unlock()
+		}
+	}))
+	defer server.Close()
+
+	if _, err := user.SetWebhook(server.URL); err != nil {
+		t.Fatal(err)
+	}
+	if err := user.WatchUnavailableCar("CCUNZEZE2022052522095673CB8DCB", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// the pending notifications are flushed right away
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	user.Update(ctx, []dto.Item{{RentalObject: dto.RentalObject{Ident: "CCUNZEZE2022052522095673CB8DCB"}}}, nil)
+	config.WaitForPendingMessages()
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(alerts) != 1 || !strings.Contains(alerts[0], "wieder verfügbar") {
+		t.Fatalf("expected the watch alert to be sent to the webhook but got %v", alerts)
+	}
+}
+
+func TestWebhookSearchAlerts(t *testing.T) {
+	config.AllowPrivateAddresses(t)
+	user := newTestUser(t)
+
+	var lock sync.Mutex
+	alerts := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload config.WebhookPayload
+		json.NewDecoder(r.Body).Decode(&payload)
+		if payload.Alert != "" {
+			lock.Lock()
+			alerts = append(alerts, payload.Alert)
+			lock.Unlock()
+		}
+	}))
+	defer server.Close()
+
+	if _, err := user.SetWebhook(server.URL); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := user.SaveSearch("stromer", "elektro", []dto.Item{}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	user.Update(ctx, []dto.Item{{OfferTypeName: "ID.3", RentalObject: dto.RentalObject{Ident: "1", KindOfFuel: "Elektro"}}}, nil)
+	config.WaitForPendingMessages()
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(alerts) != 1 || !strings.Contains(alerts[0], "Neue Treffer für stromer") {
+		t.Fatalf("expected the search alert to be sent to the webhook but got %v", alerts)
+	}
+}
@@ -24,48 +24,54 @@ var (
 		CommandTrigger:   "resume",
 		ShortDescription: "aktiviert deine update Nachrichten",
 		Description:      "",
+		GroupCommand:     true,
 		Execute: func(message *tgbotapi.Message) ([]tgbotapi.Chattable, error) {
-			return handleResumeCommand(message, UserMap.Users[message.From.ID])
+			return handleResumeCommand(message, getSubscriber(message))
 		},
 	}
 	PauseCmd = &tgcon.MessageCommand{
 		CommandTrigger:   "pause",
 		ShortDescription: "pausiert deine update Nachrichten",
 		Description:      "",
+		GroupCommand:     true,
 		Execute: func(message *tgbotapi.Message) ([]tgbotapi.Chattable, error) {
-			return handlePauseCommand(message, UserMap.Users[message.From.ID])
+			return handlePauseCommand(message, getSubscriber(message))
 		},
 	}
 	ThrottleCmd = &tgcon.MessageCommand{
 		CommandTrigger:   "throttle",
 		ShortDescription: "drosselt deine nachrichten",
 		Description:      "Drosselt deine Nachrichten sodass du nur noch maximal ein Update alle n Minuten bekommst. (Ein Update kann dennoch mehrere Nachrichten generieren)",
+		GroupCommand:     true,
 		Execute: func(message *tgbotapi.Message) ([]tgbotapi.Chattable, error) {
-			return handleThrottleCommand(message, UserMap.Users[message.From.ID])
+			return handleThrottleCommand(message, getSubscriber(message))
 		},
 	}
 	IgnoreDetailsCmd = &tgcon.MessageCommand{
 		CommandTrigger:   "ignoreDetails",
 		ShortDescription: "sendet keine details mehr",
 		Description:      "",
+		GroupCommand:     true,
 		Execute: func(message *tgbotapi.Message) ([]tgbotapi.Chattable, error) {
-			return handleIgnoreDetailsCommand(message, UserMap.Users[message.From.ID])
+			return handleIgnoreDetailsCommand(message, getSubscriber(message))
 		},
 	}
 	IgnoreRemovedCmd = &tgcon.MessageCommand{
 		CommandTrigger:   "ignoreRemoved",
 		ShortDescription: "sendet keine details für entfernte angebote",
 		Description:      "",
+		GroupCommand:     true,
 		Execute: func(message *tgbotapi.Message) ([]tgbotapi.Chattable, error) {
-			return handleIgnoreRemovedCommand(message, UserMap.Users[message.From.ID])
+			return handleIgnoreRemovedCommand(message, getSubscriber(message))
 		},
 	}
 	WhoamiCmd = &tgcon.MessageCommand{
 		CommandTrigger:   "whoami",
 		ShortDescription: "gibt alle über dich bekannten Infos zurück",
 		Description:      "",
+		GroupCommand:     true,
 		Execute: func(message *tgbotapi.Message) ([]tgbotapi.Chattable, error) {
-			return handleWhoamiCommand(message, UserMap.Users[message.From.ID])
+			return handleWhoamiCommand(message, getSubscriber(message))
 		},
 	}
 	EulaCmd = &tgcon.MessageCommand{
@@ -73,7 +79,7 @@ var (
 		ShortDescription: "End User License aggreement",
 		Description:      "",
 		Execute: func(message *tgbotapi.Message) ([]tgbotapi.Chattable, error) {
-			return handleEulaCommand(message, UserMap.Users[message.From.ID])
+			return handleEulaCommand(message, UserMap.GetUser(message.From.ID))
 		},
 	}
 )
@@ -157,7 +163,7 @@ func handleThrottleCommand(message *tgbotapi.Message, user *config.User) ([]tgbo
 			return nil, err
 		}
 
-		if !user.IsAdmin && (throttle < 15) {
+		if !user.IsAdmin && (throttle < config.MinUserWatcherDelay) {
 			text = fmt.Sprintf("Sorry, das geht nicht. Ich will kein Ärger mit Leaseplan 😨🤷‍♂️.")
 		} else {
 			user.WatcherDelay = int32(throttle)
@@ -175,8 +181,11 @@ func handleThrottleCommand(message *tgbotapi.Message, user *config.User) ([]tgbo
 }
 
 func handleStartCommand(message *tgbotapi.Message, userMap *config.UserMap) ([]tgbotapi.Chattable, error) {
-	user, exists := userMap.Users[message.From.ID]
-	if exists {
+	user := userMap.GetUser(message.From.ID)
+	if user != nil {
+		user.Language = message.From.LanguageCode
+		user.Save()
+
 		msg := tgbotapi.NewMessage(
 			message.Chat.ID,
 			fmt.Sprintf(
@@ -190,6 +199,8 @@ func handleStartCommand(message *tgbotapi.Message, userMap *config.UserMap) ([]t
 	if err != nil {
 		return nil, err
 	}
+	user.Language = message.From.LanguageCode
+	user.Save()
 
 	msg := tgbotapi.NewMessage(
 		message.Chat.ID,
@@ -283,6 +294,12 @@ func handleWhoamiCommand(message *tgbotapi.Message, user *config.User) ([]tgbota
 		return []tgbotapi.Chattable{msg}, nil
 	}
 
+	// the tokens and secrets are only shown in private chats
+	private := message.Chat.IsPrivate()
+	if !private {
+		user = user.Redacted()
+	}
+
 	infos, err := user.GetHumanReadableUserInfo()
 	if err != nil {
 		msg := tgbotapi.NewMessage(
@@ -293,9 +310,11 @@ func handleWhoamiCommand(message *tgbotapi.Message, user *config.User) ([]tgbota
 		return []tgbotapi.Chattable{msg}, nil
 	}
 
-	msg := tgbotapi.NewMessage(
-		message.Chat.ID,
-		fmt.Sprintf("Hallo %s 🙂,\nfolgende Infos habe ich über dich:\n%s\n\nAm besten löschst du die Nachricht wieder, damit dein Token hier nicht im Verlauf stehen bleibt 😉.", user.FriendlyName, infos))
+	text := fmt.Sprintf("Hallo %s 🙂,\nfolgende Infos habe ich über dich:\n%s", user.FriendlyName, infos)
+	if private {
+		text += "\n\nAm besten löschst du die Nachricht wieder, damit dein Token hier nicht im Verlauf stehen bleibt 😉."
+	}
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
 	msg.ReplyToMessageID = message.MessageID
 
 	return []tgbotapi.Chattable{msg}, nil
@@ -0,0 +1,111 @@
+package lpbot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/khase/leaseplan-bot/lpbot/config"
+	"github.com/khase/leaseplan-bot/lpbot/tgcon"
+)
+
+const emailUsage = "Verwendung:\n/email - zeigt den aktuellen Benachrichtigungskanal\n/email <adresse> - sendet einen Bestätigungscode an die Adresse\n/email confirm <code> - bestätigt die Adresse, Änderungen werden ab dann per E-Mail gesendet\n/email subject <template> - setzt den Betreff (ohne template wird der Standard verwendet)\n/email html <template> - setzt den HTML Inhalt (ohne template wird der Standard verwendet)\n/email test - sendet eine Test E-Mail\n/email off - sendet Änderungen wieder per Telegram"
+
+var (
+	EmailCmd = &tgcon.MessageCommand{
+		CommandTrigger:   "email",
+		ShortDescription: "sendet Änderungen per E-Mail statt per Telegram",
+		Description:      emailUsage,
+		Execute: func(message *tgbotapi.Message) ([]tgbotapi.Chattable, error) {
+			return handleEmailCommand(message, UserMap.GetUser(message.From.ID))
+		},
+	}
+)
+
+func handleEmailCommand(message *tgbotapi.Message, user *config.User) ([]tgbotapi.Chattable, error) {
+	if user == nil {
+		return nil, tgcon.ErrCommandPermittedForUnknownUser
+	}
+
+	if !config.IsEmailAvailable() {
+		return replyText(message, "Der E-Mail Versand ist für diesen Bot leider nicht eingerichtet 📭"), nil
+	}
+
+	subcommand, argument, _ := strings.Cut(strings.TrimSpace(message.CommandArguments()), " ")
+	argument = strings.TrimSpace(argument)
+
+	switch subcommand {
+	case "":
+		if user.Notifier != config.NotifierEmail {
+			return replyText(message, "Änderungen werden dir nicht per E-Mail geschickt.\n\n"+emailUsage), nil
+		}
+		return replyText(message, fmt.Sprintf("Änderungen werden an %s gesendet.\n\n%s", user.EmailAddress, emailUsage)), nil
+	case "off":
+		user.DisableEmail()
+		user.Save()
+
+		return replyText(message, "Änderungen werden dir ab sofort wieder per Telegram geschickt 📬"), nil
+	case "confirm":
+		err := user.ConfirmEmail(argument)
+		if errors.Is(err, config.ErrNoConfirmationPending) {
+			return replyText(message, "Es wartet keine Adresse auf ihre Bestätigung oder der Code ist abgelaufen. Fordere mit /email <adresse> einen neuen an."), nil
+		}
+		// failed attempts are counted
+		user.Save()
+		if errors.Is(err, config.ErrInvalidConfirmationCode) {
+			return replyText(message, "Der Code ist leider falsch."), nil
+		} else if errors.Is(err, config.ErrTooManyAttempts) {
+			return replyText(message, "Der Code wurde zu oft falsch eingegeben und ist nicht mehr gültig. Fordere mit /email <adresse> einen neuen an."), nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		return replyText(message, fmt.Sprintf("Änderungen werden ab sofort an %s gesendet 📧", user.EmailAddress)), nil
+	case "subject", "html":
+		subjectTemplate, htmlTemplate := user.EmailSubjectTemplate, user.EmailHtmlTemplate
+		if subcommand == "subject" {
+			subjectTemplate = argument
+		} else {
+			htmlTemplate = argument
+		}
+
+		err := user.SetEmailTemplates(subjectTemplate, htmlTemplate)
+		if err != nil {
+			return replyText(message, fmt.Sprintf("Das Template ist fehlerhaft: %s", err)), nil
+		}
+		user.Save()
+
+		return replyText(message, "Das E-Mail Template wurde gespeichert ✅"), nil
+	case "test":
+		if user.Notifier != config.NotifierEmail {
+			return replyText(message, "Du hast noch keine E-Mail Adresse bestätigt.\n\n"+emailUsage), nil
+		}
+
+		// the smtp server may take up to 30 seconds, the result is sent when done
+		notifier, frame := user.GetNotifier(nil), getTestFrame(user)
+		replyLater(message, func() string {
+			err := notifier.Notify(context.Background(), user, frame)
+			if err != nil {
+				return fmt.Sprintf("Die E-Mail konnte nicht gesendet werden: %s", err)
+			}
+			return "Die Test E-Mail wurde gesendet ✅"
+		})
+		return replyText(message, "Die Test E-Mail wird gesendet …"), nil
+	}
+
+	err := user.RequestEmailVerification(subcommand)
+	if errors.Is(err, config.ErrInvalidEmailAddress) {
+		return replyText(message, "Das ist leider keine gültige E-Mail Adresse.\n\n"+emailUsage), nil
+	} else if errors.Is(err, config.ErrConfirmationThrottled) {
+		return replyText(message, "Du hast gerade erst einen Bestätigungscode angefordert. Warte bitte ein paar Minuten, bevor du einen neuen anforderst."), nil
+	}
+	// the time of the request is kept even if sending failed
+	user.Save()
+	if err != nil {
+		return replyText(message, fmt.Sprintf("Die E-Mail mit dem Bestätigungscode konnte nicht gesendet werden: %s", err)), nil
+	}
+
+	return replyText(message, "Ich habe dir einen Bestätigungscode geschickt. Sende ihn mit /email confirm <code> innerhalb einer Stunde zurück."), nil
+}
@@ -1,6 +1,7 @@
 package lpbot
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
@@ -10,29 +11,74 @@ import (
 	"github.com/khase/leaseplan-bot/lpbot/tgcon"
 )
 
+const (
+	sortUsage      = "Verwendung:\n/sort <Reihenfolge> - sortiert die Autos in deinen Nachrichten, z.B. /sort bgv\n/sort -<Reihenfolge> - sortiert absteigend, z.B. /sort -hp\n/sort off - sortiert nach Ident (oder /rank)\n\nReihenfolgen: model (Marke/Modell), blp, bgv, netcost, hp, availability (Verfügbarkeit)"
+	aggregateUsage = "Verwendung:\n/aggregate on - fasst neue und entfernte Autos in deinen detailMessages zusammen\n/aggregate off - listet wieder jedes Auto einzeln\n/aggregate by <Schlüssel> - legt fest, wonach zusammengefasst wird, z.B. /aggregate by model\n/aggregate format <Template> - setzt das Template einer Gruppe, z.B. {{ .Count }}x {{ .Key }} ab {{ .MinSalaryWaiver }}€\n\nSchlüssel: offer (Angebot), model (Marke/Modell), brand (Marke), fuel (Antrieb) oder ein Ausdruck wie bei /filter add, z.B. .RentalObject.PowerHP"
+	rankUsage      = "Verwendung:\n/rank <Kennzahl> - sortiert neue Autos nach der Kennzahl, das beste zuerst\n/rank <Kennzahl> <N> - meldet nur neue Autos, die unter den besten N aller aktuellen Autos sind\n/rank off - Autos werden nicht bewertet\n\nKennzahlen:\nhpcost - Netto Kosten pro PS\nbgvratio - BGV im Verhältnis zum BLP\ndiscount - Ersparnis gegenüber dem durchschnittlichen BGV des gleichen Modells"
+)
+
 var (
 	SummaryFormatCmd = &tgcon.MessageCommand{
 		CommandTrigger:   "setsummarymessageformat",
 		ShortDescription: "setzt deine persönliche summaryMessage",
 		Description:      "",
+		GroupCommand:     true,
 		Execute: func(message *tgbotapi.Message) ([]tgbotapi.Chattable, error) {
-			return handleSummaryMessageFormatCommand(message, UserMap.Users[message.From.ID])
+			return handleSummaryMessageFormatCommand(message, getSubscriber(message))
 		},
 	}
 	DetailFormatCmd = &tgcon.MessageCommand{
 		CommandTrigger:   "setdetailmessageformat",
 		ShortDescription: "setzt deine persönliche detailMessage",
 		Description:      "",
+		GroupCommand:     true,
+		Execute: func(message *tgbotapi.Message) ([]tgbotapi.Chattable, error) {
+			return handleDetailMessageFormatCommand(message, getSubscriber(message))
+		},
+	}
+	DetailModeCmd = &tgcon.MessageCommand{
+		CommandTrigger:   "detailmode",
+		ShortDescription: "wählt zwischen Text und Fotos für die detailMessages",
+		Description:      "",
+		GroupCommand:     true,
+		Execute: func(message *tgbotapi.Message) ([]tgbotapi.Chattable, error) {
+			return handleDetailModeCommand(message, getSubscriber(message))
+		},
+	}
+	SortCmd = &tgcon.MessageCommand{
+		CommandTrigger:   "sort",
+		ShortDescription: "legt die Reihenfolge der Autos in deinen Nachrichten fest",
+		Description:      sortUsage,
+		GroupCommand:     true,
+		Execute: func(message *tgbotapi.Message) ([]tgbotapi.Chattable, error) {
+			return handleSortCommand(message, getSubscriber(message))
+		},
+	}
+	RankCmd = &tgcon.MessageCommand{
+		CommandTrigger:   "rank",
+		ShortDescription: "sortiert neue Autos nach dem besten Angebot",
+		Description:      rankUsage,
+		GroupCommand:     true,
+		Execute: func(message *tgbotapi.Message) ([]tgbotapi.Chattable, error) {
+			return handleRankCommand(message, getSubscriber(message))
+		},
+	}
+	AggregateCmd = &tgcon.MessageCommand{
+		CommandTrigger:   "aggregate",
+		ShortDescription: "fasst gleiche Autos in deinen detailMessages zusammen",
+		Description:      aggregateUsage,
+		GroupCommand:     true,
 		Execute: func(message *tgbotapi.Message) ([]tgbotapi.Chattable, error) {
-			return handleDetailMessageFormatCommand(message, UserMap.Users[message.From.ID])
+			return handleAggregateCommand(message, getSubscriber(message))
 		},
 	}
 	TestFormatCmd = &tgcon.MessageCommand{
 		CommandTrigger:   "test",
 		ShortDescription: "gibt die aktuellen Daten als Testnachricht zurück",
 		Description:      "",
+		GroupCommand:     true,
 		Execute: func(message *tgbotapi.Message) ([]tgbotapi.Chattable, error) {
-			return handleTestCommand(message, UserMap.Users[message.From.ID])
+			return handleTestCommand(message, getSubscriber(message))
 		},
 	}
 )
@@ -55,7 +101,7 @@ func handleTestCommand(message *tgbotapi.Message, user *config.User) ([]tgbotapi
 			return []tgbotapi.Chattable{msg}, err
 		}
 
-		return messages, nil
+		return toChat(messages, message.Chat.ID), nil
 	} else if len(command) == 2 {
 		testMessages, err := strconv.Atoi(command[1])
 		if err != nil {
@@ -72,12 +118,189 @@ func handleTestCommand(message *tgbotapi.Message, user *config.User) ([]tgbotapi
 			return []tgbotapi.Chattable{msg}, err
 		}
 
-		return messages, nil
+		return toChat(messages, message.Chat.ID), nil
 	}
 
 	return nil, nil
 }
 
+func handleDetailModeCommand(message *tgbotapi.Message, user *config.User) ([]tgbotapi.Chattable, error) {
+	if user == nil {
+		return nil, tgcon.ErrCommandPermittedForUnknownUser
+	}
+
+	switch strings.TrimSpace(message.CommandArguments()) {
+	case "":
+	case config.DetailModeText:
+		user.DetailMode = ""
+		user.Save()
+	case config.DetailModeRich:
+		user.DetailMode = config.DetailModeRich
+		user.Save()
+	default:
+		return replyText(message, fmt.Sprintf("Was diese \"%s\"??? text oder rich?", message.CommandArguments())), nil
+	}
+
+	var msgTxt string
+	if user.DetailMode == config.DetailModeRich {
+		msgTxt = fmt.Sprintf("Hallo %s,\ndu bekommst neue Autos als Foto mit deiner detailMessage als Beschreibung. Autos ohne Bild kommen weiterhin als Text.", user.FriendlyName)
+	} else {
+		msgTxt = fmt.Sprintf("Hallo %s,\ndu bekommst deine detailMessages als Text.", user.FriendlyName)
+	}
+
+	return replyText(message, msgTxt), nil
+}
+
+func handleSortCommand(message *tgbotapi.Message, user *config.User) ([]tgbotapi.Chattable, error) {
+	if user == nil {
+		return nil, tgcon.ErrCommandPermittedForUnknownUser
+	}
+
+	order := strings.TrimSpace(message.CommandArguments())
+	if order == "" {
+		current := "Deine Autos werden aktuell nicht sortiert."
+		if user.SortOrder != "" {
+			current = fmt.Sprintf("Deine Autos werden aktuell nach %s sortiert.", user.SortOrder)
+		}
+		return replyText(message, fmt.Sprintf("%s\n\n%s", current, sortUsage)), nil
+	}
+	if order == "off" {
+		order = ""
+	}
+
+	err := user.SetSortOrder(order)
+	if errors.Is(err, config.ErrUnknownSortOrder) {
+		return replyText(message, sortUsage), nil
+	} else if err != nil {
+		return nil, err
+	}
+	user.Save()
+
+	if user.SortOrder == "" {
+		return replyText(message, "Deine Autos werden nicht mehr sortiert."), nil
+	}
+	return replyText(message, fmt.Sprintf("Deine Autos werden ab sofort nach %s sortiert 🔃", user.SortOrder)), nil
+}
+
+func handleRankCommand(message *tgbotapi.Message, user *config.User) ([]tgbotapi.Chattable, error) {
+	if user == nil {
+		return nil, tgcon.ErrCommandPermittedForUnknownUser
+	}
+
+	args := strings.Fields(message.CommandArguments())
+	if len(args) == 0 || len(args) > 2 {
+		current := "Neue Autos werden aktuell nicht bewertet."
+		if user.RankMetric != "" {
+			current = fmt.Sprintf("Neue Autos werden aktuell nach %s sortiert.", user.RankMetric)
+		}
+		if user.RankTopN > 0 {
+			current += fmt.Sprintf(" Du bekommst nur die besten %d.", user.RankTopN)
+		}
+		return replyText(message, fmt.Sprintf("%s\n\n%s", current, rankUsage)), nil
+	}
+
+	metric, topN := args[0], 0
+	if metric == "off" {
+		metric = ""
+	}
+	if len(args) == 2 {
+		var err error
+		topN, err = strconv.Atoi(args[1])
+		if err != nil {
+			return replyText(message, fmt.Sprintf("Was diese \"%s\"??? Bitte gib eine Zahl an.", args[1])), nil
+		}
+	}
+
+	err := user.SetRanking(metric, topN)
+	if errors.Is(err, config.ErrUnknownMetric) {
+		return replyText(message, rankUsage), nil
+	} else if err != nil {
+		return nil, err
+	}
+	user.Save()
+
+	if user.RankMetric == "" {
+		return replyText(message, "Neue Autos werden nicht mehr bewertet."), nil
+	} else if user.RankTopN > 0 {
+		return replyText(message, fmt.Sprintf("Du bekommst nur noch neue Autos unter den besten %d nach %s 🏆", user.RankTopN, user.RankMetric)), nil
+	}
+	return replyText(message, fmt.Sprintf("Neue Autos werden ab sofort nach %s sortiert 🏆", user.RankMetric)), nil
+}
+
+func handleAggregateCommand(message *tgbotapi.Message, user *config.User) ([]tgbotapi.Chattable, error) {
+	if user == nil {
+		return nil, tgcon.ErrCommandPermittedForUnknownUser
+	}
+
+	args := strings.TrimSpace(message.CommandArguments())
+	action, argument := args, ""
+	if index := strings.IndexAny(args, " \n"); index > -1 {
+		action, argument = args[:index], strings.TrimSpace(args[index+1:])
+	}
+
+	switch action {
+	case "on":
+		user.GroupDetails = true
+	case "off":
+		user.GroupDetails = false
+	case "by":
+		err := user.SetGroupBy(argument)
+		if errors.Is(err, config.ErrInvalidGroupKey) {
+			return replyText(message, fmt.Sprintf("Nach \"%s\" kann ich leider nicht zusammenfassen: %s", argument, err)), nil
+		} else if err != nil {
+			return nil, err
+		}
+	case "format":
+		if argument == "" {
+			return replyText(message, fmt.Sprintf("Dein aktuelles Template für Gruppen ist:\n%s", user.GetGroupMessageTemplate())), nil
+		}
+		err := user.SetGroupMessageTemplate(argument)
+		if err != nil {
+			return replyText(message, fmt.Sprintf("Deine Formatierung schlägt leider fehl: %s", err)), nil
+		}
+	default:
+		current := "Deine Autos werden aktuell einzeln aufgelistet."
+		if user.GroupDetails {
+			current = fmt.Sprintf("Deine Autos werden aktuell nach %s zusammengefasst.", getGroupByName(user))
+		}
+		return replyText(message, fmt.Sprintf("%s\n\n%s", current, aggregateUsage)), nil
+	}
+	user.Save()
+
+	if !user.GroupDetails {
+		return replyText(message, "Deine Autos werden ab sofort wieder einzeln aufgelistet."), nil
+	}
+	return replyText(message, fmt.Sprintf("Deine Autos werden ab sofort nach %s zusammengefasst 📦", getGroupByName(user))), nil
+}
+
+func getGroupByName(user *config.User) string {
+	if user.GroupBy == "" {
+		return config.GroupByOffer
+	}
+
+	return user.GroupBy
+}
+
+// toChat sends test messages to the chat the command came from, so editing a
+// channel does not post into the channel.
+func toChat(messages []tgbotapi.Chattable, chatId int64) []tgbotapi.Chattable {
+	for i, msg := range messages {
+		switch chatMessage := msg.(type) {
+		case tgbotapi.MessageConfig:
+			chatMessage.ChatID = chatId
+			messages[i] = chatMessage
+		case tgbotapi.PhotoConfig:
+			chatMessage.ChatID = chatId
+			messages[i] = chatMessage
+		case tgbotapi.MediaGroupConfig:
+			chatMessage.ChatID = chatId
+			messages[i] = chatMessage
+		}
+	}
+
+	return messages
+}
+
 func handleSummaryMessageFormatCommand(message *tgbotapi.Message, user *config.User) ([]tgbotapi.Chattable, error) {
 	if user == nil {
 		return nil, tgcon.ErrCommandPermittedForUnknownUser
@@ -0,0 +1,50 @@
+package lpbot
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+)
+
+// lifecycle runs the long living parts of the bot. The first task returning an
+// error cancels the shared context so all other tasks shut down as well.
+type lifecycle struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	wg      sync.WaitGroup
+	errOnce sync.Once
+	err     error
+}
+
+func newLifecycle(parent context.Context) (*lifecycle, context.Context) {
+	ctx, cancel := context.WithCancel(parent)
+
+	return &lifecycle{ctx: ctx, cancel: cancel}, ctx
+}
+
+func (l *lifecycle) Go(name string, task func(ctx context.Context) error) {
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+
+		err := task(l.ctx)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			log.Printf("Lifecycle: %s stopped with error: %s", name, err)
+			l.errOnce.Do(func() {
+				l.err = err
+				l.cancel()
+			})
+			return
+		}
+		log.Printf("Lifecycle: %s stopped", name)
+	}()
+}
+
+func (l *lifecycle) Wait() error {
+	l.wg.Wait()
+	l.cancel()
+
+	return l.err
+}
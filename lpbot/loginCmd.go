@@ -1,6 +1,8 @@
 package lpbot
 
 import (
+	"fmt"
+	"log"
 	"strings"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
@@ -16,7 +18,7 @@ var (
 		ShortDescription: "loggt dich bei leaseplan ein email/password",
 		Description:      "",
 		Execute: func(message *tgbotapi.Message) ([]tgbotapi.Chattable, error) {
-			return handleLoginCommand(message, UserMap.Users[message.From.ID])
+			return handleLoginCommand(message, UserMap.GetUser(message.From.ID))
 		},
 	}
 	TokenCmd = &tgcon.MessageCommand{
@@ -24,7 +26,7 @@ var (
 		ShortDescription: "loggt dich bei leaseplan ein token",
 		Description:      "",
 		Execute: func(message *tgbotapi.Message) ([]tgbotapi.Chattable, error) {
-			return handleSetTokenCommand(message, UserMap.Users[message.From.ID])
+			return handleSetTokenCommand(message, UserMap.GetUser(message.From.ID))
 		},
 	}
 	ConnectCmd = &tgcon.MessageCommand{
@@ -32,7 +34,7 @@ var (
 		ShortDescription: "verwende den lp-Account eines Kollegen",
 		Description:      "",
 		Execute: func(message *tgbotapi.Message) ([]tgbotapi.Chattable, error) {
-			return handleConnectCommand(message, UserMap.Users[message.From.ID])
+			return handleConnectCommand(message, UserMap.GetUser(message.From.ID))
 		},
 	}
 )
@@ -85,17 +87,34 @@ func handleSetTokenCommand(message *tgbotapi.Message, user *config.User) ([]tgbo
 }
 
 func setToken(token string, message *tgbotapi.Message, user *config.User) ([]tgbotapi.Chattable, error) {
-	user.LeaseplanToken = token
-	user.StartWatcher()
+	expiry, err := lpcon.GetTokenExpiry(token)
+	if err != nil {
+		log.Printf("Could not decode token expiry for %s(%d): %s", user.FriendlyName, user.UserId, err)
+	}
+
+	watcherStarted := user.SetLeaseplanToken(token, expiry)
 	user.Save()
-	lpcon.RegisterUserWatcher(user)
+	if watcherStarted {
+		lpcon.RegisterUserWatcher(user)
+	}
 
 	deleteCredsMsg := tgbotapi.NewDeleteMessage(
 		message.Chat.ID,
 		message.MessageID)
+
+	text := "Perfekt 🎉, das hat schonmal geklappt 😊.\nSicherheitshalber habe ich das Token aus unserem Verlauf gelöscht.\n\n"
+	if watcherStarted {
+		text += "Deine Updates wurden automatisch aktiviert ✅. Du kannst natürlich noch das Nachrichtenformat (/messageFormat) sowie eigene Filter (/filter) einstellen."
+	} else {
+		text += "Deine Updates sind weiterhin pausiert ⏸. Du kannst sie jederzeit mit /resume aktivieren."
+	}
+	if !expiry.IsZero() {
+		text += fmt.Sprintf("\n\nDein Token ist gültig bis %s.", expiry.Local().Format("02.01.2006 15:04"))
+	}
+
 	msg := tgbotapi.NewMessage(
 		message.Chat.ID,
-		"Perfekt 🎉, das hat schonmal geklappt 😊.\nSicherheitshalber habe ich das Token aus unserem Verlauf gelöscht.\n\nDeine Updates wurden automatisch aktiviert ✅. Du kannst natürlich noch das Nachrichtenformat (/messageFormat) sowie eigene Filter (/filter) einstellen.")
+		text)
 
 	return []tgbotapi.Chattable{deleteCredsMsg, msg}, nil
 }
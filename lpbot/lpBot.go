@@ -1,12 +1,14 @@
 package lpbot
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
+	"net"
 	"os"
 	"os/signal"
-	"time"
+	"syscall"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/khase/leaseplan-bot/lpbot/api"
@@ -16,21 +18,53 @@ import (
 )
 
 var (
-	UserMap *config.UserMap
+	UserMap     *config.UserMap
+	tgConnector *tgcon.TgConnector
 
 	ErrUserDataFileNotExistant = errors.New("userdata file does not exist")
-	ErrExternalInterrupt       = errors.New("interrupted from external signal")
+	ErrUserDataFileInUse       = errors.New("userdata file is used by another bot")
 )
 
-func StartBot(token string, debug bool, userDataFile string, createNew bool, watcherDelay int, watcherPageSize int) error {
-	go api.InitAndListen()
+// StartBot runs the bot until the context is done. The config is validated
+// before anything is started.
+func StartBot(ctx context.Context, botConfig *config.BotConfig) error {
+	err := botConfig.Validate(true)
+	if err != nil {
+		return err
+	}
+	config.SetCacheDir(botConfig.CacheDir)
+	config.SetSendDelay(botConfig.SendDelay)
+
+	userDataFile := botConfig.UserDataFile
+	apiConfig := api.ServerConfig{
+		Enabled:     botConfig.ApiEnabled,
+		Address:     botConfig.ApiAddress,
+		TlsCertFile: botConfig.ApiTlsCert,
+		TlsKeyFile:  botConfig.ApiTlsKey,
+	}
+
+	auditLog, err := openAuditLog(botConfig.AuditLogFile)
+	if err != nil {
+		return err
+	}
+	if auditLog != nil {
+		defer auditLog.Close()
+	}
+
+	lock, err := config.LockUserData(userDataFile)
+	if errors.Is(err, config.ErrUserDataLocked) {
+		return ErrUserDataFileInUse
+	} else if err != nil {
+		return err
+	}
+	defer lock.Unlock()
 
 	userMap, err := config.LoadUserMap(userDataFile)
 	if err != nil {
 		if !errors.Is(err, os.ErrNotExist) {
 			return err
 		}
-		if !createNew {
+		if !botConfig.CreateNew {
 			return ErrUserDataFileNotExistant
 		}
 
@@ -38,7 +72,7 @@ func StartBot(token string, debug bool, userDataFile string, createNew bool, wat
 	}
 	UserMap = userMap
 
-	tgBot := tgcon.NewTgConnector(token, debug)
+	tgBot := tgcon.NewTgConnector(botConfig.TelegramApiToken, botConfig.Debug)
 	tgBot.AddCommand(StartCmd)
 	tgBot.AddCommand(WhoamiCmd)
 	tgBot.AddCommand(ResumeCmd)
@@ -52,72 +86,111 @@ func StartBot(token string, debug bool, userDataFile string, createNew bool, wat
 	tgBot.AddCommand(IgnoreRemovedCmd)
 	tgBot.AddCommand(SummaryFormatCmd)
 	tgBot.AddCommand(DetailFormatCmd)
+	tgBot.AddCommand(DetailModeCmd)
+	tgBot.AddCommand(SortCmd)
+	tgBot.AddCommand(RankCmd)
+	tgBot.AddCommand(AggregateCmd)
 	tgBot.AddCommand(TestFormatCmd)
+	tgBot.AddCommand(WatchCmd)
+	tgBot.AddCommand(SearchCmd)
+	tgBot.AddCommand(StatsCmd)
 	tgBot.AddCommand(FilterCmd)
+	tgBot.AddCommand(ApiKeyCmd)
+	tgBot.AddCommand(WebhookCmd)
+	tgBot.AddCommand(EmailCmd)
+	tgBot.AddCommand(PushCmd)
+	tgBot.AddCommand(GroupCmd)
+	tgBot.AddCommand(ChannelCmd)
+	tgBot.AddCommand(CompareCmd)
+	tgBot.AddCommand(AdminCmd)
+	tgBot.AddCallback(CarActionCallback)
+	tgBot.AddCallback(SearchCallback)
+	tgBot.SetPermissionCheck(checkCommandPermission)
 
 	log.Printf("Bot Command Descriptions:\n%s", tgBot.GetCommandDescriptions())
 	err = tgBot.Init()
 
 	if errors.Is(err, tgcon.ErrTelegramTokenUnser) {
 		return errors.New("No bot token set. Use flag `-t` to provide a telegram bot api token")
+	} else if err != nil {
+		return err
+	}
+	tgConnector = tgBot
+
+	api.SetUserMap(UserMap)
+	api.SetAdminKeys(botConfig.ApiAdminKeys)
+	lpcon.SetFrameListener(api.PublishFrame)
+	config.SetSmtpConfig(botConfig.GetSmtpConfig())
+
+	var apiListener net.Listener
+	if apiConfig.Enabled {
+		log.Printf("Setting up http api...")
+		apiListener, err = api.Listen(apiConfig)
+		if err != nil {
+			return fmt.Errorf("could not start http api: %w", err)
+		}
+	} else {
+		log.Printf("Http api is disabled.")
 	}
 
-	commandChannel := make(chan error)
+	lifecycle, ctx := newLifecycle(ctx)
+	if apiListener != nil {
+		lifecycle.Go("http api", func(ctx context.Context) error {
+			return api.Serve(ctx, apiListener, apiConfig)
+		})
+	}
+	lifecycle.Go("telegram receiver", tgBot.ReceiveMessages)
+	lifecycle.Go("reload signal handler", handleReloadSignals)
 
-	go tgBot.ReceiveMessages()
+	startActiveHandlers(ctx, UserMap, tgBot.GetTgBotApi(), botConfig.WatcherDelay, botConfig.WatcherPageSize, botConfig.TokenWarningHours)
 
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-	go func() {
-		for range c {
-			tgBot.Shutdown()
-			commandChannel <- ErrExternalInterrupt
-		}
-	}()
+	lifecycle.Go("system notifications", func(ctx context.Context) error {
+		return scheduleSystemNotifications(ctx, UserMap, tgBot.GetTgBotApi())
+	})
 
-	sendSystemNotifications(UserMap, tgBot.GetTgBotApi())
-	startActiveHandlers(UserMap, tgBot.GetTgBotApi(), watcherDelay, watcherPageSize)
+	<-ctx.Done()
+	fmt.Printf("\nReceived shutdown signal.\n")
+	fmt.Printf("Shutting down bot...\n")
 
-	for {
-		for command := range commandChannel {
-			if errors.Is(command, ErrExternalInterrupt) {
-				fmt.Printf("\nReceived external interrupt.\n")
-				fmt.Printf("Shutting down bot...\n")
-				time.Sleep(5 * time.Second)
-				return nil
-			}
-		}
+	err = lifecycle.Wait()
+	lpcon.WaitForWatchers()
+	config.WaitForPendingMessages()
+
+	if saveErr := UserMap.Save(); saveErr != nil {
+		log.Printf("Could not save userdata on shutdown: %s", saveErr)
 	}
+
+	return err
 }
 
-func sendSystemNotifications(userMap *config.UserMap, bot *tgbotapi.BotAPI) {
-	for _, user := range userMap.Users {
-		for _, notification := range config.SystemNotifications {
-			if notification.Publish.After(user.LastSystemnotification) {
-				if notification.UserCondition(user) {
-					fmt.Printf("Sending System Notification to user %s(%d)\n", user.FriendlyName, user.UserId)
-					bot.Send(
-						tgbotapi.NewMessage(
-							user.UserId,
-							notification.Message,
-						),
-					)
-					notification.UserAction(user)
-				}
-				user.LastSystemnotification = notification.Publish
-				user.Save()
+func handleReloadSignals(ctx context.Context) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-signals:
+			log.Printf("Received SIGHUP, reloading userdata and config")
+			_, err := Reload()
+			if err != nil {
+				log.Printf("Reload failed: %s", err)
 			}
 		}
 	}
 }
 
-func startActiveHandlers(userMap *config.UserMap, bot *tgbotapi.BotAPI, delay int, pageSize int) error {
+func startActiveHandlers(ctx context.Context, userMap *config.UserMap, bot *tgbotapi.BotAPI, delay int, pageSize int, tokenWarningHours int) error {
+	lpcon.SetWatcherContext(ctx)
 	lpcon.SetTgBotForWatcher(bot)
 	lpcon.SetWatcherDelay(delay)
 	lpcon.SetWatcherPageSize(pageSize)
+	lpcon.SetTokenWarningHours(tokenWarningHours)
 
-	for _, user := range userMap.Users {
-		if user.WatcherActive {
+	for _, user := range userMap.SortedUsers() {
+		if user.WatcherActive && !user.Banned {
 			lpcon.RegisterUserWatcher(user)
 		}
 	}
@@ -0,0 +1,58 @@
+package lpcon
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+
+	"github.com/khase/leaseplanabocarexporter/dto"
+	"github.com/khase/leaseplanabocarexporter/pkg"
+)
+
+var (
+	ErrUnauthorized = errors.New("leaseplan rejected the token")
+	ErrTokenExpired = errors.New("leaseplan token expired")
+
+	// authErrorPattern matches the status codes and reason phrases of rejected
+	// tokens as whole words, so e.g. ids containing 401 do not match.
+	authErrorPattern = regexp.MustCompile(`\b(401|403|Unauthorized|Forbidden)\b`)
+)
+
+// GetUserInfo requests the user info of the token from leaseplan, rejected
+// tokens are reported as ErrUnauthorized.
+func GetUserInfo(token string) (dto.UserInfo, error) {
+	userInfo, err := pkg.GetUserInfo(token)
+	return userInfo, classifyError(err)
+}
+
+// classifyError maps the errors of the leaseplan exporter onto typed errors so
+// callers can use errors.Is. The exporter does not expose the HTTP status:
+// failed requests are returned as *url.Error, invalid responses as errors of
+// json.Unmarshal and all responses other than 200 as plain text error holding
+// the response body. Only the latter are matched against authErrorPattern.
+func classifyError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var urlError *url.Error
+	var syntaxError *json.SyntaxError
+	var typeError *json.UnmarshalTypeError
+	if errors.As(err, &urlError) || errors.As(err, &syntaxError) || errors.As(err, &typeError) {
+		return err
+	}
+
+	text := err.Error()
+	if authErrorPattern.MatchString(text) {
+		return fmt.Errorf("%w: %s", ErrUnauthorized, text)
+	}
+
+	return err
+}
+
+// IsAuthError reports whether err means the users token can not be used anymore.
+func IsAuthError(err error) bool {
+	return errors.Is(err, ErrUnauthorized) || errors.Is(err, ErrTokenExpired)
+}
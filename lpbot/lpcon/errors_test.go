@@ -0,0 +1,57 @@
+package lpcon_test
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/khase/leaseplan-bot/lpbot/lpcon"
+)
+
+// responseTransport answers all requests of the exporter, which uses the
+// default http client, with the given status and body.
+type responseTransport struct {
+	status int
+	body   string
+}
+
+func (transport responseTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: transport.status,
+		Status:     http.StatusText(transport.status),
+		Header:     http.Header{},
+		Body:       io.NopCloser(strings.NewReader(transport.body)),
+		Request:    request,
+	}, nil
+}
+
+func TestGetUserInfoErrors(t *testing.T) {
+	defaultTransport := http.DefaultClient.Transport
+	defer func() { http.DefaultClient.Transport = defaultTransport }()
+
+	// the exporter allows 3 requests per 20 seconds
+	for _, test := range []struct {
+		status int
+		body   string
+		auth   bool
+	}{
+		{status: http.StatusUnauthorized, body: "Unauthorized", auth: true},
+		{status: http.StatusForbidden, body: `{"status":403,"title":"Forbidden"}`, auth: true},
+		{status: http.StatusInternalServerError, body: "request 14017 failed", auth: false},
+	} {
+		http.DefaultClient.Transport = responseTransport{status: test.status, body: test.body}
+
+		_, err := lpcon.GetUserInfo("token")
+		if err == nil {
+			t.Fatalf("expected an error for status %d", test.status)
+		}
+		if lpcon.IsAuthError(err) != test.auth || errors.Is(err, lpcon.ErrUnauthorized) != test.auth {
+			t.Fatalf("expected auth error %t for status %d but got %v", test.auth, test.status, err)
+		}
+		if !strings.Contains(err.Error(), test.body) {
+			t.Fatalf("expected the response body in the error but got %v", err)
+		}
+	}
+}
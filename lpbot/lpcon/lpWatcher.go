@@ -1,11 +1,12 @@
 package lpcon
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"math/rand"
-	"reflect"
 	"strconv"
-	"strings"
+	"sync"
 	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
@@ -53,15 +54,28 @@ var (
 			"key",
 		})
 
+	// watcherList is changed by reloads, bot commands and the api while the
+	// watchers and the api read it, it is guarded by watcherListLock
 	watcherList        map[string]*LpWatcher = make(map[string]*LpWatcher)
+	watcherListLock    sync.RWMutex
+	watcherContext     context.Context = context.Background()
+	watcherGroup       sync.WaitGroup
 	tgBot              *tgbotapi.BotAPI
 	globalWatcherDelay int
 	watcherPageSize    int
+	tokenWarningHours  int
+	frameListener      FrameListener
 )
 
+// FrameListener gets notified about every change of the car list of a level
+// key.
+type FrameListener func(levelKey string, frame *config.DataFrame)
+
 type LpWatcher struct {
 	levelKey string
 
+	// lock guards the userlist and the currentCarList
+	lock           sync.RWMutex
 	userlist       map[string]*config.User
 	currentCarList []dto.Item
 
@@ -94,48 +108,111 @@ func NewLpWatcher(levelKey string) *LpWatcher {
 	return watcher
 }
 
+// SetWatcherContext sets the context all watchers started afterwards run in.
+// Cancelling it shuts the watchers down, see WaitForWatchers.
+func SetWatcherContext(ctx context.Context) {
+	watcherContext = ctx
+}
+
+// WaitForWatchers blocks until all running watchers have shut down.
+func WaitForWatchers() {
+	watcherGroup.Wait()
+}
+
 func SetTgBotForWatcher(bot *tgbotapi.BotAPI) {
 	tgBot = bot
 }
 
+func SetFrameListener(listener FrameListener) {
+	frameListener = listener
+}
+
 func SetWatcherDelay(delay int) {
 	globalWatcherDelay = delay
 }
 
+func GetWatcherDelay() int {
+	return globalWatcherDelay
+}
+
 func SetWatcherPageSize(pageZize int) {
 	watcherPageSize = pageZize
 }
 
+func GetWatcherPageSize() int {
+	return watcherPageSize
+}
+
+func SetTokenWarningHours(hours int) {
+	tokenWarningHours = hours
+}
+
+func GetTokenWarningHours() int {
+	return tokenWarningHours
+}
+
 func RegisterUserWatcher(user *config.User) {
 	if updateUserInfo(user) != nil {
 		return
 	}
 
+	watcherListLock.Lock()
 	watcher, exists := watcherList[user.LeaseplanLevelKey]
-
 	if !exists {
 		watcher = NewLpWatcher(user.LeaseplanLevelKey)
 		watcherList[user.LeaseplanLevelKey] = watcher
 
-		go watcher.Start()
+		watcherGroup.Add(1)
+		go func() {
+			defer watcherGroup.Done()
+			watcher.Start(watcherContext)
+		}()
 	}
+	watcherListLock.Unlock()
 
 	watcher.registerUser(user)
 }
 
 func UnregisterUserWatcher(user *config.User) {
-	watcher, exists := watcherList[user.LeaseplanLevelKey]
+	for _, watcher := range getWatchers() {
+		if watcher.hasUser(user) {
+			watcher.unregisterUser(user)
+		}
+	}
+}
 
-	if !exists {
-		return
+// SyncUserWatcher registers or unregisters the user depending on its
+// WatcherActive flag and reports whether anything had to be changed.
+func SyncUserWatcher(user *config.User) bool {
+	watched := IsUserWatched(user)
+	active := user.WatcherActive && !user.Banned
+	if active && !watched {
+		RegisterUserWatcher(user)
+		return true
+	}
+	if !active && watched {
+		UnregisterUserWatcher(user)
+		return true
 	}
 
-	watcher.unregisterUser(user)
+	return false
+}
+
+func IsUserWatched(user *config.User) bool {
+	for _, watcher := range getWatchers() {
+		if watcher.hasUser(user) {
+			return true
+		}
+	}
+
+	return false
 }
 
 func GetStates() map[string]*LpWatcherState {
-	result := make(map[string]*LpWatcherState)
+	watcherListLock.RLock()
+	defer watcherListLock.RUnlock()
 
+	result := make(map[string]*LpWatcherState)
 	for key, element := range watcherList {
 		result[key] = element.state
 	}
@@ -145,15 +222,19 @@ func GetStates() map[string]*LpWatcherState {
 
 func GetCars() map[string][]dto.Item {
 	result := make(map[string][]dto.Item)
-
-	for key, element := range watcherList {
-		result[key] = element.currentCarList
+	for _, watcher := range getWatchers() {
+		watcher.lock.RLock()
+		result[watcher.levelKey] = watcher.currentCarList
+		watcher.lock.RUnlock()
 	}
 
 	return result
 }
 
 func GetWatcherKeys() []string {
+	watcherListLock.RLock()
+	defer watcherListLock.RUnlock()
+
 	result := make([]string, 0, len(watcherList))
 	for key := range watcherList {
 		result = append(result, key)
@@ -162,44 +243,115 @@ func GetWatcherKeys() []string {
 	return result
 }
 
+// getWatchers returns a snapshot of all watchers, so they can be used without
+// holding watcherListLock.
+func getWatchers() []*LpWatcher {
+	watcherListLock.RLock()
+	defer watcherListLock.RUnlock()
+
+	result := make([]*LpWatcher, 0, len(watcherList))
+	for _, watcher := range watcherList {
+		result = append(result, watcher)
+	}
+
+	return result
+}
+
 func (watcher *LpWatcher) registerUser(user *config.User) {
 	log.Printf("Leaseplanwatcher for %s: adding user %s(%d)\n", watcher.levelKey, user.FriendlyName, user.UserId)
-	watcher.userlist[strconv.FormatInt(user.UserId, 10)] = user
+	watcher.lock.Lock()
+	defer watcher.lock.Unlock()
 
+	watcher.userlist[strconv.FormatInt(user.UserId, 10)] = user
 	watcher.state.UserCount = len(watcher.userlist)
 }
 
 func (watcher *LpWatcher) unregisterUser(user *config.User) {
 	log.Printf("Leaseplanwatcher for %s: removing user %s(%d)\n", watcher.levelKey, user.FriendlyName, user.UserId)
-	delete(watcher.userlist, strconv.FormatInt(user.UserId, 10))
+	watcher.lock.Lock()
+	defer watcher.lock.Unlock()
 
+	delete(watcher.userlist, strconv.FormatInt(user.UserId, 10))
 	watcher.state.UserCount = len(watcher.userlist)
 }
 
+func (watcher *LpWatcher) hasUser(user *config.User) bool {
+	watcher.lock.RLock()
+	defer watcher.lock.RUnlock()
+
+	_, exists := watcher.userlist[strconv.FormatInt(user.UserId, 10)]
+	return exists
+}
+
+// getUsers returns a snapshot of the users of the watcher, so they can be
+// updated while users are registered or unregistered. Users replaced by a
+// reload are swapped for the current ones.
+func (watcher *LpWatcher) getUsers() []*config.User {
+	watcher.lock.RLock()
+	users := make([]*config.User, 0, len(watcher.userlist))
+	for _, user := range watcher.userlist {
+		users = append(users, user)
+	}
+	watcher.lock.RUnlock()
+
+	for i, user := range users {
+		if current := user.Current(); current != user {
+			users[i] = current
+			watcher.lock.Lock()
+			if watcher.userlist[strconv.FormatInt(user.UserId, 10)] == user {
+				watcher.userlist[strconv.FormatInt(user.UserId, 10)] = current
+			}
+			watcher.lock.Unlock()
+		}
+	}
+
+	return users
+}
+
 func (watcher *LpWatcher) Stop() {
 	watcher.state.IsActive = false
 }
 
-func (watcher *LpWatcher) Start() {
+func (watcher *LpWatcher) Start(ctx context.Context) {
 	watcher.state.IsActive = true
 
 	updateChannel := make(chan []dto.Item)
-	go watcher.watch(updateChannel)
+	go watcher.watch(ctx, updateChannel)
 
+	var previous []dto.Item
+	polled := false
 	for update := range updateChannel {
-		for _, user := range watcher.userlist {
+		// the first poll only establishes the current state
+		if polled && frameListener != nil {
+			frame := config.NewDataFrame(previous, update)
+			if frame.HasChanges {
+				frameListener(watcher.levelKey, frame)
+			}
+		}
+		previous = update
+		polled = true
+		config.RecordModelHistory(watcher.levelKey, update)
+		config.RecordAssortment(watcher.levelKey, update)
+
+		for _, user := range watcher.getUsers() {
+			if user.IsChat() {
+				user.SyncOwner()
+			}
 			if user.LeaseplanLevelKey != watcher.levelKey {
 				watcher.reallocateUser(user)
 				continue
 			}
 			if user.WatcherActive {
-				user.Update(update, tgBot)
+				user.Update(ctx, update, tgBot)
+				if !user.IsChat() {
+					checkTokenExpiry(user)
+				}
 			}
 		}
 	}
 }
 
-func (watcher *LpWatcher) watch(itemChannel chan []dto.Item) {
+func (watcher *LpWatcher) watch(ctx context.Context, itemChannel chan []dto.Item) {
 	log.Printf("Leaseplanwatcher for %s: starting\n", watcher.levelKey)
 	watcher.state.IsActive = true
 	defer func() {
@@ -208,41 +360,54 @@ func (watcher *LpWatcher) watch(itemChannel chan []dto.Item) {
 		close(itemChannel)
 	}()
 
-	for watcher.state.IsActive {
-		if len(watcher.userlist) == 0 {
+	for watcher.state.IsActive && ctx.Err() == nil {
+		users := watcher.getUsers()
+		if len(users) == 0 {
 			log.Printf("Leaseplanwatcher for %s: has no users in pool -> suspending for 30 sec\n", watcher.levelKey)
-			time.Sleep(30 * time.Second)
+			if !watcher.sleep(ctx, 30*time.Second) {
+				return
+			}
 			continue
 		}
 
 		var donorUser *config.User
 		// select donor token
 		rand.Seed(time.Now().Unix())
-		userIds := reflect.ValueOf(watcher.userlist).MapKeys()
-		for len(userIds) > 0 {
-			idx := rand.Intn(len(userIds))
-			userId := userIds[idx].String()
-			user := watcher.userlist[userId]
+		candidates := users
+		for len(candidates) > 0 {
+			idx := rand.Intn(len(candidates))
+			user := candidates[idx]
 			if !user.WatcherActive {
-				userIds[idx] = userIds[len(userIds)-1]
-				userIds = userIds[:len(userIds)-1]
+				candidates[idx] = candidates[len(candidates)-1]
+				candidates = candidates[:len(candidates)-1]
 				continue
 			}
+			if user.IsChat() {
+				// chat subscriptions poll with the token of their owner
+				owner := user.GetOwner()
+				if owner == nil || !owner.EULA || owner.Banned || updateUserInfo(owner) != nil || owner.LeaseplanLevelKey != watcher.levelKey {
+					candidates[idx] = candidates[len(candidates)-1]
+					candidates = candidates[:len(candidates)-1]
+					continue
+				}
+				donorUser = owner
+				break
+			}
 			if !user.EULA {
 				user.WatcherError = "EULA not accepted. Accept with /eula true"
-				userIds[idx] = userIds[len(userIds)-1]
-				userIds = userIds[:len(userIds)-1]
+				candidates[idx] = candidates[len(candidates)-1]
+				candidates = candidates[:len(candidates)-1]
 				continue
 			}
 			if updateUserInfo(user) != nil {
-				userIds[idx] = userIds[len(userIds)-1]
-				userIds = userIds[:len(userIds)-1]
+				candidates[idx] = candidates[len(candidates)-1]
+				candidates = candidates[:len(candidates)-1]
 				continue
 			}
 			if user.LeaseplanLevelKey != watcher.levelKey {
 				watcher.reallocateUser(user)
-				userIds[idx] = userIds[len(userIds)-1]
-				userIds = userIds[:len(userIds)-1]
+				candidates[idx] = candidates[len(candidates)-1]
+				candidates = candidates[:len(candidates)-1]
 				continue
 			}
 			donorUser = user
@@ -250,8 +415,10 @@ func (watcher *LpWatcher) watch(itemChannel chan []dto.Item) {
 		}
 
 		if donorUser == nil {
-			log.Printf("Leaseplanwatcher for %s: could not select donor user (pool size: %d)\n", watcher.levelKey, len(watcher.userlist))
-			time.Sleep(5 * time.Second)
+			log.Printf("Leaseplanwatcher for %s: could not select donor user (pool size: %d)\n", watcher.levelKey, len(users))
+			if !watcher.sleep(ctx, 5*time.Second) {
+				return
+			}
 			continue
 		}
 
@@ -277,44 +444,132 @@ func (watcher *LpWatcher) watch(itemChannel chan []dto.Item) {
 			totalRequestErrors.WithLabelValues(donorUser.FriendlyName, watcher.levelKey).Inc()
 			log.Printf("Leaseplanwatcher for %s with donor %s(%d): could not get car list %s\n", watcher.levelKey, donorUser.FriendlyName, donorUser.UserId, err)
 		} else {
+			watcher.lock.Lock()
 			watcher.currentCarList = carList
+			watcher.lock.Unlock()
 			watcher.state.CurrentCarCount = len(carList)
 
 			itemChannel <- carList
 		}
 
 		log.Printf("Leaseplanwatcher for %s: sleeping for %d minutes\n", watcher.levelKey, globalWatcherDelay)
-		for minutesToSleep := globalWatcherDelay; minutesToSleep > 0; minutesToSleep-- {
-			for secondsToSleep := 60; secondsToSleep > 0; secondsToSleep -= 5 {
-				if !watcher.state.IsActive {
-					return
-				}
-				time.Sleep(5 * time.Second)
-			}
+		if !watcher.sleepUntilNextPoll(ctx, requestStart) {
+			return
+		}
+	}
+}
+
+// sleepUntilNextPoll waits until the global watcher delay has passed since
+// the last poll. The delay is re-evaluated while waiting so changes made by a
+// reload take effect immediately.
+func (watcher *LpWatcher) sleepUntilNextPoll(ctx context.Context, lastPoll time.Time) bool {
+	for time.Since(lastPoll) < time.Duration(globalWatcherDelay)*time.Minute {
+		if !watcher.sleep(ctx, 5*time.Second) {
+			return false
+		}
+	}
+
+	return watcher.state.IsActive
+}
+
+// sleep pauses the watcher for the given duration while checking every few
+// seconds whether it has been stopped. It returns false if the watcher should
+// shut down.
+func (watcher *LpWatcher) sleep(ctx context.Context, duration time.Duration) bool {
+	for remaining := duration; remaining > 0; remaining -= 5 * time.Second {
+		if !watcher.state.IsActive {
+			return false
+		}
+
+		step := 5 * time.Second
+		if remaining < step {
+			step = remaining
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(step):
 		}
 	}
+
+	return watcher.state.IsActive
 }
 
 func updateUserInfo(user *config.User) error {
-	lpUserInfo, err := pkg.GetUserInfo(user.LeaseplanToken)
+	if user.IsChat() {
+		return updateChatInfo(user)
+	}
+	if !user.LeaseplanTokenExpiry.IsZero() && time.Now().After(user.LeaseplanTokenExpiry) {
+		return handleUserInfoError(user, ErrTokenExpired)
+	}
+
+	lpUserInfo, err := GetUserInfo(user.LeaseplanToken)
 	if err != nil {
-		totalRequestErrors.WithLabelValues(user.FriendlyName, user.LeaseplanLevelKey).Inc()
-		log.Printf("Leaseplanwatcher %s(%d): could not get userInfo: %s\n", user.FriendlyName, user.UserId, err)
+		return handleUserInfoError(user, err)
+	}
+	user.LeaseplanLevelKey = lpUserInfo.AddressRole.RoleName
+	user.Save()
+
+	return nil
+}
+
+// updateChatInfo takes the level key of a chat subscription from its owner.
+// Chats have no token of their own, so they never get login or token
+// messages.
+func updateChatInfo(chat *config.User) error {
+	chat.SyncOwner()
+	if chat.LeaseplanLevelKey == "" {
+		log.Printf("Leaseplanwatcher %s(%d): owner %d has no level key\n", chat.FriendlyName, chat.UserId, chat.OwnerId)
+		return config.ErrNoLevelKey
+	}
+
+	return nil
+}
+
+func handleUserInfoError(user *config.User, err error) error {
+	totalRequestErrors.WithLabelValues(user.FriendlyName, user.LeaseplanLevelKey).Inc()
+	log.Printf("Leaseplanwatcher %s(%d): could not get userInfo: %s\n", user.FriendlyName, user.UserId, err)
+
+	if !IsAuthError(err) {
 		user.WatcherError = err.Error()
 		user.WatcherActive = false
 		user.Save()
-
-		if strings.Contains(err.Error(), "401") || strings.Contains(err.Error(), "403") || strings.Contains(err.Error(), "Unauthorized") {
-			msg := tgbotapi.NewMessage(user.UserId, "⚠️ Dein Leaseplan Token ist abgelaufen oder ungültig. Bitte logge dich neu ein (/login), um weiterhin Benachrichtigungen zu erhalten.")
-			tgBot.Send(msg)
-		}
-
 		return err
 	}
-	user.LeaseplanLevelKey = lpUserInfo.AddressRole.RoleName
+
+	wasActive := user.WatcherActive
+	user.SuspendWatcher(err.Error())
 	user.Save()
 
-	return nil
+	if wasActive {
+		msg := tgbotapi.NewMessage(user.UserId, "⚠️ Dein Leaseplan Token ist abgelaufen oder ungültig. Bitte logge dich neu ein (/login), um weiterhin Benachrichtigungen zu erhalten. Deine bisherigen Einstellungen bleiben erhalten und werden nach dem Login automatisch wieder aktiviert.")
+		tgBot.Send(msg)
+	}
+
+	return err
+}
+
+// checkTokenExpiry warns the user once when the decoded token expiry is
+// closer than the configured warning window.
+func checkTokenExpiry(user *config.User) {
+	if tokenWarningHours <= 0 || user.TokenExpiryWarned || user.LeaseplanTokenExpiry.IsZero() {
+		return
+	}
+
+	remaining := time.Until(user.LeaseplanTokenExpiry)
+	if remaining > time.Duration(tokenWarningHours)*time.Hour {
+		return
+	}
+
+	log.Printf("Leaseplanwatcher %s(%d): token expires at %s\n", user.FriendlyName, user.UserId, user.LeaseplanTokenExpiry)
+	msg := tgbotapi.NewMessage(
+		user.UserId,
+		fmt.Sprintf("⏳ Dein Leaseplan Token läuft am %s ab. Bitte logge dich rechtzeitig neu ein (/login), damit deine Benachrichtigungen nicht unterbrochen werden.", user.LeaseplanTokenExpiry.Local().Format("02.01.2006 15:04")))
+	tgBot.Send(msg)
+
+	user.TokenExpiryWarned = true
+	user.Save()
 }
 
 func (watcher *LpWatcher) reallocateUser(user *config.User) {
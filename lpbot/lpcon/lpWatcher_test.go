@@ -0,0 +1,106 @@
+package lpcon_test
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/khase/leaseplan-bot/lpbot/config"
+	"github.com/khase/leaseplan-bot/lpbot/lpcon"
+	"golang.org/x/exp/slices"
+)
+
+func TestRegisterChatWatcher(t *testing.T) {
+	// the watchers shut down right away instead of polling leaseplan
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	lpcon.SetWatcherContext(ctx)
+	t.Cleanup(func() {
+		lpcon.WaitForWatchers()
+		lpcon.SetWatcherContext(context.Background())
+	})
+
+	userMap := config.NewUserMap(filepath.Join(t.TempDir(), "test.userdata"))
+	owner, _ := userMap.CreateNewUser(1, "owner")
+	owner.LeaseplanLevelKey = "Chat Level"
+	chat, err := userMap.CreateChatSubscription(-100, config.ChatTypeGroup, "group", owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the chat has no token, it must not be asked for its user info
+	owner.LeaseplanLevelKey = "Other Level"
+	lpcon.RegisterUserWatcher(chat)
+	t.Cleanup(func() { lpcon.UnregisterUserWatcher(chat) })
+
+	if !lpcon.IsUserWatched(chat) {
+		t.Fatalf("expected the chat to be registered")
+	}
+	if chat.LeaseplanLevelKey != "Other Level" || !slices.Contains(lpcon.GetWatcherKeys(), "Other Level") {
+		t.Fatalf("expected the chat to be watched with the level key of its owner but got %q (%v)", chat.LeaseplanLevelKey, lpcon.GetWatcherKeys())
+	}
+}
+
+func TestRegisterChatWatcherWithoutOwner(t *testing.T) {
+	userMap := config.NewUserMap(filepath.Join(t.TempDir(), "test.userdata"))
+	owner, _ := userMap.CreateNewUser(1, "owner")
+	owner.LeaseplanLevelKey = "Chat Level"
+	chat, err := userMap.CreateChatSubscription(-100, config.ChatTypeGroup, "group", owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userMap.DeleteUser(owner.UserId)
+	chat.LeaseplanLevelKey = ""
+
+	lpcon.RegisterUserWatcher(chat)
+	if lpcon.IsUserWatched(chat) {
+		t.Fatalf("expected a chat without level key not to be registered")
+	}
+}
+
+func TestConcurrentWatcherRegistration(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	lpcon.SetWatcherContext(ctx)
+	t.Cleanup(func() {
+		lpcon.WaitForWatchers()
+		lpcon.SetWatcherContext(context.Background())
+	})
+
+	userMap := config.NewUserMap(filepath.Join(t.TempDir(), "test.userdata"))
+	owner, _ := userMap.CreateNewUser(1, "owner")
+	owner.LeaseplanLevelKey = "Concurrent Level"
+	chats := []*config.User{}
+	for i := int64(1); i <= 10; i++ {
+		chat, err := userMap.CreateChatSubscription(-100-i, config.ChatTypeGroup, "group", owner)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chats = append(chats, chat)
+	}
+
+	var group sync.WaitGroup
+	for _, chat := range chats {
+		group.Add(2)
+		go func(chat *config.User) {
+			defer group.Done()
+			lpcon.RegisterUserWatcher(chat)
+			lpcon.UnregisterUserWatcher(chat)
+		}(chat)
+		go func(chat *config.User) {
+			defer group.Done()
+			lpcon.IsUserWatched(chat)
+			lpcon.GetCars()
+			lpcon.GetWatcherKeys()
+			lpcon.GetStates()
+		}(chat)
+	}
+	group.Wait()
+
+	for _, chat := range chats {
+		if lpcon.IsUserWatched(chat) {
+			t.Fatalf("expected all chats to be unregistered")
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package lpcon
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrTokenNotDecodable = errors.New("token does not contain a decodable expiry")
+)
+
+type tokenClaims struct {
+	ExpiresAt int64 `json:"exp"`
+}
+
+// GetTokenExpiry tries to read the expiry ("exp" claim) of a JWT formatted
+// leaseplan token. Tokens that are not JWTs return ErrTokenNotDecodable.
+func GetTokenExpiry(token string) (time.Time, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, ErrTokenNotDecodable
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}, ErrTokenNotDecodable
+	}
+
+	var claims tokenClaims
+	err = json.Unmarshal(payload, &claims)
+	if err != nil || claims.ExpiresAt == 0 {
+		return time.Time{}, ErrTokenNotDecodable
+	}
+
+	return time.Unix(claims.ExpiresAt, 0), nil
+}
@@ -0,0 +1,31 @@
+package lpcon_test
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/khase/leaseplan-bot/lpbot/lpcon"
+)
+
+func TestTokenExpiry(t *testing.T) {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"123","exp":1700000000}`))
+	token := "eyJhbGciOiJIUzI1NiJ9." + payload + ".c2lnbmF0dXJl"
+
+	expiry, err := lpcon.GetTokenExpiry(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !expiry.Equal(time.Unix(1700000000, 0)) {
+		t.Fatalf("expected expiry %s but got %s", time.Unix(1700000000, 0), expiry)
+	}
+}
+
+func TestTokenExpiryOpaqueToken(t *testing.T) {
+	_, err := lpcon.GetTokenExpiry("5f0c7a0e-8a43-4b43-9a9e-3c4a5c1f3b7d")
+	if !errors.Is(err, lpcon.ErrTokenNotDecodable) {
+		t.Fatalf("expected ErrTokenNotDecodable but got %v", err)
+	}
+}
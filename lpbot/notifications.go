@@ -0,0 +1,90 @@
+package lpbot
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/khase/leaseplan-bot/lpbot/config"
+	"github.com/khase/leaseplan-bot/lpbot/lpcon"
+)
+
+var (
+	notificationLock sync.Mutex
+)
+
+// scheduleSystemNotifications sends all due system notifications right away
+// and checks for newly due ones every minute.
+func scheduleSystemNotifications(ctx context.Context, userMap *config.UserMap, bot *tgbotapi.BotAPI) error {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		sendSystemNotifications(userMap, bot)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func sendSystemNotifications(userMap *config.UserMap, bot *tgbotapi.BotAPI) {
+	notificationLock.Lock()
+	defer notificationLock.Unlock()
+
+	changed := false
+	for _, notification := range userMap.GetNotifications() {
+		if notification.Prune(time.Now()) {
+			changed = true
+		}
+		if !notification.IsDue(time.Now()) || notification.IsExpired(time.Now()) {
+			continue
+		}
+
+		for _, user := range userMap.SortedUsers() {
+			if user.Banned || user.IsChat() || !notification.IsPending(user) {
+				continue
+			}
+			changed = true
+
+			if !notification.Matches(user) {
+				notification.RecordDelivery(user, false, nil)
+				continue
+			}
+
+			fmt.Printf("Sending System Notification %d to user %s(%d)\n", notification.Id, user.FriendlyName, user.UserId)
+			_, err := bot.Send(
+				tgbotapi.NewMessage(
+					user.UserId,
+					notification.Message,
+				),
+			)
+			notification.RecordDelivery(user, err == nil, err)
+			if err != nil {
+				continue
+			}
+
+			applyNotificationActions(user, notification.Actions)
+		}
+	}
+
+	if changed {
+		userMap.Save()
+	}
+}
+
+func applyNotificationActions(user *config.User, actions []string) {
+	for _, action := range actions {
+		switch action {
+		case config.NotificationActionPauseWatcher:
+			user.StopWatcher()
+			lpcon.UnregisterUserWatcher(user)
+		case config.NotificationActionClearCache:
+			user.ClearCache()
+		}
+	}
+}
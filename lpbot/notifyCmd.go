@@ -0,0 +1,113 @@
+package lpbot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/khase/leaseplan-bot/lpbot/config"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	notifyUsage = "/admin notify list\n/admin notify show <id>\n/admin notify delete <id>\n/admin notify add [at=2006-01-02T15:04] [watcher=true|false] [level=<key>] [filters=true|false] [lang=de] [action=pauseWatcher,clearCache]\n<Nachricht ab der zweiten Zeile>"
+)
+
+func handleAdminNotify(message *tgbotapi.Message, admin *config.User, arguments string) ([]tgbotapi.Chattable, error) {
+	firstLine, body, _ := strings.Cut(arguments, "\n")
+	args := strings.Fields(firstLine)
+	if len(args) == 0 {
+		return replyText(message, fmt.Sprintf("Verfügbare Kommandos:\n%s", notifyUsage)), nil
+	}
+
+	notificationLock.Lock()
+	defer notificationLock.Unlock()
+
+	switch args[0] {
+	case "list":
+		notifications := UserMap.GetNotifications()
+		if len(notifications) == 0 {
+			return replyText(message, "Es sind keine Systemnachrichten hinterlegt."), nil
+		}
+		lines := []string{}
+		for _, notification := range notifications {
+			sent, skipped, failed := notification.CountDeliveries()
+			lines = append(lines, fmt.Sprintf("#%d %s (gesendet: %d, übersprungen: %d, fehlgeschlagen: %d): %s", notification.Id, notification.Publish.Format("02.01.2006 15:04"), sent, skipped, failed, getNotificationPreview(notification.Message)))
+		}
+		return replyLines(message, lines), nil
+
+	case "show":
+		notification, reply := findNotification(message, args)
+		if notification == nil {
+			return reply, nil
+		}
+		data, err := yaml.Marshal(notification)
+		if err != nil {
+			return nil, err
+		}
+		return replyText(message, string(data)), nil
+
+	case "delete":
+		notification, reply := findNotification(message, args)
+		if notification == nil {
+			return reply, nil
+		}
+		UserMap.RemoveNotification(notification.Id)
+		UserMap.Save()
+		audit(admin, "delete notification #%d", notification.Id)
+		return replyText(message, fmt.Sprintf("Systemnachricht #%d gelöscht 🗑", notification.Id)), nil
+
+	case "add":
+		body = strings.TrimSpace(body)
+		if body == "" {
+			return replyText(message, fmt.Sprintf("Die Nachricht fehlt, sie beginnt in der zweiten Zeile:\n%s", notifyUsage)), nil
+		}
+
+		notification := config.NewSystemNotification(time.Now(), body)
+		notification.CreatedBy = fmt.Sprintf("%s(%d)", admin.FriendlyName, admin.UserId)
+		for _, option := range args[1:] {
+			key, value, _ := strings.Cut(option, "=")
+			if err := notification.SetOption(key, value); err != nil {
+				return replyText(message, fmt.Sprintf("Die Option '%s' ist ungültig: %s", option, err)), nil
+			}
+		}
+
+		UserMap.AddNotification(notification)
+		UserMap.Save()
+		audit(admin, "add notification #%d published at %s", notification.Id, notification.Publish)
+
+		return replyText(message, fmt.Sprintf("Systemnachricht #%d wird am %s veröffentlicht 📣", notification.Id, notification.Publish.Format("02.01.2006 15:04"))), nil
+	}
+
+	return replyText(message, fmt.Sprintf("Ich kann mit '%s' leider nichts anfangen 😨", args[0])), nil
+}
+
+func findNotification(message *tgbotapi.Message, args []string) (*config.SystemNotification, []tgbotapi.Chattable) {
+	if len(args) < 2 {
+		return nil, replyText(message, fmt.Sprintf("Bitte gib die Nummer der Systemnachricht an: /admin notify %s <id>", args[0]))
+	}
+
+	id, err := strconv.Atoi(strings.TrimPrefix(args[1], "#"))
+	if err != nil {
+		return nil, replyText(message, fmt.Sprintf("'%s' ist keine gültige Nummer", args[1]))
+	}
+
+	notification := UserMap.FindNotification(id)
+	if notification == nil {
+		return nil, replyText(message, fmt.Sprintf("Systemnachricht #%d gibt es nicht", id))
+	}
+
+	return notification, nil
+}
+
+func getNotificationPreview(text string) string {
+	line, _, _ := strings.Cut(text, "\n")
+	preview := []rune(line)
+	if len(preview) > 60 {
+		return string(preview[:60]) + "…"
+	}
+
+	return line
+}
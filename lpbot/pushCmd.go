@@ -0,0 +1,93 @@
+package lpbot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/khase/leaseplan-bot/lpbot/config"
+	"github.com/khase/leaseplan-bot/lpbot/tgcon"
+)
+
+const pushUsage = "Verwendung:\n/push - zeigt den aktuellen Benachrichtigungskanal\n/push ntfy <topic url> [token] - sendet Änderungen an ein ntfy Topic\n/push gotify <server url> <app token> - sendet Änderungen an einen Gotify Server\n/push matrix <homeserver url> <raum id> <access token> - sendet Änderungen in einen Matrix Raum\n/push test - sendet eine Testbenachrichtigung\n/push off - sendet Änderungen wieder per Telegram"
+
+var (
+	PushCmd = &tgcon.MessageCommand{
+		CommandTrigger:   "push",
+		ShortDescription: "sendet Änderungen an ntfy, Gotify oder Matrix",
+		Description:      pushUsage,
+		Execute: func(message *tgbotapi.Message) ([]tgbotapi.Chattable, error) {
+			return handlePushCommand(message, UserMap.GetUser(message.From.ID))
+		},
+	}
+)
+
+func handlePushCommand(message *tgbotapi.Message, user *config.User) ([]tgbotapi.Chattable, error) {
+	if user == nil {
+		return nil, tgcon.ErrCommandPermittedForUnknownUser
+	}
+
+	arguments := strings.Fields(message.CommandArguments())
+	if len(arguments) == 0 {
+		if !isPushNotifier(user.Notifier) {
+			return replyText(message, "Du hast keinen Push Dienst eingerichtet.\n\n"+pushUsage), nil
+		}
+		return replyText(message, fmt.Sprintf("Änderungen werden per %s gesendet.\n\n%s", user.Notifier, pushUsage)), nil
+	}
+
+	var err error
+	switch {
+	case arguments[0] == "off":
+		user.DisablePush()
+		user.Save()
+
+		return replyText(message, "Änderungen werden dir ab sofort wieder per Telegram geschickt 📬"), nil
+	case arguments[0] == "test":
+		if !isPushNotifier(user.Notifier) {
+			return replyText(message, "Du hast keinen Push Dienst eingerichtet.\n\n"+pushUsage), nil
+		}
+
+		notifier, frame := user.GetNotifier(nil), getTestFrame(user)
+		replyLater(message, func() string {
+			err := notifier.Notify(context.Background(), user, frame)
+			if err != nil {
+				return fmt.Sprintf("Die Testbenachrichtigung konnte nicht zugestellt werden: %s", err)
+			}
+			return "Die Testbenachrichtigung wurde zugestellt ✅"
+		})
+		return replyText(message, "Die Testbenachrichtigung wird gesendet …"), nil
+	case arguments[0] == config.NotifierNtfy && (len(arguments) == 2 || len(arguments) == 3):
+		token := ""
+		if len(arguments) == 3 {
+			token = arguments[2]
+		}
+		err = user.SetNtfy(arguments[1], token)
+	case arguments[0] == config.NotifierGotify && len(arguments) == 3:
+		err = user.SetGotify(arguments[1], arguments[2])
+	case arguments[0] == config.NotifierMatrix && len(arguments) == 4:
+		err = user.SetMatrix(arguments[1], arguments[2], arguments[3])
+	default:
+		return replyText(message, pushUsage), nil
+	}
+
+	if errors.Is(err, config.ErrInvalidPushUrl) {
+		return replyText(message, "Das ist leider keine gültige http(s) url.\n\n"+pushUsage), nil
+	} else if errors.Is(err, config.ErrPrivateAddress) {
+		return replyText(message, "Push Dienste müssen unter einer öffentlich erreichbaren Adresse laufen."), nil
+	} else if errors.Is(err, config.ErrUnresolvableHost) {
+		return replyText(message, "Der Server der url konnte nicht gefunden werden.\n\n"+pushUsage), nil
+	} else if errors.Is(err, config.ErrInvalidRoomId) {
+		return replyText(message, "Die Raum id muss die Form !raum:server haben. Du findest sie in den erweiterten Einstellungen des Raums.\n\n"+pushUsage), nil
+	} else if err != nil {
+		return replyText(message, fmt.Sprintf("%s\n\n%s", err, pushUsage)), nil
+	}
+	user.Save()
+
+	return replyText(message, fmt.Sprintf("Änderungen werden ab sofort per %s gesendet. Mit /push test kannst du es ausprobieren. Lösche am besten deine Nachricht mit dem Token 🔐", user.Notifier)), nil
+}
+
+func isPushNotifier(notifier string) bool {
+	return notifier == config.NotifierNtfy || notifier == config.NotifierGotify || notifier == config.NotifierMatrix
+}
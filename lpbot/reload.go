@@ -0,0 +1,135 @@
+package lpbot
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/khase/leaseplan-bot/lpbot/api"
+	"github.com/khase/leaseplan-bot/lpbot/config"
+	"github.com/khase/leaseplan-bot/lpbot/lpcon"
+	"github.com/spf13/viper"
+)
+
+var (
+	reloadLock sync.Mutex
+)
+
+type ReloadReport struct {
+	Users *config.UserMapChanges
+
+	WatchersStarted []*config.User
+	WatchersStopped []*config.User
+
+	Settings     []string
+	TokenRotated bool
+	Warnings     []string
+}
+
+// Reload re-reads the userdata file as well as the config and reconciles the
+// running watchers with the result.
+func Reload() (*ReloadReport, error) {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+
+	report := new(ReloadReport)
+
+	err := viper.ReadInConfig()
+	if err != nil {
+		report.Warnings = append(report.Warnings, fmt.Sprintf("config konnte nicht gelesen werden: %s", err))
+	}
+
+	// the notifications are replaced as well
+	notificationLock.Lock()
+	changes, err := UserMap.Reload()
+	notificationLock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	report.Users = changes
+
+	botConfig := config.LoadBotConfig()
+	if err := botConfig.Validate(false); err != nil {
+		report.Warnings = append(report.Warnings, fmt.Sprintf("Einstellungen werden nicht übernommen: %s", err))
+	} else {
+		report.reloadSetting("watcherDelay", botConfig.WatcherDelay, lpcon.GetWatcherDelay, lpcon.SetWatcherDelay)
+		report.reloadSetting("watcherPageSize", botConfig.WatcherPageSize, lpcon.GetWatcherPageSize, lpcon.SetWatcherPageSize)
+		report.reloadSetting("tokenWarningHours", botConfig.TokenWarningHours, lpcon.GetTokenWarningHours, lpcon.SetTokenWarningHours)
+		report.reloadSetting("sendDelay", botConfig.SendDelay, config.GetSendDelay, config.SetSendDelay)
+
+		api.SetAdminKeys(botConfig.ApiAdminKeys)
+
+		rotated, err := tgConnector.RotateToken(botConfig.TelegramApiToken)
+		if err != nil {
+			report.Warnings = append(report.Warnings, fmt.Sprintf("telegram token wurde nicht übernommen: %s", err))
+		}
+		report.TokenRotated = rotated
+	}
+
+	for _, user := range changes.Removed {
+		if lpcon.IsUserWatched(user) {
+			lpcon.UnregisterUserWatcher(user)
+			report.WatchersStopped = append(report.WatchersStopped, user)
+		}
+	}
+	for _, user := range UserMap.SortedUsers() {
+		if !lpcon.SyncUserWatcher(user) {
+			continue
+		}
+		if lpcon.IsUserWatched(user) {
+			report.WatchersStarted = append(report.WatchersStarted, user)
+		} else {
+			report.WatchersStopped = append(report.WatchersStopped, user)
+		}
+	}
+
+	log.Printf("Reload finished:\n%s", report)
+
+	return report, nil
+}
+
+func (report *ReloadReport) reloadSetting(key string, newValue int, get func() int, set func(int)) {
+	oldValue := get()
+	if newValue == oldValue {
+		return
+	}
+
+	set(newValue)
+	report.Settings = append(report.Settings, fmt.Sprintf("%s: %d -> %d", key, oldValue, newValue))
+}
+
+func (report *ReloadReport) String() string {
+	buf := new(bytes.Buffer)
+
+	buf.WriteString(fmt.Sprintf("Benutzer: +%d, -%d, ~%d\n", len(report.Users.Added), len(report.Users.Removed), len(report.Users.Changed)))
+	writeUserList(buf, "  neu", report.Users.Added)
+	writeUserList(buf, "  entfernt", report.Users.Removed)
+	writeUserList(buf, "  geändert", report.Users.Changed)
+	writeUserList(buf, "Watcher gestartet", report.WatchersStarted)
+	writeUserList(buf, "Watcher gestoppt", report.WatchersStopped)
+
+	for _, setting := range report.Settings {
+		buf.WriteString(fmt.Sprintf("%s\n", setting))
+	}
+	if report.TokenRotated {
+		buf.WriteString("Telegram Token wurde getauscht\n")
+	}
+	for _, warning := range report.Warnings {
+		buf.WriteString(fmt.Sprintf("⚠️ %s\n", warning))
+	}
+
+	return buf.String()
+}
+
+func writeUserList(buf *bytes.Buffer, title string, users []*config.User) {
+	if len(users) == 0 {
+		return
+	}
+
+	buf.WriteString(fmt.Sprintf("%s:", title))
+	for _, user := range users {
+		buf.WriteString(fmt.Sprintf(" %s(%d)", user.FriendlyName, user.UserId))
+	}
+	buf.WriteString("\n")
+}
@@ -0,0 +1,221 @@
+package lpbot
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/khase/leaseplan-bot/lpbot/config"
+	"github.com/khase/leaseplan-bot/lpbot/lpcon"
+	"github.com/khase/leaseplan-bot/lpbot/tgcon"
+)
+
+const (
+	searchUsage = "Verwendung:\n/search <Suche> - sucht in allen Autos die du gerade sehen kannst, z.B. /search bmw elektro hp>300 sort:netcost\n/search save <Name> <Suche> - speichert die Suche, du bekommst eine Nachricht bei neuen Treffern\n/search list - zeigt deine gespeicherten Suchen\n/search <Name> - führt eine gespeicherte Suche aus\n/search delete <Name> - löscht eine gespeicherte Suche\n\nWörter müssen in Marke, Modell, Angebot oder Antrieb vorkommen. Vergleiche gehen mit hp, blp, bgv, netcost und tax (z.B. bgv<=400), sortiert wird mit sort:model, sort:blp, sort:bgv, sort:netcost, sort:hp oder sort:availability (absteigend mit sort:-hp)."
+
+	searchCallbackPrefix = "search"
+	searchPageSize       = 10
+	// searchPageLength keeps a page below the message limit of telegram
+	searchPageLength = 3500
+)
+
+var (
+	SearchCmd = &tgcon.MessageCommand{
+		CommandTrigger:   "search",
+		ShortDescription: "sucht in den aktuell verfügbaren Autos",
+		Description:      searchUsage,
+		GroupCommand:     true,
+		Execute: func(message *tgbotapi.Message) ([]tgbotapi.Chattable, error) {
+			return handleSearchCommand(message, getSubscriber(message))
+		},
+	}
+	SearchCallback = &tgcon.CallbackCommand{
+		Prefix: searchCallbackPrefix,
+		Execute: func(query *tgbotapi.CallbackQuery, arguments string) (string, []tgbotapi.Chattable, error) {
+			subscriber, err := getCallbackSubscriber(query)
+			if err != nil {
+				return "", nil, err
+			}
+			return handleSearchPage(query, subscriber, arguments)
+		},
+	}
+)
+
+func handleSearchCommand(message *tgbotapi.Message, user *config.User) ([]tgbotapi.Chattable, error) {
+	if user == nil {
+		return nil, tgcon.ErrCommandPermittedForUnknownUser
+	}
+
+	arguments := strings.TrimSpace(message.CommandArguments())
+	args := strings.Fields(arguments)
+	switch {
+	case len(args) == 0:
+		return replyText(message, searchUsage), nil
+	case args[0] == "list" && len(args) == 1:
+		return handleSearchList(message, user), nil
+	case args[0] == "save" && len(args) >= 3:
+		return handleSearchSave(message, user, args[1], strings.Join(args[2:], " "))
+	case args[0] == "delete" && len(args) == 2:
+		err := user.DeleteSavedSearch(args[1])
+		if errors.Is(err, config.ErrSearchNotFound) {
+			return replyText(message, fmt.Sprintf("Du hast keine Suche %s gespeichert.", args[1])), nil
+		}
+		user.Save()
+		return replyText(message, fmt.Sprintf("Die Suche %s wurde gelöscht 🗑", args[1])), nil
+	}
+
+	if saved := user.GetSavedSearch(arguments); saved != nil {
+		arguments = saved.Query
+	}
+	search, err := config.ParseSearchQuery(arguments)
+	if errors.Is(err, config.ErrInvalidSearchQuery) {
+		return replyText(message, fmt.Sprintf("Diese Suche verstehe ich leider nicht 😨: %s\n\n%s", err, searchUsage)), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	key := user.RememberSearch(search.Query)
+	user.Save()
+
+	text, keyboard, err := getSearchPage(user, search, key, 0)
+	if err != nil {
+		return nil, err
+	}
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	msg.ParseMode = "Markdown"
+	msg.DisableWebPagePreview = true
+	if keyboard != nil {
+		msg.ReplyMarkup = *keyboard
+	}
+
+	return []tgbotapi.Chattable{msg}, nil
+}
+
+func handleSearchSave(message *tgbotapi.Message, user *config.User, name string, query string) ([]tgbotapi.Chattable, error) {
+	saved, err := user.SaveSearch(name, query, lpcon.GetCars()[user.LeaseplanLevelKey])
+	if errors.Is(err, config.ErrInvalidSearchQuery) {
+		return replyText(message, fmt.Sprintf("Diese Suche verstehe ich leider nicht 😨: %s", err)), nil
+	} else if errors.Is(err, config.ErrSearchExists) {
+		return replyText(message, fmt.Sprintf("Du hast bereits eine Suche %s gespeichert, lösche sie zuerst mit /search delete %s", name, name)), nil
+	} else if errors.Is(err, config.ErrTooManySearches) {
+		return replyText(message, fmt.Sprintf("Du kannst höchstens %d Suchen speichern.", config.MaxSavedSearches)), nil
+	} else if err != nil {
+		return nil, err
+	}
+	user.Save()
+
+	return replyText(message, fmt.Sprintf("Suche %s gespeichert 💾 Aktuell gibt es %d Treffer, ich sage dir Bescheid sobald neue dazukommen.", saved.Name, len(saved.Matches))), nil
+}
+
+func handleSearchList(message *tgbotapi.Message, user *config.User) []tgbotapi.Chattable {
+	savedSearches := user.GetSavedSearches()
+	if len(savedSearches) == 0 {
+		return replyText(message, "Du hast noch keine Suchen gespeichert. Das geht mit /search save <Name> <Suche>.")
+	}
+
+	lines := []string{"Deine gespeicherten Suchen:"}
+	for _, saved := range savedSearches {
+		lines = append(lines, fmt.Sprintf("%s: %s (%d Treffer)", saved.Name, saved.Query, len(saved.Matches)))
+	}
+
+	return replyLines(message, lines)
+}
+
+func handleSearchPage(query *tgbotapi.CallbackQuery, user *config.User, arguments string) (string, []tgbotapi.Chattable, error) {
+	key, pageArgument, found := strings.Cut(arguments, ":")
+	page, err := strconv.Atoi(pageArgument)
+	if !found || err != nil {
+		return "", nil, tgcon.ErrCommandNotImplemented
+	}
+	searchText, found := user.GetSearchByKey(key)
+	if !found {
+		return "Diese Suche ist abgelaufen, starte eine neue mit /search", nil, nil
+	}
+	search, err := config.ParseSearchQuery(searchText)
+	if err != nil {
+		return "Diese Suche ist abgelaufen, starte eine neue mit /search", nil, nil
+	}
+
+	text, keyboard, err := getSearchPage(user, search, key, page)
+	if err != nil {
+		return "", nil, err
+	}
+	edit := tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, text)
+	edit.ParseMode = "Markdown"
+	edit.DisableWebPagePreview = true
+	edit.ReplyMarkup = keyboard
+
+	return "", []tgbotapi.Chattable{edit}, nil
+}
+
+// getSearchPage renders one page of search results with buttons to the
+// previous and next page. The buttons reference the query by its key.
+func getSearchPage(user *config.User, search *config.SearchQuery, key string, page int) (string, *tgbotapi.InlineKeyboardMarkup, error) {
+	cars := search.Find(lpcon.GetCars()[user.LeaseplanLevelKey])
+	query := tgbotapi.EscapeText(tgbotapi.ModeMarkdown, search.Query)
+	if len(cars) == 0 {
+		return fmt.Sprintf("🔎 Keine Treffer für %s", query), nil, nil
+	}
+
+	lines := make([]string, 0, len(cars))
+	for _, car := range cars {
+		line, err := config.GetCarDetails(car, user.DetailMessageTemplate, user.TemplateFuncs())
+		if err != nil {
+			return "", nil, err
+		}
+		lines = append(lines, line)
+	}
+
+	starts := getSearchPageStarts(lines)
+	pages := len(starts)
+	if page < 0 {
+		page = 0
+	} else if page >= pages {
+		page = pages - 1
+	}
+	start := starts[page]
+	end := len(lines)
+	if page < pages-1 {
+		end = starts[page+1]
+	}
+
+	text := fmt.Sprintf("🔎 Treffer %d-%d von %d für %s:\n%s", start+1, end, len(cars), query, strings.Join(lines[start:end], "\n"))
+	if pages == 1 {
+		return text, nil, nil
+	}
+	buttons := []tgbotapi.InlineKeyboardButton{}
+	if page > 0 {
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData("◀️", getSearchCallbackData(key, page-1)))
+	}
+	buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("%d/%d", page+1, pages), getSearchCallbackData(key, page)))
+	if page < pages-1 {
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData("▶️", getSearchCallbackData(key, page+1)))
+	}
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(buttons)
+
+	return text, &keyboard, nil
+}
+
+// getSearchPageStarts splits the result lines into pages of up to
+// searchPageSize lines and searchPageLength bytes and returns the index of
+// the first line of every page.
+func getSearchPageStarts(lines []string) []int {
+	starts := []int{0}
+	count, length := 0, 0
+	for i, line := range lines {
+		if count > 0 && (count == searchPageSize || length+len(line)+1 > searchPageLength) {
+			starts = append(starts, i)
+			count, length = 0, 0
+		}
+		count++
+		length += len(line) + 1
+	}
+
+	return starts
+}
+
+func getSearchCallbackData(key string, page int) string {
+	return tgcon.NewCallbackData(searchCallbackPrefix, key+":"+strconv.Itoa(page))
+}
@@ -0,0 +1,49 @@
+package lpbot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/khase/leaseplan-bot/lpbot/config"
+	"github.com/khase/leaseplan-bot/lpbot/tgcon"
+)
+
+const (
+	statsUsage = "Verwendung:\n/stats - zeigt, wie sich die Autos auf deiner Stufe und deine gefilterten Autos in den letzten 30 Tagen entwickelt haben\n/stats <Tage> - zeigt die letzten Tage, höchstens 90"
+)
+
+var (
+	StatsCmd = &tgcon.MessageCommand{
+		CommandTrigger:   "stats",
+		ShortDescription: "zeigt die Entwicklung des Angebots als Diagramm",
+		Description:      statsUsage,
+		GroupCommand:     true,
+		Execute: func(message *tgbotapi.Message) ([]tgbotapi.Chattable, error) {
+			return handleStatsCommand(message, getSubscriber(message))
+		},
+	}
+)
+
+func handleStatsCommand(message *tgbotapi.Message, user *config.User) ([]tgbotapi.Chattable, error) {
+	if user == nil {
+		return nil, tgcon.ErrCommandPermittedForUnknownUser
+	}
+
+	days := config.DefaultStatsDays
+	if argument := strings.TrimSpace(message.CommandArguments()); argument != "" {
+		value, err := strconv.Atoi(argument)
+		if err != nil || value < 1 || value > config.MaxStatsDays {
+			return replyText(message, fmt.Sprintf("Was diese \"%s\"??? Bitte gib eine Anzahl Tage zwischen 1 und %d an.", argument, config.MaxStatsDays)), nil
+		}
+		days = value
+	}
+
+	messages, err := config.GetStatsMessages(user, user.GetAssortmentReport(days), days)
+	if err != nil {
+		return nil, err
+	}
+
+	return toChat(messages, message.Chat.ID), nil
+}
@@ -0,0 +1,19 @@
+package tgcon
+
+import (
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// CallbackCommand handles the callback queries of inline buttons. The data of
+// a button has the form "<Prefix>:<arguments>", the arguments are passed to
+// Execute. The returned text is shown to the user as answer of the query.
+type CallbackCommand struct {
+	Prefix  string
+	Execute func(query *tgbotapi.CallbackQuery, arguments string) (string, []tgbotapi.Chattable, error)
+}
+
+// NewCallbackData builds the data of an inline button for the given callback
+// prefix. Telegram limits the data to 64 bytes.
+func NewCallbackData(prefix string, arguments string) string {
+	return prefix + ":" + arguments
+}
@@ -4,9 +4,17 @@ import (
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
+// MessageCommand describes a bot command. Only commands flagged as
+// GroupCommand may be used in group chats.
 type MessageCommand struct {
 	CommandTrigger   string
 	ShortDescription string
 	Description      string
+	AdminOnly        bool
+	GroupCommand     bool
 	Execute          func(message *tgbotapi.Message) ([]tgbotapi.Chattable, error)
 }
+
+// PermissionCheck decides whether the sender of a message may execute the
+// given command. It should return ErrCommandPermitted to deny the command.
+type PermissionCheck func(message *tgbotapi.Message, cmd *MessageCommand) error
@@ -0,0 +1,18 @@
+package tgcon
+
+import (
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Send delivers a message to telegram. Media groups are answered with a list
+// of messages, which BotAPI.Send can not decode, so they are sent via
+// SendMediaGroup.
+func Send(bot *tgbotapi.BotAPI, message tgbotapi.Chattable) error {
+	if mediaGroup, ok := message.(tgbotapi.MediaGroupConfig); ok {
+		_, err := bot.SendMediaGroup(mediaGroup)
+		return err
+	}
+
+	_, err := bot.Send(message)
+	return err
+}
@@ -2,11 +2,13 @@ package tgcon
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"log"
 	"math"
 	"math/rand"
+	"net/http"
 	"strconv"
 	"strings"
 
@@ -58,23 +60,32 @@ var (
 			"username",
 		})
 
-	ErrTelegramTokenUnser = errors.New("no telegram token has been provided")
+	ErrTelegramTokenUnser  = errors.New("no telegram token has been provided")
+	ErrUpdateChannelClosed = errors.New("telegram update channel has been closed")
+	ErrTelegramBotChanged  = errors.New("the new telegram token belongs to a different bot")
 
 	ErrUnknown                        = errors.New("internal error")
 	ErrCommandNotImplemented          = errors.New("command not implemended")
 	ErrCommandPermittedForUnknownUser = errors.New("this command can not be executed by unknown users")
 	ErrCommandPermitted               = errors.New("this command can not be executed by this users")
+	ErrUserBanned                     = errors.New("this user has been banned")
+	ErrCommandOnlyInPrivateChat       = errors.New("this command can only be executed in private chats")
+	ErrChatAdminRequired              = errors.New("this command can only be executed by chat admins")
+	ErrChatNotSubscribed              = errors.New("this chat has not been subscribed yet")
 )
 
 type TgConnector struct {
 	token string
 	debug bool
 
-	telegram *tgbotapi.BotAPI
+	telegram  *tgbotapi.BotAPI
+	transport *tokenTransport
 
 	receiverRunning bool
 
-	commands []*MessageCommand
+	commands        []*MessageCommand
+	callbacks       []*CallbackCommand
+	permissionCheck PermissionCheck
 }
 
 func NewTgConnector(token string, debug bool) *TgConnector {
@@ -83,6 +94,7 @@ func NewTgConnector(token string, debug bool) *TgConnector {
 	tgCon.debug = debug
 	tgCon.receiverRunning = false
 	tgCon.commands = []*MessageCommand{}
+	tgCon.callbacks = []*CallbackCommand{}
 
 	return tgCon
 }
@@ -91,6 +103,14 @@ func (bot *TgConnector) AddCommand(cmd *MessageCommand) {
 	bot.commands = append(bot.commands, cmd)
 }
 
+func (bot *TgConnector) AddCallback(callback *CallbackCommand) {
+	bot.callbacks = append(bot.callbacks, callback)
+}
+
+func (bot *TgConnector) SetPermissionCheck(check PermissionCheck) {
+	bot.permissionCheck = check
+}
+
 func (bot *TgConnector) GetTgBotApi() *tgbotapi.BotAPI {
 	return bot.telegram
 }
@@ -100,7 +120,8 @@ func (bot *TgConnector) Init() error {
 		return ErrTelegramTokenUnser
 	}
 
-	telegram, err := tgbotapi.NewBotAPI(bot.token)
+	bot.transport = &tokenTransport{token: bot.token}
+	telegram, err := tgbotapi.NewBotAPIWithClient(bot.token, tgbotapi.APIEndpoint, &http.Client{Transport: bot.transport})
 	if err != nil {
 		return err
 	}
@@ -112,7 +133,34 @@ func (bot *TgConnector) Init() error {
 	return nil
 }
 
-func (bot *TgConnector) ReceiveMessages() {
+// RotateToken switches the running connector to a new token of the same bot.
+// The token is swapped in the transport of the existing api object so every
+// component holding it keeps working. It reports whether the token actually
+// changed.
+func (bot *TgConnector) RotateToken(token string) (bool, error) {
+	if token == "" {
+		return false, ErrTelegramTokenUnser
+	}
+	if token == bot.token {
+		return false, nil
+	}
+
+	telegram, err := tgbotapi.NewBotAPI(token)
+	if err != nil {
+		return false, err
+	}
+	if telegram.Self.ID != bot.telegram.Self.ID {
+		return false, ErrTelegramBotChanged
+	}
+
+	log.Printf("Telegram: rotating token for account %s", telegram.Self.UserName)
+	bot.token = token
+	bot.transport.setToken(token)
+
+	return true, nil
+}
+
+func (bot *TgConnector) ReceiveMessages(ctx context.Context) error {
 	log.Printf("Telegram receiver (%s): started.", bot.telegram.Self.UserName)
 	bot.receiverRunning = true
 	defer func() {
@@ -125,10 +173,14 @@ func (bot *TgConnector) ReceiveMessages() {
 
 	updates := bot.telegram.GetUpdatesChan(u)
 
-	for bot.receiverRunning {
-		for update := range updates {
-			if !bot.receiverRunning {
-				return
+	for {
+		select {
+		case <-ctx.Done():
+			bot.telegram.StopReceivingUpdates()
+			return nil
+		case update, ok := <-updates:
+			if !ok {
+				return ErrUpdateChannelClosed
 			}
 			if update.Message != nil { // If we got a message
 				if err := bot.handleMessage(update.Message); err != nil {
@@ -137,12 +189,63 @@ func (bot *TgConnector) ReceiveMessages() {
 					fmt.Printf("Error occured for user \"%s\": %s", update.Message.From.UserName, err)
 				}
 			}
+			if update.CallbackQuery != nil {
+				if err := bot.handleCallbackQuery(update.CallbackQuery); err != nil {
+					totalErrorsOccured.WithLabelValues(update.CallbackQuery.From.UserName).Inc()
+
+					fmt.Printf("Error occured for user \"%s\": %s", update.CallbackQuery.From.UserName, err)
+				}
+			}
 		}
 	}
 }
 
-func (bot *TgConnector) Shutdown() {
-	bot.receiverRunning = false
+func (bot *TgConnector) handleCallbackQuery(query *tgbotapi.CallbackQuery) error {
+	answer := tgbotapi.NewCallback(query.ID, "")
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("catched Panic: %+v\n", r)
+			totalPanicsCatched.WithLabelValues(query.From.FirstName).Inc()
+			answer.Text = "Ouch, da ist aber etwas richtig schief gelaufen 🤯"
+		}
+		bot.telegram.Request(answer)
+	}()
+
+	log.Printf("Handle callback query from %s: %s", query.From.FirstName, query.Data)
+	prefix, arguments, _ := strings.Cut(query.Data, ":")
+	for _, callback := range bot.callbacks {
+		if callback.Prefix != prefix {
+			continue
+		}
+
+		text, resultMessages, err := callback.Execute(query, arguments)
+		answer.Text = text
+		for _, resultMessage := range resultMessages {
+			if resultMessage == nil {
+				continue
+			}
+			Send(bot.telegram, resultMessage)
+		}
+
+		switch {
+		case errors.Is(err, ErrCommandNotImplemented):
+			answer.Text = "Tut mir leid, aber das kann ich leider noch nicht 😣"
+		case errors.Is(err, ErrCommandPermittedForUnknownUser):
+			answer.Text = "Du hast noch gar kein Profil bei mir, starte mit /start 😉"
+		case errors.Is(err, ErrUserBanned):
+			answer.Text = "Du wurdest vom Bot-Admin gesperrt ⛔"
+		case errors.Is(err, ErrChatAdminRequired):
+			answer.Text = "Nur Admins dieses Chats können das 👮"
+		case err != nil:
+			answer.Text = "Ouch, da ist irgendetwas schief gelaufen 😵"
+			return err
+		}
+
+		return nil
+	}
+
+	answer.Text = "Tut mir leid, aber das kann ich leider noch nicht 😣"
+	return ErrCommandNotImplemented
 }
 
 func (bot *TgConnector) handleMessage(message *tgbotapi.Message) error {
@@ -166,6 +269,11 @@ func (bot *TgConnector) handleMessage(message *tgbotapi.Message) error {
 		totalNonCommandMessagesRecieved.WithLabelValues(message.From.FirstName).Inc()
 		log.Printf("Got non command Message from %s: %s", message.From.FirstName, message.Text)
 
+		// stay quiet in groups
+		if !message.Chat.IsPrivate() {
+			return nil
+		}
+
 		msg := tgbotapi.NewMessage(message.Chat.ID, strconv.FormatInt(int64(math.Pow(2, 16))+rand.Int63n(int64(math.Pow(2, 32))), 2))
 		msg.ReplyToMessageID = message.MessageID
 
@@ -188,6 +296,46 @@ func (bot *TgConnector) handleMessage(message *tgbotapi.Message) error {
 				fmt.Sprintf("Hallo %s,\ndu hast noch gar kein Profil bei mir. Du kannst jeder Zeit mit dem Kommando /start ein Profil bei mir erstellen 😉", message.From.FirstName))
 			msg.ReplyToMessageID = message.MessageID
 
+			bot.telegram.Send(msg)
+			return nil
+		} else if errors.Is(err, ErrCommandPermitted) {
+			msg := tgbotapi.NewMessage(
+				message.Chat.ID,
+				"Dieses Kommando ist leider nur für Admins verfügbar 👮")
+			msg.ReplyToMessageID = message.MessageID
+
+			bot.telegram.Send(msg)
+			return nil
+		} else if errors.Is(err, ErrUserBanned) {
+			msg := tgbotapi.NewMessage(
+				message.Chat.ID,
+				"Du wurdest vom Bot-Admin gesperrt ⛔")
+			msg.ReplyToMessageID = message.MessageID
+
+			bot.telegram.Send(msg)
+			return nil
+		} else if errors.Is(err, ErrCommandOnlyInPrivateChat) {
+			msg := tgbotapi.NewMessage(
+				message.Chat.ID,
+				"Dieses Kommando funktioniert nur in einem privaten Chat mit mir 🤫")
+			msg.ReplyToMessageID = message.MessageID
+
+			bot.telegram.Send(msg)
+			return nil
+		} else if errors.Is(err, ErrChatAdminRequired) {
+			msg := tgbotapi.NewMessage(
+				message.Chat.ID,
+				"Nur Admins dieser Gruppe können mich hier einstellen 👮")
+			msg.ReplyToMessageID = message.MessageID
+
+			bot.telegram.Send(msg)
+			return nil
+		} else if errors.Is(err, ErrChatNotSubscribed) {
+			msg := tgbotapi.NewMessage(
+				message.Chat.ID,
+				"Diese Gruppe ist noch nicht eingerichtet. Ein Admin der Gruppe kann das mit /group subscribe erledigen.")
+			msg.ReplyToMessageID = message.MessageID
+
 			bot.telegram.Send(msg)
 			return nil
 		} else if err != nil {
@@ -207,17 +355,38 @@ func (bot *TgConnector) handleMessage(message *tgbotapi.Message) error {
 func (bot *TgConnector) GetCommandDescriptions() string {
 	buf := new(bytes.Buffer)
 	for _, cmd := range bot.commands {
+		if cmd.AdminOnly {
+			continue
+		}
 		buf.WriteString(fmt.Sprintf("%s - %s\n", strings.ToLower(cmd.CommandTrigger), cmd.ShortDescription))
 	}
 
 	return buf.String()
 }
 
+func (bot *TgConnector) checkPermission(message *tgbotapi.Message, cmd *MessageCommand) error {
+	if bot.permissionCheck == nil {
+		if cmd.AdminOnly {
+			return ErrCommandPermitted
+		}
+		return nil
+	}
+
+	return bot.permissionCheck(message, cmd)
+}
+
 func (bot *TgConnector) handleCommand(message *tgbotapi.Message) error {
 	log.Printf("Handle command Message from %s: %s", message.From.FirstName, message.Text)
+	// commands in groups may be addressed to other bots
+	if _, recipient, addressed := strings.Cut(message.CommandWithAt(), "@"); addressed && !strings.EqualFold(recipient, bot.telegram.Self.UserName) {
+		return nil
+	}
 	for _, cmd := range bot.commands {
 		if strings.ToLower(cmd.CommandTrigger) == strings.ToLower(message.Command()) {
 			totalCommandMessagesRecieved.WithLabelValues(message.From.FirstName, cmd.CommandTrigger).Inc()
+			if err := bot.checkPermission(message, cmd); err != nil {
+				return err
+			}
 			// log.Printf("Executing command: %s", cmd.CommandTrigger)
 			resultMessages, err := cmd.Execute(message)
 			// data, err := yaml.Marshal(resultMessages)
@@ -226,7 +395,7 @@ func (bot *TgConnector) handleCommand(message *tgbotapi.Message) error {
 				if resultMessage == nil {
 					continue
 				}
-				bot.telegram.Send(resultMessage)
+				Send(bot.telegram, resultMessage)
 			}
 			return err
 		}
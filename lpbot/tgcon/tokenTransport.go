@@ -0,0 +1,39 @@
+package tgcon
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// tokenTransport puts the current token into the urls of the bot api
+// (https://api.telegram.org/bot<token>/<method>). The api object reads its
+// token without any lock, so the token is rotated here instead.
+type tokenTransport struct {
+	lock  sync.RWMutex
+	token string
+}
+
+func (transport *tokenTransport) setToken(token string) {
+	transport.lock.Lock()
+	defer transport.lock.Unlock()
+
+	transport.token = token
+}
+
+func (transport *tokenTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	transport.lock.RLock()
+	token := transport.token
+	transport.lock.RUnlock()
+
+	path := request.URL.Path
+	if strings.HasPrefix(path, "/bot") {
+		if end := strings.Index(path[len("/bot"):], "/"); end >= 0 {
+			request = request.Clone(request.Context())
+			request.URL.Path = "/bot" + token + path[len("/bot")+end:]
+			request.URL.RawPath = ""
+		}
+	}
+
+	return http.DefaultTransport.RoundTrip(request)
+}
@@ -0,0 +1,135 @@
+package lpbot
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/khase/leaseplan-bot/lpbot/config"
+	"github.com/khase/leaseplan-bot/lpbot/lpcon"
+	"github.com/khase/leaseplan-bot/lpbot/tgcon"
+	"github.com/khase/leaseplanabocarexporter/dto"
+)
+
+const (
+	watchUsage = "Verwendung:\n/watch <Portal Link oder Ident> - beobachtet ein Auto, unabhängig von deinen Filtern\n/watch list - zeigt deine beobachteten Autos\n/watch remove <Nummer, Portal Link oder Ident> - beendet die Beobachtung\n\nDu bekommst eine Nachricht, sobald das Auto verschwindet, wieder auftaucht oder sich BGV, BLP oder Verfügbarkeit ändern."
+)
+
+var (
+	WatchCmd = &tgcon.MessageCommand{
+		CommandTrigger:   "watch",
+		ShortDescription: "beobachtet ein bestimmtes Auto",
+		Description:      watchUsage,
+		GroupCommand:     true,
+		Execute: func(message *tgbotapi.Message) ([]tgbotapi.Chattable, error) {
+			return handleWatchCommand(message, getSubscriber(message))
+		},
+	}
+)
+
+func handleWatchCommand(message *tgbotapi.Message, user *config.User) ([]tgbotapi.Chattable, error) {
+	if user == nil {
+		return nil, tgcon.ErrCommandPermittedForUnknownUser
+	}
+
+	args := strings.Fields(message.CommandArguments())
+	switch {
+	case len(args) == 0:
+		return replyText(message, watchUsage), nil
+	case args[0] == "list" && len(args) == 1:
+		return handleWatchList(message, user), nil
+	case args[0] == "remove" && len(args) == 2:
+		return handleWatchRemove(message, user, args[1])
+	case args[0] == "list" || args[0] == "remove":
+		return replyText(message, watchUsage), nil
+	case len(args) == 1:
+		return handleWatchAdd(message, user, args[0])
+	}
+
+	return replyText(message, watchUsage), nil
+}
+
+func handleWatchAdd(message *tgbotapi.Message, user *config.User, reference string) ([]tgbotapi.Chattable, error) {
+	ident, offerIdent, err := config.ParseCarReference(reference)
+	if errors.Is(err, config.ErrInvalidCarReference) {
+		return replyText(message, fmt.Sprintf("Mit '%s' kann ich leider nichts anfangen 😨\n\n%s", reference, watchUsage)), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var text string
+	car, exists := findVisibleCar(user, ident)
+	if exists {
+		err = user.WatchCar(car)
+		text = fmt.Sprintf("Ich sage dir Bescheid, sobald sich %s ändert 🔔", car.OfferTypeName)
+	} else {
+		err = user.WatchUnavailableCar(ident, offerIdent)
+		text = fmt.Sprintf("Das Auto %s ist gerade nicht verfügbar. Ich sage dir Bescheid, sobald es auftaucht 🔔", ident)
+	}
+	if errors.Is(err, config.ErrWatchlistFull) {
+		return replyText(message, fmt.Sprintf("Du beobachtest bereits %d Autos, entferne zuerst welche mit /watch remove", config.MaxWatchedCars)), nil
+	} else if err != nil {
+		return nil, err
+	}
+	user.Save()
+
+	return replyText(message, text), nil
+}
+
+// findVisibleCar looks up a car among all cars of the level of the user,
+// regardless of his filters.
+func findVisibleCar(user *config.User, ident string) (dto.Item, bool) {
+	for _, car := range lpcon.GetCars()[user.LeaseplanLevelKey] {
+		if car.RentalObject.Ident == ident {
+			return car, true
+		}
+	}
+
+	return user.FindCar(ident)
+}
+
+func handleWatchList(message *tgbotapi.Message, user *config.User) []tgbotapi.Chattable {
+	watchedCars := user.GetWatchedCars()
+	if len(watchedCars) == 0 {
+		return replyText(message, "Du beobachtest noch keine Autos. Füge eins mit /watch <Portal Link> oder dem 🔔 Knopf hinzu.")
+	}
+
+	lines := []string{fmt.Sprintf("Du beobachtest %d Autos:", len(watchedCars))}
+	for i, watched := range watchedCars {
+		line := fmt.Sprintf("%d. %s", i+1, watched.Name)
+		if watched.Available {
+			line += fmt.Sprintf(" ✅ BGV: %d€, BLP: %.2f€, Verfügbar: %s", watched.SalaryWaiver, watched.PriceProducer1, watched.DateRegistration.Format("02.01.2006"))
+		} else {
+			line += " ❌ nicht verfügbar"
+		}
+		if url := watched.Url(); url != "" {
+			line += "\n" + url
+		}
+		lines = append(lines, line)
+	}
+
+	return replyLines(message, lines)
+}
+
+func handleWatchRemove(message *tgbotapi.Message, user *config.User, reference string) ([]tgbotapi.Chattable, error) {
+	var watched *config.WatchedCar
+	if index, err := strconv.Atoi(reference); err == nil {
+		watchedCars := user.GetWatchedCars()
+		if index >= 1 && index <= len(watchedCars) {
+			watched = watchedCars[index-1]
+		}
+	} else if ident, _, err := config.ParseCarReference(reference); err == nil {
+		watched, _ = user.GetWatchedCar(ident)
+	}
+
+	if watched == nil {
+		return replyText(message, fmt.Sprintf("Du beobachtest kein Auto '%s'. Deine Autos zeigt /watch list.", reference)), nil
+	}
+
+	user.UnwatchCar(watched.Ident)
+	user.Save()
+
+	return replyText(message, fmt.Sprintf("Du beobachtest %s nicht mehr 🔕", watched.Name)), nil
+}
@@ -0,0 +1,91 @@
+package lpbot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/khase/leaseplan-bot/lpbot/config"
+	"github.com/khase/leaseplan-bot/lpbot/tgcon"
+)
+
+const webhookUsage = "Verwendung:\n/webhook - zeigt den aktuellen Benachrichtigungskanal\n/webhook <url> - sendet Änderungen per HTTP POST an die url\n/webhook test - sendet eine Testbenachrichtigung an den Webhook\n/webhook off - sendet Änderungen wieder per Telegram"
+
+var (
+	WebhookCmd = &tgcon.MessageCommand{
+		CommandTrigger:   "webhook",
+		ShortDescription: "sendet Änderungen an einen Webhook statt per Telegram",
+		Description:      webhookUsage,
+		Execute: func(message *tgbotapi.Message) ([]tgbotapi.Chattable, error) {
+			return handleWebhookCommand(message, UserMap.GetUser(message.From.ID))
+		},
+	}
+)
+
+func handleWebhookCommand(message *tgbotapi.Message, user *config.User) ([]tgbotapi.Chattable, error) {
+	if user == nil {
+		return nil, tgcon.ErrCommandPermittedForUnknownUser
+	}
+
+	argument := strings.TrimSpace(message.CommandArguments())
+	switch argument {
+	case "":
+		if user.Notifier != config.NotifierWebhook {
+			return replyText(message, "Änderungen werden dir per Telegram geschickt.\n\n"+webhookUsage), nil
+		}
+		return replyText(message, fmt.Sprintf("Änderungen werden an %s gesendet.\n\n%s", user.WebhookUrl, webhookUsage)), nil
+	case "off":
+		user.DisableWebhook()
+		user.Save()
+
+		return replyText(message, "Änderungen werden dir ab sofort wieder per Telegram geschickt 📬"), nil
+	case "test":
+		if user.Notifier != config.NotifierWebhook {
+			return replyText(message, "Du hast noch keinen Webhook eingerichtet.\n\n"+webhookUsage), nil
+		}
+
+		// retries take several seconds, the result is sent when done
+		notifier, frame := user.GetNotifier(nil), getTestFrame(user)
+		replyLater(message, func() string {
+			err := notifier.Notify(context.Background(), user, frame)
+			if err != nil {
+				return fmt.Sprintf("Der Webhook konnte nicht erreicht werden: %s", err)
+			}
+			return "Die Testbenachrichtigung wurde zugestellt ✅"
+		})
+		return replyText(message, "Die Testbenachrichtigung wird gesendet …"), nil
+	}
+
+	secret, err := user.SetWebhook(argument)
+	if errors.Is(err, config.ErrInvalidWebhookUrl) {
+		return replyText(message, "Das ist leider keine gültige http(s) url.\n\n"+webhookUsage), nil
+	} else if errors.Is(err, config.ErrPrivateAddress) {
+		return replyText(message, "Webhooks können nur an öffentlich erreichbare Adressen gesendet werden."), nil
+	} else if errors.Is(err, config.ErrUnresolvableHost) {
+		return replyText(message, "Der Server der url konnte nicht gefunden werden.\n\n"+webhookUsage), nil
+	} else if err != nil {
+		return nil, err
+	}
+	user.Save()
+
+	msg := tgbotapi.NewMessage(
+		message.Chat.ID,
+		fmt.Sprintf("Änderungen werden ab sofort als JSON an deinen Webhook gesendet.\n\nJede Anfrage ist mit dem folgenden Schlüssel per HMAC-SHA256 signiert, die Signatur steht im `%s` Header:\n\n`%s`\n\nMit /webhook test kannst du ihn ausprobieren.", config.WebhookSignatureHeader, secret))
+	msg.ParseMode = "Markdown"
+	msg.ReplyToMessageID = message.MessageID
+
+	return []tgbotapi.Chattable{msg}, nil
+}
+
+// getTestFrame returns a frame announcing the first current car of the user
+// as added.
+func getTestFrame(user *config.User) *config.DataFrame {
+	current := user.LastFrame.Current
+	if len(current) > 1 {
+		current = current[:1]
+	}
+
+	return config.NewDataFrame(nil, current)
+}